@@ -0,0 +1,10 @@
+//go:build !(linux || darwin || freebsd)
+
+package main
+
+import "errors"
+
+// diskUsage is not available on this platform; df reports that instead.
+func diskUsage(path string) (total, free, avail uint64, err error) {
+	return 0, 0, 0, errors.New("not supported on this platform")
+}
@@ -1,16 +1,42 @@
 package main
 
 import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
 	"encoding/json"
+	"fmt"
 	"io"
+	"mime"
+	"mime/multipart"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"os"
 	"path/filepath"
+	"regexp"
+	"slices"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
+var ansiRe = regexp.MustCompile("\x1b\\[[0-9;]*m")
+
+// stripANSI removes color codes from terminal output
+func stripANSI(s string) string {
+	return ansiRe.ReplaceAllString(s, "")
+}
+
 func TestHandleConfig_ReadmeAndPath(t *testing.T) {
 	s := newTestServer(t)
 	// add README.md in root
@@ -73,6 +99,25 @@ func execJSON(t *testing.T, s *server, input string) execResp {
 	return resp
 }
 
+// execSession runs a command in the session identified by sid, creating it
+// on first use, so state such as cwd carries across calls
+func execSession(t *testing.T, s *server, sid, input string) execResp {
+	t.Helper()
+	if _, ok := s.sessions[sid]; !ok {
+		s.sessions[sid] = &session{id: sid, cwd: "/"}
+	}
+	body, _ := json.Marshal(execReq{Input: input})
+	r := httptest.NewRequest("POST", "/api/exec", strings.NewReader(string(body)))
+	r.AddCookie(&http.Cookie{Name: "sid", Value: sid})
+	w := httptest.NewRecorder()
+	s.handleExec(w, r)
+	var resp execResp
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
 func TestHandleExec_BasicPwdHelp(t *testing.T) {
 	s := newTestServer(t)
 	out := execJSON(t, s, "pwd")
@@ -180,6 +225,12 @@ func TestHandleExec_DownloadVariants(t *testing.T) {
 		t.Fatalf("dir dl: %#v", r3)
 	}
 
+	// -0 requests a stored archive
+	r3s := execJSON(t, s, "get -0 dd")
+	if !strings.HasSuffix(r3s.Download, "&store=1") {
+		t.Fatalf("store dl: %#v", r3s)
+	}
+
 	// no match
 	r4 := execJSON(t, s, "download *.none")
 	if !strings.Contains(r4.Output, "no matching files") {
@@ -423,3 +474,2448 @@ func TestNoJSHTMLEscaping(t *testing.T) {
 		t.Error("Found literal quote in href attribute - HTML escaping not applied")
 	}
 }
+
+func TestNoJSScriptInjection(t *testing.T) {
+	s := newTestServer(t)
+
+	dirName := `"><b>dir`
+	fileName := `"><script>alert('x').txt`
+	if err := os.Mkdir(filepath.Join(s.rootAbs, dirName), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(s.rootAbs, dirName, fileName), []byte("x"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	r := httptest.NewRequest("GET", "/?nojs=1", nil)
+	w := httptest.NewRecorder()
+	s.serveNoJSDirectory(w, r, "/"+dirName)
+	if w.Code != 200 {
+		t.Fatalf("nojs status: %d", w.Code)
+	}
+	body := w.Body.String()
+
+	for _, bad := range []string{"<script>", "<b>", `"><script`, `"><b>`} {
+		if strings.Contains(body, bad) {
+			t.Fatalf("unescaped markup %q in nojs output: %q", bad, body)
+		}
+	}
+	if !strings.Contains(body, "&lt;script&gt;") {
+		t.Fatalf("expected escaped display name, body: %q", body)
+	}
+	if !strings.Contains(body, "%3Cscript%3E") {
+		t.Fatalf("expected URL-encoded href, body: %q", body)
+	}
+}
+
+func TestNoJSListingSizesAndDates(t *testing.T) {
+	s := newTestServer(t)
+	if err := os.Mkdir(filepath.Join(s.rootAbs, "zdir"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	fp := filepath.Join(s.rootAbs, "afile.bin")
+	if err := os.WriteFile(fp, make([]byte, 2048), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	info, _ := os.Stat(fp)
+
+	r := httptest.NewRequest("GET", "/?nojs=1", nil)
+	w := httptest.NewRecorder()
+	s.serveNoJSDirectory(w, r, "/")
+	body := w.Body.String()
+
+	if !strings.Contains(body, "2.0K") || strings.Contains(body, "bytes") {
+		t.Fatalf("expected human-readable size, body: %q", body)
+	}
+	if !strings.Contains(body, info.ModTime().Format("2006-01-02 15:04")) {
+		t.Fatalf("expected modification date, body: %q", body)
+	}
+	// directories are still listed before files
+	if strings.Index(body, "zdir/") > strings.Index(body, "afile.bin") {
+		t.Fatalf("dirs should come first, body: %q", body)
+	}
+}
+
+func TestHandleExec_LsColumns(t *testing.T) {
+	s := newTestServer(t)
+	for _, n := range []string{"one.txt", "two.txt", "three.txt"} {
+		if err := os.WriteFile(filepath.Join(s.rootAbs, n), []byte("x"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if out := execJSON(t, s, "ls").Output; strings.Contains(out, "\n") {
+		t.Fatalf("ls should fit on one row at 80 cols: %q", out)
+	}
+	if out := execJSON(t, s, "ls -1").Output; strings.Count(out, "\n") != 2 {
+		t.Fatalf("ls -1 should print one per line: %q", out)
+	}
+
+	body, _ := json.Marshal(execReq{Input: "ls", Cols: 12})
+	r := httptest.NewRequest("POST", "/api/exec", strings.NewReader(string(body)))
+	w := httptest.NewRecorder()
+	s.handleExec(w, r)
+	var resp execResp
+	if err := json.NewDecoder(w.Result().Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Count(resp.Output, "\n") != 2 {
+		t.Fatalf("ls at 12 cols should wrap to one per line: %q", resp.Output)
+	}
+}
+
+func TestListingPagination(t *testing.T) {
+	s := newTestServer(t)
+	if err := os.Mkdir(filepath.Join(s.rootAbs, "adir"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for i := range 5 {
+		if err := os.WriteFile(filepath.Join(s.rootAbs, fmt.Sprintf("f%d.txt", i)), []byte("x"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	out := execJSON(t, s, "ls -1 --limit 2 --offset=2").Output
+	if !strings.Contains(out, "f1.txt") || !strings.Contains(out, "f2.txt") || strings.Contains(out, "f3.txt") {
+		t.Fatalf("unexpected page: %q", out)
+	}
+	if !strings.Contains(out, "entries 3-4 of 6") || !strings.Contains(out, "ls --offset 4") {
+		t.Fatalf("expected page footer: %q", out)
+	}
+	if out := execJSON(t, s, "ls -1").Output; strings.Contains(out, "entries") {
+		t.Fatalf("small folders should not be paged: %q", out)
+	}
+	if out := execJSON(t, s, "ls --limit x").Output; !strings.Contains(out, "positive number") {
+		t.Fatalf("expected limit error: %q", out)
+	}
+
+	r := httptest.NewRequest("GET", "/?nojs=1&offset=1&limit=2", nil)
+	w := httptest.NewRecorder()
+	s.serveNoJSDirectory(w, r, "/")
+	body := w.Body.String()
+	if strings.Contains(body, "adir/") || !strings.Contains(body, "f0.txt") || strings.Contains(body, "f2.txt") {
+		t.Fatalf("unexpected no-JS page: %q", body)
+	}
+	if !strings.Contains(body, "Entries 2-3 of 6") ||
+		!strings.Contains(body, "?limit=2&amp;nojs=1&amp;offset=0") ||
+		!strings.Contains(body, "?limit=2&amp;nojs=1&amp;offset=3") {
+		t.Fatalf("expected prev/next links: %q", body)
+	}
+}
+
+func uploadRequest(t *testing.T, name, content string) *http.Request {
+	t.Helper()
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	fw, err := mw.CreateFormFile("file", name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = fw.Write([]byte(content))
+	_ = mw.Close()
+	r := httptest.NewRequest("POST", "/api/upload", &buf)
+	r.Header.Set("Content-Type", mw.FormDataContentType())
+	return r
+}
+
+func TestHandleUpload(t *testing.T) {
+	s := newTestServer(t)
+
+	// read-only by default
+	w := httptest.NewRecorder()
+	s.handleUpload(w, uploadRequest(t, "a.txt", "A"))
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("read-only upload: %d", w.Code)
+	}
+
+	s.writable = true
+	w = httptest.NewRecorder()
+	s.handleUpload(w, uploadRequest(t, "a.txt", "A"))
+	if w.Code != 200 {
+		t.Fatalf("upload status: %d %s", w.Code, w.Body.String())
+	}
+	if data, _ := os.ReadFile(filepath.Join(s.rootAbs, "a.txt")); string(data) != "A" {
+		t.Fatalf("uploaded content: %q", data)
+	}
+
+	// no overwrite without -force
+	w = httptest.NewRecorder()
+	s.handleUpload(w, uploadRequest(t, "a.txt", "B"))
+	if w.Code != http.StatusConflict {
+		t.Fatalf("overwrite status: %d", w.Code)
+	}
+	s.force = true
+	w = httptest.NewRecorder()
+	s.handleUpload(w, uploadRequest(t, "a.txt", "B"))
+	if data, _ := os.ReadFile(filepath.Join(s.rootAbs, "a.txt")); w.Code != 200 || string(data) != "B" {
+		t.Fatalf("forced overwrite: %d %q", w.Code, data)
+	}
+
+	// ignored and hidden names are refused
+	if err := os.WriteFile(filepath.Join(s.rootAbs, ".lsgetignore"), []byte("*.key\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"secret.key", ".lsgetignore"} {
+		w = httptest.NewRecorder()
+		s.handleUpload(w, uploadRequest(t, name, "x"))
+		if w.Code == 200 {
+			t.Fatalf("upload of %q should be refused", name)
+		}
+	}
+}
+
+func TestHandleExec_Mkdir(t *testing.T) {
+	s := newTestServer(t)
+
+	if resp := execJSON(t, s, "mkdir new"); !strings.Contains(resp.Output, "read-only") {
+		t.Fatalf("read-only mkdir: %q", resp.Output)
+	}
+	if _, err := os.Stat(filepath.Join(s.rootAbs, "new")); err == nil {
+		t.Fatal("mkdir must not create anything when read-only")
+	}
+
+	s.writable = true
+	if resp := execJSON(t, s, "mkdir new"); resp.Output != "" {
+		t.Fatalf("mkdir: %q", resp.Output)
+	}
+	if info, err := os.Stat(filepath.Join(s.rootAbs, "new")); err != nil || !info.IsDir() {
+		t.Fatalf("directory not created: %v", err)
+	}
+	if resp := execJSON(t, s, "mkdir new"); !strings.Contains(resp.Output, "File exists") {
+		t.Fatalf("existing dir: %q", resp.Output)
+	}
+	if resp := execJSON(t, s, "mkdir a/b"); !strings.Contains(resp.Output, "No such file") {
+		t.Fatalf("missing parent: %q", resp.Output)
+	}
+	if resp := execJSON(t, s, "mkdir -p a/b/c"); resp.Output != "" {
+		t.Fatalf("mkdir -p: %q", resp.Output)
+	}
+	if _, err := os.Stat(filepath.Join(s.rootAbs, "a", "b", "c")); err != nil {
+		t.Fatalf("parents not created: %v", err)
+	}
+	// names upload refuses are refused here too
+	if err := os.WriteFile(filepath.Join(s.rootAbs, ".lsgetignore"), []byte("private*\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	for _, d := range []string{".hidden", "private", "-p .x/y", "-p a/b/private/z"} {
+		if resp := execJSON(t, s, "mkdir "+d); !strings.Contains(resp.Output, "Permission denied") {
+			t.Fatalf("mkdir %s: %q", d, resp.Output)
+		}
+	}
+	for _, rel := range []string{".hidden", "private", ".x", "a/b/private"} {
+		if _, err := os.Stat(filepath.Join(s.rootAbs, rel)); err == nil {
+			t.Fatalf("%s should not have been created", rel)
+		}
+	}
+	// .. is clamped at the virtual root
+	execJSON(t, s, "mkdir ../../escape")
+	if _, err := os.Stat(filepath.Join(s.rootAbs, "escape")); err != nil {
+		t.Fatalf("mkdir ../../escape should stay in root: %v", err)
+	}
+}
+
+func TestHandleExec_Rm(t *testing.T) {
+	s := newTestServer(t)
+	root := s.rootAbs
+	if err := os.WriteFile(filepath.Join(root, "f.txt"), []byte("x"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(root, "d", "sub"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	if resp := execJSON(t, s, "rm f.txt"); !strings.Contains(resp.Output, "read-only") {
+		t.Fatalf("read-only rm: %q", resp.Output)
+	}
+
+	s.writable = true
+	if resp := execJSON(t, s, "rm f.txt"); resp.Output != "" {
+		t.Fatalf("rm: %q", resp.Output)
+	}
+	if _, err := os.Stat(filepath.Join(root, "f.txt")); !os.IsNotExist(err) {
+		t.Fatal("file not removed")
+	}
+	if resp := execJSON(t, s, "rm d"); !strings.Contains(resp.Output, "Is a directory") {
+		t.Fatalf("rm dir without -r: %q", resp.Output)
+	}
+	if resp := execJSON(t, s, "rm -r d"); resp.Output != "" {
+		t.Fatalf("rm -r: %q", resp.Output)
+	}
+	if _, err := os.Stat(filepath.Join(root, "d")); !os.IsNotExist(err) {
+		t.Fatal("directory not removed")
+	}
+	// removing the files that hide things must not reveal them
+	if err := os.WriteFile(filepath.Join(root, ".lsgetignore"), []byte("secret*\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "secret.txt"), []byte("s"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if resp := execJSON(t, s, "rm .lsgetignore"); !strings.Contains(resp.Output, "not permitted") || resp.Error != codePerm {
+		t.Fatalf("rm .lsgetignore: %+v", resp)
+	}
+	if resp := execJSON(t, s, "rm secret.txt"); !strings.Contains(resp.Output, "No such file") {
+		t.Fatalf("rm of ignored file: %q", resp.Output)
+	}
+	for _, rel := range []string{".lsgetignore", "secret.txt"} {
+		if _, err := os.Stat(filepath.Join(root, rel)); err != nil {
+			t.Fatalf("%s was removed: %v", rel, err)
+		}
+	}
+	// nor may rm -r take along what the client cannot see
+	for rel, data := range map[string]string{"sub/private/" + accessFile: "deny all\n", "sub/private/s.txt": "s", "hold/secret.txt": "s"} {
+		p := filepath.Join(root, filepath.FromSlash(rel))
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, dir := range []string{"sub", "hold"} {
+		if resp := execJSON(t, s, "rm -r "+dir); resp.Error != codeAccess {
+			t.Fatalf("rm -r %s: %+v", dir, resp)
+		}
+	}
+	for _, rel := range []string{"sub/private/s.txt", "hold/secret.txt"} {
+		if _, err := os.Stat(filepath.Join(root, filepath.FromSlash(rel))); err != nil {
+			t.Fatalf("%s was removed: %v", rel, err)
+		}
+	}
+	for _, in := range []string{"rm -r /", "rm -r ..", "rm -r ."} {
+		if resp := execJSON(t, s, in); !strings.Contains(resp.Output, "refusing") {
+			t.Fatalf("%s: %q", in, resp.Output)
+		}
+	}
+	if _, err := os.Stat(root); err != nil {
+		t.Fatal("root was removed")
+	}
+}
+
+func TestHandleExec_MvCp(t *testing.T) {
+	s := newTestServer(t)
+	root := s.rootAbs
+	write := func(rel, data string) {
+		t.Helper()
+		p := filepath.Join(root, rel)
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("a.txt", "A")
+	write("dir/x.txt", "X")
+	write("dir/.hidden", "H")
+	write("secret.key", "K")
+	write(".lsgetignore", "*.key\n")
+
+	if resp := execJSON(t, s, "cp a.txt b.txt"); !strings.Contains(resp.Output, "read-only") {
+		t.Fatalf("read-only cp: %q", resp.Output)
+	}
+
+	s.writable = true
+	if resp := execJSON(t, s, "cp a.txt b.txt"); resp.Output != "" {
+		t.Fatalf("cp: %q", resp.Output)
+	}
+	if data, _ := os.ReadFile(filepath.Join(root, "b.txt")); string(data) != "A" {
+		t.Fatalf("copied content: %q", data)
+	}
+	if resp := execJSON(t, s, "cp a.txt b.txt"); !strings.Contains(resp.Output, "already exists") {
+		t.Fatalf("cp over existing file: %q", resp.Output)
+	}
+	if resp := execJSON(t, s, "cp dir copy"); !strings.Contains(resp.Output, "-r not specified") {
+		t.Fatalf("cp dir without -r: %q", resp.Output)
+	}
+	if resp := execJSON(t, s, "cp -r dir copy"); resp.Output != "" {
+		t.Fatalf("cp -r: %q", resp.Output)
+	}
+	if data, _ := os.ReadFile(filepath.Join(root, "copy", "x.txt")); string(data) != "X" {
+		t.Fatalf("recursive copy content: %q", data)
+	}
+	if _, err := os.Stat(filepath.Join(root, "copy", ".hidden")); err == nil {
+		t.Fatal("hidden files should not be copied")
+	}
+	if resp := execJSON(t, s, "cp -r dir dir/sub"); !strings.Contains(resp.Output, "into itself") {
+		t.Fatalf("cp into itself: %q", resp.Output)
+	}
+	if resp := execJSON(t, s, "cp secret.key leak.txt"); !strings.Contains(resp.Output, "No such file") {
+		t.Fatalf("cp of ignored file: %q", resp.Output)
+	}
+
+	// mv into an existing directory keeps the base name
+	if resp := execJSON(t, s, "mv b.txt dir"); resp.Output != "" {
+		t.Fatalf("mv: %q", resp.Output)
+	}
+	if _, err := os.Stat(filepath.Join(root, "dir", "b.txt")); err != nil {
+		t.Fatalf("mv into dir: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(root, "b.txt")); !os.IsNotExist(err) {
+		t.Fatal("mv source still present")
+	}
+	if resp := execJSON(t, s, "mv a.txt dir/x.txt"); !strings.Contains(resp.Output, "already exists") {
+		t.Fatalf("mv over existing file: %q", resp.Output)
+	}
+	if resp := execJSON(t, s, "mv / elsewhere"); !strings.Contains(resp.Output, "Permission denied") {
+		t.Fatalf("mv root: %q", resp.Output)
+	}
+	if resp := execJSON(t, s, "cp a.txt dir/x.txt"); !strings.Contains(resp.Output, "'dir/x.txt' already exists") {
+		t.Fatalf("exists error should name the destination: %q", resp.Output)
+	}
+
+	// control files can be neither replaced nor moved away, and nothing
+	// may be given a name that hides it
+	for _, in := range []string{"mv a.txt .lsgetignore", "cp a.txt .lsgetaccess", "cp a.txt dir/.lsgetignore", "mv a.txt .hidden", "cp a.txt new.key"} {
+		if resp := execJSON(t, s, in); !strings.Contains(resp.Output, "Permission denied") {
+			t.Fatalf("%s: %q", in, resp.Output)
+		}
+	}
+	write("box/k.key", "K")
+	write("guarded/"+accessFile, "deny all\n")
+	for _, dir := range []string{"box", "guarded"} {
+		if resp := execJSON(t, s, "mv "+dir+" moved"); resp.Error != codeAccess {
+			t.Fatalf("mv %s: %+v", dir, resp)
+		}
+	}
+	if resp := execJSON(t, s, "mv .lsgetignore visible.txt"); !strings.Contains(resp.Output, "not permitted") {
+		t.Fatalf("mv of .lsgetignore: %q", resp.Output)
+	}
+	if data, _ := os.ReadFile(filepath.Join(root, ".lsgetignore")); string(data) != "*.key\n" {
+		t.Fatalf(".lsgetignore changed: %q", data)
+	}
+	for _, rel := range []string{".lsgetaccess", "dir/.lsgetignore", ".hidden", "new.key", "visible.txt", "moved"} {
+		if _, err := os.Lstat(filepath.Join(root, rel)); err == nil {
+			t.Fatalf("%s should not exist", rel)
+		}
+	}
+
+	// -force replaces files but not through symlinks
+	s.force = true
+	write("keep.txt", "keep")
+	if err := os.Symlink(filepath.Join(root, "keep.txt"), filepath.Join(root, "link.txt")); err != nil {
+		t.Skip("symlinks not supported")
+	}
+	if resp := execJSON(t, s, "cp a.txt link.txt"); !strings.Contains(resp.Output, "symlink") {
+		t.Fatalf("cp over symlink: %q", resp.Output)
+	}
+	if data, _ := os.ReadFile(filepath.Join(root, "keep.txt")); string(data) != "keep" {
+		t.Fatalf("symlink target overwritten: %q", data)
+	}
+	if resp := execJSON(t, s, "cp a.txt dir/x.txt"); resp.Output != "" {
+		t.Fatalf("cp -force: %q", resp.Output)
+	}
+}
+
+func TestHandleExec_Bookmark(t *testing.T) {
+	s := newTestServer(t)
+	if err := os.MkdirAll(filepath.Join(s.rootAbs, "deep", "tree"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	exec := func(input string) execResp {
+		t.Helper()
+		return execSession(t, s, "test-session", input)
+	}
+
+	if resp := exec("bookmark -l"); !strings.Contains(resp.Output, "no bookmarks") {
+		t.Fatalf("empty list: %q", resp.Output)
+	}
+	if resp := exec("bookmark work deep/tree"); resp.Output != "" {
+		t.Fatalf("bookmark: %q", resp.Output)
+	}
+	if resp := exec("bookmark -l"); !strings.Contains(resp.Output, "/deep/tree") {
+		t.Fatalf("list: %q", resp.Output)
+	}
+	if resp := exec("cd work"); resp.CWD != "/deep/tree" {
+		t.Fatalf("cd to bookmark: %+v", resp)
+	}
+	if resp := exec("bookmark bad missing"); !strings.Contains(resp.Output, "not a directory") {
+		t.Fatalf("bookmark to missing dir: %q", resp.Output)
+	}
+	// without -bookmarks they live in memory only
+	if entries, _ := os.ReadDir(s.rootAbs); len(entries) != 1 {
+		t.Fatalf("bookmarks written into the root: %v", entries)
+	}
+
+	// with -bookmarks they persist and revive the session after a restart
+	s.bookmarksPath = filepath.Join(t.TempDir(), "bookmarks.json")
+	exec("bookmark home /")
+	data, err := os.ReadFile(s.bookmarksPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "test-session") || !strings.Contains(string(data), bookmarkKey("test-session")) {
+		t.Fatalf("bookmarks file must key sessions by hash, not cookie: %s", data)
+	}
+	s2 := newServer(s.rootAbs, s.catMax, "", "")
+	s2.bookmarksPath = s.bookmarksPath
+	s2.loadBookmarks()
+	r := httptest.NewRequest("GET", "/", nil)
+	r.AddCookie(&http.Cookie{Name: "sid", Value: "test-session"})
+	sess := s2.getSession(httptest.NewRecorder(), r)
+	if sess.bookmarks["work"] != "/deep/tree" || sess.bookmarks["home"] != "/" {
+		t.Fatalf("restored bookmarks: %v", sess.bookmarks)
+	}
+
+	if resp := exec("bookmark -d work"); resp.Output != "" {
+		t.Fatalf("delete: %q", resp.Output)
+	}
+	if resp := exec("cd work"); resp.CWD != "" {
+		t.Fatalf("cd to deleted bookmark: %+v", resp)
+	}
+}
+
+func TestHandleExec_SetSort(t *testing.T) {
+	s := newTestServer(t)
+	now := time.Now()
+	for i, f := range []struct {
+		name string
+		size int
+		age  time.Duration
+	}{{"a.txt", 10, 2 * time.Hour}, {"b.txt", 300, 3 * time.Hour}, {"c.txt", 20, time.Hour}} {
+		p := filepath.Join(s.rootAbs, f.name)
+		if err := os.WriteFile(p, bytes.Repeat([]byte("x"), f.size), 0o644); err != nil {
+			t.Fatal(i, err)
+		}
+		if err := os.Chtimes(p, now.Add(-f.age), now.Add(-f.age)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ls := func(input string) string {
+		t.Helper()
+		return stripANSI(execSession(t, s, "sort", input).Output)
+	}
+
+	if got := ls("ls -1"); got != "a.txt\nb.txt\nc.txt" {
+		t.Fatalf("default order: %q", got)
+	}
+	if got := ls("ls -1 -t"); got != "c.txt\na.txt\nb.txt" {
+		t.Fatalf("ls -t: %q", got)
+	}
+	if got := ls("ls -1S"); got != "b.txt\nc.txt\na.txt" {
+		t.Fatalf("ls -S: %q", got)
+	}
+
+	if resp := execSession(t, s, "sort", "set sort time"); resp.Output != "" {
+		t.Fatalf("set sort: %q", resp.Output)
+	}
+	if got := ls("ls -1"); got != "c.txt\na.txt\nb.txt" {
+		t.Fatalf("session order: %q", got)
+	}
+	if got := ls("ls -1 -S"); got != "b.txt\nc.txt\na.txt" {
+		t.Fatalf("explicit flag overrides session order: %q", got)
+	}
+	if resp := execSession(t, s, "sort", "set"); resp.Output != "sort time\ndirsfirst off" {
+		t.Fatalf("set: %q", resp.Output)
+	}
+	if resp := execSession(t, s, "sort", "set sort random"); !strings.Contains(resp.Output, "invalid") {
+		t.Fatalf("invalid order: %q", resp.Output)
+	}
+	// other sessions keep the default
+	if got := stripANSI(execSession(t, s, "other", "ls -1").Output); got != "a.txt\nb.txt\nc.txt" {
+		t.Fatalf("other session: %q", got)
+	}
+}
+
+func TestHandleExec_CdGlob(t *testing.T) {
+	s := newTestServer(t)
+	for _, d := range []string{"build-1.4.2", "release-1", "release-2", ".hidden-1"} {
+		if err := os.MkdirAll(filepath.Join(s.rootAbs, d), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(s.rootAbs, "build-notes.txt"), []byte("x"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	// files are not candidates, so build-* is unambiguous
+	if resp := execSession(t, s, "glob", "cd build-*"); resp.CWD != "/build-1.4.2" {
+		t.Fatalf("cd build-*: %+v", resp)
+	}
+	if resp := execSession(t, s, "glob", "cd ../release-*"); !strings.Contains(resp.Output, "ambiguous") ||
+		!strings.Contains(resp.Output, "release-1 release-2") {
+		t.Fatalf("ambiguous: %q", resp.Output)
+	}
+	if resp := execSession(t, s, "glob", "cd /nothing-*"); !strings.Contains(resp.Output, "no such") {
+		t.Fatalf("no match: %q", resp.Output)
+	}
+	if resp := execSession(t, s, "glob", "cd /*-1"); resp.CWD != "/release-1" {
+		t.Fatalf("hidden dirs must not match: %+v", resp)
+	}
+
+	// a name that only looks like a pattern is taken literally
+	for _, d := range []string{"a[1]", "a1"} {
+		if err := os.Mkdir(filepath.Join(s.rootAbs, d), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if resp := execSession(t, s, "glob", "cd /a[1]"); resp.CWD != "/a[1]" {
+		t.Fatalf("literal brackets: %+v", resp)
+	}
+	if resp := execSession(t, s, "glob", "cd /a[0-9]"); resp.CWD != "/a1" {
+		t.Fatalf("bracket pattern: %+v", resp)
+	}
+}
+
+func TestHandleExec_CdDash(t *testing.T) {
+	s := newTestServer(t)
+	for _, d := range []string{"a", "b"} {
+		if err := os.Mkdir(filepath.Join(s.rootAbs, d), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if resp := execSession(t, s, "dash", "cd -"); !strings.Contains(resp.Output, "OLDPWD not set") {
+		t.Fatalf("cd - without history: %q", resp.Output)
+	}
+	execSession(t, s, "dash", "cd a")
+	execSession(t, s, "dash", "cd /b")
+	if resp := execSession(t, s, "dash", "cd -"); resp.CWD != "/a" || resp.Output != "/a" {
+		t.Fatalf("cd - back: %+v", resp)
+	}
+	if resp := execSession(t, s, "dash", "cd -"); resp.CWD != "/b" {
+		t.Fatalf("cd - forth: %+v", resp)
+	}
+	// a failed cd does not touch the history
+	execSession(t, s, "dash", "cd missing")
+	if resp := execSession(t, s, "dash", "cd -"); resp.CWD != "/a" {
+		t.Fatalf("cd - after failed cd: %+v", resp)
+	}
+}
+
+// completeNames posts a completion request and returns the item names
+func completeNames(t *testing.T, s *server, req completeReq) []string {
+	t.Helper()
+	b, _ := json.Marshal(req)
+	w := httptest.NewRecorder()
+	s.handleComplete(w, httptest.NewRequest("POST", "/api/complete", strings.NewReader(string(b))))
+	var cr completeResp
+	if err := json.NewDecoder(w.Body).Decode(&cr); err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, it := range cr.Items {
+		names = append(names, it.Name)
+	}
+	return names
+}
+
+func TestHandleComplete_Commands(t *testing.T) {
+	s := newTestServer(t)
+	if err := os.WriteFile(filepath.Join(s.rootAbs, "cathedral.txt"), []byte("x"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := completeNames(t, s, completeReq{Line: "c"}); strings.Join(got, " ") != "cat cd checksum" {
+		t.Fatalf("commands for c: %v", got)
+	}
+	if got := completeNames(t, s, completeReq{Line: "  gr"}); strings.Join(got, " ") != "grep" {
+		t.Fatalf("commands for gr: %v", got)
+	}
+	// write commands are only offered on writable servers
+	if got := completeNames(t, s, completeReq{Line: "mk"}); len(got) != 0 {
+		t.Fatalf("mkdir offered on read-only server: %v", got)
+	}
+	s.writable = true
+	if got := completeNames(t, s, completeReq{Line: "mk"}); strings.Join(got, " ") != "mkdir" {
+		t.Fatalf("commands for mk: %v", got)
+	}
+	// past the first word, paths are completed as before
+	if got := completeNames(t, s, completeReq{Line: "cat ca", Path: "ca"}); strings.Join(got, " ") != "cathedral.txt" {
+		t.Fatalf("path completion: %v", got)
+	}
+}
+
+func TestCommandTable(t *testing.T) {
+	s := newTestServer(t)
+	s.writable = true
+	help := renderHelp(true, "", nil)
+	for _, c := range commands {
+		for _, name := range c.names {
+			if !strings.Contains(help, "<strong>"+name+"</strong>") {
+				t.Errorf("help does not list %s", name)
+			}
+		}
+		// every name, advertised or not, must reach a handler
+		for _, name := range append(slices.Clone(c.names), c.hidden...) {
+			if resp := execJSON(t, s, name); strings.Contains(resp.Output, "command not found") {
+				t.Errorf("%s is in the command table but not handled: %+v", name, resp)
+			}
+		}
+	}
+	if got := commandFlags("dir"); !slices.Contains(got, "--limit") {
+		t.Fatalf("flags for dir: %v", got)
+	}
+}
+
+func TestHandleComplete_Flags(t *testing.T) {
+	s := newTestServer(t)
+	if got := completeNames(t, s, completeReq{Line: "ls -"}); strings.Join(got, " ") != "--group-directories-first --limit --offset --owner -1 -S -a -g -h -l -t -v" {
+		t.Fatalf("ls flags: %v", got)
+	}
+	if got := completeNames(t, s, completeReq{Line: "find . -n"}); strings.Join(got, " ") != "-name" {
+		t.Fatalf("find flags: %v", got)
+	}
+	if got := completeNames(t, s, completeReq{Line: "pwd -"}); len(got) != 0 {
+		t.Fatalf("pwd has no flags: %v", got)
+	}
+}
+
+func TestHandleComplete_CaseInsensitiveFallback(t *testing.T) {
+	s := newTestServer(t)
+	for _, name := range []string{"readme.md", "README.txt", "Readings", ".Rc"} {
+		if err := os.WriteFile(filepath.Join(s.rootAbs, name), []byte("x"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// exact-case matches win when there are any
+	if got := completeNames(t, s, completeReq{Path: "READ"}); strings.Join(got, " ") != "README.txt" {
+		t.Fatalf("exact prefix: %v", got)
+	}
+	// otherwise fall back to case-insensitive matches
+	if got := completeNames(t, s, completeReq{Path: "rEaD"}); strings.Join(got, " ") != "README.txt Readings readme.md" {
+		t.Fatalf("folded prefix: %v", got)
+	}
+	// dotfiles still need a leading dot
+	if got := completeNames(t, s, completeReq{Path: "r"}); slices.Contains(got, ".Rc") {
+		t.Fatalf("hidden file offered: %v", got)
+	}
+	if got := completeNames(t, s, completeReq{Path: ".r"}); strings.Join(got, " ") != ".Rc" {
+		t.Fatalf("hidden folded: %v", got)
+	}
+}
+
+func TestHandleComplete_Replacement(t *testing.T) {
+	s := newTestServer(t)
+	if err := os.MkdirAll(filepath.Join(s.rootAbs, "sub", "first"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(s.rootAbs, "sub", "file.txt"), []byte("x"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	b, _ := json.Marshal(completeReq{Path: "sub/fi"})
+	w := httptest.NewRecorder()
+	s.handleComplete(w, httptest.NewRequest("POST", "/api/complete", strings.NewReader(string(b))))
+	var cr completeResp
+	if err := json.NewDecoder(w.Body).Decode(&cr); err != nil {
+		t.Fatal(err)
+	}
+	want := []completeItem{
+		{Name: "first", Dir: true, Replacement: "sub/first/"},
+		{Name: "file.txt", Replacement: "sub/file.txt"},
+	}
+	if !slices.Equal(cr.Items, want) {
+		t.Fatalf("items: %+v", cr.Items)
+	}
+}
+
+// Several tabs share one session cookie; run with -race to catch
+// unguarded access to session state.
+func TestSessionConcurrentTabs(t *testing.T) {
+	s := newTestServer(t)
+	for _, d := range []string{"a", "b"} {
+		if err := os.Mkdir(filepath.Join(s.rootAbs, d), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s.sessions["tabs"] = &session{id: "tabs", cwd: "/"}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				if i%2 == 0 {
+					body, _ := json.Marshal(execReq{Input: "cd /" + string(rune('a'+j%2))})
+					r := httptest.NewRequest("POST", "/api/exec", strings.NewReader(string(body)))
+					r.AddCookie(&http.Cookie{Name: "sid", Value: "tabs"})
+					s.handleExec(httptest.NewRecorder(), r)
+				} else {
+					b, _ := json.Marshal(completeReq{Path: "x"})
+					r := httptest.NewRequest("POST", "/api/complete", strings.NewReader(string(b)))
+					r.AddCookie(&http.Cookie{Name: "sid", Value: "tabs"})
+					s.handleComplete(httptest.NewRecorder(), r)
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	if cwd := s.sessions["tabs"].getCwd(); cwd != "/a" && cwd != "/b" {
+		t.Fatalf("cwd: %q", cwd)
+	}
+}
+
+func TestHandleExec_CatDirectoryHint(t *testing.T) {
+	s := newTestServer(t)
+	if err := os.Mkdir(filepath.Join(s.rootAbs, "docs"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	resp := execJSON(t, s, "cat docs")
+	if !strings.Contains(resp.Output, "is a directory") || !strings.Contains(resp.Output, "ls docs") {
+		t.Fatalf("cat dir: %q", resp.Output)
+	}
+}
+
+func TestHandleExec_CatSumWildcards(t *testing.T) {
+	s := newTestServer(t)
+	files := map[string]string{
+		"a.conf":       "alpha\n",
+		"b.conf":       "beta\n",
+		".hidden.conf": "secret\n",
+		"big.conf":     strings.Repeat("x", int(s.catMax)+1),
+		"bin.conf":     "\x00\x01\x02",
+	}
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(s.rootAbs, name), []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	out := stripANSI(execJSON(t, s, "cat *.conf").Output)
+	for _, want := range []string{"==> /a.conf <==\nalpha", "==> /b.conf <==\nbeta", "big.conf <==\ncat: file too large", "bin.conf <==\ncat: binary file"} {
+		if !strings.Contains(out, want) {
+			t.Fatalf("cat *.conf missing %q in %q", want, out)
+		}
+	}
+	if strings.Contains(out, "secret") {
+		t.Fatalf("dotfiles must not match *: %q", out)
+	}
+	if resp := execJSON(t, s, "cat *.none"); !strings.Contains(resp.Output, "no such file") {
+		t.Fatalf("no match: %q", resp.Output)
+	}
+
+	out = stripANSI(execJSON(t, s, "sum [ab].conf").Output)
+	if strings.Count(out, "SHA256:") != 2 || !strings.Contains(out, "/a.conf\nMD5:") {
+		t.Fatalf("sum [ab].conf: %q", out)
+	}
+
+	// names that only look like patterns are read as written
+	if err := os.WriteFile(filepath.Join(s.rootAbs, "c[1].txt"), []byte("literal\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if out := execJSON(t, s, "cat c[1].txt").Output; out != "literal\n" && out != "literal" {
+		t.Fatalf("cat c[1].txt: %q", out)
+	}
+	if out := execJSON(t, s, "sum c[1].txt").Output; !strings.Contains(out, "SHA256:") || strings.Contains(out, "==>") {
+		t.Fatalf("sum c[1].txt: %q", out)
+	}
+}
+
+func TestHandleExec_SumLimit(t *testing.T) {
+	s := newTestServer(t)
+	if err := os.WriteFile(filepath.Join(s.rootAbs, "big.iso"), make([]byte, 2048), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if s.maxSum != defaultMaxSum {
+		t.Fatalf("sum should be limited by default: %d", s.maxSum)
+	}
+	if out := execJSON(t, s, "sum big.iso").Output; !strings.Contains(out, "SHA256: ") {
+		t.Fatalf("sum under the default limit: %q", out)
+	}
+	s.maxSum = 0
+	if out := execJSON(t, s, "sum big.iso").Output; !strings.Contains(out, "SHA256: ") {
+		t.Fatalf("unlimited sum: %q", out)
+	}
+	s.maxSum = 1024
+	if out := execJSON(t, s, "sum big.iso").Output; out != "sum: file too large (2.0K > limit 1.0K)" {
+		t.Fatalf("expected limit error: %q", out)
+	}
+}
+
+func TestHandleExec_GrepLargeFile(t *testing.T) {
+	s := newTestServer(t)
+	s.grepMax = 64
+	var b strings.Builder
+	for i := range 10 {
+		fmt.Fprintf(&b, "line %d needle\n", i)
+	}
+	if err := os.WriteFile(filepath.Join(s.rootAbs, "big.log"), []byte(b.String()), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	out := stripANSI(execJSON(t, s, "grep needle big.log").Output)
+	if !strings.Contains(out, "line 0 needle") || strings.Contains(out, "line 9 needle") {
+		t.Fatalf("expected only the head to be searched: %q", out)
+	}
+	if !strings.Contains(out, "grep: /big.log: only the first 64B searched") {
+		t.Fatalf("expected partial-search note: %q", out)
+	}
+
+	s.grepMax = 0
+	out = stripANSI(execJSON(t, s, "grep -r needle").Output)
+	if strings.Count(out, "needle") != 10 || strings.Contains(out, "only the first") {
+		t.Fatalf("expected the whole file to be searched: %q", out)
+	}
+}
+
+func TestHandleExec_GrepLongLine(t *testing.T) {
+	s := newTestServer(t)
+	line := strings.Repeat("x", 200*1024) + "needle"
+	if err := os.WriteFile(filepath.Join(s.rootAbs, "app.min.js"), []byte("first\n"+line+"\nlast\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	out := stripANSI(execJSON(t, s, "grep -n needle app.min.js").Output)
+	if !strings.HasPrefix(out, "2:xxx") || !strings.HasSuffix(out, "needle") {
+		t.Fatalf("long line should match in full, got %d bytes: %.40q", len(out), out)
+	}
+}
+
+func TestHandleExec_GrepFileColors(t *testing.T) {
+	s := newTestServer(t)
+	for name, content := range map[string]string{"main.go": "// needle\n", "notes.txt": "needle\n"} {
+		if err := os.WriteFile(filepath.Join(s.rootAbs, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	out := execJSON(t, s, "grep -r needle /").Output
+	if !strings.Contains(out, colorYellow+"/main.go"+colorReset+":") {
+		t.Fatalf("a .go match should carry the code color: %q", out)
+	}
+	if !strings.Contains(out, colorWhite+"/notes.txt"+colorReset+":") {
+		t.Fatalf("a .txt match should carry the document color: %q", out)
+	}
+	if !strings.Contains(out, colorYellow+colorBold+"needle"+colorReset) {
+		t.Fatalf("matches should stay highlighted: %q", out)
+	}
+}
+
+func TestHandleExec_GrepLimits(t *testing.T) {
+	s := newTestServer(t)
+	for _, name := range []string{"a.txt", "b.txt", "c.txt"} {
+		if err := os.WriteFile(filepath.Join(s.rootAbs, name), []byte("needle 1\nneedle 2\nneedle 3\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	out := stripANSI(execJSON(t, s, "grep -r -m 1 needle /").Output)
+	if strings.Count(out, "needle") != 3 || strings.Contains(out, "needle 2") {
+		t.Fatalf("grep -m 1 should report the first match of each file: %q", out)
+	}
+	if out := stripANSI(execJSON(t, s, "grep -m2 needle a.txt").Output); out != "needle 1\nneedle 2" {
+		t.Fatalf("grep -m2: %q", out)
+	}
+	if out := execJSON(t, s, "grep -m 0 needle a.txt").Output; !strings.Contains(out, "positive number") {
+		t.Fatalf("grep -m 0: %q", out)
+	}
+
+	s.grepResults = 4
+	out = stripANSI(execJSON(t, s, "grep -r needle /").Output)
+	if strings.Count(out, "needle") != 4 || !strings.HasSuffix(out, "... results truncated after 4 matches") {
+		t.Fatalf("global cap: %q", out)
+	}
+	s.grepResults = 9
+	if out := stripANSI(execJSON(t, s, "grep -r needle /").Output); strings.Contains(out, "truncated") {
+		t.Fatalf("exactly the cap should not be reported as truncated: %q", out)
+	}
+}
+
+func TestHandleExec_GrepBinary(t *testing.T) {
+	s := newTestServer(t)
+	bin := append([]byte{0x7f, 'E', 'L', 'F', 0, 0, 1}, []byte("\nversion needle-1.2\n\x00\x01")...)
+	if err := os.WriteFile(filepath.Join(s.rootAbs, "app.bin"), bin, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if out := execJSON(t, s, "grep needle app.bin").Output; out != "grep: no matches found" {
+		t.Fatalf("binary files should be skipped by default: %q", out)
+	}
+	if out := execJSON(t, s, "grep --binary-files=binary needle app.bin").Output; out != "Binary file /app.bin matches" {
+		t.Fatalf("--binary-files=binary: %q", out)
+	}
+	for _, in := range []string{"grep -a needle app.bin", "grep --text needle app.bin", "grep --binary-files=text needle app.bin"} {
+		if out := stripANSI(execJSON(t, s, in).Output); out != "version needle-1.2" {
+			t.Fatalf("%s: %q", in, out)
+		}
+	}
+	if out := execJSON(t, s, "grep --binary-files=maybe needle app.bin").Output; !strings.Contains(out, "invalid --binary-files") {
+		t.Fatalf("invalid type: %q", out)
+	}
+}
+
+func TestHandleExec_CRLF(t *testing.T) {
+	s := newTestServer(t)
+	s.catMax = 1024
+	files := map[string]string{
+		"win.txt": "alpha\r\nbeta needle\r\ngamma\r\n",
+		"mac.txt": "alpha\rbeta needle\rgamma\r",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(s.rootAbs, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for name := range files {
+		for _, cmd := range []string{"cat", "head", "tail -n 3"} {
+			out := stripANSI(execJSON(t, s, cmd+" "+name).Output)
+			if strings.Contains(out, "\r") || !strings.Contains(out, "alpha\nbeta needle\ngamma") {
+				t.Fatalf("%s %s: %q", cmd, name, out)
+			}
+		}
+		if out := stripANSI(execJSON(t, s, "grep -n needle "+name).Output); out != "2:beta needle" {
+			t.Fatalf("grep %s: %q", name, out)
+		}
+	}
+}
+
+func TestHandleExec_ParseError(t *testing.T) {
+	s := newTestServer(t)
+	if out := execJSON(t, s, `cat "foo`).Output; out != "parse error: unterminated quote" {
+		t.Fatalf("unterminated quote: %q", out)
+	}
+	if out := execJSON(t, s, `''`).Output; out != "" {
+		t.Fatalf("empty quoted line: %q", out)
+	}
+}
+
+func TestHandleExec_BodyTooLarge(t *testing.T) {
+	s := newTestServer(t)
+	s.maxBody = 64
+	body := `{"input":"echo ` + strings.Repeat("x", 100) + `"}`
+	for _, h := range []http.HandlerFunc{s.handleExec, s.handleComplete} {
+		w := httptest.NewRecorder()
+		h(w, httptest.NewRequest("POST", "/api/exec", strings.NewReader(body)))
+		if w.Code != http.StatusRequestEntityTooLarge {
+			t.Fatalf("oversized body: got %d, want 413", w.Code)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	s.handleExec(w, httptest.NewRequest("POST", "/api/exec", strings.NewReader(`{"input":"pwd"}`)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("small body refused: %d", w.Code)
+	}
+	w = httptest.NewRecorder()
+	s.handleExec(w, httptest.NewRequest("POST", "/api/exec", strings.NewReader(`{"input":`)))
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("malformed body: got %d, want 400", w.Code)
+	}
+}
+
+func TestAliases(t *testing.T) {
+	s := newTestServer(t)
+	if err := os.WriteFile(filepath.Join(s.rootAbs, ".hidden"), []byte("x"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(t.TempDir(), "aliases")
+	content := "# shortcuts\nll = ls -l\nla = ll -a\nls = ls -1\nping = pong\npong = ping\n"
+	if err := os.WriteFile(file, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	aliases, err := loadAliases(file)
+	if err != nil || len(aliases) != 5 {
+		t.Fatalf("loadAliases: %v %v", aliases, err)
+	}
+	s.aliases = aliases
+
+	if out := execJSON(t, s, "la").Output; !strings.Contains(out, ".hidden") || !strings.Contains(out, "-rw") {
+		t.Fatalf("la should run ls -l -a: %q", out)
+	}
+	if out := execJSON(t, s, "ls").Output; strings.Contains(out, "unknown") {
+		t.Fatalf("self-referencing alias should expand once: %q", out)
+	}
+	if out := execJSON(t, s, "ping").Output; out != "ping: alias loop" {
+		t.Fatalf("expected loop error: %q", out)
+	}
+	if got := completeNames(t, s, completeReq{Line: "l"}); !slices.Contains(got, "ll") || !slices.Contains(got, "la") {
+		t.Fatalf("aliases should complete: %v", got)
+	}
+
+	if err := os.WriteFile(file, []byte("ll ls -l\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadAliases(file); err == nil || !strings.Contains(err.Error(), ":1:") {
+		t.Fatalf("expected a parse error with line number, got %v", err)
+	}
+}
+
+func TestCommandAllowList(t *testing.T) {
+	s := newTestServer(t)
+	var err error
+	if s.enabled, err = parseCommandList("ls, cat,get"); err != nil {
+		t.Fatal(err)
+	}
+	if out := execJSON(t, s, "tree").Output; out != "tree: command disabled" {
+		t.Fatalf("tree should be disabled: %q", out)
+	}
+	if out := execJSON(t, s, "dir").Output; strings.Contains(out, "disabled") {
+		t.Fatalf("dir is an alias of ls and should be enabled: %q", out)
+	}
+	if html := execJSON(t, s, "help").HTML; strings.Contains(html, "<strong>tree</strong>") || !strings.Contains(html, "<strong>wget</strong>") {
+		t.Fatalf("help should only list enabled commands: %q", html)
+	}
+	if got := completeNames(t, s, completeReq{Line: "t"}); len(got) != 0 {
+		t.Fatalf("disabled commands should not complete: %v", got)
+	}
+
+	s.enabled = nil
+	if s.disabled, err = parseCommandList("sum"); err != nil {
+		t.Fatal(err)
+	}
+	if out := execJSON(t, s, "checksum x").Output; out != "checksum: command disabled" {
+		t.Fatalf("checksum should follow sum: %q", out)
+	}
+	if out := execJSON(t, s, "pwd").Output; out != "/" {
+		t.Fatalf("pwd should still work: %q", out)
+	}
+	// aliases help does not show are covered too
+	if s.disabled, err = parseCommandList("bookmark,get"); err != nil {
+		t.Fatal(err)
+	}
+	for _, cmd := range []string{"bm -l", "rget x"} {
+		if out := execJSON(t, s, cmd).Output; !strings.HasSuffix(out, ": command disabled") {
+			t.Fatalf("%s should be disabled: %q", cmd, out)
+		}
+	}
+	if _, err := parseCommandList("ls,format"); err == nil {
+		t.Fatal("unknown commands should be rejected")
+	}
+}
+
+func TestHandleDownload_AccentedFilename(t *testing.T) {
+	s := newTestServer(t)
+	if err := os.WriteFile(filepath.Join(s.rootAbs, "café.txt"), []byte("x"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	s.handleDownload(w, httptest.NewRequest("GET", "/api/download?path="+url.QueryEscape("/café.txt"), nil))
+	cd := w.Result().Header.Get("Content-Disposition")
+	_, params, err := mime.ParseMediaType(cd)
+	if err != nil || params["filename"] != "café.txt" {
+		t.Fatalf("Content-Disposition %q parsed as %v (%v)", cd, params, err)
+	}
+}
+
+func TestServeFileInline(t *testing.T) {
+	s := newTestServer(t)
+	if err := os.WriteFile(filepath.Join(s.rootAbs, "doc.pdf"), []byte("%PDF-1.4"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	s.handleIndex(w, httptest.NewRequest("GET", "/doc.pdf", nil))
+	if cd := w.Result().Header.Get("Content-Disposition"); !strings.HasPrefix(cd, "attachment;") {
+		t.Fatalf("default disposition: %q", cd)
+	}
+	w = httptest.NewRecorder()
+	s.handleIndex(w, httptest.NewRequest("GET", "/doc.pdf?inline=1", nil))
+	if cd := w.Result().Header.Get("Content-Disposition"); !strings.HasPrefix(cd, "inline;") {
+		t.Fatalf("inline disposition: %q", cd)
+	}
+
+	resp := execJSON(t, s, "view doc.pdf")
+	if resp.Open != "/doc.pdf?inline=1" || !strings.Contains(resp.HTML, `href="/doc.pdf?inline=1"`) {
+		t.Fatalf("view: %+v", resp)
+	}
+	if resp := execJSON(t, s, "view missing.pdf"); resp.Open != "" {
+		t.Fatalf("view missing: %+v", resp)
+	}
+}
+
+func TestHandleExec_Preview(t *testing.T) {
+	s := newTestServer(t)
+	var long strings.Builder
+	for i := 1; i <= 100; i++ {
+		fmt.Fprintf(&long, "line %d\n", i)
+	}
+	files := map[string]string{
+		"short.txt": "one\ntwo\n",
+		"long.txt":  long.String(),
+		"pic.png":   "\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR\x00\x00",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(s.rootAbs, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if out := execJSON(t, s, "preview short.txt").Output; out != "one\ntwo" {
+		t.Fatalf("short preview: %q", out)
+	}
+	out := stripANSI(execJSON(t, s, "preview long.txt").Output)
+	if !strings.Contains(out, "line 40\n") || strings.Contains(out, "line 41") || !strings.Contains(out, "truncated") {
+		t.Fatalf("long preview: %q", out)
+	}
+	if out := execJSON(t, s, "head pic.png").Output; out != "pic.png: PNG image data" {
+		t.Fatalf("binary preview: %q", out)
+	}
+}
+
+func TestHandleExec_MediaInfo(t *testing.T) {
+	s := newTestServer(t)
+
+	png := []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\x0dIHDR\x00\x00\x02\x80\x00\x00\x01\xe0")
+	jpeg := []byte{0xff, 0xd8, 0xff, 0xe0, 0x00, 0x04, 0x00, 0x00, // APP0
+		0xff, 0xc0, 0x00, 0x11, 0x08, 0x00, 0x48, 0x00, 0x64} // SOF0 100x72
+	// ID3v2.3 tag with a title, then 128 kbps / 44.1 kHz stereo frames
+	title := append([]byte("TIT2\x00\x00\x00\x06\x00\x00\x00"), "Song\x00"...)
+	mp3 := append([]byte("ID3\x03\x00\x00\x00\x00\x00"), byte(len(title)))
+	mp3 = append(mp3, title...)
+	frame := make([]byte, 417)
+	copy(frame, []byte{0xff, 0xfb, 0x90, 0x00})
+	for range 500 {
+		mp3 = append(mp3, frame...)
+	}
+
+	files := map[string][]byte{"a.png": png, "b.jpg": jpeg, "c.mp3": mp3, "d.txt": []byte("hello\n")}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(s.rootAbs, name), content, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		input string
+		want  []string
+	}{
+		{"meta a.png", []string{"PNG", "640x480"}},
+		{"mediainfo b.jpg", []string{"JPEG", "100x72"}},
+		{"meta c.mp3", []string{"MP3", "Song", "128 kbps", "44100 Hz, stereo", "0:13"}},
+		{"meta d.txt", []string{"d.txt: no metadata"}},
+	}
+	for _, tt := range tests {
+		out := stripANSI(execJSON(t, s, tt.input).Output)
+		for _, w := range tt.want {
+			if !strings.Contains(out, w) {
+				t.Errorf("%s: missing %q in %q", tt.input, w, out)
+			}
+		}
+	}
+}
+
+// buildExifJPEG assembles a minimal JPEG whose APP1 segment holds a
+// big-endian TIFF with Make, an Exif IFD (FNumber) and a GPS IFD.
+func buildExifJPEG() []byte {
+	be := binary.BigEndian
+	tiff := []byte("MM\x00\x2a\x00\x00\x00\x08")
+	entry := func(tag, typ uint16, count, value uint32) []byte {
+		b := make([]byte, 12)
+		be.PutUint16(b, tag)
+		be.PutUint16(b[2:], typ)
+		be.PutUint32(b[4:], count)
+		be.PutUint32(b[8:], value)
+		return b
+	}
+	rational := func(n, d uint32) []byte {
+		return be.AppendUint32(be.AppendUint32(nil, n), d)
+	}
+
+	// IFD0 at 8: Make, ExifIFD, GPSIFD (3 entries) -> data starts at 8+2+36+4 = 50
+	make0 := "Canon\x00"
+	exifOff := uint32(50 + len(make0))
+	gpsOff := exifOff + 2 + 12 + 4 + 8
+	tiff = be.AppendUint16(tiff, 3)
+	tiff = append(tiff, entry(0x010f, 2, uint32(len(make0)), 50)...)
+	tiff = append(tiff, entry(0x8769, 4, 1, exifOff)...)
+	tiff = append(tiff, entry(0x8825, 4, 1, gpsOff)...)
+	tiff = append(tiff, 0, 0, 0, 0)
+	tiff = append(tiff, make0...)
+
+	// Exif IFD: FNumber rational stored after the IFD
+	tiff = be.AppendUint16(tiff, 1)
+	tiff = append(tiff, entry(0x829d, 5, 1, exifOff+2+12+4)...)
+	tiff = append(tiff, 0, 0, 0, 0)
+	tiff = append(tiff, rational(28, 10)...)
+
+	// GPS IFD: N 45°30'0", E 9°15'0"
+	latOff := gpsOff + 2 + 4*12 + 4
+	lonOff := latOff + 24
+	tiff = be.AppendUint16(tiff, 4)
+	tiff = append(tiff, entry(1, 2, 2, 0x4e000000)...)
+	tiff = append(tiff, entry(2, 5, 3, latOff)...)
+	tiff = append(tiff, entry(3, 2, 2, 0x45000000)...)
+	tiff = append(tiff, entry(4, 5, 3, lonOff)...)
+	tiff = append(tiff, 0, 0, 0, 0)
+	tiff = append(tiff, slices.Concat(rational(45, 1), rational(30, 1), rational(0, 1))...)
+	tiff = append(tiff, slices.Concat(rational(9, 1), rational(15, 1), rational(0, 1))...)
+
+	app1 := append([]byte("Exif\x00\x00"), tiff...)
+	jpeg := []byte{0xff, 0xd8, 0xff, 0xe1}
+	jpeg = be.AppendUint16(jpeg, uint16(len(app1)+2))
+	jpeg = append(jpeg, app1...)
+	return append(jpeg, 0xff, 0xda, 0x00, 0x02, 0xff, 0xd9)
+}
+
+func TestHandleExec_Exif(t *testing.T) {
+	s := newTestServer(t)
+	if err := os.WriteFile(filepath.Join(s.rootAbs, "photo.jpg"), buildExifJPEG(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(s.rootAbs, "plain.jpg"), []byte{0xff, 0xd8, 0xff, 0xd9}, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	out := stripANSI(execJSON(t, s, "exif photo.jpg").Output)
+	for _, want := range []string{"make:      Canon", "aperture:  f/2.8", "gps:       present (hidden"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("missing %q in %q", want, out)
+		}
+	}
+	if strings.Contains(out, "45.5") {
+		t.Errorf("GPS leaked without -exifgps: %q", out)
+	}
+
+	s.exifGPS = true
+	if out := execJSON(t, s, "exif photo.jpg").Output; !strings.Contains(out, "45.500000, 9.250000") {
+		t.Errorf("gps: %q", out)
+	}
+	if out := execJSON(t, s, "exif plain.jpg").Output; out != "plain.jpg: no EXIF" {
+		t.Errorf("plain: %q", out)
+	}
+}
+
+func TestReadExif_MalformedSegments(t *testing.T) {
+	dir := t.TempDir()
+	cases := map[string][]byte{
+		"zero-length":   {0xff, 0xd8, 0xff, 0xe1, 0x00, 0x00},
+		"length-one":    {0xff, 0xd8, 0xff, 0xe1, 0x00, 0x01, 'E'},
+		"short-app1":    {0xff, 0xd8, 0xff, 0xe1, 0x00, 0x04, 'E', 'x'},
+		"truncated":     {0xff, 0xd8, 0xff, 0xe1, 0x10, 0x00, 'E', 'x', 'i', 'f'},
+		"prefix-only":   append([]byte{0xff, 0xd8, 0xff, 0xe1, 0x00, 0x08}, "Exif\x00\x00"...),
+		"header-cutoff": {0xff, 0xd8, 0xff, 0xe1, 0x00},
+	}
+	for name, data := range cases {
+		rp := filepath.Join(dir, name+".jpg")
+		if err := os.WriteFile(rp, data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		fields, err := readExif(rp, false)
+		if err != nil || fields != nil {
+			t.Errorf("%s: got %v, %v", name, fields, err)
+		}
+	}
+}
+
+func TestHandleExec_Diff(t *testing.T) {
+	s := newTestServer(t)
+	_ = os.Mkdir(filepath.Join(s.rootAbs, "dir"), 0o755)
+	_ = os.WriteFile(filepath.Join(s.rootAbs, "a.conf"), []byte("port=80\nhost=a\n"), 0o644)
+	_ = os.WriteFile(filepath.Join(s.rootAbs, "b.conf"), []byte("port=8080\nhost=a\n"), 0o644)
+	_ = os.WriteFile(filepath.Join(s.rootAbs, "bin"), []byte{0, 1, 2, 3}, 0o644)
+
+	out := execJSON(t, s, "diff a.conf b.conf").Output
+	if !strings.Contains(out, colorRed+"-port=80"+colorReset) || !strings.Contains(out, colorGreen+"+port=8080"+colorReset) {
+		t.Fatalf("diff: %q", out)
+	}
+	if out := execJSON(t, s, "diff a.conf dir").Output; out != "diff: dir: is a directory" {
+		t.Fatalf("dir: %q", out)
+	}
+	if out := execJSON(t, s, "diff a.conf bin").Output; !strings.Contains(out, "binary file") {
+		t.Fatalf("binary: %q", out)
+	}
+}
+
+func TestHandleExec_Zcat(t *testing.T) {
+	s := newTestServer(t)
+	s.catMax = 32
+	write := func(name string, content []byte) {
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		_, _ = zw.Write(content)
+		_ = zw.Close()
+		if err := os.WriteFile(filepath.Join(s.rootAbs, name), buf.Bytes(), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("small.log.gz", []byte("GET /\nGET /a\n"))
+	write("big.log.gz", bytes.Repeat([]byte("GET /index.html\n"), 100))
+	write("blob.gz", []byte{0, 1, 2, 3, 0, 0, 0})
+	_ = os.WriteFile(filepath.Join(s.rootAbs, "plain.txt"), []byte("hi\n"), 0o644)
+
+	if out := execJSON(t, s, "zcat small.log.gz").Output; out != "GET /\nGET /a" {
+		t.Fatalf("small: %q", out)
+	}
+	if out := stripANSI(execJSON(t, s, "zcat big.log.gz").Output); !strings.HasSuffix(out, "(truncated at 32 bytes)") {
+		t.Fatalf("big: %q", out)
+	}
+	if out := execJSON(t, s, "zcat blob.gz").Output; !strings.Contains(out, "binary content") {
+		t.Fatalf("blob: %q", out)
+	}
+	if out := execJSON(t, s, "zcat plain.txt").Output; !strings.Contains(out, "not in gzip or bzip2 format") {
+		t.Fatalf("plain: %q", out)
+	}
+}
+
+func TestHandleExec_UnzipList(t *testing.T) {
+	s := newTestServer(t)
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, name := range []string{"docs/", "docs/readme.txt", "main.go"} {
+		fw, err := zw.CreateHeader(&zip.FileHeader{Name: name, Modified: time.Date(2024, 5, 1, 9, 30, 0, 0, time.UTC)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.HasSuffix(name, "/") {
+			_, _ = fw.Write([]byte("12345"))
+		}
+	}
+	_ = zw.Close()
+	_ = os.WriteFile(filepath.Join(s.rootAbs, "a.zip"), buf.Bytes(), 0o644)
+	_ = os.WriteFile(filepath.Join(s.rootAbs, "bad.zip"), []byte("PK\x03\x04garbage"), 0o644)
+
+	out := execJSON(t, s, "unzip -l a.zip").Output
+	for _, want := range []string{"        5  2024-05-01 09:30   docs/readme.txt", "       10                     3 files"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("missing %q in:\n%s", want, out)
+		}
+	}
+	if out := execJSON(t, s, "unzip -l bad.zip").Output; out != "unzip: cannot read bad.zip: not a valid zip archive" {
+		t.Errorf("corrupt: %q", out)
+	}
+	if out := execJSON(t, s, "unzip a.zip").Output; !strings.Contains(out, "usage") {
+		t.Errorf("extract: %q", out)
+	}
+}
+
+func TestHandleExec_TarList(t *testing.T) {
+	s := newTestServer(t)
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for _, name := range []string{"pkg/bin/tool", "pkg/README"} {
+		_ = tw.WriteHeader(&tar.Header{Name: name, Mode: 0o755, Size: 4, Typeflag: tar.TypeReg})
+		_, _ = tw.Write([]byte("data"))
+	}
+	_ = tw.Close()
+	_ = gz.Close()
+	_ = os.WriteFile(filepath.Join(s.rootAbs, "pkg.tar.gz"), buf.Bytes(), 0o644)
+
+	var plain bytes.Buffer
+	tw = tar.NewWriter(&plain)
+	_ = tw.WriteHeader(&tar.Header{Name: "big.bin", Mode: 0o644, Size: 4096, Typeflag: tar.TypeReg})
+	_, _ = tw.Write(make([]byte, 4096))
+	_ = tw.WriteHeader(&tar.Header{Name: "next", Mode: 0o644, Size: 1, Typeflag: tar.TypeReg})
+	_ = os.WriteFile(filepath.Join(s.rootAbs, "cut.tar"), plain.Bytes()[:512+4096+100], 0o644)
+
+	out := execJSON(t, s, "tar -tzf pkg.tar.gz").Output
+	if out != "-rwxr-xr-x          4  pkg/bin/tool\n-rwxr-xr-x          4  pkg/README" {
+		t.Fatalf("list: %q", out)
+	}
+	out = stripANSI(execJSON(t, s, "tar -t cut.tar").Output)
+	if !strings.HasPrefix(out, "-rw-r--r--       4096  big.bin") || !strings.HasSuffix(out, "unexpected end of archive") {
+		t.Fatalf("truncated: %q", out)
+	}
+	if out := execJSON(t, s, "tar -xf pkg.tar.gz").Output; !strings.Contains(out, "usage") {
+		t.Fatalf("extract: %q", out)
+	}
+}
+
+func TestHandleArchive(t *testing.T) {
+	s := newTestServer(t)
+
+	var zbuf bytes.Buffer
+	zw := zip.NewWriter(&zbuf)
+	fw, _ := zw.Create("docs/readme.txt")
+	_, _ = fw.Write([]byte("zip entry\n"))
+	_ = zw.Close()
+	_ = os.WriteFile(filepath.Join(s.rootAbs, "bundle.zip"), zbuf.Bytes(), 0o644)
+
+	var tbuf bytes.Buffer
+	gz := gzip.NewWriter(&tbuf)
+	tw := tar.NewWriter(gz)
+	_ = tw.WriteHeader(&tar.Header{Name: "./pkg/data.json", Mode: 0o644, Size: 2, Typeflag: tar.TypeReg})
+	_, _ = tw.Write([]byte("{}"))
+	_ = tw.Close()
+	_ = gz.Close()
+	_ = os.WriteFile(filepath.Join(s.rootAbs, "bundle.tgz"), tbuf.Bytes(), 0o644)
+
+	get := func(query string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		s.handleArchive(w, httptest.NewRequest("GET", "/api/archive?"+query, nil))
+		return w
+	}
+
+	w := get("path=bundle.zip&entry=docs/readme.txt")
+	if w.Code != http.StatusOK || w.Body.String() != "zip entry\n" {
+		t.Fatalf("zip: %d %q", w.Code, w.Body.String())
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "text/plain; charset=utf-8" {
+		t.Fatalf("zip content type: %q", ct)
+	}
+	w = get("path=/bundle.tgz&entry=pkg/data.json&inline=1")
+	if w.Code != http.StatusOK || w.Body.String() != "{}" || !strings.HasPrefix(w.Header().Get("Content-Disposition"), "inline;") {
+		t.Fatalf("tar: %d %q %v", w.Code, w.Body.String(), w.Header())
+	}
+
+	for query, code := range map[string]int{
+		"path=bundle.zip&entry=../etc/passwd": http.StatusBadRequest,
+		"path=bundle.zip&entry=/etc/passwd":   http.StatusBadRequest,
+		"path=bundle.zip&entry=missing.txt":   http.StatusNotFound,
+		"path=missing.zip&entry=a":            http.StatusNotFound,
+		"path=bundle.zip":                     http.StatusBadRequest,
+	} {
+		if w := get(query); w.Code != code {
+			t.Errorf("%s: got %d, want %d", query, w.Code, code)
+		}
+	}
+
+	// relative paths resolve against the session's directory
+	_ = os.Mkdir(filepath.Join(s.rootAbs, "sub"), 0o755)
+	_ = os.WriteFile(filepath.Join(s.rootAbs, "sub", "inner.zip"), zbuf.Bytes(), 0o644)
+	if resp := execSession(t, s, "arc", "cd sub"); resp.CWD != "/sub" {
+		t.Fatalf("cd sub: %+v", resp)
+	}
+	r := httptest.NewRequest("GET", "/api/archive?path=inner.zip&entry=docs/readme.txt", nil)
+	r.AddCookie(&http.Cookie{Name: "sid", Value: "arc"})
+	w = httptest.NewRecorder()
+	s.handleArchive(w, r)
+	if w.Code != http.StatusOK || w.Body.String() != "zip entry\n" {
+		t.Fatalf("from /sub: %d %q", w.Code, w.Body.String())
+	}
+}
+
+// wsTestClient speaks just enough WebSocket to exercise /api/ws
+type wsTestClient struct {
+	conn net.Conn
+	br   *bufio.Reader
+}
+
+func dialWS(t *testing.T, srv *httptest.Server, origin string) (*wsTestClient, *http.Response) {
+	t.Helper()
+	conn, err := net.Dial("tcp", srv.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	host := srv.Listener.Addr().String()
+	req := "GET /api/ws HTTP/1.1\r\nHost: " + host + "\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n" +
+		"Sec-WebSocket-Version: 13\r\nSec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\nCookie: sid=wstest\r\n"
+	if origin != "" {
+		req += "Origin: " + origin + "\r\n"
+	}
+	if _, err := conn.Write([]byte(req + "\r\n")); err != nil {
+		t.Fatal(err)
+	}
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &wsTestClient{conn: conn, br: br}, resp
+}
+
+func (c *wsTestClient) send(t *testing.T, payload string) {
+	t.Helper()
+	mask := [4]byte{1, 2, 3, 4}
+	frame := []byte{0x81, 0x80 | byte(len(payload))}
+	frame = append(frame, mask[:]...)
+	for i := range len(payload) {
+		frame = append(frame, payload[i]^mask[i%4])
+	}
+	if _, err := c.conn.Write(frame); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func (c *wsTestClient) read(t *testing.T) execResp {
+	t.Helper()
+	_ = c.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var h [2]byte
+	if _, err := io.ReadFull(c.br, h[:]); err != nil {
+		t.Fatal(err)
+	}
+	n := int(h[1] & 0x7f)
+	if n == 126 {
+		var b [2]byte
+		_, _ = io.ReadFull(c.br, b[:])
+		n = int(binary.BigEndian.Uint16(b[:]))
+	}
+	payload := make([]byte, n)
+	if _, err := io.ReadFull(c.br, payload); err != nil {
+		t.Fatal(err)
+	}
+	var resp execResp
+	if err := json.Unmarshal(payload, &resp); err != nil {
+		t.Fatalf("frame %x: %v", h, err)
+	}
+	return resp
+}
+
+func TestHandleWS(t *testing.T) {
+	s := newTestServer(t)
+	for _, name := range []string{"a.txt", "b.txt", "c.txt"} {
+		_ = os.WriteFile(filepath.Join(s.rootAbs, name), []byte("needle here\n"), 0o644)
+	}
+	srv := httptest.NewServer(logRequests(http.HandlerFunc(s.handleWS)))
+	defer srv.Close()
+
+	c, resp := dialWS(t, srv, "")
+	if resp.StatusCode != http.StatusSwitchingProtocols || resp.Header.Get("Sec-WebSocket-Accept") != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Fatalf("handshake: %d %v", resp.StatusCode, resp.Header)
+	}
+
+	c.send(t, `{"input":"pwd"}`)
+	if r := c.read(t); r.Output != "/" || r.Stream {
+		t.Fatalf("pwd: %+v", r)
+	}
+
+	c.send(t, `{"input":"grep -r needle"}`)
+	var streamed int
+	for {
+		r := c.read(t)
+		if !r.Stream {
+			if r.Output != "" {
+				t.Fatalf("final grep response should carry no buffered lines: %q", r.Output)
+			}
+			break
+		}
+		if !strings.Contains(stripANSI(r.Output), ".txt:needle here") {
+			t.Fatalf("streamed line: %q", r.Output)
+		}
+		streamed++
+	}
+	if streamed != 3 {
+		t.Fatalf("streamed %d lines, want 3", streamed)
+	}
+
+	c.send(t, `{"input":"find . -name *.txt"}`)
+	var found []string
+	for {
+		r := c.read(t)
+		if !r.Stream {
+			if r.Output != "" {
+				t.Fatalf("final find response should carry no buffered lines: %q", r.Output)
+			}
+			break
+		}
+		found = append(found, stripANSI(r.Output))
+	}
+	if strings.Join(found, " ") != "/a.txt /b.txt /c.txt" {
+		t.Fatalf("streamed find results: %q", found)
+	}
+
+	if _, resp := dialWS(t, srv, "http://evil.example"); resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("cross-origin handshake: %d", resp.StatusCode)
+	}
+}
+
+func TestFollowFileLongLine(t *testing.T) {
+	s := newTestServer(t)
+	logPath := filepath.Join(s.rootAbs, "nolf.log")
+	if err := os.WriteFile(logPath, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), tailPollInterval*3/2)
+	defer cancel()
+	out := newLineOutput(io.Discard)
+	done := make(chan struct{})
+	go func() {
+		s.followFile(ctx, logPath, "nolf.log", 0, out)
+		close(done)
+	}()
+	// a writer that never ends its line
+	if err := os.WriteFile(logPath, bytes.Repeat([]byte("x"), int(s.catMax)*5/2), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	<-done
+	if len(out.lines) != 2 {
+		t.Fatalf("expected 2 pieces of catMax bytes, got %d lines", len(out.lines))
+	}
+	for _, l := range out.lines {
+		if len(l) != int(s.catMax) {
+			t.Fatalf("piece of %d bytes", len(l))
+		}
+	}
+}
+
+func TestHandleExec_Tail(t *testing.T) {
+	s := newTestServer(t)
+	logPath := filepath.Join(s.rootAbs, "app.log")
+	var content strings.Builder
+	for i := 1; i <= 20; i++ {
+		fmt.Fprintf(&content, "line %d\n", i)
+	}
+	_ = os.WriteFile(logPath, []byte(content.String()), 0o644)
+
+	if out := execJSON(t, s, "tail -n 2 app.log").Output; out != "line 19\nline 20" {
+		t.Fatalf("tail -n 2: %q", out)
+	}
+	if out := execJSON(t, s, "tail -f app.log").Output; !strings.Contains(out, "needs a live connection") {
+		t.Fatalf("tail -f over http: %q", out)
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(s.handleWS))
+	defer srv.Close()
+	c, _ := dialWS(t, srv, "")
+	c.send(t, `{"input":"tail -n 1 -f app.log"}`)
+	if r := c.read(t); r.Output != "line 20" || !r.Stream {
+		t.Fatalf("initial line: %+v", r)
+	}
+
+	f, err := os.OpenFile(logPath, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = f.WriteString("line 21\npartial")
+	_ = f.Close()
+	if r := c.read(t); r.Output != "line 21" || !r.Stream {
+		t.Fatalf("followed line: %+v", r)
+	}
+
+	c.send(t, `{"interrupt":true}`)
+	if r := c.read(t); r.Stream {
+		t.Fatalf("expected final response after interrupt: %+v", r)
+	}
+}
+
+func TestServesPath(t *testing.T) {
+	s := newTestServer(t)
+	for p, want := range map[string]bool{
+		filepath.Join(s.rootAbs, "bookmarks.json"):        true,
+		filepath.Join(s.rootAbs, "sub", "bookmarks.json"): true,
+		filepath.Join(t.TempDir(), "bookmarks.json"):      false,
+		s.rootAbs + "-state.json":                         false,
+	} {
+		if got := s.servesPath(p); got != want {
+			t.Errorf("servesPath(%q) = %v, want %v", p, got, want)
+		}
+	}
+}
+
+func TestServerReloadBookmarks(t *testing.T) {
+	s := newTestServer(t)
+	s.bookmarksPath = filepath.Join(t.TempDir(), "bookmarks.json")
+	execSession(t, s, "hup-session", "bookmark old /")
+
+	// an operator edits the persisted bookmarks while the server runs
+	edited := fmt.Sprintf(`{%q: {"logs": "/var/log"}}`, bookmarkKey("hup-session"))
+	if err := os.WriteFile(s.bookmarksPath, []byte(edited), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	// this session's bookmarks were never saved to the file
+	s.sessions["unsaved"] = &session{id: "unsaved", cwd: "/", bookmarks: map[string]string{"tmp": "/tmp"}}
+	s.reload()
+	bm := s.sessions["hup-session"].getBookmarks()
+	if bm["logs"] != "/var/log" || bm["old"] != "" {
+		t.Fatalf("bookmarks after reload: %v", bm)
+	}
+	if bm := s.sessions["unsaved"].getBookmarks(); bm["tmp"] != "/tmp" {
+		t.Fatalf("reload wiped unsaved bookmarks: %v", bm)
+	}
+}
+
+func TestGlobalIgnore(t *testing.T) {
+	s := newTestServer(t)
+	for _, name := range []string{"server.key", "deep/er/tls.key", "deep/er/notes.txt"} {
+		p := filepath.Join(s.rootAbs, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte("x"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// a .lsgetignore in the same tree is merged, not replaced
+	if err := os.WriteFile(filepath.Join(s.rootAbs, "deep", ".lsgetignore"), []byte("*.txt\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	rule, _ := parseIgnoreRule("*.key")
+	s.ignoreRules = []ignoreRule{rule}
+
+	if out := execJSON(t, s, "ls").Output; strings.Contains(out, "server.key") {
+		t.Fatalf("ls shows a globally ignored file: %q", out)
+	}
+	if out := execJSON(t, s, "find").Output; strings.Contains(out, ".key") || strings.Contains(out, "notes.txt") || !strings.Contains(out, "deep/er") {
+		t.Fatalf("find: %q", out)
+	}
+	// naming an ignored file does not reveal it either
+	for _, cmd := range []string{"ls server.key", "ls -l server.key", "sum server.key", "sum deep/er/notes.txt", "cat server.key"} {
+		if resp := execJSON(t, s, cmd); resp.Error != codeNoEnt {
+			t.Fatalf("%s: %+v", cmd, resp)
+		}
+	}
+	for _, p := range []string{"/server.key", "/deep/er/tls.key"} {
+		w := httptest.NewRecorder()
+		s.handleDownload(w, httptest.NewRequest("GET", "/api/download?path="+p, nil))
+		if w.Code != http.StatusNotFound {
+			t.Fatalf("download of %s: got %d, want 404", p, w.Code)
+		}
+	}
+}
+
+func TestOnlyAllowlist(t *testing.T) {
+	s := newTestServer(t)
+	for _, name := range []string{"os.iso", "os.iso.sha256", "notes.txt", "old/os.iso", "old/private.txt", "public/any.txt", "secret.iso"} {
+		p := filepath.Join(s.rootAbs, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte("x"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s.onlyRules, _ = parsePatterns([]string{"*.iso", "*.sha256", "public/"})
+	s.ignoreRules, _ = parsePatterns([]string{"secret.*"}) // deny wins
+
+	out := execJSON(t, s, "ls")
+	for _, want := range []string{"os.iso", "os.iso.sha256", "old", "public"} {
+		if !strings.Contains(out.Output, want) {
+			t.Fatalf("ls misses %s: %q", want, out.Output)
+		}
+	}
+	if strings.Contains(out.Output, "notes.txt") || strings.Contains(out.Output, "secret.iso") {
+		t.Fatalf("ls shows files outside the allowlist: %q", out.Output)
+	}
+	for _, cmd := range []string{"find", "tree"} {
+		out := execJSON(t, s, cmd).Output
+		if strings.Contains(out, "private.txt") || !strings.Contains(out, "any.txt") {
+			t.Fatalf("%s: %q", cmd, out)
+		}
+	}
+	if got := completeNames(t, s, completeReq{Path: "n"}); len(got) != 0 {
+		t.Fatalf("completion offers hidden files: %v", got)
+	}
+	for p, want := range map[string]int{"/notes.txt": http.StatusNotFound, "/secret.iso": http.StatusNotFound, "/old/os.iso": http.StatusOK} {
+		w := httptest.NewRecorder()
+		s.handleDownload(w, httptest.NewRequest("GET", "/api/download?path="+p, nil))
+		if w.Code != want {
+			t.Fatalf("download of %s: got %d, want %d", p, w.Code, want)
+		}
+	}
+}
+
+func TestHandleExec_ErrorCodes(t *testing.T) {
+	s := newTestServer(t)
+	if err := os.Mkdir(filepath.Join(s.rootAbs, "dir"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(s.rootAbs, "big.txt"), make([]byte, s.catMax+1), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	for line, want := range map[string]string{
+		"cat nope.txt":   "ENOENT",
+		"cat dir":        "EISDIR",
+		"cd big.txt":     "ENOTDIR",
+		"cat":            "EINVAL",
+		`cat "open`:      "EINVAL",
+		"cat big.txt":    "EFBIG",
+		"mkdir new":      "EROFS",
+		"frobnicate":     "ENOSYS",
+		"cat ../../etc":  "ENOENT",
+		"ls /nope /also": "ENOENT",
+	} {
+		resp := execJSON(t, s, line)
+		if resp.Error != want || resp.Code != errnoNumbers[want] || resp.Output == "" {
+			t.Errorf("%q: error %q code %d (output %q), want %s", line, resp.Error, resp.Code, resp.Output, want)
+		}
+	}
+	if resp := execJSON(t, s, "pwd"); resp.Error != "" || resp.Code != 0 {
+		t.Fatalf("success carries an error: %+v", resp)
+	}
+
+	s.writable = true
+	resp := execJSON(t, s, "mkdir dir fresh")
+	if resp.Error != "EEXIST" || !strings.Contains(resp.Output, "File exists") {
+		t.Fatalf("partial mkdir failure: %+v", resp)
+	}
+}
+
+func TestHandleExec_Whoami(t *testing.T) {
+	s := newTestServer(t)
+	if err := os.Mkdir(filepath.Join(s.rootAbs, "docs"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	sess := s.getSession(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	sess.chdir("/docs")
+	out := whoami(sess, "192.0.2.1")
+	for _, want := range []string{"anonymous", sess.id[:8] + "…", "/docs", "previous", "192.0.2.1"} {
+		if !strings.Contains(out, want) {
+			t.Fatalf("whoami misses %q: %q", want, out)
+		}
+	}
+	if strings.Contains(out, sess.id) || strings.Contains(out, s.rootAbs) {
+		t.Fatalf("whoami leaks the session id or host path: %q", out)
+	}
+	if out := execJSON(t, s, "whoami").Output; !strings.Contains(out, "session") {
+		t.Fatalf("whoami command: %q", out)
+	}
+}
+
+func TestHandleExec_Env(t *testing.T) {
+	s := newTestServer(t)
+	s.maxSum = 2048
+	out := execJSON(t, s, "env").Output
+	for _, want := range []string{filepath.Base(s.rootAbs), "read-only", "catmax", "4.0K", "maxsum", "2.0K", "maxzipbytes", "unlimited", "logging"} {
+		if !strings.Contains(out, want) {
+			t.Fatalf("env misses %q: %q", want, out)
+		}
+	}
+	if strings.Contains(out, s.rootAbs) {
+		t.Fatalf("env leaks the host path: %q", out)
+	}
+}
+
+func TestHandleExec_Df(t *testing.T) {
+	s := newTestServer(t)
+	if err := os.Mkdir(filepath.Join(s.rootAbs, "sub"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, _, err := diskUsage(s.rootAbs); err != nil {
+		resp := execJSON(t, s, "df")
+		if resp.Error != "ENOSYS" {
+			t.Fatalf("df on unsupported platform: %+v", resp)
+		}
+		return
+	}
+	out := execJSON(t, s, "df").Output
+	if !strings.Contains(out, "Avail") || !strings.Contains(out, "%") || !strings.HasSuffix(out, " /") {
+		t.Fatalf("unexpected df output: %q", out)
+	}
+	if out := execJSON(t, s, "df sub").Output; !strings.HasSuffix(out, " /sub") {
+		t.Fatalf("df sub: %q", out)
+	}
+	if resp := execJSON(t, s, "df missing"); resp.Error != "ENOENT" {
+		t.Fatalf("df missing: %+v", resp)
+	}
+	if strings.Contains(out, s.rootAbs) {
+		t.Fatalf("df leaks the host path: %q", out)
+	}
+}
+
+func TestFormatDiskUsage(t *testing.T) {
+	out := formatDiskUsage("/", 1000, 0, 0)
+	if !strings.Contains(out, "100%") {
+		t.Fatalf("full disk should read 100%%: %q", out)
+	}
+	out = formatDiskUsage("/", 1000, 500, 499)
+	if !strings.Contains(out, "51%") {
+		t.Fatalf("Use%% should round up: %q", out)
+	}
+	if out := formatDiskUsage("/", 0, 0, 0); !strings.Contains(out, " - ") {
+		t.Fatalf("empty filesystem: %q", out)
+	}
+}
+
+func TestHandleAssets(t *testing.T) {
+	s := newTestServer(t)
+	s.assetsAbs = t.TempDir()
+	if err := os.WriteFile(filepath.Join(s.assetsAbs, "custom.css"), []byte("body{}"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(s.assetsAbs, ".secret"), []byte("x"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	outside := filepath.Join(s.rootAbs, "outside.txt")
+	if err := os.WriteFile(outside, []byte("nope"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(outside, filepath.Join(s.assetsAbs, "link.txt")); err != nil {
+		t.Fatal(err)
+	}
+
+	get := func(p string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "/", nil)
+		r.URL.Path = p
+		s.handleAssets(w, r)
+		return w
+	}
+	w := get("/assets/custom.css")
+	if w.Code != 200 || w.Body.String() != "body{}" || !strings.HasPrefix(w.Header().Get("Content-Type"), "text/css") {
+		t.Fatalf("custom.css: %d %q %q", w.Code, w.Header().Get("Content-Type"), w.Body.String())
+	}
+	for _, p := range []string{"/assets/../outside.txt", "/assets/link.txt", "/assets/.secret", "/assets/", "/assets/missing.png"} {
+		if w := get(p); w.Code != 404 {
+			t.Fatalf("%s: expected 404, got %d %q", p, w.Code, w.Body.String())
+		}
+	}
+
+	out := string(s.processHTMLTemplate([]byte("{{CUSTOM_ASSETS}}"), "/"))
+	if !strings.Contains(out, `<link rel="stylesheet" href="/assets/custom.css?v=`) || strings.Contains(out, "custom.js") {
+		t.Fatalf("custom assets: %q", out)
+	}
+	s.assetsAbs = ""
+	if out := string(s.processHTMLTemplate([]byte("{{CUSTOM_ASSETS}}"), "/")); out != "" {
+		t.Fatalf("no assets expected without -assets: %q", out)
+	}
+}
+
+func TestMounts(t *testing.T) {
+	docs, media := t.TempDir(), t.TempDir()
+	if err := os.WriteFile(filepath.Join(docs, "guide.txt"), []byte("read the guide"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(filepath.Join(media, "img"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(media, "img", "cat.png"), []byte("png"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	// a link from one mount into another leaves its jail
+	if err := os.Symlink(filepath.Join(media, "img"), filepath.Join(docs, "img")); err != nil {
+		t.Fatal(err)
+	}
+	var mounts []mount
+	for _, spec := range []string{"docs=" + docs, "media=" + media} {
+		m, err := parseMount(spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		mounts = append(mounts, m)
+	}
+	root, err := newMountRoot(mounts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = os.RemoveAll(root) })
+	s := newServer(root, 4096, "", "")
+	s.mounts = mounts
+
+	if out := stripANSI(execJSON(t, s, "ls -1").Output); out != "docs/\nmedia/" {
+		t.Fatalf("ls /: %q", out)
+	}
+	if out := execJSON(t, s, "cat /docs/guide.txt").Output; out != "read the guide" {
+		t.Fatalf("cat: %q", out)
+	}
+	for _, cmd := range []string{"cat /docs/img/cat.png", "cat /other/guide.txt", "cd /docs/../../etc"} {
+		if resp := execJSON(t, s, cmd); resp.Code == 0 {
+			t.Fatalf("%s should fail: %+v", cmd, resp)
+		}
+	}
+	if out := stripANSI(execJSON(t, s, "find / -name cat.png").Output); out != "/media/img/cat.png" {
+		t.Fatalf("find: %q", out)
+	}
+	if out := stripANSI(execJSON(t, s, "grep -r guide /").Output); !strings.Contains(out, "/docs/guide.txt") {
+		t.Fatalf("grep -r: %q", out)
+	}
+	if out := stripANSI(execJSON(t, s, "tree").Output); !strings.Contains(out, "cat.png") || !strings.Contains(out, "guide.txt") {
+		t.Fatalf("tree: %q", out)
+	}
+	files, err := s.collectFilesFromDirectory("/media", filepath.Join(root, "media"))
+	if err != nil || len(regularFiles(files)) != 1 {
+		t.Fatalf("walk into mount: %v %+v", err, files)
+	}
+
+	if s.ignoreRules, err = parsePatterns([]string{"/docs/guide.txt"}); err != nil {
+		t.Fatal(err)
+	}
+	if resp := execJSON(t, s, "cat /docs/guide.txt"); resp.Code == 0 {
+		t.Fatalf("-ignore should apply from the top of the tree: %+v", resp)
+	}
+	s.ignoreRules = nil
+
+	s.writable = true
+	for _, cmd := range []string{"rm -r /docs", "mkdir /new", "mv /docs/guide.txt /"} {
+		if resp := execJSON(t, s, cmd); resp.Code == 0 {
+			t.Fatalf("%s should be refused: %+v", cmd, resp)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(docs, "guide.txt")); err != nil {
+		t.Fatal("mounted file was touched")
+	}
+
+	for _, spec := range []string{"docs", "=" + docs, ".hidden=" + docs, "a/b=" + docs, "x=" + filepath.Join(docs, "guide.txt")} {
+		if _, err := parseMount(spec); err == nil {
+			t.Fatalf("parseMount(%q) should fail", spec)
+		}
+	}
+	if _, err := newMountRoot([]mount{mounts[0], mounts[0]}); err == nil {
+		t.Fatal("duplicate mount names should fail")
+	}
+}
+
+func TestAccessFile(t *testing.T) {
+	s := newTestServer(t)
+	private := filepath.Join(s.rootAbs, "private")
+	if err := os.MkdirAll(filepath.Join(private, "deep"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(private, "deep", "secret.txt"), []byte("s3cret"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(private, accessFile), []byte("# office only\nallow 10.0.0.0/8\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	exec := func(input, remote string, header http.Header) execResp {
+		t.Helper()
+		body, _ := json.Marshal(execReq{Input: input})
+		r := httptest.NewRequest("POST", "/api/exec", strings.NewReader(string(body)))
+		r.RemoteAddr = remote
+		for k, v := range header {
+			r.Header[k] = v
+		}
+		w := httptest.NewRecorder()
+		s.handleExec(w, r)
+		var resp execResp
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	outsider := "192.0.2.1:1234"
+	for _, cmd := range []string{"cd private/deep", "ls private", "cat private/deep/secret.txt", "cat private/*/secret.txt"} {
+		if resp := exec(cmd, outsider, nil); resp.Error != "EACCES" {
+			t.Fatalf("%s from outside: %+v", cmd, resp)
+		}
+	}
+	if out := exec("grep -r s3cret /", outsider, nil).Output; strings.Contains(out, "s3cret") {
+		t.Fatalf("grep -r entered a restricted directory: %q", out)
+	}
+	if out := exec("find / -name secret.txt", outsider, nil).Output; strings.Contains(out, "secret.txt") {
+		t.Fatalf("find entered a restricted directory: %q", out)
+	}
+	// brace alternatives are checked one by one, by get and by the URL it returns
+	if err := os.WriteFile(filepath.Join(s.rootAbs, "pub.txt"), []byte("public"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	for _, pattern := range []string{"{private/deep/secret.txt,pub.txt}", "{private,pub.txt}"} {
+		if resp := exec("get "+pattern, outsider, nil); resp.Error != "EACCES" {
+			t.Fatalf("get %s from outside: %+v", pattern, resp)
+		}
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "/api/download?cwd=/&pattern="+url.QueryEscape(pattern), nil)
+		r.RemoteAddr = outsider
+		s.handleDownload(w, r)
+		if w.Code != http.StatusForbidden || strings.Contains(w.Body.String(), "s3cret") {
+			t.Fatalf("download of %s from outside: %d", pattern, w.Code)
+		}
+	}
+	if files := s.accessibleFiles([]fileInfo{{realPath: filepath.Join(private, "deep", "secret.txt")}, {realPath: filepath.Join(s.rootAbs, "pub.txt")}}, net.ParseIP("192.0.2.1")); len(files) != 1 {
+		t.Fatalf("accessibleFiles kept a restricted file: %+v", files)
+	}
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/private/deep/secret.txt", nil)
+	r.RemoteAddr = outsider
+	s.handleIndex(w, r)
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("direct URL from outside: %d", w.Code)
+	}
+
+	office := "10.1.2.3:1234"
+	if out := exec("cat private/deep/secret.txt", office, nil).Output; out != "s3cret" {
+		t.Fatalf("cat from the office: %q", out)
+	}
+	if resp := exec("cat private/"+accessFile, office, nil); resp.Code == 0 {
+		t.Fatalf("access rules must not be served: %+v", resp)
+	}
+
+	// X-Forwarded-For only counts from trusted proxies
+	spoofed := http.Header{"X-Forwarded-For": {"10.1.2.3"}}
+	if resp := exec("cat private/deep/secret.txt", outsider, spoofed); resp.Error != "EACCES" {
+		t.Fatalf("spoofed X-Forwarded-For was believed: %+v", resp)
+	}
+	network, err := parseNetwork("192.0.2.0/24")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.trustedProxies = []*net.IPNet{network}
+	if out := exec("cat private/deep/secret.txt", outsider, spoofed).Output; out != "s3cret" {
+		t.Fatalf("trusted proxy: %q", out)
+	}
+
+	// broken rules admit no one
+	if err := os.WriteFile(filepath.Join(private, accessFile), []byte("allow 10.0.0.0/33\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if resp := exec("cat private/deep/secret.txt", office, nil); resp.Error != "EACCES" {
+		t.Fatalf("invalid rules should deny: %+v", resp)
+	}
+}
+
+func TestAccessAdmits(t *testing.T) {
+	writeTemp := func(t *testing.T, content string) string {
+		file := filepath.Join(t.TempDir(), accessFile)
+		if err := os.WriteFile(file, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return file
+	}
+	rules, err := parseAccessFile(writeTemp(t, "deny 10.0.0.5\nallow 10.0.0.0/8\nallow 2001:db8::/32\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for ip, want := range map[string]bool{"10.0.0.5": false, "10.9.9.9": true, "2001:db8::1": true, "192.0.2.1": false} {
+		if got := accessAdmits(rules, net.ParseIP(ip)); got != want {
+			t.Errorf("%s: got %v, want %v", ip, got, want)
+		}
+	}
+	rules, err = parseAccessFile(writeTemp(t, "deny 192.0.2.0/24\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !accessAdmits(rules, net.ParseIP("10.0.0.1")) || accessAdmits(rules, net.ParseIP("192.0.2.7")) {
+		t.Fatal("a deny-only list should admit everyone else")
+	}
+	if _, err := parseAccessFile(writeTemp(t, "permit all\n")); err == nil {
+		t.Fatal("unknown keyword should fail")
+	}
+}
+
+func TestHandleExec_LsOwner(t *testing.T) {
+	s := newTestServer(t)
+	f := filepath.Join(s.rootAbs, "mine.txt")
+	if err := os.WriteFile(f, []byte("x"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	info, _ := os.Stat(f)
+	owner, group, ok := fileOwner(info)
+	if !ok {
+		t.Skip("file ownership not available on this platform")
+	}
+	cols := fmt.Sprintf("%-8s %-8s ", owner, group)
+	for _, in := range []string{"ls -g", "ls --owner", "ls -lg mine.txt"} {
+		if out := stripANSI(execJSON(t, s, in).Output); !strings.Contains(out, cols) {
+			t.Fatalf("%s missing owner %q: %q", in, cols, out)
+		}
+	}
+	if out := stripANSI(execJSON(t, s, "ls -l").Output); strings.Contains(out, cols) {
+		t.Fatalf("ls -l should not show owners: %q", out)
+	}
+}
+
+func TestHandleExec_LsSymlinkTargets(t *testing.T) {
+	s := newTestServer(t)
+	if err := os.WriteFile(filepath.Join(s.rootAbs, "a.txt"), []byte("alpha"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	outside := filepath.Join(t.TempDir(), "secret.txt")
+	if err := os.WriteFile(outside, []byte("s"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	for name, target := range map[string]string{
+		"rel":     "a.txt",
+		"abs":     filepath.Join(s.rootAbs, "a.txt"),
+		"escapes": outside,
+	} {
+		if err := os.Symlink(target, filepath.Join(s.rootAbs, name)); err != nil {
+			t.Skip("symlinks not supported")
+		}
+	}
+	out := stripANSI(execJSON(t, s, "ls -l").Output)
+	if !strings.Contains(out, "rel -> a.txt") || !strings.Contains(out, "abs -> a.txt") {
+		t.Fatalf("ls -l should show link targets: %q", out)
+	}
+	if strings.Contains(out, "secret") || strings.Contains(out, "escapes ->") {
+		t.Fatalf("ls -l revealed a target outside the root: %q", out)
+	}
+	if strings.Contains(stripANSI(execJSON(t, s, "ls").Output), "->") {
+		t.Fatal("short listing should not show link targets")
+	}
+	if out := stripANSI(execJSON(t, s, "ls -l rel").Output); !strings.HasSuffix(out, "rel -> a.txt") {
+		t.Fatalf("ls -l on a link: %q", out)
+	}
+}
+
+func TestHandleExec_VersionSort(t *testing.T) {
+	s := newTestServer(t)
+	for _, n := range []string{"v10", "v2", "v1"} {
+		if err := os.MkdirAll(filepath.Join(s.rootAbs, n, "r10"), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Mkdir(filepath.Join(s.rootAbs, n, "r9"), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if out := stripANSI(execJSON(t, s, "ls -1v").Output); out != "v1/\nv2/\nv10/" {
+		t.Fatalf("ls -v: %q", out)
+	}
+	if out := stripANSI(execJSON(t, s, "ls -1").Output); out != "v1/\nv10/\nv2/" {
+		t.Fatalf("ls should stay lexical by default: %q", out)
+	}
+	tree := stripANSI(execJSON(t, s, "tree -v").Output)
+	if !(strings.Index(tree, "v2") < strings.Index(tree, "v10") && strings.Index(tree, "r9") < strings.Index(tree, "r10")) {
+		t.Fatalf("tree -v: %q", tree)
+	}
+	find := stripANSI(execJSON(t, s, "find / -type d -v").Output)
+	if !(strings.Index(find, "/v2/") < strings.Index(find, "/v10/") && strings.Index(find, "/v1/r9/") < strings.Index(find, "/v1/r10/")) {
+		t.Fatalf("find -v: %q", find)
+	}
+}
+
+func TestHandleExec_GroupDirectoriesFirst(t *testing.T) {
+	s := newTestServer(t)
+	for _, n := range []string{"a.txt", "c.txt"} {
+		if err := os.WriteFile(filepath.Join(s.rootAbs, n), []byte("x"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, n := range []string{"b", "d"} {
+		if err := os.Mkdir(filepath.Join(s.rootAbs, n), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ls := func(input string) string {
+		t.Helper()
+		return stripANSI(execSession(t, s, "dirs", input).Output)
+	}
+	if got := ls("ls -1"); got != "a.txt\nb/\nc.txt\nd/" {
+		t.Fatalf("default order: %q", got)
+	}
+	if got := ls("ls -1 --group-directories-first"); got != "b/\nd/\na.txt\nc.txt" {
+		t.Fatalf("--group-directories-first: %q", got)
+	}
+	if resp := execSession(t, s, "dirs", "set dirsfirst on"); resp.Output != "" {
+		t.Fatalf("set dirsfirst: %q", resp.Output)
+	}
+	if got := ls("ls -1"); got != "b/\nd/\na.txt\nc.txt" {
+		t.Fatalf("session default: %q", got)
+	}
+	if resp := execSession(t, s, "dirs", "set dirsfirst maybe"); !strings.Contains(resp.Output, "usage") {
+		t.Fatalf("invalid value: %q", resp.Output)
+	}
+}
+
+func TestHandleExec_Strings(t *testing.T) {
+	s := newTestServer(t)
+	bin := []byte("\x7fELF\x00\x01\x02GCC: (GNU) 13.2\x00ab\x00\xffusr/lib/ld.so\x00tail")
+	if err := os.WriteFile(filepath.Join(s.rootAbs, "a.out"), bin, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if out := execJSON(t, s, "strings a.out").Output; out != "GCC: (GNU) 13.2\nusr/lib/ld.so\ntail" {
+		t.Fatalf("strings: %q", out)
+	}
+	if out := execJSON(t, s, "strings -n 2 a.out").Output; !strings.Contains(out, "\nab\n") {
+		t.Fatalf("strings -n 2: %q", out)
+	}
+	if out := execJSON(t, s, "strings -n 0 a.out").Output; !strings.Contains(out, "invalid minimum") {
+		t.Fatalf("strings -n 0: %q", out)
+	}
+	s.catMax = 20
+	if out := stripANSI(execJSON(t, s, "strings a.out").Output); !strings.HasPrefix(out, "GCC: (GNU) 13\n") || !strings.Contains(out, "only the first 20") {
+		t.Fatalf("strings should stop at catMax: %q", out)
+	}
+}
+
+func TestHandleExec_WalkTimeout(t *testing.T) {
+	s := newTestServer(t)
+	if err := os.MkdirAll(filepath.Join(s.rootAbs, "a", "b"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(s.rootAbs, "a", "b", "needle.txt"), []byte("needle\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	for _, in := range []string{"find / -name needle.txt", "grep -r needle /", "tree /"} {
+		if out := stripANSI(execJSON(t, s, in).Output); strings.Contains(out, "timed out") || !strings.Contains(out, "needle") {
+			t.Fatalf("%s within the timeout: %q", in, out)
+		}
+	}
+	s.walkTimeout = time.Nanosecond
+	for _, in := range []string{"find / -name needle.txt", "grep -r needle /", "tree /"} {
+		cmd := strings.Fields(in)[0]
+		if out := stripANSI(execJSON(t, s, in).Output); !strings.Contains(out, cmd+": search timed out, results are partial") {
+			t.Fatalf("%s past the timeout: %q", in, out)
+		}
+	}
+}
+
+func TestHandleExec_Du(t *testing.T) {
+	s := newTestServer(t)
+	files := map[string]int{
+		"big/a.bin":       3000,
+		"big/sub/b.bin":   2000,
+		"small/c.txt":     100,
+		"main.go":         700,
+		".hidden/x.bin":   9000,
+		"big/.cache.bin":  9000,
+		"medium/d.tar.gz": 1500,
+	}
+	for name, size := range files {
+		p := filepath.Join(s.rootAbs, name)
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, bytes.Repeat([]byte("x"), size), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	out := stripANSI(execJSON(t, s, "du").Output)
+	want := "      5000  big/\n      1500  medium/\n       700  main.go\n       100  small/\n      7300  total"
+	if out != want {
+		t.Fatalf("du:\n%s\nwant:\n%s", out, want)
+	}
+	if out := stripANSI(execJSON(t, s, "du --top 2").Output); out != "      5000  big/\n      1500  medium/\n      7300  total" {
+		t.Fatalf("du --top 2: %q", out)
+	}
+	if out := stripANSI(execJSON(t, s, "du -s big").Output); out != "      5000  big/" {
+		t.Fatalf("du -s big: %q", out)
+	}
+	if out := stripANSI(execJSON(t, s, "du -sh m*").Output); out != "      1.5K  medium/\n      700B  main.go\n      2.1K  total" {
+		t.Fatalf("du -sh m*: %q", out)
+	}
+	if out := execJSON(t, s, "du").Output; !strings.Contains(out, colorYellow+"main.go"+colorReset) {
+		t.Fatalf("du should color names by type: %q", out)
+	}
+	if out := execJSON(t, s, "du --top 0").Output; !strings.Contains(out, "positive number") {
+		t.Fatalf("du --top 0: %q", out)
+	}
+}
+
+func TestHandleExec_SumManifest(t *testing.T) {
+	s := newTestServer(t)
+	for name, content := range map[string]string{
+		"rel/app.tar.gz":   "app",
+		"rel/docs/README":  "docs",
+		"rel/.hidden":      "secret",
+		"rel/.git/HEAD":    "ref",
+		"rel/signing.key":  "key",
+		"rel/.lsgetignore": "*.key\n",
+	} {
+		p := filepath.Join(s.rootAbs, name)
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	line := func(sum [32]byte, name string) string { return hex.EncodeToString(sum[:]) + "  " + name }
+	want := line(sha256.Sum256([]byte("app")), "app.tar.gz") + "\n" + line(sha256.Sum256([]byte("docs")), "docs/README")
+	if out := execJSON(t, s, "sum -r rel").Output; out != want {
+		t.Fatalf("sum -r:\n%s\nwant:\n%s", out, want)
+	}
+	md5Line := fmt.Sprintf("%x  app.tar.gz", md5.Sum([]byte("app")))
+	if out := execJSON(t, s, "checksum -r -a md5 rel").Output; !strings.HasPrefix(out, md5Line+"\n") {
+		t.Fatalf("sum -a md5: %q", out)
+	}
+	for input, msg := range map[string]string{
+		"sum -r -a crc32 rel":        "unknown algorithm",
+		"sum -r rel/app.tar.gz":      "needs a directory",
+		"sum -a md5 rel/docs/README": "only applies",
+		"sum -r rel/.git":            "no such file",
+	} {
+		if out := execJSON(t, s, input).Output; !strings.Contains(out, msg) {
+			t.Fatalf("%s: %q", input, out)
+		}
+	}
+	s.maxSum = 3
+	if out := stripANSI(execJSON(t, s, "sum -r rel").Output); !strings.Contains(out, "sum: docs/README: file too large") {
+		t.Fatalf("expected the oversized file to be reported: %q", out)
+	}
+
+	// -maxsum also bounds the manifest as a whole
+	s.maxSum = 5
+	resp := execJSON(t, s, "sum -r rel")
+	if out := stripANSI(resp.Output); !strings.Contains(out, "app.tar.gz\nsum: stopped at the") || strings.Contains(out, "docs/README") {
+		t.Fatalf("total limit: %q", out)
+	}
+	if resp.Error != codeTooLarge {
+		t.Fatalf("total limit code: %+v", resp)
+	}
+
+	// the walk ends at -walk-timeout like find and grep -r
+	s.maxSum = 0
+	s.walkTimeout = time.Nanosecond
+	if out := stripANSI(execJSON(t, s, "sum -r rel").Output); !strings.Contains(out, "sum: search timed out") {
+		t.Fatalf("timeout: %q", out)
+	}
+}
+
+func TestSingleFileCommandsRefuseAlike(t *testing.T) {
+	s := newTestServer(t)
+	if err := os.WriteFile(filepath.Join(s.rootAbs, ".lsgetignore"), []byte("*.key\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(s.rootAbs, "server.key"), []byte("k"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(filepath.Join(s.rootAbs, "dir"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for _, cmd := range []string{"cat %s", "sum %s", "preview %s", "mediainfo %s", "exif %s", "diff %[1]s %[1]s", "zcat %s",
+		"unzip -l %s", "tar -t %s", "tail %s", "strings %s", "view %s", "url %s"} {
+		for operand, code := range map[string]string{"server.key": codeNoEnt, "missing.txt": codeNoEnt, "dir": codeIsDir} {
+			in := fmt.Sprintf(cmd, operand)
+			if resp := execJSON(t, s, in); resp.Error != code {
+				t.Errorf("%s: got %+v, want %s", in, resp, code)
+			}
+		}
+	}
+}
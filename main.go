@@ -1,48 +1,149 @@
 package main
 
 import (
+	"archive/tar"
 	"archive/zip"
 	"bufio"
 	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"container/list"
 	"context"
 	"crypto/md5"
 	"crypto/rand"
+	"crypto/sha1"
 	"crypto/sha256"
+	"crypto/sha512"
 	_ "embed"
+	"encoding/base64"
+	"encoding/binary"
 	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
+	"hash"
 	"html"
 	"html/template"
 	"io"
+	"io/fs"
+	"maps"
 	"mime"
+	"net"
 	"net/http"
 	"net/url"
 	"os"
 	"os/signal"
 	"path"
 	"path/filepath"
+	"regexp"
+	"runtime"
+	"runtime/debug"
+	"slices"
 	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"syscall"
 	"time"
+	"unicode"
+	"unicode/utf16"
 	"unicode/utf8"
 )
 
 var version = "dev"
 
+// buildDate is set with -ldflags "-X main.buildDate=..."; without it the
+// commit time recorded by go build is reported instead
+var buildDate = ""
+
 // Indirections for testability
 var (
 	exitFunc       = os.Exit
 	listenAndServe = func(srv *http.Server) error { return srv.ListenAndServe() }
 	pidFile        = ""
-	logFile        = ""
+	mountRoot      = "" // temporary directory of -mount links, removed on exit
+	logFile        = "" // access log written by logRequests
+	appLogFile     = "" // operational messages, only when separate from the access log
 	logMutex       sync.Mutex
+	logFormat      = logFormatCombined
+	consoleLevel   = logLevelInfo
+	maxLineBytes   = 4 * 1024 * 1024 // longest line grep and .lsgetignore parsing accept
+)
+
+// logf prints an operational message to stdout and, when configured, appends
+// it with a timestamp to the application log (never to the access log)
+func logf(format string, args ...any) {
+	msg := fmt.Sprintf(format, args...)
+	fmt.Print(msg)
+	if appLogFile == "" {
+		return
+	}
+	logMutex.Lock()
+	defer logMutex.Unlock()
+	if logDir := filepath.Dir(appLogFile); logDir != "" {
+		_ = os.MkdirAll(logDir, 0755)
+	}
+	f, err := os.OpenFile(appLogFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return
+	}
+	defer func() { _ = f.Close() }()
+	_, _ = fmt.Fprintf(f, "%s %s\n", time.Now().Format(time.RFC3339), strings.TrimSpace(msg))
+}
+
+// ===== Log levels =====
+
+// logLevel controls which requests logRequests prints to stdout
+type logLevel int
+
+const (
+	logLevelError logLevel = iota
+	logLevelWarn
+	logLevelInfo
+	logLevelDebug
 )
 
+func (l logLevel) String() string {
+	switch l {
+	case logLevelError:
+		return "error"
+	case logLevelWarn:
+		return "warn"
+	case logLevelDebug:
+		return "debug"
+	default:
+		return "info"
+	}
+}
+
+// parseLogLevel converts a -loglevel value into a logLevel
+func parseLogLevel(s string) (logLevel, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "error":
+		return logLevelError, nil
+	case "warn", "warning":
+		return logLevelWarn, nil
+	case "info", "":
+		return logLevelInfo, nil
+	case "debug":
+		return logLevelDebug, nil
+	}
+	return logLevelInfo, fmt.Errorf("unknown log level %q (use error, warn, info or debug)", s)
+}
+
+// statusLogLevel maps an HTTP status to the level a request is logged at
+func statusLogLevel(code int) logLevel {
+	switch {
+	case code >= 500:
+		return logLevelError
+	case code >= 400:
+		return logLevelWarn
+	default:
+		return logLevelInfo
+	}
+}
+
 // ===== ANSI Color Codes =====
 
 const (
@@ -68,34 +169,217 @@ const (
 	colorBold = "\033[1m"
 )
 
-const helpTpl = `Welcome to <span class="ps1">lsget</span> <span style="color: #666;">v{{.Version}}</span>!
+const helpTpl = `Welcome to <span class="ps1">{{html .Brand}}</span> <span style="color: #666;">v{{.Version}}</span>!
 <span style="color: #888;">Type one of the commands below to get started.</span>
 <br/>
 
 <span style="color: #aaa;">Available commands:</span>
-• <strong>help</strong> - <span style="color: #bbb;">print this message again</span>
-• <strong>pwd</strong> - <span style="color: #bbb;">print working directory</span>
-• <strong>ls</strong> <span style="color: #888;">[-l] [-h]</span>|<strong>dir</strong> <span style="color: #888;">[-l] [-h]</span> - <span style="color: #bbb;">list files (-h for human readable sizes)</span>
-• <strong>cd</strong> <span style="color: #888;">DIR</span> - <span style="color: #bbb;">change directory</span>
-• <strong>cat</strong> <span style="color: #888;">FILE</span> - <span style="color: #bbb;">view a text file</span>
-• <strong>sum</strong>|<strong>checksum</strong> <span style="color: #888;">FILE</span> - <span style="color: #bbb;">print MD5 and SHA256 checksums</span>
-• <strong>get</strong>|<strong>wget</strong>|<strong>download</strong> <span style="color: #888;">FILE</span> - <span style="color: #bbb;">download a file</span>
-• <strong>url</strong>|<strong>share</strong> <span style="color: #888;">FILE</span> - <span style="color: #bbb;">get shareable URL (copies to clipboard)</span>
-• <strong>tree</strong> <span style="color: #888;">[-L&lt;DEPTH&gt;] [-a]</span> - <span style="color: #bbb;">directory structure</span>
-• <strong>find</strong> <span style="color: #888;">[PATH] [-name PATTERN] [-type f|d]</span> - <span style="color: #bbb;">search for files and directories</span>
-• <strong>grep</strong> <span style="color: #888;">[-r] [-i] [-n] PATTERN [FILE...]</span> - <span style="color: #bbb;">search for text patterns in files</span>
+{{.Commands}}
 
 <br/><br/>
 <span style="color: #aaa;">Hint: to autocomplete filenames and dir use</span> <kbd class="ps1">Tab</kbd>
 `
 
-func renderHelp() string {
+// command is one entry of the command table, from which help, completion
+// and -enable/-disable are all derived
+type command struct {
+	names   []string // the command and the aliases help lists with it
+	hidden  []string // aliases that work but help does not advertise
+	usage   string   // arguments, as plain text
+	summary string   // one-line description, as HTML
+	flags   []string // options offered by completion
+	writes  bool     // only available with -writable
+}
+
+// commands lists every command in the order help shows them
+var commands = []command{
+	{names: []string{"help"}, summary: "print this message again"},
+	{names: []string{"pwd"}, summary: "print working directory"},
+	{names: []string{"whoami"}, summary: "show your session, directory and settings"},
+	{names: []string{"env"}, summary: "show the limits and settings of the server"},
+	{names: []string{"df"}, usage: "[DIR]", summary: "show size and free space of the disk holding the files"},
+	{names: []string{"du"}, usage: "[-s] [-h] [--top N] [PATH...]", summary: "show what takes up space, largest first",
+		flags: []string{"--top", "-h", "-s"}},
+	{names: []string{"ls", "dir"}, usage: "[-l] [-g] [-h] [-1] [-t|-S|-v] [--group-directories-first] [--offset N] [--limit N]",
+		summary: "list files (-g owner and group, -h human readable sizes, -1 one per line, -t/-S/-v sort by time/size/version)",
+		flags:   []string{"--group-directories-first", "--limit", "--offset", "--owner", "-1", "-S", "-a", "-g", "-h", "-l", "-t", "-v"}},
+	{names: []string{"cd"}, usage: "DIR|-", summary: "change directory (- for the previous one)"},
+	{names: []string{"bookmark"}, hidden: []string{"bm"}, usage: "[-l] [-d] NAME [DIR]",
+		summary: "save a directory, then <strong>cd</strong> NAME to jump there", flags: []string{"-d", "-l"}},
+	{names: []string{"cat"}, usage: "[--head] FILE", summary: "view a text file (--head shows the start of one too large)",
+		flags: []string{"--head"}},
+	{names: []string{"sum", "checksum"}, usage: "FILE | -r [-a ALGO] DIR",
+		summary: "print MD5 and SHA256 checksums, or a SHA256SUMS manifest of DIR", flags: []string{"-a", "-r"}},
+	{names: []string{"get", "wget", "download"}, hidden: []string{"rget"}, usage: "[-0] FILE",
+		summary: "download a file (-0 zips without compression)", flags: []string{"--store", "-0"}},
+	{names: []string{"preview", "head"}, usage: "FILE", summary: "show the first lines of a file, or its type"},
+	{names: []string{"mediainfo", "meta"}, usage: "FILE", summary: "show image size or audio duration and bitrate"},
+	{names: []string{"exif"}, usage: "FILE", summary: "show camera, date and exposure of a JPEG photo"},
+	{names: []string{"diff"}, usage: "FILE1 FILE2", summary: "compare two text files"},
+	{names: []string{"zcat", "bzcat"}, usage: "FILE", summary: "print a gzip or bzip2 compressed text file"},
+	{names: []string{"unzip"}, usage: "-l FILE.zip", summary: "list the contents of a zip archive", flags: []string{"-l"}},
+	{names: []string{"tar"}, usage: "-t FILE.tar[.gz]", summary: "list the contents of a tarball", flags: []string{"-t", "-tf", "-tvf"}},
+	{names: []string{"tail"}, usage: "[-n N] [-f] FILE", summary: "show the end of a file; -f keeps following it (Ctrl+C stops)",
+		flags: []string{"-f", "-n"}},
+	{names: []string{"strings"}, usage: "[-n MIN] FILE", summary: "print the runs of readable text in a binary file",
+		flags: []string{"-n"}},
+	{names: []string{"view", "open"}, usage: "FILE", summary: "open a file (e.g. a PDF) in a new browser tab"},
+	{names: []string{"url", "share"}, usage: "FILE", summary: "get shareable URL (copies to clipboard)"},
+	{names: []string{"set"}, usage: "sort name|size|time|version | dirsfirst on|off", summary: "set the default ls order for this session"},
+	{names: []string{"tree"}, usage: "[-L<DEPTH>] [-a] [-v]", summary: "directory structure", flags: []string{"-L", "-a", "-v"}},
+	{names: []string{"find"}, usage: "[PATH] [-name PATTERN] [-type f|d] [-v]", summary: "search for files and directories",
+		flags: []string{"-name", "-type", "-v"}},
+	{names: []string{"grep"}, usage: "[-r] [-i] [-n] [-a] [-m N] PATTERN [FILE...]", summary: "search for text patterns in files",
+		flags: []string{"--binary-files=", "--text", "-a", "-i", "-m", "-n", "-r"}},
+	{names: []string{"mkdir"}, usage: "[-p] DIR...", summary: "create directories (-p creates parents)",
+		flags: []string{"--parents", "-p"}, writes: true},
+	{names: []string{"rm"}, usage: "[-r] FILE...", summary: "remove files (-r removes directories)",
+		flags: []string{"--recursive", "-r"}, writes: true},
+	{names: []string{"mv"}, usage: "SOURCE... DEST", summary: "move or rename files", writes: true},
+	{names: []string{"cp"}, usage: "[-r] SOURCE... DEST", summary: "copy files (-r copies directories)",
+		flags: []string{"--recursive", "-r"}, writes: true},
+}
+
+// findCommand returns the table entry for name or one of its aliases
+func findCommand(name string) *command {
+	for i := range commands {
+		if slices.Contains(commands[i].names, name) || slices.Contains(commands[i].hidden, name) {
+			return &commands[i]
+		}
+	}
+	return nil
+}
+
+// helpLine renders c as one line of help
+func (c command) helpLine() string {
+	var b strings.Builder
+	b.WriteString("• ")
+	for i, n := range c.names {
+		if i > 0 {
+			b.WriteString("|")
+		}
+		b.WriteString("<strong>" + n + "</strong>")
+	}
+	if c.usage != "" {
+		b.WriteString(` <span style="color: #888;">` + html.EscapeString(c.usage) + `</span>`)
+	}
+	b.WriteString(` - <span style="color: #bbb;">` + c.summary + `</span>`)
+	return b.String()
+}
+
+// commandNames lists the commands shown by help, aliases included, so
+// completion always offers exactly what help advertises
+func commandNames(writable bool) []string {
+	var names []string
+	for _, c := range commands {
+		if c.writes && !writable {
+			continue
+		}
+		names = append(names, c.names...)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// commandFlags lists the options name accepts, for completion
+func commandFlags(name string) []string {
+	if c := findCommand(name); c != nil {
+		return c.flags
+	}
+	return nil
+}
+
+// commandGroup returns name together with its built-in aliases (ls and dir,
+// sum and checksum, bookmark and bm, ...)
+func commandGroup(name string) []string {
+	c := findCommand(name)
+	if c == nil {
+		return nil
+	}
+	return append(slices.Clone(c.names), c.hidden...)
+}
+
+// parseCommandList reads a comma or space separated list of commands for
+// -enable and -disable; each command brings its built-in aliases along
+func parseCommandList(list string) (map[string]bool, error) {
+	names := strings.FieldsFunc(list, func(r rune) bool { return r == ',' || r == ' ' })
+	if len(names) == 0 {
+		return nil, nil
+	}
+	set := make(map[string]bool)
+	for _, name := range names {
+		group := commandGroup(name)
+		if group == nil {
+			return nil, fmt.Errorf("unknown command %q", name)
+		}
+		for _, n := range group {
+			set[n] = true
+		}
+	}
+	return set, nil
+}
+
+// commandAllowed applies -enable and -disable; help is always available
+func (s *server) commandAllowed(name string) bool {
+	if name == "help" {
+		return true
+	}
+	if s.enabled != nil && !s.enabled[name] {
+		return false
+	}
+	return !s.disabled[name]
+}
+
+// helpHTML renders the help for this server, leaving out disabled commands
+func (s *server) helpHTML() string {
+	return renderHelp(s.writable, s.brand, s.commandAllowed)
+}
+
+// renderHelp renders the help text; allowed, if not nil, picks the commands
+// to list
+func renderHelp(writable bool, brand string, allowed func(string) bool) string {
+	if brand == "" {
+		brand = defaultBrand
+	}
+	var lines []string
+	for _, c := range commands {
+		if (c.writes && !writable) || (allowed != nil && !allowed(c.names[0])) {
+			continue
+		}
+		lines = append(lines, c.helpLine())
+	}
 	helpMessage := template.Must(template.New("help").Parse(helpTpl))
 	var b bytes.Buffer
-	_ = helpMessage.Execute(&b, struct{ Version string }{Version: version})
+	_ = helpMessage.Execute(&b, struct {
+		Version  string
+		Commands template.HTML
+		Brand    string
+	}{Version: version, Commands: template.HTML(strings.Join(lines, "\n")), Brand: brand})
 	return b.String()
 }
 
+// Extension lists shared by getFileColor and zip compression selection
+var (
+	archiveExts = []string{".tar", ".tgz", ".tar.gz", ".tar.bz2", ".tar.xz", ".zip", ".rar", ".7z", ".gz", ".bz2", ".xz"}
+	imageExts   = []string{".jpg", ".jpeg", ".png", ".gif", ".bmp", ".svg", ".ico", ".tiff", ".webp"}
+	audioExts   = []string{".mp3", ".wav", ".flac", ".aac", ".ogg", ".wma", ".m4a"}
+	videoExts   = []string{".mp4", ".avi", ".mkv", ".mov", ".wmv", ".flv", ".webm", ".m4v"}
+
+	// media formats in the lists above that are stored uncompressed
+	uncompressedMediaExts = []string{".bmp", ".svg", ".tiff", ".wav"}
+)
+
+// isPrecompressed reports whether a file's format is already compressed,
+// so deflating it again would only waste CPU
+func isPrecompressed(name string) bool {
+	ext := strings.ToLower(filepath.Ext(name))
+	if slices.Contains(uncompressedMediaExts, ext) {
+		return false
+	}
+	return slices.Contains(archiveExts, ext) || slices.Contains(imageExts, ext) ||
+		slices.Contains(audioExts, ext) || slices.Contains(videoExts, ext)
+}
+
 // getFileColor returns the appropriate ANSI color code for a file based on its type and permissions
 func getFileColor(info os.FileInfo, name string) string {
 	mode := info.Mode()
@@ -128,15 +412,17 @@ func getFileColor(info os.FileInfo, name string) string {
 
 	// Regular files - color by extension
 	ext := strings.ToLower(filepath.Ext(name))
-	switch ext {
-	case ".tar", ".tgz", ".tar.gz", ".tar.bz2", ".tar.xz", ".zip", ".rar", ".7z", ".gz", ".bz2", ".xz":
+	switch {
+	case slices.Contains(archiveExts, ext):
 		return colorRed
-	case ".jpg", ".jpeg", ".png", ".gif", ".bmp", ".svg", ".ico", ".tiff", ".webp":
+	case slices.Contains(imageExts, ext):
 		return colorMagenta
-	case ".mp3", ".wav", ".flac", ".aac", ".ogg", ".wma", ".m4a":
+	case slices.Contains(audioExts, ext):
 		return colorGreen
-	case ".mp4", ".avi", ".mkv", ".mov", ".wmv", ".flv", ".webm", ".m4v":
+	case slices.Contains(videoExts, ext):
 		return colorBrightGreen
+	}
+	switch ext {
 	case ".pdf", ".doc", ".docx", ".txt", ".md", ".rst", ".tex":
 		return colorWhite
 	case ".py", ".js", ".ts", ".jsx", ".tsx", ".go", ".rs", ".cpp", ".c", ".h", ".java", ".kt", ".swift":
@@ -154,6 +440,35 @@ func getFileColor(info os.FileInfo, name string) string {
 	}
 }
 
+// linkTarget returns the " -> target" suffix ls -l shows after a symlink.
+// Links pointing outside the served tree keep their target to themselves,
+// and absolute targets are shown relative to the link so host paths don't
+// leak into the listing.
+func (s *server) linkTarget(realPath string) string {
+	target, err := os.Readlink(realPath)
+	if err != nil {
+		return ""
+	}
+	v, err := s.virtualFromReal(realPath)
+	if err != nil {
+		return ""
+	}
+	if _, err := s.realFromVirtual(v); err != nil {
+		return ""
+	}
+	if filepath.IsAbs(target) {
+		dir, err1 := filepath.EvalSymlinks(filepath.Dir(realPath))
+		resolved, err2 := filepath.EvalSymlinks(realPath)
+		if err1 != nil || err2 != nil {
+			return ""
+		}
+		if target, err = filepath.Rel(dir, resolved); err != nil {
+			return ""
+		}
+	}
+	return colorBrightBlack + " -> " + colorReset + colorCyan + filepath.ToSlash(target) + colorReset
+}
+
 // colorizeName wraps a filename with appropriate ANSI color codes
 func colorizeName(info os.FileInfo, name string) string {
 	color := getFileColor(info, name)
@@ -319,11 +634,206 @@ var embeddedMarkedJS []byte
 //go:embed assets/js/datastar.js
 var embeddedDatastarJS []byte
 
+//go:embed assets/favicon.ico
+var embeddedFavicon []byte
+
 // ===== Server state =====
 
 type session struct {
+	id string
+
+	// mu guards the fields below: several browser tabs share one
+	// session cookie and may hit the server concurrently
+	mu sync.Mutex
 	// virtual cwd like "/sub/dir"
 	cwd string
+	// named virtual paths saved with the bookmark command
+	bookmarks map[string]string
+	// default ls ordering chosen with `set sort`; empty means by name
+	sortBy string
+	// ls lists directories before files, chosen with `set dirsfirst`
+	dirsFirst bool
+	// directory before the last successful cd, for `cd -`
+	prevCwd string
+}
+
+// getCwd returns the session's current virtual directory
+func (sess *session) getCwd() string {
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+	return sess.cwd
+}
+
+// chdir moves the session to v, remembering where it was for `cd -`
+func (sess *session) chdir(v string) {
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+	if v != sess.cwd {
+		sess.prevCwd = sess.cwd
+	}
+	sess.cwd = v
+}
+
+// getPrevCwd returns the directory `cd -` goes back to
+func (sess *session) getPrevCwd() string {
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+	return sess.prevCwd
+}
+
+// whoami describes a session to its owner. Only the start of the session
+// id is shown: enough to tell tabs apart, too little to hijack it.
+func whoami(sess *session, ip string) string {
+	id := sess.id
+	if len(id) > 8 {
+		id = id[:8] + "…"
+	}
+	order := sess.getSortBy()
+	if order == "" {
+		order = sortByName
+	}
+	rows := [][2]string{
+		{"user", "anonymous (lsget has no logins)"},
+		{"session", id},
+		{"cwd", sess.getCwd()},
+	}
+	if prev := sess.getPrevCwd(); prev != "" {
+		rows = append(rows, [2]string{"previous", prev})
+	}
+	rows = append(rows,
+		[2]string{"sort", order},
+		[2]string{"bookmarks", strconv.Itoa(len(sess.getBookmarks()))},
+		[2]string{"address", ip},
+	)
+	var b strings.Builder
+	for _, row := range rows {
+		fmt.Fprintf(&b, "%s%-10s%s%s\n", colorCyan, row[0], colorReset, row[1])
+	}
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+// env lists the settings that shape what commands may do, so users can
+// see why e.g. cat refuses a file. Host paths and anything secret stay
+// out: the root shows by name only and log files as on or off.
+func (s *server) env() string {
+	limit := func(n int64) string {
+		if n <= 0 {
+			return "unlimited"
+		}
+		return formatHumanSize(n)
+	}
+	onOff := func(b bool) string {
+		if b {
+			return "on"
+		}
+		return "off"
+	}
+	mode := "read-only"
+	if s.writable {
+		mode = "writable"
+		if s.force {
+			mode += ", may replace files"
+		}
+	}
+	walkTimeout := "unlimited"
+	if s.walkTimeout > 0 {
+		walkTimeout = s.walkTimeout.String()
+	}
+	maxZipFiles := "unlimited"
+	if s.maxZipFiles > 0 {
+		maxZipFiles = strconv.Itoa(s.maxZipFiles)
+	}
+	rows := [][2]string{
+		{"root", s.rootName()},
+		{"mode", mode},
+		{"catmax", limit(s.catMax)},
+		{"grepmax", limit(s.grepMax)},
+		{"grepresults", strconv.Itoa(s.grepResults)},
+		{"maxline", limit(int64(maxLineBytes))},
+		{"maxsum", limit(s.maxSum)},
+		{"maxzipfiles", maxZipFiles},
+		{"maxzipbytes", limit(s.maxZipBytes)},
+		{"logging", onOff(s.logfile != "" || logFile != "")},
+		{"colors", "ANSI, always on"},
+		{"gitignore", onOff(s.useGitignore)},
+		{"exifgps", onOff(s.exifGPS)},
+		{"walk-timeout", walkTimeout},
+	}
+	var b strings.Builder
+	for _, row := range rows {
+		fmt.Fprintf(&b, "%s%-12s%s%s\n", colorCyan, row[0], colorReset, row[1])
+	}
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+// shellVars are the variables commands may reference. They only describe
+// the virtual filesystem; the host environment is never exposed.
+func (sess *session) shellVars() map[string]string {
+	cwd := sess.getCwd()
+	vars := map[string]string{"HOME": "/", "ROOT": "/", "CWD": cwd, "PWD": cwd}
+	if prev := sess.getPrevCwd(); prev != "" {
+		vars["OLDPWD"] = prev
+	}
+	return vars
+}
+
+// getSortBy returns the session's default ls ordering
+func (sess *session) getSortBy() string {
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+	return sess.sortBy
+}
+
+func (sess *session) setSortBy(order string) {
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+	sess.sortBy = order
+}
+
+// getDirsFirst reports whether ls groups directories first by default
+func (sess *session) getDirsFirst() bool {
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+	return sess.dirsFirst
+}
+
+func (sess *session) setDirsFirst(on bool) {
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+	sess.dirsFirst = on
+}
+
+// getBookmarks returns a copy of the session's bookmarks
+func (sess *session) getBookmarks() map[string]string {
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+	return maps.Clone(sess.bookmarks)
+}
+
+// setBookmark saves v under name, or deletes name when v is empty; it
+// reports whether anything changed
+func (sess *session) setBookmark(name, v string) bool {
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+	if v == "" {
+		if _, ok := sess.bookmarks[name]; !ok {
+			return false
+		}
+		delete(sess.bookmarks, name)
+		return true
+	}
+	if sess.bookmarks == nil {
+		sess.bookmarks = make(map[string]string)
+	}
+	sess.bookmarks[name] = v
+	return true
+}
+
+// replaceBookmarks swaps in a fresh set of bookmarks, e.g. after reload
+func (sess *session) replaceBookmarks(bm map[string]string) {
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+	sess.bookmarks = maps.Clone(bm)
 }
 
 type server struct {
@@ -333,6 +843,50 @@ type server struct {
 	mu       sync.RWMutex
 	logfile  string // path to log file for statistics
 	baseURL  string // optional: public base URL (e.g., https://files.example.com) - auto-detects from request if empty
+	writable bool   // allow uploads and other filesystem writes
+	force    bool   // allow writes to replace existing files
+
+	maxZipFiles int   // max files per zip download (0 = unlimited)
+	maxZipBytes int64 // max uncompressed bytes per zip download (0 = unlimited)
+
+	exifGPS bool  // show GPS coordinates in exif output
+	maxSum  int64 // max file size hashed by sum (0 = unlimited)
+	grepMax int64 // bytes of each file searched by grep (0 = whole file)
+	maxBody int64 // max bytes in an /api/exec or /api/complete request body
+
+	grepResults int           // matching lines one grep reports (0 = unlimited)
+	walkTimeout time.Duration // longest find, grep -r or tree may run (0 = no limit)
+
+	listings *listingCache // cached directory listings (nil = disabled)
+
+	aliases map[string]string // custom command names loaded from -aliases
+
+	enabled  map[string]bool // if set, the only commands accepted (-enable)
+	disabled map[string]bool // commands refused (-disable)
+
+	corsOrigins []string // other origins allowed to call /api/* (-cors-origin)
+
+	useGitignore bool         // also hide what .gitignore files exclude (-use-gitignore)
+	ignoreRules  []ignoreRule // patterns hidden everywhere (-ignore)
+	onlyRules    []ignoreRule // if set, files must match one to be seen (-only)
+
+	caseInsensitive bool // the filesystem ignores case, so path checks and patterns must too
+
+	mounts []mount // with -mount, the top-level directories; rootAbs then only holds links to them
+
+	trustedProxies []*net.IPNet // proxies whose X-Forwarded-For .lsgetaccess believes (-trusted-proxy)
+
+	title string // browser title of the pages (-title)
+	brand string // name in the welcome message and error pages (-brand)
+
+	message     string // banner shown above the welcome message (-message)
+	messageFile string // file holding the banner, read on every page load (-message-file)
+
+	assetsAbs string // operator's CSS, JS and images served at /assets/ (-assets)
+
+	bookmarksPath  string                       // file bookmarks persist in, outside the root (-bookmarks)
+	bookmarksMu    sync.Mutex                   // guards savedBookmarks and the bookmarks file
+	savedBookmarks map[string]map[string]string // persisted bookmarks by bookmarkKey
 }
 
 func newServer(rootAbs string, catMax int64, logfile, baseURL string) *server {
@@ -342,11 +896,102 @@ func newServer(rootAbs string, catMax int64, logfile, baseURL string) *server {
 		sessions: make(map[string]*session),
 		logfile:  logfile,
 		baseURL:  baseURL,
+		grepMax:  defaultGrepMax,
+		maxBody:  defaultMaxBody,
+		maxSum:   defaultMaxSum,
+
+		grepResults: defaultGrepResults,
+		walkTimeout: defaultWalkTimeout,
+	}
+}
+
+// defaultBrand and defaultTitle name the pages unless -brand or -title do
+const (
+	defaultBrand = "lsget"
+	defaultTitle = "📂 lsget"
+)
+
+// brandName is what the pages call the site
+func (s *server) brandName() string {
+	if s.brand != "" {
+		return s.brand
 	}
+	return defaultBrand
 }
 
+// pageTitle is the browser title of the terminal; a custom brand stands in
+// for the title when only -brand is set
+func (s *server) pageTitle() string {
+	switch {
+	case s.title != "":
+		return s.title
+	case s.brand != "":
+		return s.brand
+	}
+	return defaultTitle
+}
+
+// defaultGrepMax is how much of each file grep searches unless -grepmax
+// says otherwise
+const defaultGrepMax = 10 * 1024 * 1024
+
+// defaultMaxSum is the largest file sum hashes unless -maxsum says
+// otherwise; hashing reads the whole file, so one request could otherwise
+// keep the disk busy for minutes
+const defaultMaxSum = 1024 * 1024 * 1024
+
+// defaultGrepResults caps the matching lines of one grep unless
+// -grepresults says otherwise, so a recursive grep for a common word
+// doesn't send megabytes to the browser
+const defaultGrepResults = 10000
+
+// defaultWalkTimeout is how long find, grep -r and tree may walk unless
+// -walk-timeout says otherwise
+const defaultWalkTimeout = 30 * time.Second
+
+// defaultMaxBody bounds JSON request bodies unless -maxbody says
+// otherwise; a command line or completion request is a few hundred bytes
+const defaultMaxBody = 1024 * 1024
+
 // ===== .lsgetignore support =====
 
+// newLineScanner returns a line scanner that accepts lines up to
+// maxLineBytes, well past bufio's 64KB default that minified JS and
+// single-line JSON logs routinely exceed
+func newLineScanner(r io.Reader) *bufio.Scanner {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxLineBytes)
+	scanner.Split(scanAnyLines)
+	return scanner
+}
+
+// scanAnyLines is bufio.ScanLines that also ends a line at a lone \r, so
+// classic Mac files are not read as one huge line
+func scanAnyLines(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	if atEOF && len(data) == 0 {
+		return 0, nil, nil
+	}
+	if i := bytes.IndexAny(data, "\r\n"); i >= 0 {
+		if data[i] == '\n' {
+			return i + 1, data[:i], nil
+		}
+		if i+1 < len(data) {
+			if data[i+1] == '\n' {
+				return i + 2, data[:i], nil
+			}
+			return i + 1, data[:i], nil
+		}
+		if atEOF {
+			return i + 1, data[:i], nil
+		}
+		return 0, nil, nil // need more data to tell \r from \r\n
+	}
+	if atEOF {
+		return len(data), data, nil
+	}
+	return 0, nil, nil
+}
+
 // parseIgnoreFile reads and parses a .lsgetignore file, returning a slice of patterns
 func parseIgnoreFile(ignoreFilePath string) ([]string, error) {
 	file, err := os.Open(ignoreFilePath)
@@ -359,7 +1004,7 @@ func parseIgnoreFile(ignoreFilePath string) ([]string, error) {
 	defer func() { _ = file.Close() }()
 
 	var patterns []string
-	scanner := bufio.NewScanner(file)
+	scanner := newLineScanner(file)
 	for scanner.Scan() {
 		line := strings.TrimSpace(scanner.Text())
 		// Skip empty lines and comments
@@ -375,6 +1020,16 @@ func parseIgnoreFile(ignoreFilePath string) ([]string, error) {
 // shouldIgnore checks if a file/directory should be ignored based on .lsgetignore patterns
 // It looks for .lsgetignore files in the current directory and all parent directories up to rootAbs
 func (s *server) shouldIgnore(realPath, name string) bool {
+	// Bookmarks left in the root by older versions hold session ids
+	if bookmarks := filepath.Join(s.rootAbs, legacyBookmarksFile); realPath == bookmarks || realPath == bookmarks+".tmp" ||
+		s.caseInsensitive && (strings.EqualFold(realPath, bookmarks) || strings.EqualFold(realPath, bookmarks+".tmp")) {
+		return true
+	}
+	// Access rules would tell who else may get in
+	if base := filepath.Base(realPath); base == accessFile || s.caseInsensitive && strings.EqualFold(base, accessFile) {
+		return true
+	}
+
 	// Start from the directory containing the file/directory
 	currentDir := filepath.Dir(realPath)
 
@@ -393,7 +1048,7 @@ func (s *server) shouldIgnore(realPath, name string) bool {
 			// Check if the file matches any pattern
 			for _, pattern := range patterns {
 				// Support both simple filename matching and path-based matching
-				matched, err := filepath.Match(pattern, name)
+				matched, err := matchFold(pattern, name, s.caseInsensitive)
 				if err == nil && matched {
 					return true
 				}
@@ -401,13 +1056,13 @@ func (s *server) shouldIgnore(realPath, name string) bool {
 				// Also check if the pattern matches the relative path from current directory
 				relPath, err := filepath.Rel(currentDir, realPath)
 				if err == nil {
-					matched, err := filepath.Match(pattern, relPath)
+					matched, err := matchFold(pattern, relPath, s.caseInsensitive)
 					if err == nil && matched {
 						return true
 					}
 					// Also check directory-based patterns
 					if strings.Contains(relPath, "/") {
-						matched, err := filepath.Match(pattern, filepath.Base(relPath))
+						matched, err := matchFold(pattern, filepath.Base(relPath), s.caseInsensitive)
 						if err == nil && matched {
 							return true
 						}
@@ -424,55 +1079,446 @@ func (s *server) shouldIgnore(realPath, name string) bool {
 		currentDir = parentDir
 	}
 
-	return false
+	// -ignore patterns and .gitignore files only add to what .lsgetignore
+	// hides; they are checked apart so a .gitignore cannot re-include
+	// what the operator hid
+	if len(s.ignoreRules) > 0 && s.ignoredByRules(realPath, s.ignoreRules, false) {
+		return true
+	}
+	if s.useGitignore && s.ignoredByRules(realPath, nil, true) {
+		return true
+	}
+	return len(s.onlyRules) > 0 && !s.onlyAllowed(realPath)
 }
 
-// ===== Utilities =====
+// onlyAllowed reports whether realPath passes the -only allowlist: a file
+// must match one of the patterns itself or sit in a directory that does.
+// Directories stay visible so the allowed files can be reached.
+func (s *server) onlyAllowed(realPath string) bool {
+	rel, err := filepath.Rel(s.rootAbs, realPath)
+	if err != nil || rel == "." || strings.HasPrefix(rel, "..") {
+		return true
+	}
+	parts := strings.Split(filepath.ToSlash(rel), "/")
+	for i := range parts {
+		prefix, isDir := strings.Join(parts[:i+1], "/"), i < len(parts)-1
+		for _, rule := range s.onlyRules {
+			if !rule.negate && rule.match(prefix, isDir, s.caseInsensitive) {
+				return true
+			}
+		}
+	}
+	info, err := os.Stat(realPath)
+	return err == nil && info.IsDir()
+}
 
-// sitemapEntry represents an entry in the sitemap
-type sitemapEntry struct {
-	loc         string
-	lastmod     string
-	isImage     bool
-	imageURL    string
-	isVideo     bool
-	videoURL    string
-	isDocument  bool
-	isDir       bool
-	fileSize    int64
+// ignoreRule is one pattern line of a .gitignore file
+type ignoreRule struct {
+	glob     []string // slash-separated segments; "**" spans any number of them
+	negate   bool     // "!pattern" re-includes what earlier rules excluded
+	dirOnly  bool     // "pattern/" matches directories only
+	anchored bool     // a slash before the end ties the pattern to the file's directory
 }
 
-// generateSitemap creates a sitemap.xml file in the root directory
-func (s *server) generateSitemap() error {
-	if s.baseURL == "" {
-		return nil
+// parseIgnoreRule parses one .gitignore line; ok is false for blank lines
+// and comments
+func parseIgnoreRule(line string) (rule ignoreRule, ok bool) {
+	line = strings.TrimRight(line, " \t")
+	if line == "" || strings.HasPrefix(line, "#") {
+		return rule, false
+	}
+	if strings.HasPrefix(line, "!") {
+		rule.negate = true
+		line = line[1:]
+	} else if strings.HasPrefix(line, `\!`) || strings.HasPrefix(line, `\#`) {
+		line = line[1:]
+	}
+	if strings.HasSuffix(line, "/") {
+		rule.dirOnly = true
+		line = strings.TrimRight(line, "/")
+	}
+	rule.anchored = strings.Contains(line, "/")
+	line = strings.TrimPrefix(line, "/")
+	if line == "" {
+		return rule, false
 	}
+	rule.glob = strings.Split(line, "/")
+	return rule, true
+}
 
-	var entries []sitemapEntry
-	err := filepath.Walk(s.rootAbs, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return nil
-		}
+// match reports whether rel, a slash-separated path relative to the
+// directory holding the rule's .gitignore, is matched by the rule; fold
+// ignores case
+func (r ignoreRule) match(rel string, isDir, fold bool) bool {
+	if r.dirOnly && !isDir {
+		return false
+	}
+	if !r.anchored {
+		return matchSegments(r.glob, []string{path.Base(rel)}, fold)
+	}
+	return matchSegments(r.glob, strings.Split(rel, "/"), fold)
+}
 
-		if s.shouldIgnore(path, filepath.Base(path)) {
-			if info.IsDir() {
-				return filepath.SkipDir
+// matchFold is filepath.Match, ignoring case when fold is set
+func matchFold(pattern, name string, fold bool) (bool, error) {
+	if fold {
+		pattern, name = strings.ToLower(pattern), strings.ToLower(name)
+	}
+	return filepath.Match(pattern, name)
+}
+
+// matchSegments matches path segments against glob segments, where a "**"
+// segment matches zero or more path segments
+func matchSegments(glob, segs []string, fold bool) bool {
+	for len(glob) > 0 {
+		if glob[0] == "**" {
+			for i := 0; i <= len(segs); i++ {
+				if matchSegments(glob[1:], segs[i:], fold) {
+					return true
+				}
 			}
-			return nil
+			return false
 		}
-
-		vp, err := s.virtualFromReal(path)
-		if err != nil {
-			return nil
+		if len(segs) == 0 {
+			return false
 		}
-
-		// Format lastmod in W3C Datetime format (ISO 8601)
-		lastmod := info.ModTime().Format(time.RFC3339)
-
-		entry := sitemapEntry{
-			loc:     s.baseURL + vp,
-			lastmod: lastmod,
-			isDir:   info.IsDir(),
+		if ok, err := matchFold(glob[0], segs[0], fold); err != nil || !ok {
+			return false
+		}
+		glob, segs = glob[1:], segs[1:]
+	}
+	return len(segs) == 0
+}
+
+// parsePatterns parses the patterns given to -ignore or -only
+func parsePatterns(patterns []string) ([]ignoreRule, error) {
+	var rules []ignoreRule
+	for _, pattern := range patterns {
+		rule, ok := parseIgnoreRule(pattern)
+		if !ok {
+			continue
+		}
+		for _, seg := range rule.glob {
+			if _, err := path.Match(seg, ""); err != nil {
+				return nil, fmt.Errorf("%q: %v", pattern, err)
+			}
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// parseGitignore reads the rules of a .gitignore file; a missing file has
+// none
+func parseGitignore(file string) ([]ignoreRule, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer func() { _ = f.Close() }()
+
+	var rules []ignoreRule
+	scanner := newLineScanner(f)
+	for scanner.Scan() {
+		if rule, ok := parseIgnoreRule(scanner.Text()); ok {
+			rules = append(rules, rule)
+		}
+	}
+	return rules, scanner.Err()
+}
+
+// ignoreRuleSet holds the rules of one .gitignore and the directory it
+// applies to, as a slash-separated path relative to the root ("" for the
+// root itself)
+type ignoreRuleSet struct {
+	base  string
+	rules []ignoreRule
+}
+
+// ignoredBy applies sets in order, as git does: the last matching rule
+// decides, so deeper files override shallower ones and "!" re-includes
+func ignoredBy(sets []ignoreRuleSet, rel string, isDir, fold bool) bool {
+	ignored := false
+	for _, set := range sets {
+		sub := rel
+		if set.base != "" {
+			sub = strings.TrimPrefix(rel, set.base+"/")
+		}
+		for _, rule := range set.rules {
+			if rule.match(sub, isDir, fold) {
+				ignored = !rule.negate
+			}
+		}
+	}
+	return ignored
+}
+
+// ignoredByRules reports whether realPath is excluded by rootRules, which
+// apply from rootAbs down, or, with gitignore set, by the .gitignore files
+// between rootAbs and realPath. As in git, nothing inside an excluded
+// directory can be re-included.
+func (s *server) ignoredByRules(realPath string, rootRules []ignoreRule, gitignore bool) bool {
+	rel, err := filepath.Rel(s.rootAbs, realPath)
+	if err != nil || rel == "." || strings.HasPrefix(rel, "..") {
+		return false
+	}
+	parts := strings.Split(filepath.ToSlash(rel), "/")
+	var sets []ignoreRuleSet
+	if len(rootRules) > 0 {
+		sets = append(sets, ignoreRuleSet{rules: rootRules})
+	}
+	dir := s.rootAbs
+	for i := range parts {
+		if gitignore {
+			if rules, err := parseGitignore(filepath.Join(dir, ".gitignore")); err == nil && len(rules) > 0 {
+				sets = append(sets, ignoreRuleSet{base: strings.Join(parts[:i], "/"), rules: rules})
+			}
+		}
+		dir = filepath.Join(dir, parts[i])
+		if len(sets) == 0 {
+			continue
+		}
+		isDir := i < len(parts)-1
+		if !isDir {
+			info, err := os.Stat(realPath)
+			isDir = err == nil && info.IsDir()
+		}
+		if ignoredBy(sets, strings.Join(parts[:i+1], "/"), isDir, s.caseInsensitive) {
+			return true
+		}
+	}
+	return false
+}
+
+// ===== Access control =====
+
+// accessFile restricts who may enter its directory and everything below
+const accessFile = ".lsgetaccess"
+
+// accessRule is one allow or deny line of an accessFile; a nil network
+// stands for all addresses
+type accessRule struct {
+	allow   bool
+	network *net.IPNet
+}
+
+// parseAccessFile reads the rules of an accessFile. Lines read "allow X"
+// or "deny X", where X is an address, a CIDR range or "all"; # starts a
+// comment.
+func parseAccessFile(file string) ([]accessRule, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var rules []accessRule
+	for n, line := range strings.Split(string(data), "\n") {
+		line, _, _ = strings.Cut(line, "#")
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) != 2 || fields[0] != "allow" && fields[0] != "deny" {
+			return nil, fmt.Errorf("%s:%d: expected allow or deny and an address", file, n+1)
+		}
+		rule := accessRule{allow: fields[0] == "allow"}
+		if fields[1] != "all" {
+			if rule.network, err = parseNetwork(fields[1]); err != nil {
+				return nil, fmt.Errorf("%s:%d: %v", file, n+1, err)
+			}
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// parseNetwork parses a CIDR range, or a single address as a range of one
+func parseNetwork(addr string) (*net.IPNet, error) {
+	if strings.Contains(addr, "/") {
+		_, network, err := net.ParseCIDR(addr)
+		return network, err
+	}
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return nil, fmt.Errorf("invalid address %q", addr)
+	}
+	bits := 8 * len(ip)
+	if ip4 := ip.To4(); ip4 != nil {
+		ip, bits = ip4, 32
+	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
+}
+
+// accessAdmits applies rules to ip: the first matching rule decides, and
+// when none matches, a list with allow rules turns the address away
+func accessAdmits(rules []accessRule, ip net.IP) bool {
+	hasAllow := false
+	for _, rule := range rules {
+		if rule.network == nil || ip != nil && rule.network.Contains(ip) {
+			return rule.allow
+		}
+		hasAllow = hasAllow || rule.allow
+	}
+	return !hasAllow
+}
+
+// accessAllowed reports whether ip may enter realPath: every accessFile
+// from the directory of realPath up to the root must admit it. Files that
+// cannot be read or parsed admit no one.
+func (s *server) accessAllowed(realPath string, ip net.IP) bool {
+	dir := realPath
+	if info, err := os.Stat(realPath); err != nil || !info.IsDir() {
+		dir = filepath.Dir(realPath)
+	}
+	for {
+		rel, err := filepath.Rel(s.rootAbs, dir)
+		if err != nil || strings.HasPrefix(rel, "..") {
+			return true
+		}
+		rules, err := parseAccessFile(filepath.Join(dir, accessFile))
+		if err != nil && !errors.Is(err, fs.ErrNotExist) || err == nil && !accessAdmits(rules, ip) {
+			return false
+		}
+		if rel == "." {
+			return true
+		}
+		dir = filepath.Dir(dir)
+	}
+}
+
+// guarded reports whether dir holds an accessFile. Recursive commands
+// started above such a directory leave it out, whoever runs them.
+func (s *server) guarded(dir string) bool {
+	_, err := os.Lstat(filepath.Join(dir, accessFile))
+	return err == nil
+}
+
+// accessIP is the client address .lsgetaccess rules are checked against.
+// X-Forwarded-For is only believed when it comes from a trusted proxy,
+// and then read from the right, skipping further trusted proxies.
+func (s *server) accessIP(r *http.Request) net.IP {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || !s.trustedProxy(ip) {
+		return ip
+	}
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := net.ParseIP(strings.TrimSpace(hops[i]))
+		if hop == nil {
+			break
+		}
+		ip = hop
+		if !s.trustedProxy(hop) {
+			break
+		}
+	}
+	return ip
+}
+
+// trustedProxy reports whether ip belongs to a -trusted-proxy range
+func (s *server) trustedProxy(ip net.IP) bool {
+	for _, network := range s.trustedProxies {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// deniedArg returns the first of args naming a path ip may not enter.
+// Arguments are read as paths when they resolve to one; for wildcards,
+// the directory they expand in is checked, and braces are checked one
+// alternative at a time.
+func (s *server) deniedArg(cwd string, args []string, ip net.IP) (string, bool) {
+	for _, arg := range args {
+		if strings.HasPrefix(arg, "-") {
+			continue
+		}
+		alts, err := expandBraces(arg)
+		if err != nil {
+			alts = []string{arg}
+		}
+		for _, p := range alts {
+			for strings.ContainsAny(p, "*?[") {
+				p = path.Dir(p)
+			}
+			rp, err := s.realFromVirtual(joinVirtual(cwd, p))
+			if err != nil {
+				continue
+			}
+			if _, err := os.Stat(rp); err == nil && !s.accessAllowed(rp, ip) {
+				return arg, true
+			}
+		}
+	}
+	return "", false
+}
+
+// accessibleFiles leaves out the files ip may not enter, in case a
+// pattern reached into a directory its access rules close
+func (s *server) accessibleFiles(files []fileInfo, ip net.IP) []fileInfo {
+	var out []fileInfo
+	for _, f := range files {
+		if s.accessAllowed(f.realPath, ip) {
+			out = append(out, f)
+		}
+	}
+	return out
+}
+
+// ===== Utilities =====
+
+// sitemapEntry represents an entry in the sitemap
+type sitemapEntry struct {
+	loc         string
+	lastmod     string
+	isImage     bool
+	imageURL    string
+	isVideo     bool
+	videoURL    string
+	isDocument  bool
+	isDir       bool
+	fileSize    int64
+}
+
+// generateSitemap creates a sitemap.xml file in the root directory
+func (s *server) generateSitemap() error {
+	if s.baseURL == "" {
+		return nil
+	}
+
+	var entries []sitemapEntry
+	err := s.walk(s.rootAbs, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return nil
+		}
+
+		// The sitemap is public: no restricted directories, not even the root
+		if s.shouldIgnore(path, filepath.Base(path)) || info.IsDir() && s.guarded(path) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		vp, err := s.virtualFromReal(path)
+		if err != nil {
+			return nil
+		}
+
+		// Format lastmod in W3C Datetime format (ISO 8601)
+		lastmod := info.ModTime().Format(time.RFC3339)
+
+		entry := sitemapEntry{
+			loc:     s.baseURL + vp,
+			lastmod: lastmod,
+			isDir:   info.IsDir(),
 		}
 
 		// Check file category for special handling
@@ -566,7 +1612,7 @@ func (s *server) startSitemapGenerator(intervalMinutes int) {
 	if err := s.generateSitemap(); err != nil {
 		fmt.Fprintf(os.Stderr, "failed to generate initial sitemap: %v\n", err)
 	} else {
-		fmt.Printf("Generated sitemap.xml (%s/sitemap.xml)\n", s.rootAbs)
+		logf("Generated sitemap.xml (%s/sitemap.xml)\n", s.rootAbs)
 	}
 
 	go func() {
@@ -622,10 +1668,16 @@ func (s *server) logCommand(cmd, filePath, ip string) {
 	}
 	defer func() { _ = f.Close() }()
 
-	timestamp := time.Now().Format("[02/Jan/2006:15:04:05 -0700]")
-	// Format: ip - - timestamp "POST /api/exec?cmd=COMMAND&file=PATH HTTP/1.1" 200 0 "-" "-"
-	logLine := fmt.Sprintf("%s - - %s \"POST /api/exec?cmd=%s&file=%s HTTP/1.1\" 200 0 \"-\" \"-\"\n",
-		ip, timestamp, cmd, url.QueryEscape(filePath))
+	// Logged as a synthetic request: "POST /api/exec?cmd=COMMAND&file=PATH HTTP/1.1" 200
+	logLine := formatAccessLog(accessLogEntry{
+		IP:     ip,
+		User:   "-",
+		Time:   time.Now(),
+		Method: "POST",
+		URI:    fmt.Sprintf("/api/exec?cmd=%s&file=%s", url.QueryEscape(cmd), url.QueryEscape(filePath)),
+		Proto:  "HTTP/1.1",
+		Status: http.StatusOK,
+	}, logFormat)
 	_, _ = f.WriteString(logLine)
 }
 
@@ -644,9 +1696,22 @@ func (s *server) getSession(w http.ResponseWriter, r *http.Request) *session {
 			return sess
 		}
 		s.mu.RUnlock()
+
+		// After a restart, revive sessions whose bookmarks were persisted
+		if bm, _ := s.savedBookmarksOf(ck.Value); len(bm) > 0 {
+			sess := &session{id: ck.Value, cwd: "/", bookmarks: bm}
+			s.mu.Lock()
+			if existing, ok := s.sessions[ck.Value]; ok {
+				sess = existing
+			} else {
+				s.sessions[ck.Value] = sess
+			}
+			s.mu.Unlock()
+			return sess
+		}
 	}
 	id := newSID()
-	sess := &session{cwd: "/"}
+	sess := &session{id: id, cwd: "/"}
 	s.mu.Lock()
 	s.sessions[id] = sess
 	s.mu.Unlock()
@@ -660,1190 +1725,4974 @@ func (s *server) getSession(w http.ResponseWriter, r *http.Request) *session {
 	return sess
 }
 
-// ensure virtual path always starts with "/" and is cleaned
-func cleanVirtual(p string) string {
-	if p == "" {
-		return "/"
+// ls sort orders, selectable per session with `set sort`
+const (
+	sortByName    = "name"
+	sortBySize    = "size"
+	sortByTime    = "time"
+	sortByVersion = "version"
+)
+
+// sortNames orders directory entries in place. Size and time put the
+// largest and newest entries first, like ls -S and ls -t, with ties broken
+// by name; version compares runs of digits by value, like ls -v.
+func sortNames(names []string, infos map[string]os.FileInfo, order string) {
+	if order == sortByVersion {
+		sort.Slice(names, func(i, j int) bool { return naturalLess(names[i], names[j]) })
+		return
 	}
-	if !strings.HasPrefix(p, "/") {
-		p = "/" + p
+	if order != sortBySize && order != sortByTime {
+		sort.Strings(names)
+		return
 	}
-	return path.Clean(p)
+	sort.Slice(names, func(i, j int) bool {
+		a, b := infos[names[i]], infos[names[j]]
+		if a != nil && b != nil {
+			if order == sortBySize && a.Size() != b.Size() {
+				return a.Size() > b.Size()
+			}
+			if order == sortByTime && !a.ModTime().Equal(b.ModTime()) {
+				return a.ModTime().After(b.ModTime())
+			}
+		}
+		return names[i] < names[j]
+	})
 }
 
-// join a virtual base with an argument (which can be absolute or relative),
-// then clean and ensure it remains absolute (virtual)
-func joinVirtual(base, arg string) string {
-	if arg == "" {
-		return cleanVirtual(base)
-	}
-	if strings.HasPrefix(arg, "/") {
-		return cleanVirtual(arg)
-	}
-	if base == "" {
-		base = "/"
+// dirsBefore compares two entries by kind only, putting directories before
+// files; entries of the same kind are left to the caller's order. tree
+// always sorts this way, ls with --group-directories-first.
+func dirsBefore(aDir, bDir bool) int {
+	switch {
+	case aDir == bDir:
+		return 0
+	case aDir:
+		return -1
 	}
-	return cleanVirtual(path.Join(base, arg))
+	return 1
 }
 
-// convert a virtual path to a real filesystem path and ensure it is
-// rooted inside s.rootAbs
-func (s *server) realFromVirtual(v string) (string, error) {
-	v = cleanVirtual(v)
-	if v == "/" {
-		return s.rootAbs, nil
-	}
-	rel := strings.TrimPrefix(v, "/")
-	fsPath := filepath.Join(s.rootAbs, filepath.FromSlash(rel))
-	abs, err := filepath.Abs(fsPath)
-	if err != nil {
-		return "", err
-	}
-	// prevent escaping the root via .. or symlinks
-	// (best-effort: compare cleaned absolute paths)
-	if abs == s.rootAbs {
-		return abs, nil
+// naturalLess orders names the way people count: runs of digits compare
+// by value, so v2 sorts before v10. Everything else compares byte by byte,
+// and names equal that way (file01 and file1) fall back to plain order.
+func naturalLess(a, b string) bool {
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		if isDigit(a[i]) && isDigit(b[j]) {
+			si, sj := i, j
+			for i < len(a) && isDigit(a[i]) {
+				i++
+			}
+			for j < len(b) && isDigit(b[j]) {
+				j++
+			}
+			na := strings.TrimLeft(a[si:i], "0")
+			nb := strings.TrimLeft(b[sj:j], "0")
+			if len(na) != len(nb) {
+				return len(na) < len(nb)
+			}
+			if na != nb {
+				return na < nb
+			}
+			continue
+		}
+		if a[i] != b[j] {
+			return a[i] < b[j]
+		}
+		i++
+		j++
 	}
-	rel2, err := filepath.Rel(s.rootAbs, abs)
-	if err != nil || strings.HasPrefix(rel2, "..") || rel2 == ".." {
-		return "", errors.New("permission denied")
+	if len(a)-i != len(b)-j {
+		return len(a)-i < len(b)-j
 	}
-	return abs, nil
+	return a < b
 }
 
-func (s *server) virtualFromReal(realPath string) (string, error) {
-rel, err := filepath.Rel(s.rootAbs, realPath)
-if err != nil {
-return "", err
+func isDigit(c byte) bool { return c >= '0' && c <= '9' }
+
+// ===== Directory listing cache =====
+
+// dirEntry is a directory entry with its stats resolved up front, so that a
+// listing can be cached and served again without touching every file
+type dirEntry struct {
+	name string
+	info os.FileInfo // the entry itself, like os.DirEntry.Info
+	stat os.FileInfo // what a symlink points to (nil if broken); info otherwise
 }
-if rel == "." {
-return "/", nil
+
+// listingCache keeps the most recently used directory listings keyed by
+// real path. A listing stays valid while the directory's modtime is
+// unchanged, which catches entries being added, removed or renamed but not
+// files rewritten in place, so it suits static shares best.
+type listingCache struct {
+	mu      sync.Mutex
+	max     int
+	lru     *list.List // of *cachedListing, most recently used first
+	entries map[string]*list.Element
 }
-return "/" + filepath.ToSlash(rel), nil
+
+type cachedListing struct {
+	dir     string
+	modTime time.Time
+	entries []dirEntry
 }
 
-// simple args parser: supports quotes ("", ”) and backslash escapes inside quotes
-func parseArgs(line string) []string {
-	var args []string
-	var buf bytes.Buffer
-	inSingle, inDouble := false, false
+func newListingCache(max int) *listingCache {
+	return &listingCache{max: max, lru: list.New(), entries: make(map[string]*list.Element)}
+}
 
-	flush := func() {
-		if buf.Len() > 0 || inSingle || inDouble {
-			args = append(args, buf.String())
-			buf.Reset()
-		}
+// get returns the cached listing of dir if it was taken at modTime
+func (c *listingCache) get(dir string, modTime time.Time) ([]dirEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.entries[dir]
+	if !ok {
+		return nil, false
 	}
+	cl := el.Value.(*cachedListing)
+	if !cl.modTime.Equal(modTime) {
+		c.lru.Remove(el)
+		delete(c.entries, dir)
+		return nil, false
+	}
+	c.lru.MoveToFront(el)
+	return cl.entries, true
+}
 
-	for i := 0; i < len(line); i++ {
-		c := line[i]
-		if inSingle {
-			if c == '\'' {
-				inSingle = false
-			} else {
-				buf.WriteByte(c)
-			}
-			continue
+func (c *listingCache) put(dir string, modTime time.Time, entries []dirEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.entries[dir]; ok {
+		el.Value = &cachedListing{dir: dir, modTime: modTime, entries: entries}
+		c.lru.MoveToFront(el)
+		return
+	}
+	c.entries[dir] = c.lru.PushFront(&cachedListing{dir: dir, modTime: modTime, entries: entries})
+	for c.lru.Len() > c.max {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cachedListing).dir)
+	}
+}
+
+// readDir lists dir sorted by name with every entry stat'ed, going through
+// the listing cache when one is configured. The result is shared with the
+// cache and must not be modified.
+func (s *server) readDir(dir string) ([]dirEntry, error) {
+	dirInfo, err := os.Stat(dir)
+	if err != nil {
+		return nil, err
+	}
+	if s.listings != nil {
+		if entries, ok := s.listings.get(dir, dirInfo.ModTime()); ok {
+			return entries, nil
 		}
-		if inDouble {
-			if c == '"' {
-				inDouble = false
-			} else if c == '\\' && i+1 < len(line) {
-				i++
-				buf.WriteByte(line[i])
-			} else {
-				buf.WriteByte(c)
+	}
+	ents, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]dirEntry, 0, len(ents))
+	for _, e := range ents {
+		info, err := e.Info()
+		if err != nil {
+			continue // removed since it was listed
+		}
+		de := dirEntry{name: e.Name(), info: info, stat: info}
+		if info.Mode()&os.ModeSymlink != 0 {
+			de.stat, _ = os.Stat(filepath.Join(dir, e.Name()))
+			if de.stat != nil && s.isMount(filepath.Join(dir, e.Name())) {
+				de.info = de.stat // mounts are shown as the directories they are
 			}
-			continue
-		}
-		switch c {
-		case ' ', '\t', '\n':
-			if buf.Len() > 0 {
-				args = append(args, buf.String())
-				buf.Reset()
-			}
-		case '\'':
-			inSingle = true
-		case '"':
-			inDouble = true
-		default:
-			buf.WriteByte(c)
 		}
+		entries = append(entries, de)
+	}
+	if s.listings != nil {
+		s.listings.put(dir, dirInfo.ModTime(), entries)
 	}
-	flush()
-	return args
+	return entries, nil
 }
 
-func formatLong(info os.FileInfo, name string, humanReadable bool) string {
-	// mode, size, date, name (owner/group omitted for portability)
-	mode := info.Mode().String()
-	size := info.Size()
-	mod := info.ModTime().Format("Jan _2 15:04")
+// ===== Aliases =====
 
-	if humanReadable {
-		sizeStr := formatHumanSize(size)
-		return fmt.Sprintf("%s %10s %s %s", mode, sizeStr, mod, name)
-	}
-	return fmt.Sprintf("%s %10d %s %s", mode, size, mod, name)
-}
+// maxAliasDepth bounds how many aliases may expand into one another, so
+// that a loop such as `a = b` and `b = a` ends with an error
+const maxAliasDepth = 10
 
-// formatHumanSize formats byte size in human-readable format
-func formatHumanSize(size int64) string {
-	if size < 1024 {
-		return fmt.Sprintf("%dB", size)
-	}
-	const unit = 1024
-	if size < unit*unit {
-		return fmt.Sprintf("%.1fK", float64(size)/unit)
-	}
-	if size < unit*unit*unit {
-		return fmt.Sprintf("%.1fM", float64(size)/(unit*unit))
+var aliasNameRe = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+// loadAliases reads an aliases file: one `name = command line` per line,
+// with blank lines and # comments ignored
+func loadAliases(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
 	}
-	if size < unit*unit*unit*unit {
-		return fmt.Sprintf("%.1fG", float64(size)/(unit*unit*unit))
+	defer func() { _ = f.Close() }()
+
+	aliases := make(map[string]string)
+	scanner := newLineScanner(f)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		name, command, ok := strings.Cut(line, "=")
+		name, command = strings.TrimSpace(name), strings.TrimSpace(command)
+		if !ok || !aliasNameRe.MatchString(name) || command == "" {
+			return nil, fmt.Errorf("%s:%d: expected NAME = COMMAND", path, lineNum)
+		}
+		if _, err := parseArgs(command); err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", path, lineNum, err)
+		}
+		aliases[name] = command
 	}
-	return fmt.Sprintf("%.1fT", float64(size)/(unit*unit*unit*unit))
+	return aliases, scanner.Err()
 }
 
-// text/binary heuristic: reject if contains NUL or too many non-printables;
-// accept if UTF-8 valid or printable ratio >= 0.85
-func looksText(sample []byte) bool {
-	if bytes.IndexByte(sample, 0x00) >= 0 {
-		return false
-	}
-	if utf8.Valid(sample) {
-		return true
-	}
-	printable := 0
-	total := 0
-	for _, b := range sample {
-		total++
-		if b == 9 || b == 10 || b == 13 || (b >= 32 && b <= 126) {
-			printable++
+// expandAliases replaces a leading alias in args with its command line,
+// repeatedly, keeping the remaining arguments. An alias that starts with
+// its own name (`ls = ls -a`) expands once, like in a shell.
+func (s *server) expandAliases(args []string, vars map[string]string) ([]string, error) {
+	for depth := 0; len(args) > 0; depth++ {
+		command, ok := s.aliases[args[0]]
+		if !ok {
+			return args, nil
+		}
+		if depth == maxAliasDepth {
+			return nil, fmt.Errorf("%s: alias loop", args[0])
+		}
+		expanded, err := parseArgsExpand(command, vars)
+		if err != nil {
+			return nil, err
+		}
+		self := len(expanded) > 0 && expanded[0] == args[0]
+		args = append(expanded, args[1:]...)
+		if self {
+			return args, nil
 		}
 	}
-	if total == 0 {
-		return true
-	}
-	return float64(printable)/float64(total) >= 0.85
+	return args, nil
 }
 
-// ===== HTTP payloads =====
+// ===== Writes to the served tree =====
 
-type execReq struct {
-	Input string `json:"input"`
-}
+// Every change to files under the root goes through the helpers below,
+// which refuse to run unless the server was started with -writable.
+// Command handlers check writable mode too; this is the guarantee that a
+// forgotten check cannot turn into a write. TestWritesGoThroughGuard fails
+// if filesystem writes show up anywhere else.
 
-type execResp struct {
-	Output    string  `json:"output"`
-	Download  string  `json:"download,omitempty"`
-	CWD       string  `json:"cwd,omitempty"`
-	Readme    *string `json:"readme,omitempty"`
-	DocType   string  `json:"docType,omitempty"`
-	Clipboard string  `json:"clipboard,omitempty"`
-	HTML      string  `json:"html,omitempty"`
-	Redirect  string  `json:"redirect,omitempty"`
+// errReadOnly is returned by the write helpers on a read-only server
+var errReadOnly = errors.New("read-only file system")
+
+func (s *server) mkdir(rp string, parents bool) error {
+	if !s.writable {
+		return errReadOnly
+	}
+	if parents {
+		return os.MkdirAll(rp, 0o755)
+	}
+	return os.Mkdir(rp, 0o755)
 }
 
-type completeReq struct {
-	Path      string `json:"path"`
-	DirsOnly  bool   `json:"dirsOnly"`
-	FilesOnly bool   `json:"filesOnly"`
-	TextOnly  bool   `json:"textOnly"`
-	MaxSize   int64  `json:"maxSize"`
+func (s *server) remove(rp string, recursive bool) error {
+	if !s.writable {
+		return errReadOnly
+	}
+	if recursive {
+		return os.RemoveAll(rp)
+	}
+	return os.Remove(rp)
 }
 
-type completeItem struct {
-	Name string `json:"name"`
-	Dir  bool   `json:"dir"`
+func (s *server) rename(src, dst string) error {
+	if !s.writable {
+		return errReadOnly
+	}
+	return os.Rename(src, dst)
 }
 
-type completeResp struct {
-	Items []completeItem `json:"items"`
+// createFile opens rp for writing; an existing file is only truncated
+// when replace is set
+func (s *server) createFile(rp string, replace bool, perm os.FileMode) (*os.File, error) {
+	if !s.writable {
+		return nil, errReadOnly
+	}
+	flags := os.O_WRONLY | os.O_CREATE | os.O_EXCL
+	if replace {
+		flags = os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	}
+	return os.OpenFile(rp, flags, perm)
 }
 
-type configResp struct {
-	CatMax  int64   `json:"catMax"`
-	Readme  *string `json:"readme,omitempty"`
-	DocType string  `json:"docType,omitempty"`
-	CWD     string  `json:"cwd,omitempty"`
+// writeFileAtomic writes to a temp file first so a crash never leaves dst
+// truncated
+func (s *server) writeFileAtomic(dst string, data []byte, perm os.FileMode) error {
+	if !s.writable {
+		return errReadOnly
+	}
+	tmp := dst + ".tmp"
+	if err := os.WriteFile(tmp, data, perm); err != nil {
+		return err
+	}
+	return os.Rename(tmp, dst)
 }
 
-// ===== Handlers =====
+// hiddenName reports whether rp is a name write commands must not create,
+// the same names upload refuses: dotfiles, which include .lsgetignore and
+// .lsgetaccess, and anything the ignore rules hide. Either would vanish
+// from listings the moment it was written.
+func (s *server) hiddenName(rp string) bool {
+	name := filepath.Base(rp)
+	return strings.HasPrefix(name, ".") || s.shouldIgnore(rp, name)
+}
 
-func (s *server) handleIndex(w http.ResponseWriter, r *http.Request) {
-	// Check for no-JS fallback query parameter
-	noJS := r.URL.Query().Get("nojs") == "1"
+// controlFiles tell lsget what to hide and whom to let in; removing or
+// replacing one would lift its rules, so write commands leave them alone
+var controlFiles = []string{".lsgetignore", ".gitignore", accessFile, legacyBookmarksFile}
 
-	// For root path, check for index.html first
-	if r.URL.Path == "/" {
-		indexPath := filepath.Join(s.rootAbs, "index.html")
-		if indexInfo, err := os.Stat(indexPath); err == nil && !indexInfo.IsDir() {
-			// Serve index.html instead of lsget interface
-			s.serveFile(w, r, indexPath, indexInfo)
-			return
+// servesPath reports whether p, which need not exist, lies under the
+// served directory or one of its mounts
+func (s *server) servesPath(p string) bool {
+	resolve := func(p string) string {
+		if real, err := filepath.EvalSymlinks(p); err == nil {
+			return real
 		}
-		
-		// No index.html, serve lsget interface or no-JS fallback
-		if noJS {
-			s.serveNoJSDirectory(w, r, "/")
-		} else {
-			s.serveMainIndex(w, r, "/")
+		return p
+	}
+	p = filepath.Join(resolve(filepath.Dir(p)), filepath.Base(p))
+	dirs := []string{s.rootAbs}
+	for _, m := range s.mounts {
+		dirs = append(dirs, m.abs)
+	}
+	for _, dir := range dirs {
+		if rel, err := filepath.Rel(resolve(dir), p); err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return true
 		}
-		return
 	}
+	return false
+}
 
-	// For other paths, check if it's a file or directory
-	requestPath := path.Clean(r.URL.Path)
-	realPath, err := s.realFromVirtual(requestPath)
-	if err != nil {
-		// Path outside root, serve appropriate response
-		if noJS {
-			http.NotFound(w, r)
-		} else {
-			s.serveMainIndex(w, r, "/")
+// isControlFile reports whether rp is one of controlFiles
+func (s *server) isControlFile(rp string) bool {
+	name := filepath.Base(rp)
+	for _, c := range controlFiles {
+		if name == c || s.caseInsensitive && strings.EqualFold(name, c) {
+			return true
 		}
-		return
 	}
+	return false
+}
 
-	// Check if path exists
-	info, err := os.Stat(realPath)
-	if err != nil {
-		// Path doesn't exist
-		if noJS {
-			http.NotFound(w, r)
-		} else {
-			s.serveMainIndex(w, r, "/")
+// holdsUnseen reports whether the tree below dir holds something the
+// client cannot see: a control file, access rules included, or an ignored
+// entry. rm -r and mv refuse such a tree rather than take those along.
+func (s *server) holdsUnseen(dir string) bool {
+	found := false
+	_ = filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || p != dir && (s.isControlFile(p) || s.shouldIgnore(p, d.Name())) {
+			found = true
+			return filepath.SkipAll
 		}
-		return
-	}
+		return nil
+	})
+	return found
+}
 
-	if info.IsDir() {
-		// Check if directory contains index.html
-		indexPath := filepath.Join(realPath, "index.html")
-		if indexInfo, err := os.Stat(indexPath); err == nil && !indexInfo.IsDir() {
-			// Serve index.html instead of lsget interface
-			s.serveFile(w, r, indexPath, indexInfo)
-			return
+// createsHidden reports whether making directory rp, parents included,
+// would create a hidden name
+func (s *server) createsHidden(rp string) bool {
+	for p := rp; p != s.rootAbs && p != filepath.Dir(p); p = filepath.Dir(p) {
+		if _, err := os.Lstat(p); err == nil {
+			return false
 		}
-		
-		// It's a directory without index.html
-		if noJS {
-			s.serveNoJSDirectory(w, r, requestPath)
-		} else {
-			s.serveMainIndex(w, r, requestPath)
+		if s.hiddenName(p) {
+			return true
 		}
-	} else {
-		// It's a file, serve it directly for download
-		s.serveFile(w, r, realPath, info)
 	}
+	return false
 }
 
-func (s *server) serveFile(w http.ResponseWriter, r *http.Request, realPath string, info os.FileInfo) {
-	// Check if file should be ignored based on .lsgetignore patterns
-	fileName := filepath.Base(realPath)
-	if s.shouldIgnore(realPath, fileName) {
-		http.NotFound(w, r)
-		return
-	}
+// ===== Bookmarks =====
 
-	// Set appropriate content type based on file extension
-	contentType := mime.TypeByExtension(filepath.Ext(realPath))
-	if contentType == "" {
-		contentType = "application/octet-stream"
-	}
-	w.Header().Set("Content-Type", contentType)
+// Bookmarks persist in the file named by -bookmarks, which must lie
+// outside the served directory. It is read once at startup and on
+// reload, and rewritten whenever a session changes its bookmarks.
+// Sessions are keyed by bookmarkKey, never by their cookie.
 
-	// For certain file types, force download with Content-Disposition
-	ext := strings.ToLower(filepath.Ext(realPath))
-	switch ext {
-	case ".pdf", ".doc", ".docx", ".xls", ".xlsx", ".zip", ".rar", ".7z", ".tar", ".gz":
-		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, fileName))
-	}
+// legacyBookmarksFile is where older versions kept bookmarks, inside the
+// served root; it stays hidden in case one was left behind
+const legacyBookmarksFile = ".lsget-bookmarks.json"
 
-	// Serve the file
-	http.ServeFile(w, r, realPath)
+// bookmarkKey identifies session sid in the bookmarks file: a hash, so
+// whoever reads the file learns no cookie to replay
+func bookmarkKey(sid string) string {
+	sum := sha256.Sum256([]byte(sid))
+	return hex.EncodeToString(sum[:])
 }
 
-func (s *server) serveMainIndex(w http.ResponseWriter, r *http.Request, initialPath string) {
-	var htmlContent []byte
-
-	// Serve from disk if available so you can iterate quickly.
-	if b, err := os.ReadFile("index.html"); err == nil {
-		htmlContent = b
-	} else {
-		// Fallback to embedded.
-		htmlContent = embeddedIndex
+// readBookmarksFile parses the bookmarks file at p; a missing or broken
+// file yields nil
+func readBookmarksFile(p string) map[string]map[string]string {
+	data, err := os.ReadFile(p)
+	if err != nil {
+		return nil
 	}
-
-	// Replace placeholder with actual help message and initial path
-	processedHTML := s.processHTMLTemplate(htmlContent, initialPath)
-
-	w.Header().Set("Content-Type", "text/html; charset=utf-8")
-	w.WriteHeader(http.StatusOK)
-	_, _ = w.Write(processedHTML)
+	var all map[string]map[string]string
+	if err := json.Unmarshal(data, &all); err != nil {
+		return nil
+	}
+	return all
 }
 
-// serveNoJSDirectory serves a plain HTML directory listing for no-JS fallback
-func (s *server) serveNoJSDirectory(w http.ResponseWriter, r *http.Request, virtualPath string) {
-	realPath, err := s.realFromVirtual(virtualPath)
-	if err != nil {
-		http.NotFound(w, r)
-		return
+// writeBookmarksFile replaces the bookmarks file at p through a temporary
+// file next to it, so a crash never leaves it truncated. The file lies
+// outside the served tree, which is why it bypasses the write helpers.
+func writeBookmarksFile(p string, data []byte) error {
+	tmp := p + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
 	}
+	return os.Rename(tmp, p)
+}
 
-	entries, err := os.ReadDir(realPath)
-	if err != nil {
-		http.Error(w, "Error reading directory", http.StatusInternalServerError)
+// loadBookmarks merges the bookmarks file into memory, if -bookmarks is
+// set: sessions in the file take its bookmarks, the others keep theirs
+func (s *server) loadBookmarks() {
+	if s.bookmarksPath == "" {
 		return
 	}
+	all := readBookmarksFile(s.bookmarksPath)
+	s.bookmarksMu.Lock()
+	defer s.bookmarksMu.Unlock()
+	if s.savedBookmarks == nil {
+		s.savedBookmarks = make(map[string]map[string]string)
+	}
+	maps.Copy(s.savedBookmarks, all)
+}
 
-	escapedVirtualPath := template.HTMLEscapeString(virtualPath)
-
-	w.Header().Set("Content-Type", "text/html; charset=utf-8")
-	w.WriteHeader(http.StatusOK)
-
-	_, _ = fmt.Fprintf(w, `<!DOCTYPE html>
-<html>
-<head>
-<title>Index of %s</title>
-<style>
-body { font-family: monospace; margin: 20px; }
-a { color: blue; text-decoration: underline; }
-a:visited { color: blue; }
-</style>
-</head>
-<body>
-`, escapedVirtualPath)
-
-	_, _ = fmt.Fprintf(w, "<h1>Index of %s</h1>\n", escapedVirtualPath)
-	_, _ = fmt.Fprintf(w, "<hr>\n")
+// savedBookmarksOf returns the persisted bookmarks of session sid, and
+// whether any were persisted
+func (s *server) savedBookmarksOf(sid string) (map[string]string, bool) {
+	s.bookmarksMu.Lock()
+	defer s.bookmarksMu.Unlock()
+	bm, ok := s.savedBookmarks[bookmarkKey(sid)]
+	return maps.Clone(bm), ok
+}
 
-	if virtualPath != "/" {
-		parentPath := path.Dir(virtualPath)
-		_, _ = fmt.Fprintf(w, "<a href=\"%s?nojs=1\">[Parent Directory]</a><br>\n", urlEscapeVirtual(parentPath))
+// reload re-reads state operators may edit while lsget runs: it checks
+// the root is still reachable and reloads persisted bookmarks into live
+// sessions. The root directory itself stays fixed; .lsgetignore files
+// need no reload since they are read on every lookup.
+func (s *server) reload() {
+	if info, err := os.Stat(s.rootAbs); err != nil || !info.IsDir() {
+		logf("Reload: root directory %s is not accessible\n", s.rootAbs)
+	}
+	if s.bookmarksPath != "" {
+		s.loadBookmarks()
+		s.mu.RLock()
+		for id, sess := range s.sessions {
+			// sessions the file does not mention keep their bookmarks,
+			// which may never have been saved
+			if bm, ok := s.savedBookmarksOf(id); ok {
+				sess.replaceBookmarks(bm)
+			}
+		}
+		s.mu.RUnlock()
 	}
+	logf("Configuration reloaded\n")
+}
 
-	var dirs []os.DirEntry
-	var files []os.DirEntry
+// saveBookmarks persists the bookmarks of a session when -bookmarks is set
+func (s *server) saveBookmarks(sess *session) error {
+	if s.bookmarksPath == "" {
+		return nil
+	}
+	s.bookmarksMu.Lock()
+	defer s.bookmarksMu.Unlock()
 
-	for _, entry := range entries {
-		name := entry.Name()
-		if strings.HasPrefix(name, ".") {
-			continue
-		}
-		realFilePath := filepath.Join(realPath, name)
-		if s.shouldIgnore(realFilePath, name) {
-			continue
-		}
-
-		if entry.IsDir() {
-			dirs = append(dirs, entry)
-		} else {
-			files = append(files, entry)
-		}
+	if s.savedBookmarks == nil {
+		s.savedBookmarks = make(map[string]map[string]string)
 	}
+	key := bookmarkKey(sess.id)
+	if bm := sess.getBookmarks(); len(bm) == 0 {
+		delete(s.savedBookmarks, key)
+	} else {
+		s.savedBookmarks[key] = bm
+	}
+	data, err := json.MarshalIndent(s.savedBookmarks, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeBookmarksFile(s.bookmarksPath, data)
+}
 
-	sort.Slice(dirs, func(i, j int) bool {
-		return dirs[i].Name() < dirs[j].Name()
-	})
-	sort.Slice(files, func(i, j int) bool {
-		return files[i].Name() < files[j].Name()
-	})
-
-	for _, dir := range dirs {
-		dirPath := path.Join(virtualPath, dir.Name())
-		_, _ = fmt.Fprintf(w, "<a href=\"%s?nojs=1\">%s/</a><br>\n", html.EscapeString(urlEscapeVirtual(dirPath)), template.HTMLEscapeString(dir.Name()))
+// ensure virtual path always starts with "/" and is cleaned
+func cleanVirtual(p string) string {
+	if p == "" {
+		return "/"
+	}
+	if !strings.HasPrefix(p, "/") {
+		p = "/" + p
 	}
+	return path.Clean(p)
+}
 
-	for _, file := range files {
-		filePath := path.Join(virtualPath, file.Name())
-		info, _ := file.Info()
-		var size string
-		if info != nil {
-			size = fmt.Sprintf(" (%d bytes)", info.Size())
-		}
-		_, _ = fmt.Fprintf(w, "<a href=\"%s\">%s</a>%s<br>\n", html.EscapeString(urlEscapeVirtual(filePath)), template.HTMLEscapeString(file.Name()), size)
+// join a virtual base with an argument (which can be absolute or relative),
+// then clean and ensure it remains absolute (virtual). A leading "~" or
+// "~/" means the virtual root, which is the jail's home; "~user" stays
+// literal.
+func joinVirtual(base, arg string) string {
+	if arg == "" {
+		return cleanVirtual(base)
+	}
+	if arg == "~" || strings.HasPrefix(arg, "~/") {
+		return cleanVirtual(arg[1:])
+	}
+	if strings.HasPrefix(arg, "/") {
+		return cleanVirtual(arg)
+	}
+	if base == "" {
+		base = "/"
 	}
+	return cleanVirtual(path.Join(base, arg))
+}
 
-	_, _ = fmt.Fprintf(w, "</body>\n</html>\n")
+// convert a virtual path to a real filesystem path and ensure it is
+// rooted inside s.rootAbs
+func (s *server) realFromVirtual(v string) (string, error) {
+	if len(s.mounts) == 0 {
+		return resolveInJail(s.rootAbs, v, s.caseInsensitive)
+	}
+	// With mounts, each one is a jail of its own, and nothing but the
+	// mount links exists at the top
+	name, rest, _ := strings.Cut(strings.TrimPrefix(cleanVirtual(v), "/"), "/")
+	if name == "" {
+		return s.rootAbs, nil
+	}
+	m, ok := s.mountNamed(name)
+	if !ok {
+		return "", errOutsideRoot
+	}
+	rp, err := resolveInJail(m.abs, rest, s.caseInsensitive)
+	if err != nil {
+		return "", err
+	}
+	rel, err := filepath.Rel(m.abs, rp)
+	if err != nil {
+		return "", errOutsideRoot
+	}
+	return filepath.Join(s.rootAbs, m.name, rel), nil
 }
 
-func (s *server) handleStaticFile(w http.ResponseWriter, r *http.Request) {
-	// Remove the /api/static prefix
-	requestPath := strings.TrimPrefix(r.URL.Path, "/api/static")
-	requestPath = path.Clean(requestPath)
+// errOutsideRoot is returned for paths that would leave the served root
+var errOutsideRoot = errors.New("permission denied")
 
-	// Convert virtual path to real filesystem path
-	realPath, err := s.realFromVirtual(requestPath)
+// mount is a directory served as a top-level entry of the virtual tree
+type mount struct {
+	name string // its name under /
+	abs  string // absolute directory it stands for, symlinks resolved
+}
+
+// parseMount parses a -mount value of the form NAME=DIR
+func parseMount(spec string) (mount, error) {
+	name, dir, ok := strings.Cut(spec, "=")
+	name = strings.TrimSpace(name)
+	if !ok || name == "" || strings.TrimSpace(dir) == "" {
+		return mount{}, fmt.Errorf("%q: expected NAME=DIR", spec)
+	}
+	if strings.ContainsAny(name, `/\`) || strings.HasPrefix(name, ".") {
+		return mount{}, fmt.Errorf("%q: invalid name %q", spec, name)
+	}
+	abs, err := filepath.Abs(strings.TrimSpace(dir))
+	if err == nil {
+		abs, err = filepath.EvalSymlinks(abs)
+	}
 	if err != nil {
-		http.NotFound(w, r)
-		return
+		return mount{}, fmt.Errorf("%q: %v", spec, err)
+	}
+	if info, err := os.Stat(abs); err != nil || !info.IsDir() {
+		return mount{}, fmt.Errorf("%q: %s is not a directory", spec, abs)
 	}
+	return mount{name: name, abs: abs}, nil
+}
 
-	// Check if file exists and get info
-	info, err := os.Stat(realPath)
+// newMountRoot creates the directory served as / when there are mounts: a
+// temporary directory holding one symlink per mount, so listing, cd and
+// .lsgetignore lookups work on it as on any root
+func newMountRoot(mounts []mount) (string, error) {
+	dir, err := os.MkdirTemp("", "lsget-mounts-")
 	if err != nil {
-		http.NotFound(w, r)
-		return
+		return "", err
+	}
+	for _, m := range mounts {
+		if err := os.Symlink(m.abs, filepath.Join(dir, m.name)); err != nil {
+			_ = os.RemoveAll(dir)
+			if errors.Is(err, os.ErrExist) {
+				return "", fmt.Errorf("mount %s given twice", m.name)
+			}
+			return "", err
+		}
 	}
+	return dir, nil
+}
 
-	// Don't serve directories as static files
-	if info.IsDir() {
-		http.NotFound(w, r)
-		return
+// mountNamed looks up a mount by the name it has under /
+func (s *server) mountNamed(name string) (mount, bool) {
+	for _, m := range s.mounts {
+		if m.name == name || s.caseInsensitive && strings.EqualFold(m.name, name) {
+			return m, true
+		}
 	}
+	return mount{}, false
+}
 
-	// Use the common serveFile function
-	s.serveFile(w, r, realPath, info)
+// isMount reports whether realPath is the link of a mount. Walkers must
+// descend into these although they are symlinks.
+func (s *server) isMount(realPath string) bool {
+	return len(s.mounts) > 0 && realPath != s.rootAbs && filepath.Dir(realPath) == s.rootAbs
 }
 
-// handleVendoredMarked serves the vendored marked.min.js library
-func (s *server) handleVendoredMarked(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/javascript; charset=utf-8")
-	w.Header().Set("Cache-Control", "public, max-age=31536000") // Cache for 1 year
-	_, _ = w.Write(embeddedMarkedJS)
+// entryIsDir is DirEntry.IsDir, counting mounts as directories
+func (s *server) entryIsDir(dir string, e os.DirEntry) bool {
+	return e.IsDir() || s.isMount(filepath.Join(dir, e.Name()))
 }
 
-// handleVendoredDatastar serves the vendored datastar.js library
-func (s *server) handleVendoredDatastar(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/javascript; charset=utf-8")
-	w.Header().Set("Cache-Control", "public, max-age=31536000") // Cache for 1 year
-	_, _ = w.Write(embeddedDatastarJS)
+// walkGuard keeps a recursive walk from going round in circles. It tracks
+// the directories above the one being entered and refuses a directory that
+// is one of them again, as a link or bind mount pointing back up the tree
+// would make it. Siblings reached twice are still walked, so overlapping
+// mounts each show their full contents. Once ctx is done, nothing more is
+// entered and the walkers stop where they are.
+type walkGuard struct {
+	ctx   context.Context
+	above []walkDir
 }
 
-// processHTMLTemplate replaces placeholders in HTML with dynamic content
-func (s *server) processHTMLTemplate(htmlContent []byte, requestPath string) []byte {
-	// Split into lines and wrap each in HTML div tags
-	lines := strings.Split(strings.TrimSpace(renderHelp()), "\n")
-	var htmlLines []string
-	for _, line := range lines {
-		if line == "" {
-			htmlLines = append(htmlLines, "<div class=\\\"line out\\\"></div>")
-		} else {
-			// Escape double quotes for JavaScript double-quoted string
-			escapedLine := strings.ReplaceAll(line, "\\", "\\\\")       // Escape backslashes first
-			escapedLine = strings.ReplaceAll(escapedLine, "\"", "\\\"") // Escape double quotes
-			htmlLines = append(htmlLines, fmt.Sprintf("<div class=\\\"line out\\\">%s</div>", escapedLine))
-		}
-	}
-	htmlLines = append(htmlLines, "<br/>")
+func newWalkGuard(ctx context.Context) *walkGuard {
+	return &walkGuard{ctx: ctx}
+}
 
-	// Join all HTML lines into a single string (no newlines between them)
-	formattedHelpMessage := strings.Join(htmlLines, "")
+// done reports whether the walk has run out of time or was cancelled
+func (g *walkGuard) done() bool {
+	return g.ctx != nil && g.ctx.Err() != nil
+}
 
-	// Clean the request path for initial CWD
-	initialPath := cleanVirtual(requestPath)
-	if initialPath == "" {
-		initialPath = "/"
+// timedOut is the notice a walking command ends with when it stopped at
+// -walk-timeout, or "" if it didn't
+func (g *walkGuard) timedOut(cmd string) string {
+	if g.ctx == nil || !errors.Is(g.ctx.Err(), context.DeadlineExceeded) {
+		return ""
 	}
+	return fmt.Sprintf("%s%s: search timed out, results are partial%s", colorYellow, cmd, colorReset)
+}
 
-	escapedInitialPath := template.HTMLEscapeString(initialPath)
-
-	result := strings.ReplaceAll(string(htmlContent), "{{HELP_MESSAGE}}", formattedHelpMessage)
-	result = strings.ReplaceAll(result, "{{INITIAL_PATH}}", escapedInitialPath)
-	return []byte(result)
+// walkContext bounds one walking command by -walk-timeout; it also ends
+// with the request
+func (s *server) walkContext(r *http.Request) (context.Context, context.CancelFunc) {
+	if s.walkTimeout > 0 {
+		return context.WithTimeout(r.Context(), s.walkTimeout)
+	}
+	return context.WithCancel(r.Context())
 }
 
-func (s *server) handleSitemap(w http.ResponseWriter, r *http.Request) {
-	sitemapPath := filepath.Join(s.rootAbs, "sitemap.xml")
+type walkDir struct {
+	path string
+	info os.FileInfo
+}
 
-	// Check if sitemap exists
-	if _, err := os.Stat(sitemapPath); os.IsNotExist(err) {
-		// Generate on-demand if not exists
-		if s.baseURL != "" {
-			if err := s.generateSitemap(); err != nil {
-				http.Error(w, "Failed to generate sitemap", http.StatusInternalServerError)
-				return
-			}
-		} else {
-			http.Error(w, "Sitemap not configured (missing -baseurl flag)", http.StatusNotFound)
-			return
+// enter reports whether the walk may descend into dir. Walks are depth
+// first, so directories that aren't ancestors of dir are done with and
+// dropped.
+func (g *walkGuard) enter(dir string) bool {
+	if g.done() {
+		return false
+	}
+	for len(g.above) > 0 && !pathWithin(g.above[len(g.above)-1].path, dir) {
+		g.above = g.above[:len(g.above)-1]
+	}
+	info, err := os.Stat(dir)
+	if err != nil {
+		return false
+	}
+	for _, a := range g.above {
+		if os.SameFile(a.info, info) {
+			return false
 		}
 	}
-
-	// Serve the file
-	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
-	http.ServeFile(w, r, sitemapPath)
+	g.above = append(g.above, walkDir{dir, info})
+	return true
 }
 
-func (s *server) handleConfig(w http.ResponseWriter, r *http.Request) {
-	sess := s.getSession(w, r)
-
-	// Check if there's an initial path from the query parameter
-	initialPath := r.URL.Query().Get("path")
-	if initialPath != "" {
-		// Validate and set the initial path
-		newV := cleanVirtual(initialPath)
-		newReal, err := s.realFromVirtual(newV)
-		if err == nil {
-			info, err := os.Stat(newReal)
-			if err == nil && info.IsDir() {
-				sess.cwd = newV
+// walk is filepath.Walk for the virtual tree: it also descends into mounts,
+// reporting their contents by paths through the mount links, and leaves
+// out subtrees with access rules of their own below root, or that loop
+// back to a directory above them
+func (s *server) walk(root string, walkFn filepath.WalkFunc) error {
+	guard := &walkGuard{}
+	fn := func(p string, info os.FileInfo, err error) error {
+		if err == nil && info.IsDir() && (p != root && s.guarded(p) || !guard.enter(p)) {
+			return filepath.SkipDir
+		}
+		return walkFn(p, info, err)
+	}
+	if len(s.mounts) == 0 || root != s.rootAbs && !s.isMount(root) {
+		return filepath.Walk(root, fn)
+	}
+	links := []string{root}
+	if root == s.rootAbs {
+		info, err := os.Stat(root)
+		if err := fn(root, info, err); err != nil {
+			if err == filepath.SkipDir || err == filepath.SkipAll {
+				return nil
 			}
+			return err
+		}
+		links = links[:0]
+		for _, m := range s.mounts {
+			links = append(links, filepath.Join(root, m.name))
 		}
 	}
-
-	// Get readme for current directory
-	var readme string
-	var docType string
-	if sess.cwd == "/" {
-		readme, docType = readDocFile(s.rootAbs)
-	} else {
-		realCwd, err := s.realFromVirtual(sess.cwd)
-		if err == nil {
-			readme, docType = readDocFile(realCwd)
+	for _, link := range links {
+		m, _ := s.mountNamed(filepath.Base(link))
+		err := filepath.Walk(m.abs, func(p string, info os.FileInfo, err error) error {
+			return fn(link+strings.TrimPrefix(p, m.abs), info, err)
+		})
+		if err == filepath.SkipAll {
+			return nil
+		}
+		if err != nil {
+			return err
 		}
 	}
+	return nil
+}
+
+// rootName names the served tree without revealing host paths: the root
+// directory's name, or the mount names
+func (s *server) rootName() string {
+	if len(s.mounts) == 0 {
+		return filepath.Base(s.rootAbs)
+	}
+	names := make([]string, len(s.mounts))
+	for i, m := range s.mounts {
+		names[i] = m.name
+	}
+	return strings.Join(names, ", ")
+}
 
-	_ = json.NewEncoder(w).Encode(configResp{CatMax: s.catMax, Readme: &readme, DocType: docType, CWD: sess.cwd})
+// resolveInJail maps a virtual path to the real path under root. The path
+// is cleaned as a virtual path first, so ".." stops at the root, and every
+// symlink along it is then followed to make sure it still lands under
+// root; links pointing elsewhere are refused. Components that do not exist
+// yet, like a directory about to be created, are checked through their
+// deepest existing ancestor. The returned path keeps the names as given
+// rather than the link targets. With fold set, the containment checks
+// ignore case, for filesystems where /Share and /share are one directory.
+func resolveInJail(root, virtual string, fold bool) (string, error) {
+	within := pathWithin
+	if fold {
+		within = func(root, p string) bool {
+			return pathWithin(strings.ToLower(root), strings.ToLower(p))
+		}
+	}
+	rel := strings.TrimPrefix(cleanVirtual(virtual), "/")
+	realPath := filepath.Join(root, filepath.FromSlash(rel))
+	if !within(root, realPath) {
+		return "", errOutsideRoot
+	}
+	realRoot, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return "", err
+	}
+	resolved, err := evalExisting(realPath, 0)
+	if err != nil {
+		return "", errOutsideRoot
+	}
+	if !within(realRoot, resolved) {
+		return "", errOutsideRoot
+	}
+	return realPath, nil
 }
 
-func (s *server) handleExec(w http.ResponseWriter, r *http.Request) {
-	sess := s.getSession(w, r)
+// pathWithin reports whether p is root or lies below it
+func pathWithin(root, p string) bool {
+	rel, err := filepath.Rel(root, p)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) && !filepath.IsAbs(rel)
+}
 
-	var req execReq
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "bad request", http.StatusBadRequest)
-		return
+// evalExisting is filepath.EvalSymlinks for paths that may not exist yet:
+// missing components are appended to the resolved parent, and a dangling
+// symlink resolves to where it points, since that is where a write to it
+// would land
+func evalExisting(p string, depth int) (string, error) {
+	if depth > 40 {
+		return "", errors.New("too many levels of symbolic links")
 	}
-	line := strings.TrimSpace(req.Input)
-	if line == "" {
-		_ = json.NewEncoder(w).Encode(execResp{Output: ""})
-		return
+	resolved, err := filepath.EvalSymlinks(p)
+	if err == nil {
+		return resolved, nil
 	}
-	args := parseArgs(line)
-	cmd := args[0]
-	argv := args[1:]
+	if !errors.Is(err, fs.ErrNotExist) && !errors.Is(err, syscall.ENOTDIR) {
+		return "", err
+	}
+	if target, err := os.Readlink(p); err == nil {
+		if !filepath.IsAbs(target) {
+			target = filepath.Join(filepath.Dir(p), target)
+		}
+		return evalExisting(target, depth+1)
+	}
+	parent := filepath.Dir(p)
+	if parent == p {
+		return p, nil
+	}
+	resolvedParent, err := evalExisting(parent, depth)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(resolvedParent, filepath.Base(p)), nil
+}
 
-	switch cmd {
-	case "pwd":
-		_ = json.NewEncoder(w).Encode(execResp{Output: sess.cwd, CWD: sess.cwd})
-		return
+func (s *server) virtualFromReal(realPath string) (string, error) {
+rel, err := filepath.Rel(s.rootAbs, realPath)
+if err != nil {
+return "", err
+}
+if rel == "." {
+return "/", nil
+}
+return "/" + filepath.ToSlash(rel), nil
+}
 
-	case "help":
-		_ = json.NewEncoder(w).Encode(execResp{HTML: renderHelp()})
-		return
+// simple args parser: supports quotes ("", ”) and backslash escapes inside quotes
+func parseArgs(line string) ([]string, error) {
+	return parseArgsExpand(line, nil)
+}
 
-	case "ls", "dir":
-		long := false
-		showHidden := false
-		humanReadable := false
-		target := sess.cwd
-		// Parse arguments: flags and optional path
-		for _, arg := range argv {
-			if strings.HasPrefix(arg, "-") {
-				// Handle flags
-				if strings.Contains(arg, "l") {
-					long = true
-				}
-				if strings.Contains(arg, "a") {
-					showHidden = true
+// parseArgsExpand is parseArgs with $NAME and ${NAME} replaced by vars
+// outside single quotes. Unknown names are kept as typed, so nothing but
+// the given variables can ever be expanded.
+func parseArgsExpand(line string, vars map[string]string) ([]string, error) {
+	var args []string
+	var buf bytes.Buffer
+	inSingle, inDouble := false, false
+
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		if inSingle {
+			if c == '\'' {
+				inSingle = false
+			} else {
+				buf.WriteByte(c)
+			}
+			continue
+		}
+		if c == '$' {
+			if name, n := scanVarName(line[i+1:]); name != "" {
+				if v, ok := vars[name]; ok {
+					buf.WriteString(v)
+					i += n
+					continue
+				}
+			}
+		}
+		if inDouble {
+			if c == '"' {
+				inDouble = false
+			} else if c == '\\' && i+1 < len(line) {
+				i++
+				buf.WriteByte(line[i])
+			} else {
+				buf.WriteByte(c)
+			}
+			continue
+		}
+		switch c {
+		case ' ', '\t', '\n':
+			if buf.Len() > 0 {
+				args = append(args, buf.String())
+				buf.Reset()
+			}
+		case '\'':
+			inSingle = true
+		case '"':
+			inDouble = true
+		default:
+			buf.WriteByte(c)
+		}
+	}
+	if inSingle || inDouble {
+		return nil, errUnterminatedQuote
+	}
+	if buf.Len() > 0 {
+		args = append(args, buf.String())
+	}
+	return args, nil
+}
+
+// errUnterminatedQuote is returned by parseArgs for a quote left open
+var errUnterminatedQuote = errors.New("unterminated quote")
+
+// scanVarName reads the variable name after a $, as NAME or {NAME}, and
+// returns it with the number of bytes it spans
+func scanVarName(s string) (string, int) {
+	if strings.HasPrefix(s, "{") {
+		end := strings.IndexByte(s, '}')
+		if end < 2 {
+			return "", 0
+		}
+		return s[1:end], end + 1
+	}
+	n := 0
+	for n < len(s) && (s[n] == '_' || s[n] >= 'A' && s[n] <= 'Z' || s[n] >= 'a' && s[n] <= 'z' || n > 0 && s[n] >= '0' && s[n] <= '9') {
+		n++
+	}
+	return s[:n], n
+}
+
+func formatLong(info os.FileInfo, name string, humanReadable bool) string {
+	// mode, size, date, name (owner/group only with ls -g, see formatLongOwner)
+	return formatLongOwner(info, name, humanReadable, "")
+}
+
+// formatLongOwner is formatLong with the owner columns from ownerColumns
+// inserted after the mode
+func formatLongOwner(info os.FileInfo, name string, humanReadable bool, owner string) string {
+	mode := info.Mode().String()
+	size := info.Size()
+	mod := info.ModTime().Format("Jan _2 15:04")
+
+	if humanReadable {
+		sizeStr := formatHumanSize(size)
+		return fmt.Sprintf("%s %s%10s %s %s", mode, owner, sizeStr, mod, name)
+	}
+	return fmt.Sprintf("%s %s%10d %s %s", mode, owner, size, mod, name)
+}
+
+// ownerColumns renders the padded owner and group of a file for ls -g, or
+// nothing where the platform doesn't expose them
+func ownerColumns(info os.FileInfo) string {
+	if info == nil {
+		return ""
+	}
+	owner, group, ok := fileOwner(info)
+	if !ok {
+		return ""
+	}
+	return fmt.Sprintf("%-8s %-8s ", owner, group)
+}
+
+// defaultTermCols is the terminal width assumed when the client doesn't send one
+const defaultTermCols = 80
+
+// visibleLen returns the number of characters s occupies on screen,
+// skipping ANSI escape sequences such as the color codes above
+func visibleLen(s string) int {
+	n := 0
+	for i := 0; i < len(s); {
+		if s[i] == '\033' && i+1 < len(s) && s[i+1] == '[' {
+			// CSI sequence: ESC [ params... final byte in 0x40-0x7E
+			i += 2
+			for i < len(s) && (s[i] < 0x40 || s[i] > 0x7e) {
+				i++
+			}
+			i++
+			continue
+		}
+		_, size := utf8.DecodeRuneInString(s[i:])
+		i += size
+		n++
+	}
+	return n
+}
+
+// formatColumns lays out names in columns filled top-to-bottom, like GNU ls
+// writing to a terminal. Widths are measured with visibleLen so colorized
+// names line up.
+func formatColumns(names []string, termWidth int) string {
+	const sep = 2
+	n := len(names)
+	if n == 0 {
+		return ""
+	}
+	widths := make([]int, n)
+	for i, name := range names {
+		widths[i] = visibleLen(name)
+	}
+
+	// Try the largest number of columns first and back off until it fits
+	maxCols := termWidth/(1+sep) + 1
+	if maxCols > n {
+		maxCols = n
+	}
+	rows := n
+	var colWidths []int
+	for ncols := maxCols; ncols >= 1; ncols-- {
+		r := (n + ncols - 1) / ncols
+		actual := (n + r - 1) / r
+		cw := make([]int, actual)
+		total := sep * (actual - 1)
+		for i, wd := range widths {
+			c := i / r
+			if wd > cw[c] {
+				total += wd - cw[c]
+				cw[c] = wd
+			}
+		}
+		if total <= termWidth || ncols == 1 {
+			rows = r
+			colWidths = cw
+			break
+		}
+	}
+
+	var b strings.Builder
+	for r := 0; r < rows; r++ {
+		for c := range colWidths {
+			i := c*rows + r
+			if i >= n {
+				break
+			}
+			b.WriteString(names[i])
+			if c < len(colWidths)-1 && i+rows < n {
+				b.WriteString(strings.Repeat(" ", colWidths[c]-widths[i]+sep))
+			}
+		}
+		if r < rows-1 {
+			b.WriteString("\n")
+		}
+	}
+	return b.String()
+}
+
+// formatHumanSize formats byte size in human-readable format
+func formatHumanSize(size int64) string {
+	if size < 1024 {
+		return fmt.Sprintf("%dB", size)
+	}
+	const unit = 1024
+	if size < unit*unit {
+		return fmt.Sprintf("%.1fK", float64(size)/unit)
+	}
+	if size < unit*unit*unit {
+		return fmt.Sprintf("%.1fM", float64(size)/(unit*unit))
+	}
+	if size < unit*unit*unit*unit {
+		return fmt.Sprintf("%.1fG", float64(size)/(unit*unit*unit))
+	}
+	return fmt.Sprintf("%.1fT", float64(size)/(unit*unit*unit*unit))
+}
+
+// dfHeader heads the columns of formatDiskUsage rows
+var dfHeader = fmt.Sprintf("%s%-8s %-8s %-8s %-5s %s%s", colorCyan, "Size", "Used", "Avail", "Use%", "Path", colorReset)
+
+// treeSize adds up the apparent size of the files under realPath that a
+// visitor can see, skipping hidden, ignored and restricted entries like a
+// directory download does. It stops early once the guard's context is done.
+func (s *server) treeSize(guard *walkGuard, realPath string) int64 {
+	var total int64
+	_ = s.walk(realPath, func(p string, info os.FileInfo, err error) error {
+		if guard.done() {
+			return filepath.SkipAll
+		}
+		if err != nil {
+			return nil
+		}
+		if p != realPath && (strings.HasPrefix(info.Name(), ".") || s.shouldIgnore(p, info.Name())) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if info.Mode().IsRegular() {
+			total += info.Size()
+		}
+		return nil
+	})
+	return total
+}
+
+// duEntry is one row of du output
+type duEntry struct {
+	name string
+	info os.FileInfo
+	size int64
+}
+
+// formatDiskUsage lays out one df row. Like GNU df, Use% counts only the
+// space unprivileged users can get at, and rounds up so that a full disk
+// never reads as 99%.
+func formatDiskUsage(mount string, total, free, avail uint64) string {
+	used := total - free
+	pct := "-"
+	if used+avail > 0 {
+		pct = strconv.FormatUint((used*100+used+avail-1)/(used+avail), 10) + "%"
+	}
+	return fmt.Sprintf("%-8s %-8s %-8s %-5s %s",
+		formatHumanSize(int64(total)), formatHumanSize(int64(used)), formatHumanSize(int64(avail)), pct, mount)
+}
+
+// text/binary heuristic: reject if contains NUL or too many non-printables;
+// accept if UTF-8 valid or printable ratio >= 0.85. UTF-16 text is
+// accepted when it decodes without control characters.
+func looksText(sample []byte) bool {
+	if bytes.IndexByte(sample, 0x00) < 0 && utf8.Valid(sample) {
+		return true
+	}
+	if order, ok := utf16Order(sample); ok {
+		return !strings.ContainsFunc(decodeUTF16(sample, order), func(r rune) bool {
+			return r < 32 && r != '\t' && r != '\n' && r != '\r'
+		})
+	}
+	if bytes.IndexByte(sample, 0x00) >= 0 {
+		return false
+	}
+	printable := 0
+	total := 0
+	for _, b := range sample {
+		total++
+		if b == 9 || b == 10 || b == 13 || (b >= 32 && b <= 126) {
+			printable++
+		}
+	}
+	if total == 0 {
+		return true
+	}
+	return float64(printable)/float64(total) >= 0.85
+}
+
+// utf16Order tells whether sample is UTF-16 and in which byte order, from
+// a byte order mark or from ASCII-range text leaving every other byte NUL
+func utf16Order(sample []byte) (binary.ByteOrder, bool) {
+	if len(sample) >= 2 {
+		switch {
+		case sample[0] == 0xff && sample[1] == 0xfe:
+			return binary.LittleEndian, true
+		case sample[0] == 0xfe && sample[1] == 0xff:
+			return binary.BigEndian, true
+		}
+	}
+	n := min(len(sample), 512) &^ 1
+	if n < 4 {
+		return nil, false
+	}
+	var evenNUL, oddNUL int
+	for i := 0; i < n; i += 2 {
+		if sample[i] == 0 {
+			evenNUL++
+		}
+		if sample[i+1] == 0 {
+			oddNUL++
+		}
+	}
+	switch pairs := n / 2; {
+	case evenNUL == 0 && oddNUL*10 >= pairs*9:
+		return binary.LittleEndian, true
+	case oddNUL == 0 && evenNUL*10 >= pairs*9:
+		return binary.BigEndian, true
+	}
+	return nil, false
+}
+
+// decodeUTF16 converts UTF-16 bytes to a Go string, dropping a leading
+// byte order mark and an odd trailing byte
+func decodeUTF16(b []byte, order binary.ByteOrder) string {
+	u := make([]uint16, 0, len(b)/2)
+	for j := 0; j+1 < len(b); j += 2 {
+		u = append(u, order.Uint16(b[j:]))
+	}
+	if len(u) > 0 && u[0] == 0xfeff {
+		u = u[1:]
+	}
+	return string(utf16.Decode(u))
+}
+
+// decodeText returns text as UTF-8, transcoding UTF-16 files such as
+// configs exported by Windows tools
+func decodeText(b []byte) string {
+	if bytes.IndexByte(b, 0x00) < 0 && utf8.Valid(b) {
+		return string(b)
+	}
+	if order, ok := utf16Order(b); ok {
+		return decodeUTF16(b, order)
+	}
+	return string(b)
+}
+
+// ===== HTTP payloads =====
+
+type execReq struct {
+	Input string `json:"input"`
+	Cols  int    `json:"cols,omitempty"` // terminal width in characters, used by `ls` column layout
+}
+
+type execResp struct {
+	Output    string  `json:"output"`
+	Download  string  `json:"download,omitempty"`
+	CWD       string  `json:"cwd,omitempty"`
+	Readme    *string `json:"readme,omitempty"`
+	DocType   string  `json:"docType,omitempty"`
+	Clipboard string  `json:"clipboard,omitempty"`
+	HTML      string  `json:"html,omitempty"`
+	Redirect  string  `json:"redirect,omitempty"`
+	Open      string  `json:"open,omitempty"`   // URL to open in a new tab
+	Stream    bool    `json:"stream,omitempty"` // partial output over /api/ws; more follows
+	Error     string  `json:"error,omitempty"`  // errno-style code such as ENOENT when the command failed
+	Code      int     `json:"code,omitempty"`   // errno number matching Error
+}
+
+// Error codes reported in execResp.Error when a command fails, named
+// after the errno values they correspond to. execResp.Code carries the
+// errno number, with Linux numbering on every platform.
+const (
+	codePerm      = "EPERM"
+	codeNoEnt     = "ENOENT"
+	codeIO        = "EIO"
+	codeAccess    = "EACCES"
+	codeExist     = "EEXIST"
+	codeNotDir    = "ENOTDIR"
+	codeIsDir     = "EISDIR"
+	codeInvalid   = "EINVAL"
+	codeTooLarge  = "EFBIG"
+	codeReadOnly  = "EROFS"
+	codeNoCommand = "ENOSYS"
+	codeTimedOut  = "ETIMEDOUT"
+	codeCanceled  = "ECANCELED"
+)
+
+var errnoNumbers = map[string]int{
+	codePerm: 1, codeNoEnt: 2, codeIO: 5, codeAccess: 13, codeExist: 17, codeNotDir: 20, codeIsDir: 21,
+	codeInvalid: 22, codeTooLarge: 27, codeReadOnly: 30, codeNoCommand: 38, codeTimedOut: 110, codeCanceled: 125,
+}
+
+// errorResp is the response of a failed command: output for the user and
+// code for programs. An empty code means nothing failed.
+func errorResp(code, output string) execResp {
+	return execResp{Output: output, Error: code, Code: errnoNumbers[code]}
+}
+
+// codedError is an error that knows which code it reports as
+type codedError struct {
+	code string
+	msg  string
+}
+
+func (e *codedError) Error() string { return e.msg }
+
+// errCode picks the code reported for err, falling back to EIO
+func errCode(err error) string {
+	var coded *codedError
+	switch {
+	case errors.As(err, &coded):
+		return coded.code
+	case errors.Is(err, errReadOnly):
+		return codeReadOnly
+	case errors.Is(err, errOutsideRoot), errors.Is(err, fs.ErrPermission):
+		return codeAccess
+	case errors.Is(err, fs.ErrNotExist):
+		return codeNoEnt
+	case errors.Is(err, fs.ErrExist):
+		return codeExist
+	case errors.Is(err, syscall.ENOTDIR):
+		return codeNotDir
+	case errors.Is(err, syscall.EISDIR):
+		return codeIsDir
+	case errors.Is(err, context.DeadlineExceeded):
+		return codeTimedOut
+	case errors.Is(err, context.Canceled):
+		return codeCanceled
+	}
+	return codeIO
+}
+
+type completeReq struct {
+	Path      string `json:"path"`
+	DirsOnly  bool   `json:"dirsOnly"`
+	FilesOnly bool   `json:"filesOnly"`
+	TextOnly  bool   `json:"textOnly"`
+	MaxSize   int64  `json:"maxSize"`
+	// Line is the whole input; while it is a single word, command names
+	// are completed instead of paths
+	Line string `json:"line,omitempty"`
+}
+
+type completeItem struct {
+	Name string `json:"name"`
+	Dir  bool   `json:"dir"`
+	// Replacement is the full text to insert for the completed word,
+	// including the directory part typed so far and a trailing slash
+	// for directories
+	Replacement string `json:"replacement"`
+}
+
+type completeResp struct {
+	Items []completeItem `json:"items"`
+}
+
+type uploadResp struct {
+	Files []string `json:"files"`
+}
+
+type configResp struct {
+	CatMax  int64   `json:"catMax"`
+	Readme  *string `json:"readme,omitempty"`
+	DocType string  `json:"docType,omitempty"`
+	CWD     string  `json:"cwd,omitempty"`
+}
+
+// ===== Handlers =====
+
+func (s *server) handleIndex(w http.ResponseWriter, r *http.Request) {
+	// Check for no-JS fallback query parameter
+	noJS := r.URL.Query().Get("nojs") == "1"
+
+	// For root path, check for index.html first
+	if r.URL.Path == "/" {
+		if !s.accessAllowed(s.rootAbs, s.accessIP(r)) {
+			s.serveError(w, r, http.StatusForbidden)
+			return
+		}
+		indexPath := filepath.Join(s.rootAbs, "index.html")
+		if indexInfo, err := os.Stat(indexPath); err == nil && !indexInfo.IsDir() {
+			// Serve index.html instead of lsget interface
+			s.serveFile(w, r, indexPath, indexInfo)
+			return
+		}
+		
+		// No index.html, serve lsget interface or no-JS fallback
+		if noJS {
+			s.serveNoJSDirectory(w, r, "/")
+		} else {
+			s.serveMainIndex(w, r, "/")
+		}
+		return
+	}
+
+	// For other paths, check if it's a file or directory
+	requestPath := path.Clean(r.URL.Path)
+	realPath, err := s.realFromVirtual(requestPath)
+	if err != nil {
+		// Path outside root, serve appropriate response
+		if noJS {
+			s.serveError(w, r, http.StatusForbidden)
+		} else {
+			s.serveMainIndex(w, r, "/")
+		}
+		return
+	}
+
+	// Check if path exists
+	info, err := os.Stat(realPath)
+	if err != nil {
+		// Path doesn't exist
+		if noJS {
+			s.serveError(w, r, http.StatusNotFound)
+		} else {
+			s.serveMainIndex(w, r, "/")
+		}
+		return
+	}
+	if !s.accessAllowed(realPath, s.accessIP(r)) {
+		s.serveError(w, r, http.StatusForbidden)
+		return
+	}
+
+	if info.IsDir() {
+		// Check if directory contains index.html
+		indexPath := filepath.Join(realPath, "index.html")
+		if indexInfo, err := os.Stat(indexPath); err == nil && !indexInfo.IsDir() {
+			// Serve index.html instead of lsget interface
+			s.serveFile(w, r, indexPath, indexInfo)
+			return
+		}
+		
+		// It's a directory without index.html
+		if noJS {
+			s.serveNoJSDirectory(w, r, requestPath)
+		} else {
+			s.serveMainIndex(w, r, requestPath)
+		}
+	} else {
+		// It's a file, serve it directly for download
+		s.serveFile(w, r, realPath, info)
+	}
+}
+
+// rfc5987Escaper percent-encodes the characters url.PathEscape leaves alone
+// but RFC 5987 does not allow in an ext-value
+var rfc5987Escaper = strings.NewReplacer("'", "%27", "(", "%28", ")", "%29", "*", "%2A",
+	",", "%2C", ";", "%3B", "=", "%3D", ":", "%3A", "@", "%40")
+
+// contentDisposition builds a Content-Disposition header value carrying an
+// ASCII fallback filename for old clients and the exact UTF-8 name as
+// filename* per RFC 5987
+func contentDisposition(disposition, filename string) string {
+	fallback := strings.Map(func(r rune) rune {
+		if r < 0x20 || r > 0x7e || r == '"' || r == '\\' {
+			return '_'
+		}
+		return r
+	}, filename)
+	return fmt.Sprintf(`%s; filename="%s"; filename*=UTF-8''%s`,
+		disposition, fallback, rfc5987Escaper.Replace(url.PathEscape(filename)))
+}
+
+// withCharset adds "; charset=utf-8" to textual content types that don't
+// declare a charset, so browsers don't guess the encoding of inline text
+func withCharset(contentType string) string {
+	if strings.Contains(contentType, "charset=") {
+		return contentType
+	}
+	mediaType, _, _ := strings.Cut(contentType, ";")
+	mediaType = strings.TrimSpace(mediaType)
+	if strings.HasPrefix(mediaType, "text/") ||
+		mediaType == "application/json" || mediaType == "application/xml" ||
+		mediaType == "application/javascript" ||
+		strings.HasSuffix(mediaType, "+json") || strings.HasSuffix(mediaType, "+xml") {
+		return contentType + "; charset=utf-8"
+	}
+	return contentType
+}
+
+// sniffUTF8Text reports whether the start of a file looks like UTF-8 text
+func sniffUTF8Text(realPath string) bool {
+	f, err := os.Open(realPath)
+	if err != nil {
+		return false
+	}
+	defer func() { _ = f.Close() }()
+	sample := make([]byte, 512)
+	n, _ := io.ReadFull(f, sample)
+	sample = sample[:n]
+	// a multi-byte rune may be cut at the end of the sample
+	for i := 0; i < utf8.UTFMax && len(sample) > 0 && !utf8.Valid(sample); i++ {
+		sample = sample[:len(sample)-1]
+	}
+	return looksText(sample) && utf8.Valid(sample)
+}
+
+func (s *server) serveFile(w http.ResponseWriter, r *http.Request, realPath string, info os.FileInfo) {
+	// Check if file should be ignored based on .lsgetignore patterns
+	fileName := filepath.Base(realPath)
+	if s.shouldIgnore(realPath, fileName) {
+		s.serveError(w, r, http.StatusNotFound)
+		return
+	}
+
+	// Set appropriate content type based on file extension
+	contentType := mime.TypeByExtension(filepath.Ext(realPath))
+	if contentType == "" {
+		contentType = "application/octet-stream"
+		// Unknown or missing extension: serve UTF-8 text as text/plain
+		if sniffUTF8Text(realPath) {
+			contentType = "text/plain"
+		}
+	}
+	w.Header().Set("Content-Type", withCharset(contentType))
+
+	// For certain file types, force download with Content-Disposition,
+	// unless ?inline=1 asks the browser to display the file instead
+	ext := strings.ToLower(filepath.Ext(realPath))
+	if r.URL.Query().Get("inline") == "1" {
+		w.Header().Set("Content-Disposition", contentDisposition("inline", fileName))
+	} else {
+		switch ext {
+		case ".pdf", ".doc", ".docx", ".xls", ".xlsx", ".zip", ".rar", ".7z", ".tar", ".gz":
+			w.Header().Set("Content-Disposition", contentDisposition("attachment", fileName))
+		}
+	}
+
+	// Serve the file
+	http.ServeFile(w, r, realPath)
+}
+
+// serveError answers with a themed HTML error page when the client asks
+// for HTML, and with a plain text error otherwise (API clients, curl).
+// A 404.html or 403.html at the top of the root replaces the built-in page.
+func (s *server) serveError(w http.ResponseWriter, r *http.Request, code int) {
+	if !strings.Contains(r.Header.Get("Accept"), "text/html") {
+		http.Error(w, http.StatusText(code), code)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	if custom, err := os.ReadFile(filepath.Join(s.rootAbs, fmt.Sprintf("%d.html", code))); err == nil {
+		w.WriteHeader(code)
+		_, _ = w.Write(custom)
+		return
+	}
+
+	reason := "No such file or directory"
+	if code == http.StatusForbidden {
+		reason = "Permission denied"
+	}
+	requested := html.EscapeString(r.URL.Path)
+	parent, home := urlEscapeVirtual(path.Dir(path.Clean(r.URL.Path))), "/"
+	if r.URL.Query().Get("nojs") == "1" {
+		parent, home = parent+"?nojs=1", home+"?nojs=1"
+	}
+	w.WriteHeader(code)
+	_, _ = fmt.Fprintf(w, `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>%d %s</title>
+<link rel="icon" href="/favicon.ico">
+<style>
+body { background: #303446; color: #c6d0f5; font-family: ui-monospace, Menlo, Consolas, monospace; margin: 0; padding: 2em; }
+.term { max-width: 60em; margin: 10vh auto; padding: 1.5em; background: #292c3c; border-radius: 8px; box-shadow: 0 0 24px rgba(166, 209, 137, 0.15); }
+.ps1 { color: #a6d189; }
+.err { color: #e78284; }
+.code { color: #ef9f76; font-size: 3em; margin: 0 0 0.3em; text-shadow: 0 0 12px rgba(239, 159, 118, 0.6); }
+a { color: #8caaee; }
+</style>
+</head>
+<body>
+<div class="term">
+<p class="code">%d</p>
+<p><span class="ps1">guest@browser:~$</span> cd %s</p>
+<p class="err">%s: %s: %s</p>
+<p><a href="%s">cd ..</a> &nbsp; <a href="%s">cd /</a></p>
+</div>
+</body>
+</html>
+`, code, http.StatusText(code), code, requested, html.EscapeString(s.brandName()), requested, reason, html.EscapeString(parent), html.EscapeString(home))
+}
+
+func (s *server) serveMainIndex(w http.ResponseWriter, r *http.Request, initialPath string) {
+	var htmlContent []byte
+
+	// Serve from disk if available so you can iterate quickly.
+	if b, err := os.ReadFile("index.html"); err == nil {
+		htmlContent = b
+	} else {
+		// Fallback to embedded.
+		htmlContent = embeddedIndex
+	}
+
+	// Replace placeholder with actual help message and initial path
+	processedHTML := s.processHTMLTemplate(htmlContent, initialPath)
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(processedHTML)
+}
+
+// serveNoJSDirectory serves a plain HTML directory listing for no-JS fallback
+func (s *server) serveNoJSDirectory(w http.ResponseWriter, r *http.Request, virtualPath string) {
+	realPath, err := s.realFromVirtual(virtualPath)
+	if err != nil {
+		s.serveError(w, r, http.StatusForbidden)
+		return
+	}
+
+	entries, err := os.ReadDir(realPath)
+	if err != nil {
+		http.Error(w, "Error reading directory", http.StatusInternalServerError)
+		return
+	}
+
+	escapedVirtualPath := html.EscapeString(virtualPath)
+	pageTitle := "Index of " + escapedVirtualPath
+	if s.title != "" || s.brand != "" {
+		pageTitle += " - " + html.EscapeString(s.pageTitle())
+	}
+	offset, _ := strconv.Atoi(r.URL.Query().Get("offset"))
+	limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+	if err != nil || limit <= 0 {
+		limit = listPageSize
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+
+	_, _ = fmt.Fprintf(w, `<!DOCTYPE html>
+<html>
+<head>
+<title>%s</title>
+<link rel="icon" href="/favicon.ico">
+<style>
+body { font-family: monospace; margin: 20px; }
+a { color: blue; text-decoration: underline; }
+a:visited { color: blue; }
+table { border-collapse: collapse; }
+th { text-align: left; }
+td, th { padding: 0 1.5em 0 0; }
+td.size { text-align: right; }
+</style>
+</head>
+<body>
+`, pageTitle)
+
+	_, _ = fmt.Fprintf(w, "<h1>Index of %s</h1>\n", escapedVirtualPath)
+	_, _ = fmt.Fprintf(w, "<table>\n")
+	_, _ = fmt.Fprintf(w, "<tr><th>Name</th><th>Last modified</th><th>Size</th></tr>\n")
+	_, _ = fmt.Fprintf(w, "<tr><th colspan=\"3\"><hr></th></tr>\n")
+
+	if virtualPath != "/" {
+		parentPath := path.Dir(virtualPath)
+		_, _ = fmt.Fprintf(w, "<tr><td><a href=\"%s?nojs=1\">[Parent Directory]</a></td><td></td><td class=\"size\">-</td></tr>\n", html.EscapeString(urlEscapeVirtual(parentPath)))
+	}
+
+	var dirs []os.DirEntry
+	var files []os.DirEntry
+
+	for _, entry := range entries {
+		name := entry.Name()
+		if strings.HasPrefix(name, ".") {
+			continue
+		}
+		realFilePath := filepath.Join(realPath, name)
+		if s.shouldIgnore(realFilePath, name) {
+			continue
+		}
+
+		if s.entryIsDir(realPath, entry) {
+			dirs = append(dirs, entry)
+		} else {
+			files = append(files, entry)
+		}
+	}
+
+	sort.Slice(dirs, func(i, j int) bool {
+		return dirs[i].Name() < dirs[j].Name()
+	})
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].Name() < files[j].Name()
+	})
+
+	// directories come first across pages; only the page's entries are stat'ed
+	total := len(dirs) + len(files)
+	start, end := pageBounds(total, offset, limit)
+	dirs, files = dirs[min(start, len(dirs)):min(end, len(dirs))], files[max(start-len(dirs), 0):max(end-len(dirs), 0)]
+
+	for _, dir := range dirs {
+		dirPath := path.Join(virtualPath, dir.Name())
+		var modified string
+		if info, err := dir.Info(); err == nil {
+			modified = info.ModTime().Format("2006-01-02 15:04")
+		}
+		_, _ = fmt.Fprintf(w, "<tr><td><a href=\"%s?nojs=1\">%s/</a></td><td>%s</td><td class=\"size\">-</td></tr>\n", html.EscapeString(urlEscapeVirtual(dirPath)), html.EscapeString(dir.Name()), modified)
+	}
+
+	for _, file := range files {
+		filePath := path.Join(virtualPath, file.Name())
+		var modified, size string
+		if info, err := file.Info(); err == nil {
+			modified = info.ModTime().Format("2006-01-02 15:04")
+			size = formatHumanSize(info.Size())
+		}
+		_, _ = fmt.Fprintf(w, "<tr><td><a href=\"%s\">%s</a></td><td>%s</td><td class=\"size\">%s</td></tr>\n", html.EscapeString(urlEscapeVirtual(filePath)), html.EscapeString(file.Name()), modified, size)
+	}
+
+	_, _ = fmt.Fprintf(w, "</table>\n<hr>\n")
+	if start > 0 || end < total {
+		pageURL := func(offset int) string {
+			q := url.Values{"nojs": {"1"}, "offset": {strconv.Itoa(offset)}}
+			if limit != listPageSize {
+				q.Set("limit", strconv.Itoa(limit))
+			}
+			return html.EscapeString(urlEscapeVirtual(virtualPath) + "?" + q.Encode())
+		}
+		_, _ = fmt.Fprintf(w, "<p>")
+		if start > 0 {
+			_, _ = fmt.Fprintf(w, "<a href=\"%s\">&laquo; Previous</a> ", pageURL(max(start-limit, 0)))
+		}
+		_, _ = fmt.Fprintf(w, "Entries %d-%d of %d", min(start+1, end), end, total)
+		if end < total {
+			_, _ = fmt.Fprintf(w, " <a href=\"%s\">Next &raquo;</a>", pageURL(end))
+		}
+		_, _ = fmt.Fprintf(w, "</p>\n")
+	}
+	_, _ = fmt.Fprintf(w, "</body>\n</html>\n")
+}
+
+// listPageSize is how many entries ls and the no-JS listing show at once
+// unless asked otherwise; huge folders are paged instead of rendered whole
+const listPageSize = 1000
+
+// pageBounds clamps an offset/limit pair to n entries
+func pageBounds(n, offset, limit int) (start, end int) {
+	start = min(max(offset, 0), n)
+	end = n
+	if limit > 0 {
+		end = min(start+limit, n)
+	}
+	return start, end
+}
+
+func (s *server) handleStaticFile(w http.ResponseWriter, r *http.Request) {
+	// Remove the /api/static prefix
+	requestPath := strings.TrimPrefix(r.URL.Path, "/api/static")
+	requestPath = path.Clean(requestPath)
+
+	// Convert virtual path to real filesystem path
+	realPath, err := s.realFromVirtual(requestPath)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	// Check if file exists and get info
+	info, err := os.Stat(realPath)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	if !s.accessAllowed(realPath, s.accessIP(r)) {
+		http.Error(w, "permission denied", http.StatusForbidden)
+		return
+	}
+
+	// Don't serve directories as static files
+	if info.IsDir() {
+		http.NotFound(w, r)
+		return
+	}
+
+	// Use the common serveFile function
+	s.serveFile(w, r, realPath, info)
+}
+
+// handleVendoredMarked serves the vendored marked.min.js library
+func (s *server) handleVendoredMarked(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/javascript; charset=utf-8")
+	w.Header().Set("Cache-Control", "public, max-age=31536000") // Cache for 1 year
+	_, _ = w.Write(embeddedMarkedJS)
+}
+
+// handleVendoredDatastar serves the vendored datastar.js library
+func (s *server) handleVendoredDatastar(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/javascript; charset=utf-8")
+	w.Header().Set("Cache-Control", "public, max-age=31536000") // Cache for 1 year
+	_, _ = w.Write(embeddedDatastarJS)
+}
+
+// assetsPrefix is where the -assets directory is served. The vendored
+// scripts keep their exact /assets/js/ routes, which take precedence.
+const assetsPrefix = "/assets/"
+
+// handleAssets serves files from the -assets directory. Dotfiles and
+// directories are not served, and paths, symlinks included, must stay
+// inside the directory.
+func (s *server) handleAssets(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	name := cleanVirtual(strings.TrimPrefix(r.URL.Path, assetsPrefix))
+	for _, seg := range strings.Split(name, "/") {
+		if strings.HasPrefix(seg, ".") {
+			http.NotFound(w, r)
+			return
+		}
+	}
+	rp, err := resolveInJail(s.assetsAbs, name, s.caseInsensitive)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	f, err := os.Open(rp)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	defer func() { _ = f.Close() }()
+	info, err := f.Stat()
+	if err != nil || !info.Mode().IsRegular() {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Cache-Control", "public, max-age=3600") // Cache for 1 hour
+	http.ServeContent(w, r, info.Name(), info.ModTime(), f)
+}
+
+// customAssetsHTML links custom.css and custom.js from the -assets
+// directory into the terminal page, when they exist, so a theme needs no
+// changes to index.html
+func (s *server) customAssetsHTML() string {
+	if s.assetsAbs == "" {
+		return ""
+	}
+	var tags []string
+	if info, err := os.Stat(filepath.Join(s.assetsAbs, "custom.css")); err == nil && info.Mode().IsRegular() {
+		tags = append(tags, fmt.Sprintf(`<link rel="stylesheet" href="%scustom.css?v=%d" />`, assetsPrefix, info.ModTime().Unix()))
+	}
+	if info, err := os.Stat(filepath.Join(s.assetsAbs, "custom.js")); err == nil && info.Mode().IsRegular() {
+		tags = append(tags, fmt.Sprintf(`<script src="%scustom.js?v=%d" defer></script>`, assetsPrefix, info.ModTime().Unix()))
+	}
+	return strings.Join(tags, "\n    ")
+}
+
+// handleFavicon serves favicon.ico from the root directory when there is
+// one, so each share can be branded, and the embedded icon otherwise
+func (s *server) handleFavicon(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "image/x-icon")
+	w.Header().Set("Cache-Control", "public, max-age=86400") // Cache for 1 day
+	rp := filepath.Join(s.rootAbs, "favicon.ico")
+	if info, err := os.Stat(rp); err == nil && info.Mode().IsRegular() && !s.shouldIgnore(rp, "favicon.ico") {
+		if f, err := os.Open(rp); err == nil {
+			defer func() { _ = f.Close() }()
+			http.ServeContent(w, r, "favicon.ico", info.ModTime(), f)
+			return
+		}
+	}
+	_, _ = w.Write(embeddedFavicon)
+}
+
+// processHTMLTemplate replaces placeholders in HTML with dynamic content
+func (s *server) processHTMLTemplate(htmlContent []byte, requestPath string) []byte {
+	// Split into lines and wrap each in HTML div tags
+	lines := strings.Split(strings.TrimSpace(s.helpHTML()), "\n")
+	var htmlLines []string
+	for _, line := range lines {
+		if line == "" {
+			htmlLines = append(htmlLines, "<div class=\\\"line out\\\"></div>")
+		} else {
+			// Escape double quotes for JavaScript double-quoted string
+			escapedLine := strings.ReplaceAll(line, "\\", "\\\\")       // Escape backslashes first
+			escapedLine = strings.ReplaceAll(escapedLine, "\"", "\\\"") // Escape double quotes
+			// The JSON sits in a single-quoted attribute, which an apostrophe would end
+			escapedLine = strings.ReplaceAll(escapedLine, "'", "&#39;")
+			htmlLines = append(htmlLines, fmt.Sprintf("<div class=\\\"line out\\\">%s</div>", escapedLine))
+		}
+	}
+	htmlLines = append(htmlLines, "<br/>")
+
+	// Join all HTML lines into a single string (no newlines between them)
+	formattedHelpMessage := strings.Join(htmlLines, "")
+
+	// Clean the request path for initial CWD
+	initialPath := cleanVirtual(requestPath)
+	if initialPath == "" {
+		initialPath = "/"
+	}
+
+	escapedInitialPath := template.HTMLEscapeString(initialPath)
+
+	result := strings.ReplaceAll(string(htmlContent), "{{HELP_MESSAGE}}", formattedHelpMessage)
+	result = strings.ReplaceAll(result, "{{INITIAL_PATH}}", escapedInitialPath)
+	result = strings.ReplaceAll(result, "{{TITLE}}", template.HTMLEscapeString(s.pageTitle()))
+	result = strings.ReplaceAll(result, "{{ANNOUNCEMENT}}", announcementHTML(s.announcement()))
+	result = strings.ReplaceAll(result, "{{CUSTOM_ASSETS}}", s.customAssetsHTML())
+	return []byte(result)
+}
+
+// maxAnnouncement caps the banner, so pointing -message-file at the wrong
+// file can't bloat every page
+const maxAnnouncement = 4096
+
+// announcement returns the operator's banner, if any. The file wins over
+// -message and is re-read on each page load, so a maintenance notice can be
+// posted or lifted without a restart; while it is missing, -message shows.
+func (s *server) announcement() string {
+	if s.messageFile == "" {
+		return s.message
+	}
+	f, err := os.Open(s.messageFile)
+	if err != nil {
+		return s.message
+	}
+	defer func() { _ = f.Close() }()
+	b, err := io.ReadAll(io.LimitReader(f, maxAnnouncement))
+	if err != nil {
+		return s.message
+	}
+	return string(b)
+}
+
+// announcementHTML renders the banner as terminal lines for the buffer
+// string in index.html. The text is shown as typed: markup is escaped and
+// control characters are dropped.
+func announcementHTML(text string) string {
+	text = strings.TrimSpace(strings.ToValidUTF8(text, "\uFFFD"))
+	if text == "" {
+		return ""
+	}
+	text = strings.Map(func(r rune) rune {
+		if r != '\n' && r != '\t' && unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, text)
+	var b strings.Builder
+	for _, line := range strings.Split(text, "\n") {
+		escaped := strings.ReplaceAll(html.EscapeString(line), "\\", "\\\\")
+		fmt.Fprintf(&b, "<div class=\\\"line announce\\\">%s</div>", escaped)
+	}
+	b.WriteString("<br/>")
+	return b.String()
+}
+
+func (s *server) handleSitemap(w http.ResponseWriter, r *http.Request) {
+	sitemapPath := filepath.Join(s.rootAbs, "sitemap.xml")
+
+	// Check if sitemap exists
+	if _, err := os.Stat(sitemapPath); os.IsNotExist(err) {
+		// Generate on-demand if not exists
+		if s.baseURL != "" {
+			if err := s.generateSitemap(); err != nil {
+				http.Error(w, "Failed to generate sitemap", http.StatusInternalServerError)
+				return
+			}
+		} else {
+			http.Error(w, "Sitemap not configured (missing -baseurl flag)", http.StatusNotFound)
+			return
+		}
+	}
+
+	// Serve the file
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	http.ServeFile(w, r, sitemapPath)
+}
+
+func (s *server) handleConfig(w http.ResponseWriter, r *http.Request) {
+	sess := s.getSession(w, r)
+
+	// Check if there's an initial path from the query parameter
+	initialPath := r.URL.Query().Get("path")
+	if initialPath != "" {
+		// Validate and set the initial path
+		newV := cleanVirtual(initialPath)
+		newReal, err := s.realFromVirtual(newV)
+		if err == nil && s.accessAllowed(newReal, s.accessIP(r)) {
+			info, err := os.Stat(newReal)
+			if err == nil && info.IsDir() {
+				sess.chdir(newV)
+			}
+		}
+	}
+
+	// Get readme for current directory
+	cwd := sess.getCwd()
+	var readme string
+	var docType string
+	if cwd == "/" {
+		readme, docType = readDocFile(s.rootAbs)
+	} else {
+		realCwd, err := s.realFromVirtual(cwd)
+		if err == nil {
+			readme, docType = readDocFile(realCwd)
+		}
+	}
+
+	_ = json.NewEncoder(w).Encode(configResp{CatMax: s.catMax, Readme: &readme, DocType: docType, CWD: cwd})
+}
+
+func (s *server) handleExec(w http.ResponseWriter, r *http.Request) {
+	sess := s.getSession(w, r)
+
+	var req execReq
+	if !s.decodeBody(w, r, &req) {
+		return
+	}
+	s.runCommand(w, r, sess, req)
+}
+
+// decodeBody reads the JSON request body into v, refusing bodies over
+// s.maxBody with 413 so a client cannot make the server buffer an
+// arbitrarily large payload. It reports whether v is usable; if not, the
+// error response has been written.
+func (s *server) decodeBody(w http.ResponseWriter, r *http.Request, v any) bool {
+	if s.maxBody > 0 {
+		r.Body = http.MaxBytesReader(w, r.Body, s.maxBody)
+	}
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			http.Error(w, "request body too large", http.StatusRequestEntityTooLarge)
+		} else {
+			http.Error(w, "bad request", http.StatusBadRequest)
+		}
+		return false
+	}
+	return true
+}
+
+// runCommand executes one command line for sess and writes the JSON
+// execResp to w. /api/exec passes the HTTP response; the WebSocket
+// endpoint passes a writer that also implements lineStreamer, so commands
+// producing many lines deliver them while they run.
+func (s *server) runCommand(w io.Writer, r *http.Request, sess *session, req execReq) {
+	line := strings.TrimSpace(req.Input)
+	if line == "" {
+		_ = json.NewEncoder(w).Encode(execResp{Output: ""})
+		return
+	}
+	vars := sess.shellVars()
+	args, err := parseArgsExpand(line, vars)
+	if err != nil {
+		_ = json.NewEncoder(w).Encode(errorResp(codeInvalid, "parse error: "+err.Error()))
+		return
+	}
+	args, err = s.expandAliases(args, vars)
+	if err != nil {
+		_ = json.NewEncoder(w).Encode(errorResp(codeInvalid, err.Error()))
+		return
+	}
+	if len(args) == 0 {
+		_ = json.NewEncoder(w).Encode(execResp{Output: ""})
+		return
+	}
+	if !s.commandAllowed(args[0]) {
+		_ = json.NewEncoder(w).Encode(errorResp(codePerm, args[0]+": command disabled"))
+		return
+	}
+	cmd := args[0]
+	argv := args[1:]
+	cwd := sess.getCwd()
+
+	// .lsgetaccess: refuse paths the client may not enter, including the
+	// current directory if its rules changed since, except to cd away
+	ip := s.accessIP(r)
+	checked := argv
+	if cmd != "cd" {
+		checked = append([]string{"."}, argv...)
+	}
+	if arg, denied := s.deniedArg(cwd, checked, ip); denied {
+		_ = json.NewEncoder(w).Encode(errorResp(codeAccess, fmt.Sprintf("%s: %s: Permission denied", cmd, arg)))
+		return
+	}
+
+	switch cmd {
+	case "pwd":
+		_ = json.NewEncoder(w).Encode(execResp{Output: cwd, CWD: cwd})
+		return
+
+	case "help":
+		_ = json.NewEncoder(w).Encode(execResp{HTML: s.helpHTML()})
+		return
+
+	case "whoami":
+		_ = json.NewEncoder(w).Encode(execResp{Output: whoami(sess, getClientIP(r))})
+		return
+
+	case "env":
+		_ = json.NewEncoder(w).Encode(execResp{Output: s.env()})
+		return
+
+	case "df":
+		vps := []string{"/"}
+		if len(argv) > 0 {
+			vps = []string{joinVirtual(cwd, argv[0])}
+		} else if len(s.mounts) > 0 {
+			// / itself is lsget's own directory of links: report the mounts
+			vps = vps[:0]
+			for _, m := range s.mounts {
+				vps = append(vps, "/"+m.name)
+			}
+		}
+		rows := []string{dfHeader}
+		for _, vp := range vps {
+			rp, err := s.realFromVirtual(vp)
+			if err != nil {
+				_ = json.NewEncoder(w).Encode(errorResp(codeAccess, "df: permission denied"))
+				return
+			}
+			if _, err := os.Stat(rp); err != nil {
+				_ = json.NewEncoder(w).Encode(errorResp(codeNoEnt, fmt.Sprintf("df: %s: no such file or directory", vp)))
+				return
+			}
+			total, free, avail, err := diskUsage(rp)
+			if err != nil {
+				_ = json.NewEncoder(w).Encode(errorResp(codeNoCommand, "df: "+err.Error()))
+				return
+			}
+			rows = append(rows, formatDiskUsage(vp, total, free, avail))
+		}
+		_ = json.NewEncoder(w).Encode(execResp{Output: strings.Join(rows, "\n")})
+		return
+
+	case "du":
+		summarize, humanReadable, top := false, false, 0
+		var operands []string
+		for i := 0; i < len(argv); i++ {
+			arg := argv[i]
+			if name, value, ok := strings.Cut(arg, "="); ok && name == "--top" {
+				arg = name
+				argv = slices.Insert(argv, i+1, value)
+			}
+			switch {
+			case arg == "--top":
+				n, err := -1, error(nil)
+				if i+1 < len(argv) {
+					n, err = strconv.Atoi(argv[i+1])
+				}
+				if n < 1 || err != nil {
+					_ = json.NewEncoder(w).Encode(errorResp(codeInvalid, "du: --top needs a positive number"))
+					return
+				}
+				top = n
+				i++
+			case strings.HasPrefix(arg, "-") && strings.Trim(arg[1:], "sh") == "" && len(arg) > 1:
+				summarize = summarize || strings.Contains(arg, "s")
+				humanReadable = humanReadable || strings.Contains(arg, "h")
+			case strings.HasPrefix(arg, "-"):
+				_ = json.NewEncoder(w).Encode(errorResp(codeInvalid, fmt.Sprintf("du: invalid option '%s'", arg)))
+				return
+			default:
+				operands = append(operands, arg)
+			}
+		}
+		if len(operands) == 0 {
+			operands = []string{"."}
+		}
+		// du -s * reports each match; a plain wildcard is expanded the same way
+		var paths []string
+		for _, op := range operands {
+			if !strings.ContainsAny(op, "*?[") {
+				paths = append(paths, op)
+				continue
+			}
+			matches, _ := s.globVirtual(cwd, op)
+			if len(matches) == 0 {
+				_ = json.NewEncoder(w).Encode(errorResp(codeNoEnt, fmt.Sprintf("du: cannot access '%s': No such file or directory", op)))
+				return
+			}
+			for _, m := range matches {
+				paths = append(paths, path.Join(path.Dir(op), path.Base(m)))
+			}
+		}
+
+		ctx, cancel := s.walkContext(r)
+		defer cancel()
+		guard := newWalkGuard(ctx)
+		var entries []duEntry
+		for _, p := range paths {
+			rp, err := s.realFromVirtual(joinVirtual(cwd, p))
+			if err != nil {
+				_ = json.NewEncoder(w).Encode(errorResp(codeAccess, "du: permission denied"))
+				return
+			}
+			info, err := os.Stat(rp)
+			if err != nil || s.shouldIgnore(rp, filepath.Base(rp)) {
+				_ = json.NewEncoder(w).Encode(errorResp(codeNoEnt, fmt.Sprintf("du: cannot access '%s': No such file or directory", p)))
+				return
+			}
+			if summarize || !info.IsDir() {
+				if !s.accessAllowed(rp, s.accessIP(r)) {
+					continue // a wildcard matched a restricted directory
+				}
+				entries = append(entries, duEntry{p, info, s.treeSize(guard, rp)})
+				continue
+			}
+			// without -s, list what is directly inside the directory
+			children, err := s.readDir(rp)
+			if err != nil {
+				_ = json.NewEncoder(w).Encode(errorResp(codeIO, fmt.Sprintf("du: %s: %v", p, err)))
+				return
+			}
+			for _, c := range children {
+				crp := filepath.Join(rp, c.name)
+				if strings.HasPrefix(c.name, ".") || s.shouldIgnore(crp, c.name) || s.guarded(crp) {
+					continue
+				}
+				name := c.name
+				if p != "." {
+					name = path.Join(p, c.name)
+				}
+				// links count as themselves, as they do inside treeSize
+				var size int64
+				if c.info.IsDir() {
+					size = s.treeSize(guard, crp)
+				} else if c.info.Mode().IsRegular() {
+					size = c.info.Size()
+				}
+				entries = append(entries, duEntry{name, c.info, size})
+			}
+		}
+
+		// largest first, so the top rows answer "what is taking up space"
+		sort.SliceStable(entries, func(i, j int) bool { return entries[i].size > entries[j].size })
+		var total int64
+		for _, e := range entries {
+			total += e.size
+		}
+		showTotal := len(entries) != 1
+		if top > 0 && len(entries) > top {
+			entries = entries[:top]
+		}
+		size := func(n int64) string {
+			if humanReadable {
+				return formatHumanSize(n)
+			}
+			return strconv.FormatInt(n, 10)
+		}
+		out := make([]string, 0, len(entries)+2)
+		for _, e := range entries {
+			out = append(out, fmt.Sprintf("%10s  %s", size(e.size), colorizeName(e.info, e.name)))
+		}
+		if showTotal {
+			out = append(out, fmt.Sprintf("%s%10s  total%s", colorBold, size(total), colorReset))
+		}
+		if notice := guard.timedOut("du"); notice != "" {
+			out = append(out, notice)
+		}
+		_ = json.NewEncoder(w).Encode(execResp{Output: strings.Join(out, "\n")})
+		return
+
+	case "ls", "dir":
+		long := false
+		showHidden := false
+		humanReadable := false
+		onePerLine := false
+		showOwner := false
+		dirsFirst := sess.getDirsFirst()
+		order := sess.getSortBy()
+		target := cwd
+		offset, limit := 0, listPageSize
+		// Parse arguments: flags and optional path
+		for i := 0; i < len(argv); i++ {
+			arg := argv[i]
+			if name, value, ok := strings.Cut(arg, "="); ok && (name == "--offset" || name == "--limit") {
+				arg = name
+				argv = slices.Insert(argv, i+1, value)
+			}
+			if arg == "--offset" || arg == "--limit" {
+				n, err := -1, error(nil)
+				if i+1 < len(argv) {
+					n, err = strconv.Atoi(argv[i+1])
+				}
+				if n < 0 || err != nil || (arg == "--limit" && n == 0) {
+					_ = json.NewEncoder(w).Encode(errorResp(codeInvalid, fmt.Sprintf("ls: %s needs a positive number", arg)))
+					return
+				}
+				if arg == "--offset" {
+					offset = n
+				} else {
+					limit = n
+				}
+				i++
+				continue
+			}
+			if arg == "--owner" {
+				showOwner, long = true, true
+				continue
+			}
+			if arg == "--group-directories-first" {
+				dirsFirst = true
+				continue
+			}
+			if strings.HasPrefix(arg, "-") {
+				// Handle flags
+				if strings.Contains(arg, "l") {
+					long = true
+				}
+				if strings.Contains(arg, "g") {
+					showOwner, long = true, true
+				}
+				if strings.Contains(arg, "t") {
+					order = sortByTime
+				}
+				if strings.Contains(arg, "S") {
+					order = sortBySize
+				}
+				if strings.Contains(arg, "v") {
+					order = sortByVersion
+				}
+				if strings.Contains(arg, "1") {
+					onePerLine = true
+				}
+				if strings.Contains(arg, "a") {
+					showHidden = true
+				}
+				if strings.Contains(arg, "h") {
+					humanReadable = true
+				}
+			} else {
+				// First non-flag argument is the path
+				target = arg
+			}
+		}
+		// Get the real path of the directory to list
+		virtualPath := joinVirtual(cwd, target)
+		realCwd, err := s.realFromVirtual(virtualPath)
+		if err != nil {
+			_ = json.NewEncoder(w).Encode(errorResp(codeAccess, "ls: permission denied"))
+			return
+		}
+		// Get file info and check if it's a directory
+		info, err := os.Stat(realCwd)
+		if err != nil || s.shouldIgnore(realCwd, filepath.Base(realCwd)) {
+			_ = json.NewEncoder(w).Encode(errorResp(codeNoEnt, "ls: cannot access '"+target+"': No such file or directory"))
+			return
+		}
+		// If path is a file, show just the file
+		if !info.IsDir() {
+			// If it's a file, show the file in the listing
+			if long {
+				owner := ""
+				if showOwner {
+					owner = ownerColumns(info)
+				}
+				display := colorizeName(info, filepath.Base(realCwd))
+				if li, err := os.Lstat(realCwd); err == nil && li.Mode()&os.ModeSymlink != 0 && !s.isMount(realCwd) {
+					display += s.linkTarget(realCwd)
+				}
+				_ = json.NewEncoder(w).Encode(execResp{Output: formatLongOwner(info, display, humanReadable, owner)})
+			} else {
+				_ = json.NewEncoder(w).Encode(execResp{Output: colorizeName(info, filepath.Base(realCwd))})
+			}
+			return
+		}
+		// It is a directory, show its contents
+		ents, err := s.readDir(realCwd)
+		if err != nil {
+			_ = json.NewEncoder(w).Encode(errorResp(codeIO, "ls: error"))
+			return
+		}
+		var names []string
+		var longs []string
+		stats := make(map[string]os.FileInfo, len(ents))
+		links := make(map[string]bool)
+		for _, e := range ents {
+			name := e.name
+			if !showHidden && strings.HasPrefix(name, ".") {
+				continue // hide dotfiles unless -a flag is used
+			}
+			// Check if file should be ignored based on .lsgetignore
+			realFilePath := filepath.Join(realCwd, name)
+			if s.shouldIgnore(realFilePath, name) {
+				continue
+			}
+			names = append(names, name)
+			if e.stat != nil {
+				stats[name] = e.stat
+			}
+			if e.info.Mode()&os.ModeSymlink != 0 {
+				links[name] = true
+			}
+		}
+		sortNames(names, stats, order)
+		if dirsFirst {
+			isDir := func(name string) bool { return stats[name] != nil && stats[name].IsDir() }
+			sort.SliceStable(names, func(i, j int) bool { return dirsBefore(isDir(names[i]), isDir(names[j])) < 0 })
+		}
+
+		// only the current page is stat'ed and rendered below
+		total := len(names)
+		start, end := pageBounds(total, offset, limit)
+		names = names[start:end]
+		var footer string
+		if start > 0 || end < total {
+			footer = fmt.Sprintf("%s… entries %d-%d of %d", colorBrightBlack, min(start+1, end), end, total)
+			if end < total {
+				footer += fmt.Sprintf("; next page: ls --offset %d", end)
+			}
+			footer += colorReset
+		}
+
+		// Add ".." at the beginning if not at root
+		if cwd != "/" {
+			names = append([]string{".."}, names...)
+		}
+
+		if !long {
+			// Colorized simple listing
+			var coloredNames []string
+			for _, name := range names {
+				if name == ".." {
+					// Special handling for parent directory
+					coloredNames = append(coloredNames, colorBlue+colorBold+"../"+colorReset)
+					continue
+				}
+				info := stats[name]
+				if info == nil {
+					coloredNames = append(coloredNames, name)
+					continue
+				}
+				coloredNames = append(coloredNames, colorizeName(info, name))
+			}
+			output := strings.Join(coloredNames, "\n")
+			if !onePerLine {
+				cols := req.Cols
+				if cols <= 0 {
+					cols = defaultTermCols
+				}
+				output = formatColumns(coloredNames, cols)
+			}
+			if footer != "" {
+				output += "\n" + footer
+			}
+			_ = json.NewEncoder(w).Encode(execResp{Output: output})
+			return
+		}
+		// Colorized long listing
+		for _, name := range names {
+			if name == ".." {
+				// Special handling for parent directory in long format
+				owner := ""
+				if showOwner {
+					parent, _ := os.Stat(filepath.Dir(realCwd))
+					owner = ownerColumns(parent)
+				}
+				longs = append(longs, "drwxr-xr-x "+owner+"         - "+colorBlue+colorBold+"../"+colorReset)
+				continue
+			}
+			info := stats[name]
+			if info == nil {
+				continue
+			}
+			owner := ""
+			if showOwner {
+				owner = ownerColumns(info)
+			}
+			display := colorizeName(info, name)
+			if links[name] {
+				display += s.linkTarget(filepath.Join(realCwd, name))
+			}
+			// Format the long listing with colorized filename
+			longEntry := formatLongOwner(info, display, humanReadable, owner)
+			longs = append(longs, longEntry)
+		}
+		if footer != "" {
+			longs = append(longs, footer)
+		}
+		_ = json.NewEncoder(w).Encode(execResp{Output: strings.Join(longs, "\n")})
+		return
+
+	case "cd":
+		target := "/"
+		if len(argv) == 1 {
+			target = argv[0]
+			if target == "" {
+				target = "/"
+			}
+		}
+		back := target == "-"
+		if back {
+			target = sess.getPrevCwd()
+			if target == "" {
+				_ = json.NewEncoder(w).Encode(errorResp(codeNoEnt, "cd: OLDPWD not set"))
+				return
+			}
+		}
+		if s.isWildcard(cwd, target) {
+			// cd into the single directory matching a wildcard
+			matches, _ := s.globVirtual(cwd, target)
+			var dirs []string
+			for _, m := range matches {
+				if rp, err := s.realFromVirtual(m); err == nil {
+					if info, err := os.Stat(rp); err == nil && info.IsDir() {
+						dirs = append(dirs, m)
+					}
+				}
+			}
+			switch len(dirs) {
+			case 0:
+				_ = json.NewEncoder(w).Encode(errorResp(codeNoEnt, "cd: "+target+": no such file or directory"))
+				return
+			case 1:
+				target = dirs[0]
+			default:
+				names := make([]string, len(dirs))
+				for i, d := range dirs {
+					names[i] = path.Base(d)
+				}
+				_ = json.NewEncoder(w).Encode(errorResp(codeInvalid, fmt.Sprintf("cd: %s: ambiguous, matches %s", target, strings.Join(names, " "))))
+				return
+			}
+		}
+		newV := joinVirtual(cwd, target)
+		newReal, err := s.realFromVirtual(newV)
+		if err != nil {
+			_ = json.NewEncoder(w).Encode(errorResp(codeAccess, "cd: permission denied"))
+			return
+		}
+		info, err := os.Stat(newReal)
+		if bm, ok := sess.getBookmarks()[target]; ok && err != nil {
+			// No such directory: fall back to a bookmark with that name
+			newV = bm
+			if newReal, err = s.realFromVirtual(newV); err == nil {
+				info, err = os.Stat(newReal)
+			}
+		}
+		if err != nil {
+			_ = json.NewEncoder(w).Encode(errorResp(codeNoEnt, "cd: no such file or directory"))
+			return
+		}
+		if !info.IsDir() {
+			_ = json.NewEncoder(w).Encode(errorResp(codeNotDir, "cd: not a directory"))
+			return
+		}
+		if !s.accessAllowed(newReal, ip) {
+			_ = json.NewEncoder(w).Encode(errorResp(codeAccess, "cd: "+target+": Permission denied"))
+			return
+		}
+		sess.chdir(newV)
+		
+		// Check if directory contains index.html
+		indexPath := filepath.Join(newReal, "index.html")
+		if _, err := os.Stat(indexPath); err == nil {
+			// Directory has index.html, redirect to it
+			_ = json.NewEncoder(w).Encode(execResp{Redirect: newV})
+			return
+		}
+		
+		readme, docType := readDocFile(newReal)
+		output := ""
+		if back {
+			// like a shell, cd - prints where it went
+			output = newV
+		}
+		// Include the new CWD in the response so client can update URL
+		_ = json.NewEncoder(w).Encode(execResp{Output: output, CWD: newV, Readme: &readme, DocType: docType})
+		return
+
+	case "cat":
+		// --head prints the first catMax bytes of a larger file instead
+		// of refusing it
+		head := false
+		var operands []string
+		for _, arg := range argv {
+			switch {
+			case arg == "--head":
+				head = true
+			case strings.HasPrefix(arg, "-") && arg != "-":
+				_ = json.NewEncoder(w).Encode(errorResp(codeInvalid, fmt.Sprintf("cat: invalid option '%s'", arg)))
+				return
+			default:
+				operands = append(operands, arg)
+			}
+		}
+		argv = operands
+		if len(argv) < 1 {
+			_ = json.NewEncoder(w).Encode(errorResp(codeInvalid, "cat: missing operand"))
+			return
+		}
+		if s.isWildcard(cwd, argv[0]) {
+			files, err := s.globFiles(cwd, argv[0])
+			if err != nil || len(files) == 0 {
+				_ = json.NewEncoder(w).Encode(errorResp(codeNoEnt, fmt.Sprintf("cat: %s: no such file or directory", argv[0])))
+				return
+			}
+			var parts []string
+			for _, f := range files {
+				text := ""
+				info, err := os.Stat(f.realPath)
+				if err == nil {
+					if category := getFileCategory(f.realPath); category != FileCategoryText && category != FileCategoryUnknown {
+						err = fmt.Errorf("cannot display %s files (use 'get' to download)", category)
+					} else {
+						text, err = s.readCatText(f.realPath, info.Size(), head)
+					}
+				}
+				if err != nil {
+					text = "cat: " + err.Error()
+				}
+				parts = append(parts, fmt.Sprintf("%s==> %s <==%s\n%s", colorCyan, f.virtualPath, colorReset, strings.TrimRight(text, "\n")))
+			}
+			_ = json.NewEncoder(w).Encode(execResp{Output: strings.Join(parts, "\n\n")})
+			return
+		}
+		vp := joinVirtual(cwd, argv[0])
+		rp, info, resp := s.statFile("cat", cwd, argv[0])
+		if resp != nil {
+			if resp.Error == codeIsDir {
+				resp.Output += fmt.Sprintf(" (try 'ls %s' or 'cd %s')", argv[0], argv[0])
+			}
+			_ = json.NewEncoder(w).Encode(resp)
+			return
+		}
+
+		// Check if file type is supported by cat
+		category := getFileCategory(argv[0])
+
+		// Display images inline
+		if category == FileCategoryImage {
+			url := urlEscapeVirtual(vp)
+			name := filepath.Base(argv[0])
+			escapedURL := template.HTMLEscapeString(url)
+			escapedName := template.HTMLEscapeString(name)
+			imgHTML := fmt.Sprintf(
+				`<div style="margin: 0.5em 0;">`+
+					`<a href="%s" target="_blank" style="display: inline-block; text-decoration: none;">`+
+					`<img src="%s" style="max-width: 100%%; height: auto; display: block; border-radius: 4px; box-shadow: 0 2px 8px rgba(0,0,0,0.3);" alt="%s">`+
+					`</a>`+
+					`<div style="color: #a6d189; font-size: 0.85em; margin-top: 0.25em;">📷 %s (%s)</div>`+
+					`</div>`,
+				escapedURL, escapedURL, escapedName, escapedName, formatHumanSize(info.Size()))
+			_ = json.NewEncoder(w).Encode(execResp{HTML: imgHTML})
+			return
+		}
+
+		// Only text files and unknown files (to be checked by content) can be displayed
+		if category != FileCategoryText && category != FileCategoryUnknown {
+			_ = json.NewEncoder(w).Encode(errorResp(codeInvalid, fmt.Sprintf("cat: cannot display %s files (use 'get' to download)", category)))
+			return
+		}
+
+		text, err := s.readCatText(rp, info.Size(), head)
+		if err != nil {
+			_ = json.NewEncoder(w).Encode(errorResp(errCode(err), "cat: "+err.Error()))
+			return
+		}
+		_ = json.NewEncoder(w).Encode(execResp{Output: text})
+		return
+
+	case "get", "rget", "wget", "download":
+		if len(argv) < 1 {
+			_ = json.NewEncoder(w).Encode(errorResp(codeInvalid, "download: missing operand"))
+			return
+		}
+
+		// -0/--store: zip without compression, with an exact Content-Length
+		storeParam := ""
+		var pattern string
+		for _, arg := range argv {
+			if arg == "-0" || arg == "--store" {
+				storeParam = "&store=1"
+			} else if pattern == "" {
+				pattern = arg
+			}
+		}
+		if pattern == "" {
+			_ = json.NewEncoder(w).Encode(errorResp(codeInvalid, "download: missing operand"))
+			return
+		}
+
+		// Get IP address for logging
+		ip := getClientIP(r)
+
+		// Check if pattern contains wildcards or is a directory
+		if strings.ContainsAny(pattern, "*?[") || pattern == "." || hasBraces(pattern) {
+			// Handle pattern-based download (multiple files)
+			files, err := s.collectFilesForDownload(cwd, pattern)
+			if err != nil {
+				_ = json.NewEncoder(w).Encode(errorResp(errCode(err), fmt.Sprintf("download: %v", err)))
+				return
+			}
+			files = s.accessibleFiles(files, s.accessIP(r))
+			regular := regularFiles(files)
+			if len(regular) == 0 {
+				_ = json.NewEncoder(w).Encode(errorResp(codeNoEnt, "download: no matching files found"))
+				return
+			}
+			if len(files) == 1 {
+				// Single file, download directly
+				s.logCommand("get", files[0].virtualPath, ip)
+				url := "/api/download?path=" + urlEscapeVirtual(files[0].virtualPath)
+				_ = json.NewEncoder(w).Encode(execResp{Output: "", Download: url})
+				return
+			}
+			// Multiple files, create zip
+			if err := s.checkZipLimits(zipEntries(files)); err != nil {
+				_ = json.NewEncoder(w).Encode(errorResp(errCode(err), "download: "+err.Error()))
+				return
+			}
+			s.logCommand("get", "(pattern match)", ip)
+			downloadURL := "/api/download?pattern=" + url.QueryEscape(pattern) + "&cwd=" + urlEscapeVirtual(cwd) + storeParam
+			_ = json.NewEncoder(w).Encode(execResp{Output: fmt.Sprintf("Downloading %d files as archive.zip", len(regular)), Download: downloadURL})
+			return
+		}
+
+		// Check if it's a directory
+		vp := joinVirtual(cwd, pattern)
+		rp, err := s.realFromVirtual(vp)
+		if err != nil {
+			_ = json.NewEncoder(w).Encode(errorResp(codeAccess, "download: permission denied"))
+			return
+		}
+		info, err := os.Stat(rp)
+		if err != nil {
+			_ = json.NewEncoder(w).Encode(errorResp(codeNoEnt, "download: no such file"))
+			return
+		}
+
+		if info.IsDir() {
+			// Download directory as zip
+			files, err := s.collectFilesFromDirectory(vp, rp)
+			if err != nil {
+				_ = json.NewEncoder(w).Encode(errorResp(errCode(err), fmt.Sprintf("download: %v", err)))
+				return
+			}
+			if len(files) == 0 {
+				_ = json.NewEncoder(w).Encode(errorResp(codeNoEnt, "download: directory is empty"))
+				return
+			}
+			count := len(regularFiles(files))
+			if err := s.checkZipLimits(zipEntries(files)); err != nil {
+				_ = json.NewEncoder(w).Encode(errorResp(errCode(err), "download: "+err.Error()))
+				return
+			}
+			dirName := filepath.Base(rp)
+			s.logCommand("get", vp+" (dir)", ip)
+			url := "/api/download?dir=" + urlEscapeVirtual(vp) + storeParam
+			_ = json.NewEncoder(w).Encode(execResp{Output: fmt.Sprintf("Downloading directory '%s' with %d files as %s.zip", dirName, count, dirName), Download: url})
+			return
+		}
+
+		// Single file download
+		s.logCommand("get", vp, ip)
+		url := "/api/download?path=" + urlEscapeVirtual(vp)
+		_ = json.NewEncoder(w).Encode(execResp{Output: "", Download: url})
+		return
+
+	case "tree":
+		// Parse options
+		showHidden := false
+		natural := false
+		maxDepth := -1 // unlimited by default
+		target := cwd
+
+		for _, arg := range argv {
+			if strings.HasPrefix(arg, "-") {
+				if strings.Contains(arg, "a") {
+					showHidden = true
+				}
+				if !strings.HasPrefix(arg, "-L") && strings.Contains(arg, "v") {
+					natural = true
+				}
+				if strings.HasPrefix(arg, "-L") && len(arg) > 2 {
+					// Simple depth parsing for -L<number>
+					depthStr := arg[2:]
+					if d, err := fmt.Sscanf(depthStr, "%d", &maxDepth); d != 1 || err != nil {
+						maxDepth = -1
+					}
+				}
+			} else {
+				// Directory argument
+				target = joinVirtual(cwd, arg)
+			}
+		}
+
+		realTarget, err := s.realFromVirtual(target)
+		if err != nil {
+			_ = json.NewEncoder(w).Encode(errorResp(codeAccess, "tree: permission denied"))
+			return
+		}
+
+		info, err := os.Stat(realTarget)
+		if err != nil {
+			_ = json.NewEncoder(w).Encode(errorResp(codeNoEnt, "tree: no such file or directory"))
+			return
+		}
+
+		if !info.IsDir() {
+			_ = json.NewEncoder(w).Encode(errorResp(codeNotDir, "tree: not a directory"))
+			return
+		}
+
+		ctx, cancel := s.walkContext(r)
+		defer cancel()
+		guard := newWalkGuard(ctx)
+		var result strings.Builder
+		dirCount, fileCount := s.buildTree(&result, guard, realTarget, "", showHidden, natural, maxDepth, 0)
+		if notice := guard.timedOut("tree"); notice != "" {
+			result.WriteString(notice + "\n")
+		}
+
+		// Add summary
+		result.WriteString(fmt.Sprintf("\n%d directories, %d files", dirCount, fileCount))
+
+		_ = json.NewEncoder(w).Encode(execResp{Output: result.String()})
+		return
+
+	case "find":
+		// Parse options
+		searchPath := cwd
+		namePattern := "*"
+		typeFilter := "" // "f" for files, "d" for directories, "" for both
+		natural := false
+
+		// Parse arguments
+		for i := 0; i < len(argv); i++ {
+			arg := argv[i]
+			if arg == "-name" && i+1 < len(argv) {
+				namePattern = argv[i+1]
+				i++ // skip next argument
+			} else if arg == "-type" && i+1 < len(argv) {
+				typeFilter = argv[i+1]
+				i++ // skip next argument
+			} else if arg == "-v" {
+				natural = true
+			} else if !strings.HasPrefix(arg, "-") {
+				// Path argument
+				searchPath = joinVirtual(cwd, arg)
+			}
+		}
+
+		// Validate type filter
+		if typeFilter != "" && typeFilter != "f" && typeFilter != "d" {
+			_ = json.NewEncoder(w).Encode(errorResp(codeInvalid, "find: invalid type filter (use 'f' for files or 'd' for directories)"))
+			return
+		}
+
+		realSearchPath, err := s.realFromVirtual(searchPath)
+		if err != nil {
+			_ = json.NewEncoder(w).Encode(errorResp(codeAccess, "find: permission denied"))
+			return
+		}
+
+		info, err := os.Stat(realSearchPath)
+		if err != nil {
+			_ = json.NewEncoder(w).Encode(errorResp(codeNoEnt, "find: no such file or directory"))
+			return
+		}
+
+		if !info.IsDir() {
+			_ = json.NewEncoder(w).Encode(errorResp(codeNotDir, "find: not a directory"))
+			return
+		}
+
+		ctx, cancel := s.walkContext(r)
+		defer cancel()
+		guard := newWalkGuard(ctx)
+		// matches stream over the WebSocket as they are found; /api/exec
+		// gets them all in the final response
+		out := newLineOutput(w)
+		err = s.findFiles(realSearchPath, searchPath, namePattern, typeFilter, natural, guard, out.add)
+		if err != nil {
+			_ = json.NewEncoder(w).Encode(errorResp(errCode(err), fmt.Sprintf("find: %v", err)))
+			return
+		}
+		if notice := guard.timedOut("find"); notice != "" {
+			out.add(notice)
+		}
+
+		if out.count == 0 {
+			_ = json.NewEncoder(w).Encode(execResp{Output: "find: no matches found"})
+			return
+		}
+
+		_ = json.NewEncoder(w).Encode(execResp{Output: out.String()})
+		return
+
+	case "preview", "head":
+		if len(argv) < 1 {
+			_ = json.NewEncoder(w).Encode(errorResp(codeInvalid, cmd+": missing file operand"))
+			return
+		}
+		rp, _, resp := s.statFile(cmd, cwd, argv[0])
+		if resp != nil {
+			_ = json.NewEncoder(w).Encode(resp)
+			return
+		}
+		text, err := previewFile(rp)
+		if err != nil {
+			_ = json.NewEncoder(w).Encode(errorResp(codeIO, cmd+": cannot open file"))
+			return
+		}
+		_ = json.NewEncoder(w).Encode(execResp{Output: text})
+		return
+
+	case "mediainfo", "meta":
+		if len(argv) < 1 {
+			_ = json.NewEncoder(w).Encode(errorResp(codeInvalid, cmd+": missing file operand"))
+			return
+		}
+		rp, info, resp := s.statFile(cmd, cwd, argv[0])
+		if resp != nil {
+			_ = json.NewEncoder(w).Encode(resp)
+			return
+		}
+		fields, err := mediaInfo(rp, info.Size())
+		if err != nil {
+			_ = json.NewEncoder(w).Encode(errorResp(codeIO, cmd+": cannot open file"))
+			return
+		}
+		if len(fields) == 0 {
+			_ = json.NewEncoder(w).Encode(execResp{Output: fmt.Sprintf("%s: no metadata", argv[0])})
+			return
+		}
+		var b strings.Builder
+		for i, f := range fields {
+			if i > 0 {
+				b.WriteByte('\n')
+			}
+			fmt.Fprintf(&b, "%s%-10s%s %s", colorCyan, f.key+":", colorReset, f.value)
+		}
+		_ = json.NewEncoder(w).Encode(execResp{Output: b.String()})
+		return
+
+	case "exif":
+		if len(argv) < 1 {
+			_ = json.NewEncoder(w).Encode(errorResp(codeInvalid, "exif: missing file operand"))
+			return
+		}
+		rp, _, resp := s.statFile("exif", cwd, argv[0])
+		if resp != nil {
+			_ = json.NewEncoder(w).Encode(resp)
+			return
+		}
+		fields, err := readExif(rp, s.exifGPS)
+		if err != nil {
+			_ = json.NewEncoder(w).Encode(errorResp(codeIO, "exif: cannot open file"))
+			return
+		}
+		if len(fields) == 0 {
+			_ = json.NewEncoder(w).Encode(execResp{Output: fmt.Sprintf("%s: no EXIF", argv[0])})
+			return
+		}
+		var b strings.Builder
+		for i, f := range fields {
+			if i > 0 {
+				b.WriteByte('\n')
+			}
+			fmt.Fprintf(&b, "%s%-10s%s %s", colorCyan, f.key+":", colorReset, f.value)
+		}
+		_ = json.NewEncoder(w).Encode(execResp{Output: b.String()})
+		return
+
+	case "diff":
+		if len(argv) != 2 {
+			_ = json.NewEncoder(w).Encode(errorResp(codeInvalid, "diff: usage: diff FILE1 FILE2"))
+			return
+		}
+		var texts [2]string
+		for i, arg := range argv {
+			rp, info, resp := s.statFile("diff", cwd, arg)
+			if resp != nil {
+				_ = json.NewEncoder(w).Encode(resp)
+				return
+			}
+			texts[i], err = s.readCatText(rp, info.Size(), false)
+			if err != nil {
+				_ = json.NewEncoder(w).Encode(errorResp(errCode(err), fmt.Sprintf("diff: %s: %v", arg, err)))
+				return
+			}
+		}
+		out, err := unifiedDiff(argv[0], argv[1], texts[0], texts[1])
+		if err != nil {
+			_ = json.NewEncoder(w).Encode(errorResp(errCode(err), "diff: "+err.Error()))
+			return
+		}
+		_ = json.NewEncoder(w).Encode(execResp{Output: out})
+		return
+
+	case "zcat", "bzcat":
+		if len(argv) < 1 {
+			_ = json.NewEncoder(w).Encode(errorResp(codeInvalid, cmd+": missing operand"))
+			return
+		}
+		rp, _, resp := s.statFile(cmd, cwd, argv[0])
+		if resp != nil {
+			_ = json.NewEncoder(w).Encode(resp)
+			return
+		}
+		text, err := s.readCompressedText(rp)
+		if err != nil {
+			_ = json.NewEncoder(w).Encode(errorResp(errCode(err), fmt.Sprintf("%s: %s: %v", cmd, argv[0], err)))
+			return
+		}
+		_ = json.NewEncoder(w).Encode(execResp{Output: text})
+		return
+
+	case "unzip":
+		if len(argv) != 2 || argv[0] != "-l" {
+			_ = json.NewEncoder(w).Encode(errorResp(codeInvalid, "unzip: usage: unzip -l FILE.zip (listing only, nothing is extracted)"))
+			return
+		}
+		rp, _, resp := s.statFile("unzip", cwd, argv[1])
+		if resp != nil {
+			_ = json.NewEncoder(w).Encode(resp)
+			return
+		}
+		listing, err := listZip(rp)
+		if err != nil {
+			_ = json.NewEncoder(w).Encode(errorResp(errCode(err), fmt.Sprintf("unzip: cannot read %s: %v", argv[1], err)))
+			return
+		}
+		_ = json.NewEncoder(w).Encode(execResp{Output: listing})
+		return
+
+	case "tar":
+		// only listing is supported: -t, -tf, -tvf, tzf and similar
+		if len(argv) != 2 || !strings.Contains(argv[0], "t") || strings.Trim(strings.TrimPrefix(argv[0], "-"), "tvfzj") != "" {
+			_ = json.NewEncoder(w).Encode(errorResp(codeInvalid, "tar: usage: tar -t FILE.tar[.gz] (listing only, nothing is extracted)"))
+			return
+		}
+		rp, _, resp := s.statFile("tar", cwd, argv[1])
+		if resp != nil {
+			_ = json.NewEncoder(w).Encode(resp)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(execResp{Output: listTar(rp)})
+		return
+
+	case "tail":
+		lines, follow := 10, false
+		var target string
+		for i := 0; i < len(argv); i++ {
+			switch arg := argv[i]; {
+			case arg == "-f":
+				follow = true
+			case arg == "-n" && i+1 < len(argv):
+				n, err := strconv.Atoi(argv[i+1])
+				if err != nil || n < 0 {
+					_ = json.NewEncoder(w).Encode(errorResp(codeInvalid, fmt.Sprintf("tail: invalid number of lines: '%s'", argv[i+1])))
+					return
 				}
-				if strings.Contains(arg, "h") {
-					humanReadable = true
+				lines = n
+				i++
+			case strings.HasPrefix(arg, "-"):
+				_ = json.NewEncoder(w).Encode(errorResp(codeInvalid, fmt.Sprintf("tail: invalid option '%s'", arg)))
+				return
+			default:
+				target = arg
+			}
+		}
+		if target == "" {
+			_ = json.NewEncoder(w).Encode(errorResp(codeInvalid, "tail: missing file operand"))
+			return
+		}
+		out := newLineOutput(w)
+		if follow && out.streamer == nil {
+			_ = json.NewEncoder(w).Encode(errorResp(codeInvalid, "tail: -f needs a live connection (WebSocket); try reloading the page"))
+			return
+		}
+		rp, _, resp := s.statFile("tail", cwd, target)
+		if resp != nil {
+			_ = json.NewEncoder(w).Encode(resp)
+			return
+		}
+		last, offset, err := tailLines(rp, lines)
+		if err != nil {
+			_ = json.NewEncoder(w).Encode(errorResp(errCode(err), fmt.Sprintf("tail: %s: %v", target, err)))
+			return
+		}
+		for _, l := range last {
+			out.add(l)
+		}
+		if follow {
+			s.followFile(r.Context(), rp, target, offset, out)
+		}
+		_ = json.NewEncoder(w).Encode(execResp{Output: out.String()})
+		return
+
+	case "strings":
+		minLen := defaultStringsMin
+		var target string
+		for i := 0; i < len(argv); i++ {
+			switch arg := argv[i]; {
+			case arg == "-n" && i+1 < len(argv):
+				n, err := strconv.Atoi(argv[i+1])
+				if err != nil || n < 1 {
+					_ = json.NewEncoder(w).Encode(errorResp(codeInvalid, fmt.Sprintf("strings: invalid minimum string length '%s'", argv[i+1])))
+					return
+				}
+				minLen = n
+				i++
+			case strings.HasPrefix(arg, "-"):
+				_ = json.NewEncoder(w).Encode(errorResp(codeInvalid, fmt.Sprintf("strings: invalid option '%s'", arg)))
+				return
+			default:
+				target = arg
+			}
+		}
+		if target == "" {
+			_ = json.NewEncoder(w).Encode(errorResp(codeInvalid, "strings: missing file operand"))
+			return
+		}
+		rp, info, resp := s.statFile("strings", cwd, target)
+		if resp != nil {
+			_ = json.NewEncoder(w).Encode(resp)
+			return
+		}
+		f, err := os.Open(rp)
+		if err != nil {
+			_ = json.NewEncoder(w).Encode(errorResp(errCode(err), fmt.Sprintf("strings: %s: %v", target, err)))
+			return
+		}
+		defer func() { _ = f.Close() }()
+		// like cat, only the first catMax bytes are read
+		out := newLineOutput(w)
+		if err := scanStrings(io.LimitReader(f, s.catMax), minLen, out.add); err != nil {
+			_ = json.NewEncoder(w).Encode(errorResp(errCode(err), fmt.Sprintf("strings: %s: %v", target, err)))
+			return
+		}
+		if info.Size() > s.catMax {
+			out.add(fmt.Sprintf("%sstrings: %s: only the first %s scanned%s", colorBrightBlack, target, formatHumanSize(s.catMax), colorReset))
+		}
+		_ = json.NewEncoder(w).Encode(execResp{Output: out.String()})
+		return
+
+	case "view", "open":
+		if len(argv) < 1 {
+			_ = json.NewEncoder(w).Encode(errorResp(codeInvalid, "view: missing file operand"))
+			return
+		}
+		vp := joinVirtual(cwd, argv[0])
+		if _, _, resp := s.statFile("view", cwd, argv[0]); resp != nil {
+			if resp.Error == codeIsDir {
+				resp.Output += fmt.Sprintf(" (try 'cd %s')", argv[0])
+			}
+			_ = json.NewEncoder(w).Encode(resp)
+			return
+		}
+		s.logCommand(cmd, vp, getClientIP(r))
+		viewURL := urlEscapeVirtual(vp) + "?inline=1"
+		// The link is a fallback for when the browser blocks the new tab
+		link := fmt.Sprintf(`Opening <a href="%s" target="_blank" rel="noopener">%s</a> in a new tab`,
+			template.HTMLEscapeString(viewURL), template.HTMLEscapeString(path.Base(vp)))
+		_ = json.NewEncoder(w).Encode(execResp{HTML: link, Open: viewURL})
+		return
+
+	case "url", "share":
+		if len(argv) < 1 {
+			_ = json.NewEncoder(w).Encode(errorResp(codeInvalid, "url: missing file operand"))
+			return
+		}
+
+		vp := joinVirtual(cwd, argv[0])
+		if _, _, resp := s.statFile("url", cwd, argv[0]); resp != nil {
+			if resp.Error == codeIsDir {
+				resp.Output = "url: cannot share directories (use 'get' to download as zip)"
+			}
+			_ = json.NewEncoder(w).Encode(resp)
+			return
+		}
+
+		// Build the full URL for the file
+		var fileURL string
+		if s.baseURL != "" {
+			// Use configured base URL (clean, no /api/static)
+			// Required when behind reverse proxy with different public URL
+			fileURL = s.baseURL + vp
+		} else {
+			// Auto-detect from request (works for most cases)
+			// Uses Host header and X-Forwarded-Proto for protocol detection
+			host := r.Host
+			if host == "" {
+				host = "localhost:8080"
+			}
+			protocol := "http"
+			if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
+				protocol = "https"
+			}
+			fileURL = fmt.Sprintf("%s://%s/%s", protocol, host, vp)
+		}
+
+		// Log the share command
+		s.logCommand(cmd, vp, getClientIP(r))
+
+		// Return the URL with clipboard instruction
+		_ = json.NewEncoder(w).Encode(execResp{
+			Output:    fmt.Sprintf("Shareable URL: %s\n%sURL copied to clipboard!%s", fileURL, colorGreen, colorReset),
+			Clipboard: fileURL,
+		})
+		return
+
+	case "grep":
+		if len(argv) < 1 {
+			_ = json.NewEncoder(w).Encode(errorResp(codeInvalid, "grep: missing pattern"))
+			return
+		}
+
+		// Parse options
+		var recursive bool
+		var ignoreCase bool
+		var showLineNumbers bool
+		var pattern string
+		var files []string
+		binaryFiles := binaryWithoutMatch
+		limit := &grepLimit{max: s.grepResults}
+
+		// Parse arguments
+		i := 0
+		for i < len(argv) {
+			arg := argv[i]
+			if strings.HasPrefix(arg, "-m") {
+				n, err := -1, error(nil)
+				if arg != "-m" {
+					n, err = strconv.Atoi(arg[2:])
+				} else if i+1 < len(argv) {
+					n, err = strconv.Atoi(argv[i+1])
+					i++
+				}
+				if n < 1 || err != nil {
+					_ = json.NewEncoder(w).Encode(errorResp(codeInvalid, "grep: -m needs a positive number"))
+					return
+				}
+				limit.perFile = n
+				i++
+				continue
+			}
+			if arg == "--text" {
+				binaryFiles = binaryText
+				i++
+				continue
+			}
+			if mode, ok := strings.CutPrefix(arg, "--binary-files="); ok {
+				if mode != binaryMatch && mode != binaryText && mode != binaryWithoutMatch {
+					_ = json.NewEncoder(w).Encode(errorResp(codeInvalid, fmt.Sprintf("grep: invalid --binary-files type '%s' (use binary, text or without-match)", mode)))
+					return
+				}
+				binaryFiles = mode
+				i++
+				continue
+			}
+			if strings.HasPrefix(arg, "-") {
+				if strings.Contains(arg, "a") {
+					binaryFiles = binaryText
+				}
+				if strings.Contains(arg, "r") {
+					recursive = true
+				}
+				if strings.Contains(arg, "i") {
+					ignoreCase = true
+				}
+				if strings.Contains(arg, "n") {
+					showLineNumbers = true
+				}
+			} else {
+				if pattern == "" {
+					pattern = arg
+				} else {
+					files = append(files, arg)
+				}
+			}
+			i++
+		}
+
+		if pattern == "" {
+			_ = json.NewEncoder(w).Encode(errorResp(codeInvalid, "grep: missing pattern"))
+			return
+		}
+
+		// If no files specified and recursive, search current directory
+		if len(files) == 0 {
+			if recursive {
+				files = []string{"."}
+			} else {
+				_ = json.NewEncoder(w).Encode(errorResp(codeInvalid, "grep: no files specified"))
+				return
+			}
+		}
+
+		ctx, cancel := s.walkContext(r)
+		defer cancel()
+		guard := newWalkGuard(ctx)
+		out := newLineOutput(w)
+		for _, file := range files {
+			vp := joinVirtual(cwd, file)
+			rp, err := s.realFromVirtual(vp)
+			if err != nil {
+				out.add(fmt.Sprintf("grep: %s: permission denied", file))
+				continue
+			}
+
+			info, err := os.Stat(rp)
+			if err != nil {
+				out.add(fmt.Sprintf("grep: %s: no such file or directory", file))
+				continue
+			}
+
+			if info.IsDir() {
+				if recursive {
+					err := s.grepInDirectory(rp, vp, pattern, ignoreCase, showLineNumbers, binaryFiles, limit, guard, out)
+					if err != nil {
+						out.add(fmt.Sprintf("grep: %s: %v", file, err))
+					}
+				} else {
+					out.add(fmt.Sprintf("grep: %s: is a directory", file))
+				}
+			} else if !limit.truncated {
+				err := s.grepInFile(ctx, rp, vp, pattern, ignoreCase, showLineNumbers, len(files) > 1, binaryFiles, limit, out)
+				if err != nil {
+					out.add(fmt.Sprintf("grep: %s: %v", file, err))
+				}
+			}
+		}
+
+		if notice := guard.timedOut("grep"); notice != "" {
+			out.add(notice)
+		}
+		if limit.truncated {
+			out.add(fmt.Sprintf("%s... results truncated after %d matches%s", colorBrightBlack, s.grepResults, colorReset))
+		}
+		if out.count == 0 {
+			_ = json.NewEncoder(w).Encode(execResp{Output: "grep: no matches found"})
+			return
+		}
+
+		_ = json.NewEncoder(w).Encode(execResp{Output: out.String()})
+		return
+
+	case "sum", "checksum":
+		// -r DIR prints a checksum manifest; -a picks its digest
+		recursive, algo := false, ""
+		var operands []string
+		for i := 0; i < len(argv); i++ {
+			switch arg := argv[i]; {
+			case arg == "-r":
+				recursive = true
+			case arg == "-a" && i+1 < len(argv):
+				algo = strings.ToLower(argv[i+1])
+				i++
+			case strings.HasPrefix(arg, "-"):
+				_ = json.NewEncoder(w).Encode(errorResp(codeInvalid, fmt.Sprintf("sum: invalid option '%s'", arg)))
+				return
+			default:
+				operands = append(operands, arg)
+			}
+		}
+		argv = operands
+		if len(argv) < 1 {
+			_ = json.NewEncoder(w).Encode(errorResp(codeInvalid, "sum: missing file operand"))
+			return
+		}
+		if algo != "" && !recursive {
+			_ = json.NewEncoder(w).Encode(errorResp(codeInvalid, "sum: -a only applies to manifests made with -r"))
+			return
+		}
+		if recursive {
+			if algo == "" {
+				algo = "sha256"
+			}
+			newHash, ok := sumAlgorithms[algo]
+			if !ok {
+				_ = json.NewEncoder(w).Encode(errorResp(codeInvalid, fmt.Sprintf("sum: unknown algorithm '%s' (use md5, sha1, sha256 or sha512)", algo)))
+				return
+			}
+			vp := joinVirtual(cwd, argv[0])
+			rp, err := s.realFromVirtual(vp)
+			if err != nil {
+				_ = json.NewEncoder(w).Encode(errorResp(codeAccess, "sum: permission denied"))
+				return
+			}
+			info, err := os.Stat(rp)
+			if err != nil || vp != "/" && strings.HasPrefix(path.Base(vp), ".") || s.shouldIgnore(rp, filepath.Base(rp)) {
+				_ = json.NewEncoder(w).Encode(errorResp(codeNoEnt, "sum: no such file or directory"))
+				return
+			}
+			if !info.IsDir() {
+				_ = json.NewEncoder(w).Encode(errorResp(codeNotDir, "sum: -r needs a directory"))
+				return
+			}
+			if !s.accessAllowed(rp, s.accessIP(r)) {
+				_ = json.NewEncoder(w).Encode(errorResp(codeAccess, fmt.Sprintf("sum: %s: Permission denied", argv[0])))
+				return
+			}
+			s.logCommand(cmd, vp, getClientIP(r))
+			ctx, cancel := s.walkContext(r)
+			defer cancel()
+			guard := newWalkGuard(ctx)
+			// lines stream over the WebSocket while the tree is hashed
+			out := newLineOutput(w)
+			err = s.sumManifest(ctx, rp, newHash, out.add)
+			if notice := guard.timedOut("sum"); notice != "" {
+				out.add(notice)
+			}
+			if errCode(err) == codeTooLarge {
+				// what was hashed before the limit is still worth having
+				out.add(colorYellow + "sum: " + err.Error() + colorReset)
+				_ = json.NewEncoder(w).Encode(errorResp(codeTooLarge, out.String()))
+				return
+			}
+			if err != nil {
+				_ = json.NewEncoder(w).Encode(errorResp(errCode(err), "sum: "+err.Error()))
+				return
+			}
+			_ = json.NewEncoder(w).Encode(execResp{Output: out.String()})
+			return
+		}
+		if s.isWildcard(cwd, argv[0]) {
+			files, err := s.globFiles(cwd, argv[0])
+			if err != nil || len(files) == 0 {
+				_ = json.NewEncoder(w).Encode(errorResp(codeNoEnt, fmt.Sprintf("sum: %s: no such file or directory", argv[0])))
+				return
+			}
+			var parts []string
+			for _, f := range files {
+				md5Sum, sha256Sum, err := fileChecksums(r.Context(), f.realPath, s.maxSum)
+				if err != nil {
+					parts = append(parts, fmt.Sprintf("%s: sum: %s", f.virtualPath, err))
+					continue
 				}
-			} else {
-				// First non-flag argument is the path
-				target = arg
+				s.logCommand(cmd, f.virtualPath, getClientIP(r))
+				parts = append(parts, fmt.Sprintf("%s%s%s\nMD5:    %s\nSHA256: %s", colorCyan, f.virtualPath, colorReset, md5Sum, sha256Sum))
 			}
+			_ = json.NewEncoder(w).Encode(execResp{Output: strings.Join(parts, "\n\n")})
+			return
 		}
-		// Get the real path of the directory to list
-		virtualPath := joinVirtual(sess.cwd, target)
-		realCwd, err := s.realFromVirtual(virtualPath)
-		if err != nil {
-			_ = json.NewEncoder(w).Encode(execResp{Output: "ls: permission denied"})
+
+		vp := joinVirtual(cwd, argv[0])
+		rp, _, resp := s.statFile("sum", cwd, argv[0])
+		if resp != nil {
+			_ = json.NewEncoder(w).Encode(resp)
 			return
 		}
-		// Get file info and check if it's a directory
-		info, err := os.Stat(realCwd)
+
+		md5Sum, sha256Sum, err := fileChecksums(r.Context(), rp, s.maxSum)
 		if err != nil {
-			_ = json.NewEncoder(w).Encode(execResp{Output: "ls: cannot access '" + target + "': No such file or directory"})
+			_ = json.NewEncoder(w).Encode(errorResp(errCode(err), "sum: "+err.Error()))
 			return
 		}
-		// If path is a file, show just the file
-		if !info.IsDir() {
-			// If it's a file, show the file in the listing
-			if long {
-				_ = json.NewEncoder(w).Encode(execResp{Output: formatLong(info, colorizeName(info, filepath.Base(realCwd)), humanReadable)})
-			} else {
-				_ = json.NewEncoder(w).Encode(execResp{Output: colorizeName(info, filepath.Base(realCwd))})
+
+		// Log the checksum command
+		s.logCommand(cmd, vp, getClientIP(r))
+
+		output := fmt.Sprintf("MD5:    %s\nSHA256: %s", md5Sum, sha256Sum)
+		_ = json.NewEncoder(w).Encode(execResp{Output: output})
+		return
+
+	case "bookmark", "bm":
+		if len(argv) == 0 || argv[0] == "-l" {
+			bookmarks := sess.getBookmarks()
+			names := make([]string, 0, len(bookmarks))
+			for name := range bookmarks {
+				names = append(names, name)
+			}
+			if len(names) == 0 {
+				_ = json.NewEncoder(w).Encode(execResp{Output: "bookmark: no bookmarks"})
+				return
+			}
+			sort.Strings(names)
+			var lines []string
+			for _, name := range names {
+				lines = append(lines, fmt.Sprintf("%s%s%s -> %s", colorBlue, name, colorReset, bookmarks[name]))
 			}
+			_ = json.NewEncoder(w).Encode(execResp{Output: strings.Join(lines, "\n")})
 			return
 		}
-		// It is a directory, show its contents
-		ents, err := os.ReadDir(realCwd)
+
+		if argv[0] == "-d" {
+			if len(argv) < 2 {
+				_ = json.NewEncoder(w).Encode(errorResp(codeInvalid, "bookmark: missing name"))
+				return
+			}
+			if !sess.setBookmark(argv[1], "") {
+				_ = json.NewEncoder(w).Encode(errorResp(codeNoEnt, fmt.Sprintf("bookmark: %s: no such bookmark", argv[1])))
+				return
+			}
+			if err := s.saveBookmarks(sess); err != nil {
+				_ = json.NewEncoder(w).Encode(errorResp(codeIO, "bookmark: cannot save bookmarks"))
+				return
+			}
+			_ = json.NewEncoder(w).Encode(execResp{Output: ""})
+			return
+		}
+
+		name := argv[0]
+		if strings.ContainsAny(name, "/ ") || strings.HasPrefix(name, "-") {
+			_ = json.NewEncoder(w).Encode(errorResp(codeInvalid, fmt.Sprintf("bookmark: %s: invalid name", name)))
+			return
+		}
+		target := cwd
+		if len(argv) > 1 {
+			target = joinVirtual(cwd, argv[1])
+		}
+		rp, err := s.realFromVirtual(target)
 		if err != nil {
-			_ = json.NewEncoder(w).Encode(execResp{Output: "ls: error"})
+			_ = json.NewEncoder(w).Encode(errorResp(codeAccess, "bookmark: permission denied"))
 			return
 		}
-		var names []string
-		var longs []string
-		for _, e := range ents {
-			name := e.Name()
-			if !showHidden && strings.HasPrefix(name, ".") {
-				continue // hide dotfiles unless -a flag is used
+		if info, err := os.Stat(rp); err != nil || !info.IsDir() {
+			_ = json.NewEncoder(w).Encode(errorResp(codeNotDir, "bookmark: not a directory"))
+			return
+		}
+		sess.setBookmark(name, target)
+		if err := s.saveBookmarks(sess); err != nil {
+			_ = json.NewEncoder(w).Encode(errorResp(codeIO, "bookmark: cannot save bookmarks"))
+			return
+		}
+		_ = json.NewEncoder(w).Encode(execResp{Output: ""})
+		return
+
+	case "set":
+		if len(argv) == 0 {
+			order := sess.getSortBy()
+			if order == "" {
+				order = sortByName
 			}
-			// Check if file should be ignored based on .lsgetignore
-			realFilePath := filepath.Join(realCwd, name)
-			if s.shouldIgnore(realFilePath, name) {
+			dirsFirst := "off"
+			if sess.getDirsFirst() {
+				dirsFirst = "on"
+			}
+			_ = json.NewEncoder(w).Encode(execResp{Output: "sort " + order + "\ndirsfirst " + dirsFirst})
+			return
+		}
+		switch argv[0] {
+		case "sort":
+			if len(argv) != 2 {
+				_ = json.NewEncoder(w).Encode(errorResp(codeInvalid, "set: usage: set sort name|size|time|version"))
+				return
+			}
+			switch argv[1] {
+			case sortByName, sortBySize, sortByTime, sortByVersion:
+				sess.setSortBy(argv[1])
+				_ = json.NewEncoder(w).Encode(execResp{Output: ""})
+			default:
+				_ = json.NewEncoder(w).Encode(errorResp(codeInvalid, fmt.Sprintf("set: %s: invalid sort order (use name, size, time or version)", argv[1])))
+			}
+		case "dirsfirst":
+			if len(argv) != 2 || (argv[1] != "on" && argv[1] != "off") {
+				_ = json.NewEncoder(w).Encode(errorResp(codeInvalid, "set: usage: set dirsfirst on|off"))
+				return
+			}
+			sess.setDirsFirst(argv[1] == "on")
+			_ = json.NewEncoder(w).Encode(execResp{Output: ""})
+		default:
+			_ = json.NewEncoder(w).Encode(errorResp(codeInvalid, fmt.Sprintf("set: %s: unknown setting", argv[0])))
+		}
+		return
+
+	case "mkdir":
+		if !s.writable {
+			_ = json.NewEncoder(w).Encode(errorResp(codeReadOnly, "mkdir: read-only file system"))
+			return
+		}
+		parents := false
+		var dirs []string
+		for _, a := range argv {
+			if a == "-p" || a == "--parents" {
+				parents = true
 				continue
 			}
-			names = append(names, name)
+			dirs = append(dirs, a)
+		}
+		if len(dirs) == 0 {
+			_ = json.NewEncoder(w).Encode(errorResp(codeInvalid, "mkdir: missing operand"))
+			return
 		}
-		sort.Strings(names)
 
-		// Add ".." at the beginning if not at root
-		if sess.cwd != "/" {
-			names = append([]string{".."}, names...)
+		var errs []string
+		code := ""
+		fail := func(c, msg string) {
+			if code == "" {
+				code = c
+			}
+			errs = append(errs, msg)
+		}
+		for _, d := range dirs {
+			vp := joinVirtual(cwd, d)
+			rp, err := s.realFromVirtual(vp)
+			if err != nil || rp == s.rootAbs || s.createsHidden(rp) {
+				fail(codeAccess, fmt.Sprintf("mkdir: cannot create directory '%s': Permission denied", d))
+				continue
+			}
+			err = s.mkdir(rp, parents)
+			switch {
+			case err == nil:
+				s.logCommand(cmd, vp, getClientIP(r))
+			case errors.Is(err, os.ErrExist):
+				fail(codeExist, fmt.Sprintf("mkdir: cannot create directory '%s': File exists", d))
+			case errors.Is(err, os.ErrNotExist):
+				fail(codeNoEnt, fmt.Sprintf("mkdir: cannot create directory '%s': No such file or directory", d))
+			default:
+				fail(codeAccess, fmt.Sprintf("mkdir: cannot create directory '%s': Permission denied", d))
+			}
 		}
+		_ = json.NewEncoder(w).Encode(errorResp(code, strings.Join(errs, "\n")))
+		return
 
-		if !long {
-			// Colorized simple listing
-			var coloredNames []string
-			for _, name := range names {
-				if name == ".." {
-					// Special handling for parent directory
-					coloredNames = append(coloredNames, colorBlue+colorBold+"../"+colorReset)
-					continue
-				}
-				info, err := os.Stat(filepath.Join(realCwd, name))
-				if err != nil {
-					coloredNames = append(coloredNames, name)
-					continue
-				}
-				coloredNames = append(coloredNames, colorizeName(info, name))
+	case "rm":
+		if !s.writable {
+			_ = json.NewEncoder(w).Encode(errorResp(codeReadOnly, "rm: read-only file system"))
+			return
+		}
+		recursive := false
+		var targets []string
+		for _, a := range argv {
+			switch a {
+			case "-r", "-R", "--recursive":
+				recursive = true
+			default:
+				targets = append(targets, a)
 			}
-			_ = json.NewEncoder(w).Encode(execResp{Output: strings.Join(coloredNames, "\n")})
+		}
+		if len(targets) == 0 {
+			_ = json.NewEncoder(w).Encode(errorResp(codeInvalid, "rm: missing operand"))
 			return
 		}
-		// Colorized long listing
-		for _, name := range names {
-			if name == ".." {
-				// Special handling for parent directory in long format
-				longs = append(longs, "drwxr-xr-x          - "+colorBlue+colorBold+"../"+colorReset)
+
+		var errs []string
+		code := ""
+		fail := func(c, msg string) {
+			if code == "" {
+				code = c
+			}
+			errs = append(errs, msg)
+		}
+		for _, t := range targets {
+			vp := joinVirtual(cwd, t)
+			rp, err := s.realFromVirtual(vp)
+			if err != nil {
+				fail(codeAccess, fmt.Sprintf("rm: cannot remove '%s': Permission denied", t))
+				continue
+			}
+			if rp == s.rootAbs {
+				fail(codePerm, "rm: refusing to remove '/'")
+				continue
+			}
+			if s.isMount(rp) {
+				fail(codePerm, fmt.Sprintf("rm: refusing to remove mount '%s'", t))
 				continue
 			}
-			info, err := os.Stat(filepath.Join(realCwd, name))
+			if s.isControlFile(rp) {
+				fail(codePerm, fmt.Sprintf("rm: cannot remove '%s': Operation not permitted", t))
+				continue
+			}
+			info, err := os.Lstat(rp)
+			if err != nil || s.shouldIgnore(rp, filepath.Base(rp)) {
+				fail(codeNoEnt, fmt.Sprintf("rm: cannot remove '%s': No such file or directory", t))
+				continue
+			}
+			if info.IsDir() && !recursive {
+				fail(codeIsDir, fmt.Sprintf("rm: cannot remove '%s': Is a directory", t))
+				continue
+			}
+			if info.IsDir() && s.holdsUnseen(rp) {
+				fail(codeAccess, fmt.Sprintf("rm: cannot remove '%s': Permission denied", t))
+				continue
+			}
+			err = s.remove(rp, info.IsDir())
 			if err != nil {
+				fail(codeAccess, fmt.Sprintf("rm: cannot remove '%s': Permission denied", t))
 				continue
 			}
-			// Format the long listing with colorized filename
-			longEntry := formatLong(info, colorizeName(info, name), humanReadable)
-			longs = append(longs, longEntry)
+			s.logCommand(cmd, vp, getClientIP(r))
 		}
-		_ = json.NewEncoder(w).Encode(execResp{Output: strings.Join(longs, "\n")})
+		_ = json.NewEncoder(w).Encode(errorResp(code, strings.Join(errs, "\n")))
 		return
 
-	case "cd":
-		target := "/"
-		if len(argv) == 1 {
-			target = argv[0]
-			if target == "" {
-				target = "/"
+	case "mv", "cp":
+		if !s.writable {
+			_ = json.NewEncoder(w).Encode(errorResp(codeReadOnly, cmd+": read-only file system"))
+			return
+		}
+		recursive := false
+		var operands []string
+		for _, a := range argv {
+			if cmd == "cp" && (a == "-r" || a == "-R" || a == "--recursive") {
+				recursive = true
+				continue
 			}
+			operands = append(operands, a)
 		}
-		newV := joinVirtual(sess.cwd, target)
-		newReal, err := s.realFromVirtual(newV)
-		if err != nil {
-			_ = json.NewEncoder(w).Encode(execResp{Output: "cd: permission denied"})
+		if len(operands) < 2 {
+			_ = json.NewEncoder(w).Encode(errorResp(codeInvalid, cmd+": missing destination file operand"))
 			return
 		}
-		info, err := os.Stat(newReal)
-		if err != nil {
-			_ = json.NewEncoder(w).Encode(execResp{Output: "cd: no such file or directory"})
+
+		dstArg := operands[len(operands)-1]
+		dstV := joinVirtual(cwd, dstArg)
+		dstReal, err := s.realFromVirtual(dstV)
+		if err != nil || len(s.mounts) > 0 && dstReal == s.rootAbs {
+			_ = json.NewEncoder(w).Encode(errorResp(codeAccess, fmt.Sprintf("%s: cannot create '%s': Permission denied", cmd, dstArg)))
 			return
 		}
-		if !info.IsDir() {
-			_ = json.NewEncoder(w).Encode(execResp{Output: "cd: not a directory"})
-			return
+		dstIsDir := false
+		if info, err := os.Stat(dstReal); err == nil && info.IsDir() {
+			dstIsDir = true
 		}
-		sess.cwd = newV
-		
-		// Check if directory contains index.html
-		indexPath := filepath.Join(newReal, "index.html")
-		if _, err := os.Stat(indexPath); err == nil {
-			// Directory has index.html, redirect to it
-			_ = json.NewEncoder(w).Encode(execResp{Redirect: newV})
+		sources := operands[:len(operands)-1]
+		if len(sources) > 1 && !dstIsDir {
+			_ = json.NewEncoder(w).Encode(errorResp(codeNotDir, fmt.Sprintf("%s: target '%s' is not a directory", cmd, dstArg)))
 			return
 		}
-		
-		readme, docType := readDocFile(newReal)
-		// Include the new CWD in the response so client can update URL
-		_ = json.NewEncoder(w).Encode(execResp{Output: "", CWD: sess.cwd, Readme: &readme, DocType: docType})
-		return
 
-	case "cat":
-		if len(argv) < 1 {
-			_ = json.NewEncoder(w).Encode(execResp{Output: "cat: missing operand"})
-			return
+		verb := "move"
+		if cmd == "cp" {
+			verb = "copy"
+		}
+		var errs []string
+		code := ""
+		fail := func(c, msg string) {
+			if code == "" {
+				code = c
+			}
+			errs = append(errs, msg)
+		}
+		for _, src := range sources {
+			srcV := joinVirtual(cwd, src)
+			srcReal, err := s.realFromVirtual(srcV)
+			if err != nil || srcReal == s.rootAbs || cmd == "mv" && s.isMount(srcReal) {
+				fail(codeAccess, fmt.Sprintf("%s: cannot %s '%s': Permission denied", cmd, verb, src))
+				continue
+			}
+			info, err := os.Stat(srcReal)
+			if err != nil || s.shouldIgnore(srcReal, filepath.Base(srcReal)) {
+				fail(codeNoEnt, fmt.Sprintf("%s: cannot stat '%s': No such file or directory", cmd, src))
+				continue
+			}
+			if s.isControlFile(srcReal) {
+				fail(codePerm, fmt.Sprintf("%s: cannot %s '%s': Operation not permitted", cmd, verb, src))
+				continue
+			}
+			if cmd == "mv" && info.IsDir() && s.holdsUnseen(srcReal) {
+				fail(codeAccess, fmt.Sprintf("%s: cannot %s '%s': Permission denied", cmd, verb, src))
+				continue
+			}
+
+			target, targetArg := dstReal, dstArg
+			if dstIsDir {
+				target = filepath.Join(dstReal, filepath.Base(srcReal))
+				targetArg = path.Join(dstArg, filepath.Base(srcReal))
+			}
+			if target == srcReal || strings.HasPrefix(target, srcReal+string(filepath.Separator)) {
+				fail(codeInvalid, fmt.Sprintf("%s: cannot %s '%s' into itself", cmd, verb, src))
+				continue
+			}
+			// the same names upload refuses: a control file would be
+			// replaced, anything else would disappear from view
+			if s.hiddenName(target) {
+				fail(codeAccess, fmt.Sprintf("%s: cannot create '%s': Permission denied", cmd, targetArg))
+				continue
+			}
+			if existing, err := os.Lstat(target); err == nil {
+				if !s.force {
+					fail(codeExist, fmt.Sprintf("%s: '%s' already exists", cmd, targetArg))
+					continue
+				}
+				if existing.Mode()&os.ModeSymlink != 0 {
+					fail(codePerm, fmt.Sprintf("%s: refusing to replace symlink '%s'", cmd, targetArg))
+					continue
+				}
+			}
+
+			if cmd == "mv" {
+				err = s.rename(srcReal, target)
+			} else if info.IsDir() {
+				if !recursive {
+					fail(codeIsDir, fmt.Sprintf("cp: -r not specified; omitting directory '%s'", src))
+					continue
+				}
+				err = s.copyDirectory(srcReal, target)
+			} else {
+				err = s.copyFile(srcReal, target)
+			}
+			if err != nil {
+				fail(codeAccess, fmt.Sprintf("%s: cannot %s '%s': Permission denied", cmd, verb, src))
+				continue
+			}
+			s.logCommand(cmd, srcV, getClientIP(r))
+		}
+		_ = json.NewEncoder(w).Encode(errorResp(code, strings.Join(errs, "\n")))
+		return
+	}
+
+	_ = json.NewEncoder(w).Encode(errorResp(codeNoCommand, fmt.Sprintf("sh: %s: command not found", cmd)))
+}
+
+// statFile resolves arg, the file operand of cmd, and stats it. Commands
+// that read a single file share it so they refuse the same things the same
+// way: paths outside the root, missing or ignored files, and directories.
+func (s *server) statFile(cmd, cwd, arg string) (string, os.FileInfo, *execResp) {
+	rp, err := s.realFromVirtual(joinVirtual(cwd, arg))
+	if err != nil {
+		resp := errorResp(codeAccess, cmd+": permission denied")
+		return "", nil, &resp
+	}
+	info, err := os.Stat(rp)
+	if err != nil || s.shouldIgnore(rp, filepath.Base(rp)) {
+		resp := errorResp(codeNoEnt, fmt.Sprintf("%s: %s: no such file or directory", cmd, arg))
+		return "", nil, &resp
+	}
+	if info.IsDir() {
+		resp := errorResp(codeIsDir, fmt.Sprintf("%s: %s: is a directory", cmd, arg))
+		return "", nil, &resp
+	}
+	return rp, info, nil
+}
+
+// globFiles expands a wildcard into matching files the same way get does,
+// but like a shell leaves out dotfiles unless the pattern starts with a dot
+func (s *server) globFiles(cwd, pattern string) ([]fileInfo, error) {
+	files, err := s.collectFilesForDownload(cwd, pattern)
+	files = regularFiles(files)
+	if err != nil || strings.HasPrefix(path.Base(pattern), ".") {
+		return files, err
+	}
+	visible := files[:0]
+	for _, f := range files {
+		if !strings.HasPrefix(filepath.Base(f.realPath), ".") {
+			visible = append(visible, f)
+		}
+	}
+	return visible, nil
+}
+
+// preview limits: whichever is hit first ends the snippet
+const (
+	previewMaxLines = 40
+	previewMaxBytes = 2048
+)
+
+// previewFile returns the start of a text file with a truncation marker,
+// or a file(1)-style description for binary files. It never reads more
+// than previewMaxBytes.
+func previewFile(rp string) (string, error) {
+	f, err := os.Open(rp)
+	if err != nil {
+		return "", err
+	}
+	defer func() { _ = f.Close() }()
+
+	buf := make([]byte, previewMaxBytes+1)
+	n, err := io.ReadFull(f, buf)
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return "", err
+	}
+	buf = buf[:n]
+	truncated := n > previewMaxBytes
+	if truncated {
+		buf = buf[:previewMaxBytes]
+	}
+	if !looksText(buf) {
+		return describeContent(rp, buf), nil
+	}
+
+	lines := strings.SplitAfter(normalizeNewlines(decodeText(buf)), "\n")
+	if len(lines) > previewMaxLines && !(len(lines) == previewMaxLines+1 && lines[previewMaxLines] == "") {
+		lines = lines[:previewMaxLines]
+		truncated = true
+	}
+	text := strings.TrimRight(strings.Join(lines, ""), "\n")
+	if truncated {
+		// don't leave half a multi-byte character at the cut
+		for len(text) > 0 && !utf8.ValidString(text) {
+			text = text[:len(text)-1]
+		}
+		text += "\n" + colorBrightBlack + "… (truncated, use 'cat' or 'get' for the full file)" + colorReset
+	}
+	return text, nil
+}
+
+// describeContent names the format of binary data in the style of file(1),
+// from well-known magic numbers with a MIME sniffing fallback
+func describeContent(name string, data []byte) string {
+	magics := []struct {
+		prefix string
+		desc   string
+	}{
+		{"\x89PNG\r\n\x1a\n", "PNG image data"},
+		{"\xff\xd8\xff", "JPEG image data"},
+		{"GIF87a", "GIF image data"},
+		{"GIF89a", "GIF image data"},
+		{"%PDF-", "PDF document"},
+		{"PK\x03\x04", "Zip archive data"},
+		{"\x1f\x8b", "gzip compressed data"},
+		{"BZh", "bzip2 compressed data"},
+		{"\xfd7zXZ\x00", "XZ compressed data"},
+		{"7z\xbc\xaf\x27\x1c", "7-zip archive data"},
+		{"Rar!\x1a\x07", "RAR archive data"},
+		{"\x7fELF", "ELF executable"},
+		{"MZ", "MS-DOS/Windows executable"},
+		{"ID3", "Audio file with ID3 tag"},
+		{"OggS", "Ogg data"},
+		{"fLaC", "FLAC audio"},
+		{"SQLite format 3\x00", "SQLite 3.x database"},
+	}
+	for _, m := range magics {
+		if strings.HasPrefix(string(data), m.prefix) {
+			return fmt.Sprintf("%s: %s", filepath.Base(name), m.desc)
 		}
-		vp := joinVirtual(sess.cwd, argv[0])
-		rp, err := s.realFromVirtual(vp)
-		if err != nil {
-			_ = json.NewEncoder(w).Encode(execResp{Output: "cat: permission denied"})
-			return
+	}
+	if len(data) >= 262 && string(data[257:262]) == "ustar" {
+		return fmt.Sprintf("%s: POSIX tar archive", filepath.Base(name))
+	}
+	return fmt.Sprintf("%s: data (%s)", filepath.Base(name), http.DetectContentType(data))
+}
+
+// mediaField is one line of mediainfo output
+type mediaField struct {
+	key, value string
+}
+
+// mediaInfo extracts basic specs from image and audio headers. Only the
+// first few KB of the file are read; an empty result means the format is
+// not recognised.
+func mediaInfo(rp string, size int64) ([]mediaField, error) {
+	f, err := os.Open(rp)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = f.Close() }()
+
+	head := make([]byte, 64*1024)
+	n, err := io.ReadFull(f, head)
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return nil, err
+	}
+	head = head[:n]
+
+	switch {
+	case bytes.HasPrefix(head, []byte("\x89PNG\r\n\x1a\n")) && len(head) >= 24:
+		return []mediaField{
+			{"format", "PNG"},
+			{"size", fmt.Sprintf("%dx%d", binary.BigEndian.Uint32(head[16:20]), binary.BigEndian.Uint32(head[20:24]))},
+		}, nil
+	case bytes.HasPrefix(head, []byte("GIF8")) && len(head) >= 10:
+		return []mediaField{
+			{"format", "GIF"},
+			{"size", fmt.Sprintf("%dx%d", binary.LittleEndian.Uint16(head[6:8]), binary.LittleEndian.Uint16(head[8:10]))},
+		}, nil
+	case bytes.HasPrefix(head, []byte("\xff\xd8")):
+		if w, h, ok := jpegSize(head); ok {
+			return []mediaField{{"format", "JPEG"}, {"size", fmt.Sprintf("%dx%d", w, h)}}, nil
+		}
+		return []mediaField{{"format", "JPEG"}}, nil
+	}
+	return mp3Info(head, size), nil
+}
+
+// jpegSize walks the JPEG segments up to the first start-of-frame marker
+func jpegSize(data []byte) (width, height int, ok bool) {
+	i := 2
+	for i+4 <= len(data) {
+		if data[i] != 0xff {
+			return 0, 0, false
 		}
-		info, err := os.Stat(rp)
-		if err != nil {
-			_ = json.NewEncoder(w).Encode(execResp{Output: "cat: no such file or directory"})
-			return
+		marker := data[i+1]
+		if marker == 0xff {
+			i++
+			continue
 		}
-		if info.IsDir() {
-			_ = json.NewEncoder(w).Encode(execResp{Output: "cat: is a directory"})
-			return
+		segLen := int(binary.BigEndian.Uint16(data[i+2 : i+4]))
+		// SOF0..SOF15, except DHT (c4), JPG (c8) and DAC (cc)
+		if marker >= 0xc0 && marker <= 0xcf && marker != 0xc4 && marker != 0xc8 && marker != 0xcc {
+			if i+9 > len(data) {
+				return 0, 0, false
+			}
+			height = int(binary.BigEndian.Uint16(data[i+5 : i+7]))
+			width = int(binary.BigEndian.Uint16(data[i+7 : i+9]))
+			return width, height, true
 		}
+		i += 2 + segLen
+	}
+	return 0, 0, false
+}
 
-		// Check if file type is supported by cat
-		category := getFileCategory(argv[0])
+// MPEG-1 Layer III tables, indexed by the header bit fields
+var (
+	mp3Bitrates    = [16]int{0, 32, 40, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320, 0}
+	mp3Bitrates2   = [16]int{0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160, 0}
+	mp3SampleRates = [3]int{44100, 48000, 32000}
+)
 
-		// Display images inline
-		if category == FileCategoryImage {
-			url := urlEscapeVirtual(vp)
-			name := filepath.Base(argv[0])
-			escapedURL := template.HTMLEscapeString(url)
-			escapedName := template.HTMLEscapeString(name)
-			imgHTML := fmt.Sprintf(
-				`<div style="margin: 0.5em 0;">`+
-					`<a href="%s" target="_blank" style="display: inline-block; text-decoration: none;">`+
-					`<img src="%s" style="max-width: 100%%; height: auto; display: block; border-radius: 4px; box-shadow: 0 2px 8px rgba(0,0,0,0.3);" alt="%s">`+
-					`</a>`+
-					`<div style="color: #a6d189; font-size: 0.85em; margin-top: 0.25em;">📷 %s (%s)</div>`+
-					`</div>`,
-				escapedURL, escapedURL, escapedName, escapedName, formatHumanSize(info.Size()))
-			_ = json.NewEncoder(w).Encode(execResp{HTML: imgHTML})
-			return
+// mp3Info reads ID3v2 text tags and the first MPEG audio frame header.
+// Duration is taken from a Xing/Info frame count when present, otherwise
+// estimated from the file size assuming constant bitrate.
+func mp3Info(head []byte, size int64) []mediaField {
+	var fields []mediaField
+	offset := 0
+	if len(head) >= 10 && string(head[:3]) == "ID3" {
+		tagSize := int(head[6]&0x7f)<<21 | int(head[7]&0x7f)<<14 | int(head[8]&0x7f)<<7 | int(head[9]&0x7f)
+		offset = 10 + tagSize
+		fields = append(fields, id3Tags(head[:min(offset, len(head))], head[3])...)
+	}
+
+	// find a frame sync near the start of the audio data
+	for i := offset; i+4 <= len(head) && i < offset+4096; i++ {
+		if head[i] != 0xff || head[i+1]&0xe0 != 0xe0 {
+			continue
+		}
+		version := (head[i+1] >> 3) & 0x03 // 3 = MPEG-1, 2 = MPEG-2, 0 = MPEG-2.5
+		layer := (head[i+1] >> 1) & 0x03   // 1 = Layer III
+		brIndex := head[i+2] >> 4
+		srIndex := (head[i+2] >> 2) & 0x03
+		if version == 1 || layer != 1 || brIndex == 0 || brIndex == 15 || srIndex == 3 {
+			continue
+		}
+		bitrate := mp3Bitrates[brIndex]
+		sampleRate := mp3SampleRates[srIndex]
+		samplesPerFrame := 1152
+		if version != 3 {
+			bitrate = mp3Bitrates2[brIndex]
+			sampleRate /= 2
+			samplesPerFrame = 576
+			if version == 0 {
+				sampleRate /= 2
+			}
 		}
+		mono := head[i+3]>>6 == 3
 
-		// Only text files and unknown files (to be checked by content) can be displayed
-		if category != FileCategoryText && category != FileCategoryUnknown {
-			_ = json.NewEncoder(w).Encode(execResp{Output: fmt.Sprintf("cat: cannot display %s files (use 'get' to download)", category)})
-			return
+		var seconds float64
+		xing := frameXingOffset(version, mono)
+		if p := i + xing; p+12 <= len(head) && (string(head[p:p+4]) == "Xing" || string(head[p:p+4]) == "Info") &&
+			binary.BigEndian.Uint32(head[p+4:p+8])&1 != 0 {
+			frames := binary.BigEndian.Uint32(head[p+8 : p+12])
+			seconds = float64(frames) * float64(samplesPerFrame) / float64(sampleRate)
+			if seconds > 0 {
+				bitrate = int(float64(size-int64(i)) * 8 / seconds / 1000)
+			}
+		} else {
+			seconds = float64(size-int64(i)) * 8 / float64(bitrate*1000)
 		}
 
-		if info.Size() > s.catMax {
-			_ = json.NewEncoder(w).Encode(execResp{Output: fmt.Sprintf("cat: file too large (%d > limit %d)", info.Size(), s.catMax)})
-			return
+		channels := "stereo"
+		if mono {
+			channels = "mono"
 		}
-		f, err := os.Open(rp)
-		if err != nil {
-			_ = json.NewEncoder(w).Encode(execResp{Output: "cat: cannot open file"})
-			return
+		fields = append(fields,
+			mediaField{"format", "MP3"},
+			mediaField{"duration", formatDuration(seconds)},
+			mediaField{"bitrate", fmt.Sprintf("%d kbps", bitrate)},
+			mediaField{"sample", fmt.Sprintf("%d Hz, %s", sampleRate, channels)},
+		)
+		return fields
+	}
+	return fields
+}
+
+// frameXingOffset is where a Xing/Info header sits after the frame header
+func frameXingOffset(version byte, mono bool) int {
+	switch {
+	case version == 3 && !mono:
+		return 4 + 32
+	case version == 3, !mono:
+		return 4 + 17
+	default:
+		return 4 + 9
+	}
+}
+
+// id3Tags decodes the title, artist and album frames of an ID3v2.3/2.4 tag
+func id3Tags(tag []byte, major byte) []mediaField {
+	names := map[string]string{"TIT2": "title", "TPE1": "artist", "TALB": "album"}
+	var fields []mediaField
+	for i := 10; i+10 <= len(tag); {
+		id := string(tag[i : i+4])
+		if tag[i] == 0 {
+			break
 		}
-		defer func() { _ = f.Close() }()
-		// read up to catMax bytes
-		var buf bytes.Buffer
-		if _, err := io.CopyN(&buf, f, s.catMax); err != nil && !errors.Is(err, io.EOF) {
-			_ = json.NewEncoder(w).Encode(execResp{Output: "cat: read error"})
-			return
+		var n int
+		if major >= 4 {
+			n = int(tag[i+4]&0x7f)<<21 | int(tag[i+5]&0x7f)<<14 | int(tag[i+6]&0x7f)<<7 | int(tag[i+7]&0x7f)
+		} else {
+			n = int(binary.BigEndian.Uint32(tag[i+4 : i+8]))
 		}
-		sample := buf.Bytes()
-		if !looksText(sample) {
-			_ = json.NewEncoder(w).Encode(execResp{Output: "cat: binary file (use 'get' to download)"})
-			return
+		start, end := i+10, i+10+n
+		if n <= 0 || end > len(tag) {
+			break
 		}
-		_ = json.NewEncoder(w).Encode(execResp{Output: string(sample)})
-		return
+		if key, ok := names[id]; ok {
+			if v := decodeID3Text(tag[start:end]); v != "" {
+				fields = append(fields, mediaField{key, v})
+			}
+		}
+		i = end
+	}
+	return fields
+}
 
-	case "get", "rget", "wget", "download":
-		if len(argv) < 1 {
-			_ = json.NewEncoder(w).Encode(execResp{Output: "download: missing operand"})
-			return
+// decodeID3Text converts an ID3 text frame body to a Go string
+func decodeID3Text(b []byte) string {
+	if len(b) < 2 {
+		return ""
+	}
+	enc, b := b[0], b[1:]
+	var text string
+	switch enc {
+	case 1, 2: // UTF-16 with BOM, UTF-16BE
+		order := binary.ByteOrder(binary.BigEndian)
+		if enc == 1 && len(b) >= 2 {
+			if b[0] == 0xff && b[1] == 0xfe {
+				order = binary.LittleEndian
+			}
+			b = b[2:]
 		}
+		text = decodeUTF16(b, order)
+	case 3: // UTF-8
+		text = string(b)
+	default: // ISO-8859-1
+		r := make([]rune, len(b))
+		for j, c := range b {
+			r[j] = rune(c)
+		}
+		text = string(r)
+	}
+	return strings.TrimSpace(strings.TrimRight(text, "\x00"))
+}
 
-		pattern := argv[0]
+// EXIF tags shown by the exif command, in display order
+var exifTags = []struct {
+	tag uint16
+	key string
+}{
+	{0x010f, "make"},
+	{0x0110, "model"},
+	{0xa434, "lens"},
+	{0x9003, "taken"},
+	{0x829a, "exposure"},
+	{0x829d, "aperture"},
+	{0x8827, "iso"},
+	{0x920a, "focal"},
+	{0x0131, "software"},
+}
 
-		// Get IP address for logging
-		ip := getClientIP(r)
+// readExif parses the APP1 Exif segment of a JPEG file. GPS coordinates
+// are only decoded when showGPS is set; otherwise their presence is noted.
+func readExif(rp string, showGPS bool) ([]mediaField, error) {
+	f, err := os.Open(rp)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = f.Close() }()
 
-		// Check if pattern contains wildcards or is a directory
-		if strings.ContainsAny(pattern, "*?[") || pattern == "." {
-			// Handle pattern-based download (multiple files)
-			files, err := s.collectFilesForDownload(sess.cwd, pattern)
-			if err != nil {
-				_ = json.NewEncoder(w).Encode(execResp{Output: fmt.Sprintf("download: %v", err)})
-				return
-			}
-			if len(files) == 0 {
-				_ = json.NewEncoder(w).Encode(execResp{Output: "download: no matching files found"})
-				return
-			}
-			if len(files) == 1 {
-				// Single file, download directly
-				s.logCommand("get", files[0].virtualPath, ip)
-				url := "/api/download?path=" + urlEscapeVirtual(files[0].virtualPath)
-				_ = json.NewEncoder(w).Encode(execResp{Output: "", Download: url})
-				return
-			}
-			// Multiple files, create zip
-			s.logCommand("get", "(pattern match)", ip)
-			downloadURL := "/api/download?pattern=" + url.QueryEscape(pattern) + "&cwd=" + urlEscapeVirtual(sess.cwd)
-			_ = json.NewEncoder(w).Encode(execResp{Output: fmt.Sprintf("Downloading %d files as archive.zip", len(files)), Download: downloadURL})
-			return
-		}
+	// the Exif segment sits right after SOI and is capped at 64KB
+	head := make([]byte, 128*1024)
+	n, err := io.ReadFull(f, head)
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return nil, err
+	}
+	head = head[:n]
+	if !bytes.HasPrefix(head, []byte("\xff\xd8")) {
+		return nil, nil
+	}
 
-		// Check if it's a directory
-		vp := joinVirtual(sess.cwd, pattern)
-		rp, err := s.realFromVirtual(vp)
-		if err != nil {
-			_ = json.NewEncoder(w).Encode(execResp{Output: "download: permission denied"})
-			return
+	var tiff []byte
+	for i := 2; i+4 <= len(head) && head[i] == 0xff; {
+		marker := head[i+1]
+		segLen := int(binary.BigEndian.Uint16(head[i+2 : i+4]))
+		if segLen < 2 { // the length counts its own two bytes
+			break
 		}
-		info, err := os.Stat(rp)
-		if err != nil {
-			_ = json.NewEncoder(w).Encode(execResp{Output: "download: no such file"})
-			return
+		end := min(i+2+segLen, len(head))
+		if marker == 0xe1 && end >= i+10 && bytes.HasPrefix(head[i+4:end], []byte("Exif\x00\x00")) {
+			tiff = head[i+10 : end]
+			break
 		}
-
-		if info.IsDir() {
-			// Download directory as zip
-			files, err := s.collectFilesFromDirectory(vp, rp)
-			if err != nil {
-				_ = json.NewEncoder(w).Encode(execResp{Output: fmt.Sprintf("download: %v", err)})
-				return
-			}
-			if len(files) == 0 {
-				_ = json.NewEncoder(w).Encode(execResp{Output: "download: directory is empty"})
-				return
-			}
-			dirName := filepath.Base(rp)
-			s.logCommand("get", vp+" (dir)", ip)
-			url := "/api/download?dir=" + urlEscapeVirtual(vp)
-			_ = json.NewEncoder(w).Encode(execResp{Output: fmt.Sprintf("Downloading directory '%s' with %d files as %s.zip", dirName, len(files), dirName), Download: url})
-			return
+		if marker == 0xda { // start of scan: no more metadata
+			break
 		}
+		i = end
+	}
+	if len(tiff) < 8 {
+		return nil, nil
+	}
 
-		// Single file download
-		s.logCommand("get", vp, ip)
-		url := "/api/download?path=" + urlEscapeVirtual(vp)
-		_ = json.NewEncoder(w).Encode(execResp{Output: "", Download: url})
-		return
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return nil, nil
+	}
 
-	case "tree":
-		// Parse options
-		showHidden := false
-		maxDepth := -1 // unlimited by default
-		target := sess.cwd
+	values := map[uint16]string{}
+	ifd0 := readIFD(tiff, order, order.Uint32(tiff[4:8]))
+	for tag, e := range ifd0 {
+		values[tag] = e.format(tiff, order)
+	}
+	if e, ok := ifd0[0x8769]; ok {
+		for tag, e := range readIFD(tiff, order, e.offset) {
+			values[tag] = e.format(tiff, order)
+		}
+	}
+	if v, ok := values[0x9003]; !ok || v == "" {
+		values[0x9003] = values[0x0132] // fall back to DateTime
+	}
 
-		for _, arg := range argv {
-			if strings.HasPrefix(arg, "-") {
-				if strings.Contains(arg, "a") {
-					showHidden = true
-				}
-				if strings.HasPrefix(arg, "-L") && len(arg) > 2 {
-					// Simple depth parsing for -L<number>
-					depthStr := arg[2:]
-					if d, err := fmt.Sscanf(depthStr, "%d", &maxDepth); d != 1 || err != nil {
-						maxDepth = -1
-					}
-				}
-			} else {
-				// Directory argument
-				target = joinVirtual(sess.cwd, arg)
+	var fields []mediaField
+	for _, t := range exifTags {
+		if v := values[t.tag]; v != "" {
+			switch t.tag {
+			case 0x829a:
+				v += " s"
+			case 0x829d:
+				v = "f/" + v
+			case 0x920a:
+				v += " mm"
 			}
+			fields = append(fields, mediaField{t.key, v})
 		}
+	}
 
-		realTarget, err := s.realFromVirtual(target)
-		if err != nil {
-			_ = json.NewEncoder(w).Encode(execResp{Output: "tree: permission denied"})
-			return
+	if e, ok := ifd0[0x8825]; ok {
+		if !showGPS {
+			fields = append(fields, mediaField{"gps", "present (hidden by server)"})
+		} else if pos := exifGPSPosition(readIFD(tiff, order, e.offset), tiff, order); pos != "" {
+			fields = append(fields, mediaField{"gps", pos})
 		}
+	}
+	return fields, nil
+}
 
-		info, err := os.Stat(realTarget)
-		if err != nil {
-			_ = json.NewEncoder(w).Encode(execResp{Output: "tree: no such file or directory"})
-			return
-		}
+// exifEntry is a raw IFD entry; offset holds the value itself when it fits
+type exifEntry struct {
+	typ    uint16
+	count  uint32
+	offset uint32
+	raw    []byte
+}
 
-		if !info.IsDir() {
-			_ = json.NewEncoder(w).Encode(execResp{Output: "tree: not a directory"})
-			return
+// readIFD reads the entries of one image file directory
+func readIFD(tiff []byte, order binary.ByteOrder, off uint32) map[uint16]exifEntry {
+	entries := map[uint16]exifEntry{}
+	if int(off)+2 > len(tiff) {
+		return entries
+	}
+	count := int(order.Uint16(tiff[off:]))
+	for i := range count {
+		p := int(off) + 2 + i*12
+		if p+12 > len(tiff) {
+			break
+		}
+		entries[order.Uint16(tiff[p:])] = exifEntry{
+			typ:    order.Uint16(tiff[p+2:]),
+			count:  order.Uint32(tiff[p+4:]),
+			offset: order.Uint32(tiff[p+8:]),
+			raw:    tiff[p+8 : p+12],
 		}
+	}
+	return entries
+}
 
-		var result strings.Builder
-		dirCount, fileCount := s.buildTree(&result, realTarget, "", showHidden, maxDepth, 0)
+// data returns the bytes of an entry of the given element size
+func (e exifEntry) data(tiff []byte, size int) []byte {
+	n := int(e.count) * size
+	if n <= 4 {
+		return e.raw[:n]
+	}
+	if int(e.offset)+n > len(tiff) || n < 0 {
+		return nil
+	}
+	return tiff[e.offset : int(e.offset)+n]
+}
 
-		// Add summary
-		result.WriteString(fmt.Sprintf("\n%d directories, %d files", dirCount, fileCount))
+// rationals decodes an unsigned RATIONAL entry
+func (e exifEntry) rationals(tiff []byte, order binary.ByteOrder) [][2]uint32 {
+	b := e.data(tiff, 8)
+	var out [][2]uint32
+	for i := 0; i+8 <= len(b); i += 8 {
+		out = append(out, [2]uint32{order.Uint32(b[i:]), order.Uint32(b[i+4:])})
+	}
+	return out
+}
 
-		_ = json.NewEncoder(w).Encode(execResp{Output: result.String()})
-		return
+// format renders ASCII, SHORT, LONG and RATIONAL entries as text
+func (e exifEntry) format(tiff []byte, order binary.ByteOrder) string {
+	switch e.typ {
+	case 2: // ASCII
+		return strings.TrimSpace(strings.TrimRight(string(e.data(tiff, 1)), "\x00"))
+	case 3: // SHORT
+		if b := e.data(tiff, 2); len(b) >= 2 {
+			return fmt.Sprint(order.Uint16(b))
+		}
+	case 4: // LONG
+		if b := e.data(tiff, 4); len(b) >= 4 {
+			return fmt.Sprint(order.Uint32(b))
+		}
+	case 5: // RATIONAL
+		r := e.rationals(tiff, order)
+		if len(r) == 0 || r[0][1] == 0 {
+			return ""
+		}
+		if r[0][0] < r[0][1] && r[0][0] > 0 {
+			return fmt.Sprintf("1/%d", (r[0][1]+r[0][0]/2)/r[0][0])
+		}
+		return strconv.FormatFloat(float64(r[0][0])/float64(r[0][1]), 'f', -1, 64)
+	}
+	return ""
+}
 
-	case "find":
-		// Parse options
-		searchPath := sess.cwd
-		namePattern := "*"
-		typeFilter := "" // "f" for files, "d" for directories, "" for both
+// exifGPSPosition converts GPS IFD degrees/minutes/seconds to decimal degrees
+func exifGPSPosition(gps map[uint16]exifEntry, tiff []byte, order binary.ByteOrder) string {
+	coord := func(refTag, valTag uint16) (float64, bool) {
+		e, ok := gps[valTag]
+		if !ok {
+			return 0, false
+		}
+		r := e.rationals(tiff, order)
+		if len(r) < 3 {
+			return 0, false
+		}
+		var v float64
+		for i, div := range []float64{1, 60, 3600} {
+			if r[i][1] == 0 {
+				return 0, false
+			}
+			v += float64(r[i][0]) / float64(r[i][1]) / div
+		}
+		if ref, ok := gps[refTag]; ok {
+			if s := ref.format(tiff, order); s == "S" || s == "W" {
+				v = -v
+			}
+		}
+		return v, true
+	}
+	lat, ok1 := coord(1, 2)
+	lon, ok2 := coord(3, 4)
+	if !ok1 || !ok2 {
+		return ""
+	}
+	return fmt.Sprintf("%.6f, %.6f", lat, lon)
+}
 
-		// Parse arguments
-		for i := 0; i < len(argv); i++ {
-			arg := argv[i]
-			if arg == "-name" && i+1 < len(argv) {
-				namePattern = argv[i+1]
-				i++ // skip next argument
-			} else if arg == "-type" && i+1 < len(argv) {
-				typeFilter = argv[i+1]
-				i++ // skip next argument
-			} else if !strings.HasPrefix(arg, "-") {
-				// Path argument
-				searchPath = joinVirtual(sess.cwd, arg)
+// formatDuration renders seconds as m:ss or h:mm:ss
+func formatDuration(seconds float64) string {
+	t := int(seconds + 0.5)
+	if t >= 3600 {
+		return fmt.Sprintf("%d:%02d:%02d", t/3600, t/60%60, t%60)
+	}
+	return fmt.Sprintf("%d:%02d", t/60, t%60)
+}
+
+// readCatText returns up to catMax bytes of a text file for cat; the
+// error text is shown to the user after the "cat: " prefix. Larger files
+// are refused unless head is set, which returns their first catMax bytes
+// followed by a truncation marker.
+func (s *server) readCatText(rp string, size int64, head bool) (string, error) {
+	if size > s.catMax && !head {
+		return "", &codedError{codeTooLarge, fmt.Sprintf("file too large (%d > limit %d)", size, s.catMax)}
+	}
+	f, err := os.Open(rp)
+	if err != nil {
+		return "", errors.New("cannot open file")
+	}
+	defer func() { _ = f.Close() }()
+	// read up to catMax bytes
+	var buf bytes.Buffer
+	if _, err := io.CopyN(&buf, f, s.catMax); err != nil && !errors.Is(err, io.EOF) {
+		return "", errors.New("read error")
+	}
+	sample := buf.Bytes()
+	if !looksText(sample) {
+		return "", &codedError{codeInvalid, "binary file (use 'get' to download)"}
+	}
+	if size <= s.catMax {
+		return normalizeNewlines(decodeText(sample)), nil
+	}
+	if _, ok := utf16Order(sample); ok {
+		sample = sample[:len(sample)&^1]
+	} else {
+		sample = trimPartialRune(sample)
+	}
+	text := strings.TrimSuffix(normalizeNewlines(decodeText(sample)), "\n")
+	return fmt.Sprintf("%s\n... [truncated, %d more bytes]", text, size-int64(len(sample))), nil
+}
+
+// trimPartialRune drops a UTF-8 sequence cut short at the end of b
+func trimPartialRune(b []byte) []byte {
+	for i := len(b) - 1; i >= 0 && i >= len(b)-utf8.UTFMax; i-- {
+		if utf8.RuneStart(b[i]) {
+			if !utf8.FullRune(b[i:]) {
+				return b[:i]
 			}
+			break
 		}
+	}
+	return b
+}
 
-		// Validate type filter
-		if typeFilter != "" && typeFilter != "f" && typeFilter != "d" {
-			_ = json.NewEncoder(w).Encode(execResp{Output: "find: invalid type filter (use 'f' for files or 'd' for directories)"})
-			return
-		}
+// normalizeNewlines turns Windows (\r\n) and classic Mac (\r) line endings
+// into \n, so text from any platform prints and splits the same way
+func normalizeNewlines(text string) string {
+	if !strings.Contains(text, "\r") {
+		return text
+	}
+	return strings.ReplaceAll(strings.ReplaceAll(text, "\r\n", "\n"), "\r", "\n")
+}
 
-		realSearchPath, err := s.realFromVirtual(searchPath)
-		if err != nil {
-			_ = json.NewEncoder(w).Encode(execResp{Output: "find: permission denied"})
-			return
+// archiveListMax caps the entries printed when listing an archive
+const archiveListMax = 500
+
+// listZip renders the central directory of a zip file in the layout of
+// `unzip -l`, without extracting anything
+func listZip(rp string) (string, error) {
+	zr, err := zip.OpenReader(rp)
+	if err != nil {
+		return "", errors.New("not a valid zip archive")
+	}
+	defer func() { _ = zr.Close() }()
+
+	var b strings.Builder
+	b.WriteString("  Length      Date    Time    Name\n")
+	b.WriteString("---------  ---------- -----   ----\n")
+	var total uint64
+	for i, f := range zr.File {
+		total += f.UncompressedSize64
+		if i < archiveListMax {
+			fmt.Fprintf(&b, "%9d  %s   %s\n", f.UncompressedSize64, f.Modified.Format("2006-01-02 15:04"), f.Name)
 		}
+	}
+	if n := len(zr.File) - archiveListMax; n > 0 {
+		fmt.Fprintf(&b, "%s… %d more entries not shown%s\n", colorBrightBlack, n, colorReset)
+	}
+	b.WriteString("---------                     -------\n")
+	noun := "files"
+	if len(zr.File) == 1 {
+		noun = "file"
+	}
+	fmt.Fprintf(&b, "%9d                     %d %s", total, len(zr.File), noun)
+	return b.String(), nil
+}
 
-		info, err := os.Stat(realSearchPath)
+// listTar prints mode, size and name of each entry of a tar file, which
+// may be gzip or bzip2 compressed. A damaged or truncated archive keeps
+// the entries read so far and ends with an error line.
+func listTar(rp string) string {
+	f, err := os.Open(rp)
+	if err != nil {
+		return "tar: cannot open file"
+	}
+	defer func() { _ = f.Close() }()
+
+	br := bufio.NewReader(f)
+	magic, _ := br.Peek(3)
+	var r io.Reader = br
+	switch {
+	case bytes.HasPrefix(magic, []byte("\x1f\x8b")):
+		gz, err := gzip.NewReader(br)
 		if err != nil {
-			_ = json.NewEncoder(w).Encode(execResp{Output: "find: no such file or directory"})
-			return
+			return "tar: corrupt gzip data"
 		}
+		defer func() { _ = gz.Close() }()
+		r = gz
+	case bytes.HasPrefix(magic, []byte("BZh")):
+		r = bzip2.NewReader(br)
+	}
 
-		if !info.IsDir() {
-			_ = json.NewEncoder(w).Encode(execResp{Output: "find: not a directory"})
-			return
+	var lines []string
+	tr := tar.NewReader(r)
+	count := 0
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
 		}
-
-		var results []string
-		err = s.findFiles(realSearchPath, searchPath, namePattern, typeFilter, &results)
 		if err != nil {
-			_ = json.NewEncoder(w).Encode(execResp{Output: fmt.Sprintf("find: %v", err)})
-			return
+			if count == 0 {
+				return "tar: this does not look like a tar archive"
+			}
+			lines = append(lines, colorRed+"tar: unexpected end of archive"+colorReset)
+			break
 		}
-
-		if len(results) == 0 {
-			_ = json.NewEncoder(w).Encode(execResp{Output: "find: no matches found"})
-			return
+		count++
+		if count <= archiveListMax {
+			lines = append(lines, fmt.Sprintf("%s %10d  %s", hdr.FileInfo().Mode(), hdr.Size, hdr.Name))
 		}
+	}
+	if n := count - archiveListMax; n > 0 {
+		lines = append(lines, fmt.Sprintf("%s… %d more entries not shown%s", colorBrightBlack, n, colorReset))
+	}
+	return strings.Join(lines, "\n")
+}
 
-		_ = json.NewEncoder(w).Encode(execResp{Output: strings.Join(results, "\n")})
-		return
+// tail limits: how far back the last lines are looked for, how often a
+// followed file is checked, and how much new data is shown per check
+const (
+	tailWindow       = 64 * 1024
+	tailPollInterval = 500 * time.Millisecond
+	tailMaxChunk     = 64 * 1024
+)
 
-	case "url", "share":
-		if len(argv) < 1 {
-			_ = json.NewEncoder(w).Encode(execResp{Output: "url: missing file operand"})
-			return
-		}
+// tailLines returns the last n lines of a text file, looking at most
+// tailWindow bytes back, and the size it read up to
+func tailLines(rp string, n int) ([]string, int64, error) {
+	f, err := os.Open(rp)
+	if err != nil {
+		return nil, 0, errors.New("cannot open file")
+	}
+	defer func() { _ = f.Close() }()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, 0, err
+	}
+	size := info.Size()
+	start := max(0, size-tailWindow) &^ 1 // keep UTF-16 code units aligned
+	buf := make([]byte, size-start)
+	if _, err := f.ReadAt(buf, start); err != nil && !errors.Is(err, io.EOF) {
+		return nil, 0, errors.New("read error")
+	}
+	if !looksText(buf) {
+		return nil, 0, &codedError{codeInvalid, "binary file"}
+	}
+	text := strings.TrimSuffix(normalizeNewlines(decodeText(buf)), "\n")
+	if text == "" || n == 0 {
+		return nil, size, nil
+	}
+	lines := strings.Split(text, "\n")
+	if start > 0 {
+		lines = lines[1:] // first line is probably cut
+	}
+	return lines[max(0, len(lines)-n):], size, nil
+}
 
-		vp := joinVirtual(sess.cwd, argv[0])
-		rp, err := s.realFromVirtual(vp)
-		if err != nil {
-			_ = json.NewEncoder(w).Encode(execResp{Output: "url: permission denied"})
+// followFile streams lines appended to rp after offset until ctx ends
+// (client disconnect or Ctrl+C). A file that shrinks is assumed to have
+// been truncated or rotated and is read again from the start; bursts
+// larger than tailMaxChunk are skipped rather than flooding the browser,
+// and a line that grows past catMax is shown in catMax-sized pieces.
+func (s *server) followFile(ctx context.Context, rp, name string, offset int64, out *lineOutput) {
+	ticker := time.NewTicker(tailPollInterval)
+	defer ticker.Stop()
+	maxLine := int(s.catMax)
+	if maxLine <= 0 {
+		maxLine = tailMaxChunk
+	}
+	var partial string
+	for {
+		select {
+		case <-ctx.Done():
 			return
+		case <-ticker.C:
 		}
-
 		info, err := os.Stat(rp)
 		if err != nil {
-			_ = json.NewEncoder(w).Encode(execResp{Output: "url: no such file or directory"})
+			out.add(fmt.Sprintf("%stail: %s: file disappeared%s", colorRed, name, colorReset))
 			return
 		}
-
-		if info.IsDir() {
-			_ = json.NewEncoder(w).Encode(execResp{Output: "url: cannot share directories (use 'get' to download as zip)"})
+		size := info.Size()
+		if size < offset {
+			out.add(fmt.Sprintf("%stail: %s: file truncated%s", colorYellow, name, colorReset))
+			offset, partial = 0, ""
+		}
+		if size == offset {
+			continue
+		}
+		if size-offset > tailMaxChunk {
+			out.add(fmt.Sprintf("%s… %s skipped%s", colorBrightBlack, formatHumanSize(size-offset-tailMaxChunk), colorReset))
+			offset, partial = size-tailMaxChunk, ""
+		}
+		f, err := os.Open(rp)
+		if err != nil {
 			return
 		}
+		buf := make([]byte, size-offset)
+		n, _ := f.ReadAt(buf, offset)
+		_ = f.Close()
+		offset += int64(n)
 
-		// Check if file should be ignored
-		if s.shouldIgnore(rp, filepath.Base(rp)) {
-			_ = json.NewEncoder(w).Encode(execResp{Output: "url: file is ignored"})
-			return
+		// only complete lines are shown; a trailing fragment waits for its newline
+		lines := strings.Split(partial+string(buf[:n]), "\n")
+		partial = lines[len(lines)-1]
+		for _, l := range lines[:len(lines)-1] {
+			out.add(strings.TrimSuffix(l, "\r"))
+		}
+		// a file that never writes a newline must not grow the fragment forever
+		for len(partial) > maxLine {
+			out.add(partial[:maxLine])
+			partial = partial[maxLine:]
 		}
+	}
+}
 
-		// Build the full URL for the file
-		var fileURL string
-		if s.baseURL != "" {
-			// Use configured base URL (clean, no /api/static)
-			// Required when behind reverse proxy with different public URL
-			fileURL = s.baseURL + vp
-		} else {
-			// Auto-detect from request (works for most cases)
-			// Uses Host header and X-Forwarded-Proto for protocol detection
-			host := r.Host
-			if host == "" {
-				host = "localhost:8080"
-			}
-			protocol := "http"
-			if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
-				protocol = "https"
-			}
-			fileURL = fmt.Sprintf("%s://%s/%s", protocol, host, vp)
+// defaultStringsMin is the shortest run strings prints unless -n says otherwise
+const defaultStringsMin = 4
+
+// scanStrings calls emit with every run of at least minLen printable ASCII
+// characters (tabs included) in r, like the Unix strings tool. The input is
+// read through a small buffer, so runs are reported as they are found.
+func scanStrings(r io.Reader, minLen int, emit func(string)) error {
+	br := bufio.NewReader(r)
+	var run []byte
+	flush := func() {
+		if len(run) >= minLen {
+			emit(string(run))
+		}
+		run = run[:0]
+	}
+	for {
+		b, err := br.ReadByte()
+		if err == io.EOF {
+			flush()
+			return nil
+		}
+		if err != nil {
+			return err
 		}
+		if b == '\t' || (b >= 0x20 && b <= 0x7e) {
+			run = append(run, b)
+			continue
+		}
+		flush()
+	}
+}
 
-		// Log the share command
-		s.logCommand(cmd, vp, getClientIP(r))
+// readCompressedText decompresses a gzip or bzip2 file, detected from its
+// magic bytes, and returns up to catMax bytes of the decompressed text
+func (s *server) readCompressedText(rp string) (string, error) {
+	f, err := os.Open(rp)
+	if err != nil {
+		return "", errors.New("cannot open file")
+	}
+	defer func() { _ = f.Close() }()
 
-		// Return the URL with clipboard instruction
-		_ = json.NewEncoder(w).Encode(execResp{
-			Output:    fmt.Sprintf("Shareable URL: %s\n%sURL copied to clipboard!%s", fileURL, colorGreen, colorReset),
-			Clipboard: fileURL,
-		})
-		return
+	br := bufio.NewReader(f)
+	magic, _ := br.Peek(6)
+	var r io.Reader
+	switch {
+	case bytes.HasPrefix(magic, []byte("\x1f\x8b")):
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return "", errors.New("corrupt gzip data")
+		}
+		defer func() { _ = gz.Close() }()
+		r = gz
+	case bytes.HasPrefix(magic, []byte("BZh")):
+		r = bzip2.NewReader(br)
+	case bytes.HasPrefix(magic, []byte("\xfd7zXZ\x00")):
+		return "", errors.New("xz is not supported (use 'get' to download)")
+	default:
+		return "", &codedError{codeInvalid, "not in gzip or bzip2 format"}
+	}
 
-	case "grep":
-		if len(argv) < 1 {
-			_ = json.NewEncoder(w).Encode(execResp{Output: "grep: missing pattern"})
-			return
-		}
+	// read one byte past the limit to know whether to mark truncation
+	var buf bytes.Buffer
+	if _, err := io.CopyN(&buf, r, s.catMax+1); err != nil && !errors.Is(err, io.EOF) {
+		return "", errors.New("corrupt compressed data")
+	}
+	truncated := int64(buf.Len()) > s.catMax
+	sample := buf.Bytes()[:min(int64(buf.Len()), s.catMax)]
+	if !looksText(sample) {
+		return "", &codedError{codeInvalid, "binary content (use 'get' to download)"}
+	}
+	text := strings.TrimRight(normalizeNewlines(decodeText(sample)), "\n")
+	if truncated {
+		text += "\n" + colorBrightBlack + fmt.Sprintf("… (truncated at %d bytes)", s.catMax) + colorReset
+	}
+	return text, nil
+}
 
-		// Parse options
-		var recursive bool
-		var ignoreCase bool
-		var showLineNumbers bool
-		var pattern string
-		var files []string
+// diffContext is the number of unchanged lines shown around each change
+const diffContext = 3
+
+// maxDiffCells bounds the LCS table, which needs one cell for every pair
+// of lines between the first and last difference of the two texts
+const maxDiffCells = 1 << 21
+
+// errDiffTooLarge is returned when the texts differ in too many lines to
+// compare within maxDiffCells
+var errDiffTooLarge = &codedError{codeTooLarge, "files differ in too many lines to compare"}
+
+// unifiedDiff compares two texts line by line using a longest common
+// subsequence table and renders the result as a colored unified diff.
+// Identical inputs produce an empty string, like diff(1).
+func unifiedDiff(nameA, nameB, textA, textB string) (string, error) {
+	a := strings.Split(strings.TrimSuffix(textA, "\n"), "\n")
+	b := strings.Split(strings.TrimSuffix(textB, "\n"), "\n")
+	if textA == "" {
+		a = nil
+	}
+	if textB == "" {
+		b = nil
+	}
 
-		// Parse arguments
-		i := 0
-		for i < len(argv) {
-			arg := argv[i]
-			if strings.HasPrefix(arg, "-") {
-				if strings.Contains(arg, "r") {
-					recursive = true
-				}
-				if strings.Contains(arg, "i") {
-					ignoreCase = true
-				}
-				if strings.Contains(arg, "n") {
-					showLineNumbers = true
-				}
+	// only the lines between the common prefix and suffix need the table
+	pre := 0
+	for pre < len(a) && pre < len(b) && a[pre] == b[pre] {
+		pre++
+	}
+	suf := 0
+	for suf < len(a)-pre && suf < len(b)-pre && a[len(a)-1-suf] == b[len(b)-1-suf] {
+		suf++
+	}
+	midA, midB := a[pre:len(a)-suf], b[pre:len(b)-suf]
+	if (len(midA)+1)*(len(midB)+1) > maxDiffCells {
+		return "", errDiffTooLarge
+	}
+
+	// lcs[i*width+j] is the LCS length of midA[i:] and midB[j:]
+	width := len(midB) + 1
+	lcs := make([]int32, (len(midA)+1)*width)
+	for i := len(midA) - 1; i >= 0; i-- {
+		for j := len(midB) - 1; j >= 0; j-- {
+			if midA[i] == midB[j] {
+				lcs[i*width+j] = lcs[(i+1)*width+j+1] + 1
 			} else {
-				if pattern == "" {
-					pattern = arg
-				} else {
-					files = append(files, arg)
-				}
+				lcs[i*width+j] = max(lcs[(i+1)*width+j], lcs[i*width+j+1])
 			}
-			i++
 		}
+	}
 
-		if pattern == "" {
-			_ = json.NewEncoder(w).Encode(execResp{Output: "grep: missing pattern"})
-			return
+	// edit script: ' ' keep, '-' delete from a, '+' insert from b
+	type edit struct {
+		op   byte
+		line string
+		ai   int // lines of a consumed before this edit
+		bi   int // lines of b consumed before this edit
+	}
+	var edits []edit
+	for k := 0; k < pre; k++ {
+		edits = append(edits, edit{' ', a[k], k, k})
+	}
+	i, j := 0, 0
+	for i < len(midA) || j < len(midB) {
+		switch {
+		case i < len(midA) && j < len(midB) && midA[i] == midB[j]:
+			edits = append(edits, edit{' ', midA[i], pre + i, pre + j})
+			i++
+			j++
+		case j < len(midB) && (i == len(midA) || lcs[i*width+j+1] > lcs[(i+1)*width+j]):
+			edits = append(edits, edit{'+', midB[j], pre + i, pre + j})
+			j++
+		default:
+			edits = append(edits, edit{'-', midA[i], pre + i, pre + j})
+			i++
 		}
+	}
+	for k := 0; k < suf; k++ {
+		ai, bi := len(a)-suf+k, len(b)-suf+k
+		edits = append(edits, edit{' ', a[ai], ai, bi})
+	}
 
-		// If no files specified and recursive, search current directory
-		if len(files) == 0 {
-			if recursive {
-				files = []string{"."}
-			} else {
-				_ = json.NewEncoder(w).Encode(execResp{Output: "grep: no files specified"})
-				return
-			}
+	var out strings.Builder
+	for k := 0; k < len(edits); {
+		if edits[k].op == ' ' {
+			k++
+			continue
 		}
-
-		var results []string
-		for _, file := range files {
-			vp := joinVirtual(sess.cwd, file)
-			rp, err := s.realFromVirtual(vp)
-			if err != nil {
-				results = append(results, fmt.Sprintf("grep: %s: permission denied", file))
+		// grow the hunk while changes are within 2*diffContext of each other
+		start := max(0, k-diffContext)
+		end := k
+		for end < len(edits) {
+			if edits[end].op != ' ' {
+				end++
 				continue
 			}
-
-			info, err := os.Stat(rp)
-			if err != nil {
-				results = append(results, fmt.Sprintf("grep: %s: no such file or directory", file))
-				continue
+			run := end
+			for run < len(edits) && edits[run].op == ' ' {
+				run++
 			}
-
-			if info.IsDir() {
-				if recursive {
-					err := s.grepInDirectory(rp, vp, pattern, ignoreCase, showLineNumbers, &results)
-					if err != nil {
-						results = append(results, fmt.Sprintf("grep: %s: %v", file, err))
-					}
-				} else {
-					results = append(results, fmt.Sprintf("grep: %s: is a directory", file))
-				}
-			} else {
-				err := s.grepInFile(rp, vp, pattern, ignoreCase, showLineNumbers, len(files) > 1, &results)
-				if err != nil {
-					results = append(results, fmt.Sprintf("grep: %s: %v", file, err))
-				}
+			if run == len(edits) || run-end > 2*diffContext {
+				break
 			}
+			end = run
 		}
+		end = min(len(edits), end+diffContext)
 
-		if len(results) == 0 {
-			_ = json.NewEncoder(w).Encode(execResp{Output: "grep: no matches found"})
-			return
+		if out.Len() == 0 {
+			fmt.Fprintf(&out, "%s--- %s\n+++ %s%s\n", colorBold, nameA, nameB, colorReset)
+		}
+		var countA, countB int
+		for _, e := range edits[start:end] {
+			if e.op != '+' {
+				countA++
+			}
+			if e.op != '-' {
+				countB++
+			}
 		}
+		startA, startB := edits[start].ai+1, edits[start].bi+1
+		if countA == 0 {
+			startA--
+		}
+		if countB == 0 {
+			startB--
+		}
+		fmt.Fprintf(&out, "%s@@ -%d,%d +%d,%d @@%s\n", colorCyan, startA, countA, startB, countB, colorReset)
+		for _, e := range edits[start:end] {
+			switch e.op {
+			case '-':
+				fmt.Fprintf(&out, "%s-%s%s\n", colorRed, e.line, colorReset)
+			case '+':
+				fmt.Fprintf(&out, "%s+%s%s\n", colorGreen, e.line, colorReset)
+			default:
+				fmt.Fprintf(&out, " %s\n", e.line)
+			}
+		}
+		k = end
+	}
+	return strings.TrimSuffix(out.String(), "\n"), nil
+}
 
-		_ = json.NewEncoder(w).Encode(execResp{Output: strings.Join(results, "\n")})
-		return
+// parallelHashMin is the file size from which each digest is computed on
+// its own core; below it a single pass through io.MultiWriter is cheaper
+const parallelHashMin = 64 << 20
+
+// fileChecksums computes the hex MD5 and SHA256 digests of a file. Files
+// larger than maxSize (when positive) are refused, and hashing stops early
+// once ctx is done.
+func fileChecksums(ctx context.Context, rp string, maxSize int64) (string, string, error) {
+	md5Hash := md5.New()
+	sha256Hash := sha256.New()
+	if err := hashFile(ctx, rp, maxSize, []hash.Hash{md5Hash, sha256Hash}); err != nil {
+		return "", "", err
+	}
+	return hex.EncodeToString(md5Hash.Sum(nil)), hex.EncodeToString(sha256Hash.Sum(nil)), nil
+}
 
-	case "sum", "checksum":
-		if len(argv) < 1 {
-			_ = json.NewEncoder(w).Encode(execResp{Output: "sum: missing file operand"})
-			return
-		}
+// sumAlgorithms are the digests sum -a can produce, named like the
+// coreutils tools whose manifests they match (sha256sum and friends)
+var sumAlgorithms = map[string]func() hash.Hash{
+	"md5":    md5.New,
+	"sha1":   sha1.New,
+	"sha256": sha256.New,
+	"sha512": sha512.New,
+}
 
-		vp := joinVirtual(sess.cwd, argv[0])
-		rp, err := s.realFromVirtual(vp)
-		if err != nil {
-			_ = json.NewEncoder(w).Encode(execResp{Output: "sum: permission denied"})
-			return
-		}
+// fileDigest computes one hex digest of a file, with fileChecksums' limits
+func fileDigest(ctx context.Context, rp string, maxSize int64, newHash func() hash.Hash) (string, error) {
+	h := newHash()
+	if err := hashFile(ctx, rp, maxSize, []hash.Hash{h}); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
 
-		info, err := os.Stat(rp)
+// hashFile feeds the contents of rp to every hash, refusing files larger
+// than maxSize (when positive)
+func hashFile(ctx context.Context, rp string, maxSize int64, hashes []hash.Hash) error {
+	f, err := os.Open(rp)
+	if err != nil {
+		return errors.New("cannot open file")
+	}
+	defer func() { _ = f.Close() }()
+	info, err := f.Stat()
+	if err != nil {
+		return errors.New("cannot open file")
+	}
+	if maxSize > 0 && info.Size() > maxSize {
+		return &codedError{codeTooLarge, fmt.Sprintf("file too large (%s > limit %s)", formatHumanSize(info.Size()), formatHumanSize(maxSize))}
+	}
+
+	if info.Size() >= parallelHashMin && runtime.GOMAXPROCS(0) > 1 && len(hashes) > 1 {
+		err = hashParallel(ctx, f, info.Size(), hashes)
+	} else {
+		err = hashSequential(ctxReader{ctx, f}, hashes)
+	}
+	if err != nil {
+		return errors.New("error reading file")
+	}
+	return nil
+}
+
+// sumManifest walks realDir and emits a sha256sum-style line for every
+// file a visitor can see, as "DIGEST  path/relative/to/dir", in walk order
+// so the output can be streamed while large trees are still being hashed.
+// Hidden, ignored and restricted entries are left out, as in directory
+// downloads; files that cannot be hashed are reported in place. -maxsum
+// bounds the whole manifest too: the walk stops before the files hashed
+// would add up to more.
+func (s *server) sumManifest(ctx context.Context, realDir string, newHash func() hash.Hash, emit func(string)) error {
+	var total int64
+	overLimit := false
+	err := s.walk(realDir, func(p string, info os.FileInfo, err error) error {
+		if ctx.Err() != nil {
+			return filepath.SkipAll
+		}
+		if err != nil || p == realDir {
+			return nil
+		}
+		if strings.HasPrefix(info.Name(), ".") || s.shouldIgnore(p, info.Name()) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(realDir, p)
 		if err != nil {
-			_ = json.NewEncoder(w).Encode(execResp{Output: "sum: no such file or directory"})
-			return
+			return nil
 		}
-
-		if info.IsDir() {
-			_ = json.NewEncoder(w).Encode(execResp{Output: "sum: is a directory"})
-			return
+		rel = filepath.ToSlash(rel)
+		if s.maxSum > 0 && info.Size() <= s.maxSum {
+			if total+info.Size() > s.maxSum {
+				overLimit = true
+				return filepath.SkipAll
+			}
+			total += info.Size()
 		}
-
-		// Open file and compute hashes
-		f, err := os.Open(rp)
+		digest, err := fileDigest(ctx, p, s.maxSum, newHash)
 		if err != nil {
-			_ = json.NewEncoder(w).Encode(execResp{Output: "sum: cannot open file"})
-			return
+			if ctx.Err() == nil {
+				emit(fmt.Sprintf("%ssum: %s: %v%s", colorRed, rel, err, colorReset))
+			}
+			return nil
 		}
-		defer func() { _ = f.Close() }()
+		emit(digest + "  " + rel)
+		return nil
+	})
+	if err == nil && overLimit {
+		err = &codedError{codeTooLarge, fmt.Sprintf("stopped at the %s limit, manifest is partial", formatHumanSize(s.maxSum))}
+	}
+	return err
+}
+
+// hashSequential feeds r to every hash in one pass
+func hashSequential(r io.Reader, hashes []hash.Hash) error {
+	writers := make([]io.Writer, len(hashes))
+	for i, h := range hashes {
+		writers[i] = h
+	}
+	_, err := io.Copy(io.MultiWriter(writers...), r)
+	return err
+}
 
-		md5Hash := md5.New()
-		sha256Hash := sha256.New()
+// hashParallel gives every hash its own goroutine reading f with pread, so
+// the digests no longer wait on each other. The readers advance at about
+// the same pace, so all but the slowest are served from the page cache.
+func hashParallel(ctx context.Context, f *os.File, size int64, hashes []hash.Hash) error {
+	errs := make([]error, len(hashes))
+	var wg sync.WaitGroup
+	for i, h := range hashes {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, errs[i] = io.Copy(h, ctxReader{ctx, io.NewSectionReader(f, 0, size)})
+		}()
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
 
-		// Use MultiWriter to compute both hashes in one pass
-		writer := io.MultiWriter(md5Hash, sha256Hash)
-		if _, err := io.Copy(writer, f); err != nil {
-			_ = json.NewEncoder(w).Encode(execResp{Output: "sum: error reading file"})
-			return
-		}
+// ctxReader fails reads once ctx is done, so a client that goes away does
+// not leave the server working through a huge file for nobody
+type ctxReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (c ctxReader) Read(p []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return c.r.Read(p)
+}
 
-		md5Sum := hex.EncodeToString(md5Hash.Sum(nil))
-		sha256Sum := hex.EncodeToString(sha256Hash.Sum(nil))
+// lineStreamer is implemented by transports that can deliver output while
+// a command is still running
+type lineStreamer interface {
+	streamLine(line string)
+}
 
-		// Log the checksum command
-		s.logCommand(cmd, vp, getClientIP(r))
+// lineOutput gathers the output lines of commands like grep and find. On
+// a streaming transport each line is sent right away instead of being
+// held for the final response.
+type lineOutput struct {
+	lines    []string
+	count    int
+	streamer lineStreamer
+}
 
-		output := fmt.Sprintf("MD5:    %s\nSHA256: %s", md5Sum, sha256Sum)
-		_ = json.NewEncoder(w).Encode(execResp{Output: output})
+func newLineOutput(w io.Writer) *lineOutput {
+	streamer, _ := w.(lineStreamer)
+	return &lineOutput{streamer: streamer}
+}
+
+func (o *lineOutput) add(line string) {
+	o.count++
+	if o.streamer != nil {
+		o.streamer.streamLine(line)
 		return
 	}
+	o.lines = append(o.lines, line)
+}
 
-	_ = json.NewEncoder(w).Encode(execResp{Output: fmt.Sprintf("sh: %s: command not found", cmd)})
+// String returns the lines not yet streamed, for the final response
+func (o *lineOutput) String() string {
+	return strings.Join(o.lines, "\n")
 }
 
-// findFiles recursively searches for files and directories matching the given pattern
-func (s *server) findFiles(realPath, virtualPath, pattern, typeFilter string, results *[]string) error {
+// findFiles recursively searches for files and directories matching the
+// given pattern, handing each match to emit as soon as it is found
+func (s *server) findFiles(realPath, virtualPath, pattern, typeFilter string, natural bool, guard *walkGuard, emit func(string)) error {
+	if !guard.enter(realPath) {
+		return nil
+	}
 	entries, err := os.ReadDir(realPath)
 	if err != nil {
 		return err
 	}
+	if natural {
+		sort.Slice(entries, func(i, j int) bool { return naturalLess(entries[i].Name(), entries[j].Name()) })
+	}
 
 	for _, entry := range entries {
+		if guard.done() {
+			return nil
+		}
 		name := entry.Name()
 
 		// Skip hidden files unless pattern starts with dot
@@ -1865,7 +6714,7 @@ func (s *server) findFiles(realPath, virtualPath, pattern, typeFilter string, re
 			continue // Invalid pattern, skip this entry
 		}
 
-		isDir := entry.IsDir()
+		isDir := s.entryIsDir(realPath, entry)
 
 		// Apply type filter and add to results if matched
 		if matched {
@@ -1884,16 +6733,16 @@ func (s *server) findFiles(realPath, virtualPath, pattern, typeFilter string, re
 				info, err := entry.Info()
 				if err == nil {
 					colorizedName := colorizeName(info, virtualEntryPath)
-					*results = append(*results, colorizedName)
+					emit(colorizedName)
 				} else {
-					*results = append(*results, virtualEntryPath)
+					emit(virtualEntryPath)
 				}
 			}
 		}
 
 		// Recursively search subdirectories
-		if isDir {
-			err := s.findFiles(realEntryPath, virtualEntryPath, pattern, typeFilter, results)
+		if isDir && !s.guarded(realEntryPath) {
+			err := s.findFiles(realEntryPath, virtualEntryPath, pattern, typeFilter, natural, guard, emit)
 			if err != nil {
 				// Continue searching other directories even if one fails
 				continue
@@ -1904,8 +6753,38 @@ func (s *server) findFiles(realPath, virtualPath, pattern, typeFilter string, re
 	return nil
 }
 
+// grep --binary-files types, as in GNU grep. Binary files are skipped
+// unless asked for: binaryMatch only says whether one matches, binaryText
+// (grep -a) prints its matching lines like any text file.
+const (
+	binaryWithoutMatch = "without-match"
+	binaryMatch        = "binary"
+	binaryText         = "text"
+)
+
+// grepLimit bounds the matches one grep reports: perFile in each file
+// (grep -m) and max across the whole search (-grepresults); 0 means no
+// limit. truncated records that matches were left out because of max.
+type grepLimit struct {
+	perFile   int
+	max       int
+	matches   int
+	truncated bool
+}
+
+// take accounts for one more matching line, reporting false once the
+// overall cap has been reached
+func (l *grepLimit) take() bool {
+	if l.max > 0 && l.matches >= l.max {
+		l.truncated = true
+		return false
+	}
+	l.matches++
+	return true
+}
+
 // grepInFile searches for a pattern within a single file
-func (s *server) grepInFile(realPath, virtualPath, pattern string, ignoreCase, showLineNumbers, showFilename bool, results *[]string) error {
+func (s *server) grepInFile(ctx context.Context, realPath, virtualPath, pattern string, ignoreCase, showLineNumbers, showFilename bool, binaryFiles string, limit *grepLimit, out *lineOutput) error {
 	file, err := os.Open(realPath)
 	if err != nil {
 		return err
@@ -1918,15 +6797,11 @@ func (s *server) grepInFile(realPath, virtualPath, pattern string, ignoreCase, s
 		return err
 	}
 
-	// Skip very large files to avoid memory issues
-	if info.Size() > 10*1024*1024 { // 10MB limit
-		return fmt.Errorf("file too large")
-	}
-
 	// Read a sample to check if it's text
 	sample := make([]byte, 4096)
 	n, _ := file.Read(sample)
-	if !looksText(sample[:n]) {
+	binary := !looksText(sample[:n])
+	if binary && binaryFiles == binaryWithoutMatch {
 		return nil // Skip binary files silently
 	}
 
@@ -1936,13 +6811,22 @@ func (s *server) grepInFile(realPath, virtualPath, pattern string, ignoreCase, s
 		return err
 	}
 
-	scanner := bufio.NewScanner(file)
+	// Lines are scanned one at a time, so memory stays bounded whatever the
+	// size; past grepMax only the head of the file is searched
+	var src io.Reader = file
+	partial := s.grepMax > 0 && info.Size() > s.grepMax
+	if partial {
+		src = io.LimitReader(file, s.grepMax)
+	}
+	src = ctxReader{ctx, src} // stop mid-file on timeout or disconnect
+	scanner := newLineScanner(src)
 	lineNum := 1
 	searchPattern := pattern
 	if ignoreCase {
 		searchPattern = strings.ToLower(pattern)
 	}
 
+	matches := 0
 	for scanner.Scan() {
 		line := scanner.Text()
 		searchLine := line
@@ -1951,11 +6835,21 @@ func (s *server) grepInFile(realPath, virtualPath, pattern string, ignoreCase, s
 		}
 
 		if strings.Contains(searchLine, searchPattern) {
+			if !limit.take() {
+				return nil
+			}
+			if binary && binaryFiles == binaryMatch {
+				// one notice instead of raw binary "lines"
+				out.add(fmt.Sprintf("Binary file %s matches", virtualPath))
+				return nil
+			}
+			matches++
 			var result strings.Builder
 
-			// Add filename if multiple files or recursive search
+			// Add filename if multiple files or recursive search, in the
+			// color ls would give it
 			if showFilename {
-				result.WriteString(colorCyan)
+				result.WriteString(getFileColor(info, path.Base(virtualPath)))
 				result.WriteString(virtualPath)
 				result.WriteString(colorReset)
 				result.WriteString(":")
@@ -1990,22 +6884,40 @@ func (s *server) grepInFile(realPath, virtualPath, pattern string, ignoreCase, s
 				result.WriteString(highlighted)
 			}
 
-			*results = append(*results, result.String())
+			out.add(result.String())
+			if matches == limit.perFile {
+				return nil // -m: the rest of the file isn't searched
+			}
 		}
 		lineNum++
 	}
 
-	return scanner.Err()
+	if err := scanner.Err(); err != nil {
+		if ctx.Err() != nil {
+			return nil // timed out or cancelled; grep says so once at the end
+		}
+		return err
+	}
+	if partial {
+		out.add(fmt.Sprintf("grep: %s: only the first %s searched", virtualPath, formatHumanSize(s.grepMax)))
+	}
+	return nil
 }
 
 // grepInDirectory recursively searches for a pattern in all text files within a directory
-func (s *server) grepInDirectory(realPath, virtualPath, pattern string, ignoreCase, showLineNumbers bool, results *[]string) error {
+func (s *server) grepInDirectory(realPath, virtualPath, pattern string, ignoreCase, showLineNumbers bool, binaryFiles string, limit *grepLimit, guard *walkGuard, out *lineOutput) error {
+	if !guard.enter(realPath) {
+		return nil
+	}
 	entries, err := os.ReadDir(realPath)
 	if err != nil {
 		return err
 	}
 
 	for _, entry := range entries {
+		if limit.truncated || guard.done() {
+			return nil
+		}
 		name := entry.Name()
 
 		// Skip hidden files and directories
@@ -2021,16 +6933,19 @@ func (s *server) grepInDirectory(realPath, virtualPath, pattern string, ignoreCa
 			continue
 		}
 
-		if entry.IsDir() {
+		if s.entryIsDir(realPath, entry) {
+			if s.guarded(realEntryPath) {
+				continue
+			}
 			// Recursively search subdirectories
-			err := s.grepInDirectory(realEntryPath, virtualEntryPath, pattern, ignoreCase, showLineNumbers, results)
+			err := s.grepInDirectory(realEntryPath, virtualEntryPath, pattern, ignoreCase, showLineNumbers, binaryFiles, limit, guard, out)
 			if err != nil {
 				// Continue searching other directories even if one fails
 				continue
 			}
 		} else {
 			// Search in file
-			err := s.grepInFile(realEntryPath, virtualEntryPath, pattern, ignoreCase, showLineNumbers, true, results)
+			err := s.grepInFile(guard.ctx, realEntryPath, virtualEntryPath, pattern, ignoreCase, showLineNumbers, true, binaryFiles, limit, out)
 			if err != nil {
 				// Continue searching other files even if one fails
 				continue
@@ -2041,17 +6956,176 @@ func (s *server) grepInDirectory(realPath, virtualPath, pattern string, ignoreCa
 	return nil
 }
 
+// isWildcard reports whether arg should be expanded as a wildcard: it
+// has *, ? or [ and does not name an existing path as written, so files
+// like "a[1]" stay reachable the way they were before globbing
+func (s *server) isWildcard(cwd, arg string) bool {
+	if !strings.ContainsAny(arg, "*?[") {
+		return false
+	}
+	rp, err := s.realFromVirtual(joinVirtual(cwd, arg))
+	if err != nil {
+		return true
+	}
+	_, err = os.Stat(rp)
+	return err != nil
+}
+
+// globVirtual expands a wildcard in the last component of pattern,
+// relative to cwd, into the sorted virtual paths of matching entries.
+// Hidden entries only match patterns that start with a dot, and ignored
+// entries never match.
+func (s *server) globVirtual(cwd, pattern string) ([]string, error) {
+	dir, filePattern := path.Split(pattern)
+	vDir := joinVirtual(cwd, dir)
+	rDir, err := s.realFromVirtual(vDir)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(rDir)
+	if err != nil {
+		return nil, err
+	}
+
+	var matches []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if strings.HasPrefix(name, ".") && !strings.HasPrefix(filePattern, ".") {
+			continue
+		}
+		matched, err := filepath.Match(filePattern, name)
+		if err != nil {
+			return nil, err
+		}
+		if !matched || s.shouldIgnore(filepath.Join(rDir, name), name) {
+			continue
+		}
+		matches = append(matches, path.Join(vDir, name))
+	}
+	return matches, nil
+}
+
 // fileInfo holds information about a file for zip archive creation
 type fileInfo struct {
 	virtualPath  string
 	realPath     string
 	relativePath string
+	isDir        bool // directory entry, kept so empty folders survive zipping
+}
+
+// regularFiles drops the directory entries from files
+func regularFiles(files []fileInfo) []fileInfo {
+	var out []fileInfo
+	for _, f := range files {
+		if !f.isDir {
+			out = append(out, f)
+		}
+	}
+	return out
+}
+
+// maxBraceWords caps how many patterns a brace expansion may produce;
+// every group multiplies the count, so a short argument could otherwise
+// expand to millions
+const maxBraceWords = 1024
+
+// errTooManyBraces is returned when an expansion passes maxBraceWords
+var errTooManyBraces = &codedError{codeTooLarge, fmt.Sprintf("brace expansion yields more than %d patterns", maxBraceWords)}
+
+// expandBraces expands shell-style {a,b} alternatives, including nested
+// and repeated groups, into the patterns they stand for. Groups without a
+// top-level comma and unbalanced braces are kept literally.
+func expandBraces(pattern string) ([]string, error) {
+	return expandBracesMax(pattern, maxBraceWords)
+}
+
+// hasBraces reports whether pattern expands to more than one alternative,
+// without expanding past the second
+func hasBraces(pattern string) bool {
+	_, err := expandBracesMax(pattern, 1)
+	return err != nil
+}
+
+// expandBracesMax is expandBraces stopping with errTooManyBraces as soon
+// as more than limit patterns would come out
+func expandBracesMax(pattern string, limit int) ([]string, error) {
+	for open := strings.IndexByte(pattern, '{'); open >= 0; {
+		depth, closing := 0, -1
+		var commas []int
+		for i := open; i < len(pattern) && closing < 0; i++ {
+			switch pattern[i] {
+			case '{':
+				depth++
+			case '}':
+				depth--
+				if depth == 0 {
+					closing = i
+				}
+			case ',':
+				if depth == 1 {
+					commas = append(commas, i)
+				}
+			}
+		}
+		if closing < 0 {
+			break
+		}
+		if len(commas) == 0 {
+			// literal braces: look for a group further on
+			next := strings.IndexByte(pattern[open+1:], '{')
+			if next < 0 {
+				break
+			}
+			open += next + 1
+			continue
+		}
+
+		var out []string
+		start := open + 1
+		for _, end := range append(commas, closing) {
+			if len(out) >= limit {
+				return nil, errTooManyBraces
+			}
+			// re-expand so nested groups and later groups are handled too
+			alts, err := expandBracesMax(pattern[:open]+pattern[start:end]+pattern[closing+1:], limit-len(out))
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, alts...)
+			start = end + 1
+		}
+		return out, nil
+	}
+	return []string{pattern}, nil
 }
 
 // collectFilesForDownload collects files matching a pattern for download
 func (s *server) collectFilesForDownload(cwd, pattern string) ([]fileInfo, error) {
 	var files []fileInfo
 
+	// Brace expansion: collect every alternative, skipping ones that
+	// match nothing and files picked up twice
+	alts, err := expandBraces(pattern)
+	if err != nil {
+		return nil, err
+	}
+	if len(alts) > 1 {
+		seen := make(map[string]bool)
+		for _, alt := range alts {
+			matched, err := s.collectFilesForDownload(cwd, alt)
+			if err != nil {
+				continue
+			}
+			for _, f := range matched {
+				if !seen[f.realPath] {
+					seen[f.realPath] = true
+					files = append(files, f)
+				}
+			}
+		}
+		return files, nil
+	}
+
 	// Handle special case for current directory
 	if pattern == "." {
 		realCwd, err := s.realFromVirtual(cwd)
@@ -2061,6 +7135,11 @@ func (s *server) collectFilesForDownload(cwd, pattern string) ([]fileInfo, error
 		return s.collectFilesFromDirectory(cwd, realCwd)
 	}
 
+	// ** matches any number of directories
+	if strings.Contains(pattern, "**") {
+		return s.collectFilesRecursive(cwd, pattern)
+	}
+
 	// Handle wildcard patterns
 	if strings.ContainsAny(pattern, "*?[") {
 		realCwd, err := s.realFromVirtual(cwd)
@@ -2086,7 +7165,7 @@ func (s *server) collectFilesForDownload(cwd, pattern string) ([]fileInfo, error
 			}
 
 			for _, entry := range entries {
-				if entry.IsDir() {
+				if s.entryIsDir(rDir, entry) {
 					continue
 				}
 
@@ -2114,7 +7193,7 @@ func (s *server) collectFilesForDownload(cwd, pattern string) ([]fileInfo, error
 			}
 
 			for _, entry := range entries {
-				if entry.IsDir() {
+				if s.entryIsDir(realCwd, entry) {
 					continue
 				}
 
@@ -2165,17 +7244,161 @@ func (s *server) collectFilesForDownload(cwd, pattern string) ([]fileInfo, error
 	return files, nil
 }
 
+// matchPathSegments reports whether the slash-separated segments of name
+// match those of pattern, where a "**" segment matches zero or more
+// directories and other segments follow path.Match
+func matchPathSegments(pattern, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(name); i++ {
+				if matchPathSegments(pattern[1:], name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(name) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], name[0]); !ok {
+			return false
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+	return len(name) == 0
+}
+
+// collectFilesRecursive collects files matching a pattern containing **,
+// walking the subtree below the pattern's literal leading directories.
+// Hidden and ignored entries are skipped, as in directory downloads.
+func (s *server) collectFilesRecursive(cwd, pattern string) ([]fileInfo, error) {
+	segs := strings.Split(pattern, "/")
+	fixed := 0
+	for fixed < len(segs) && !strings.ContainsAny(segs[fixed], "*?[") {
+		fixed++
+	}
+	base := strings.Join(segs[:fixed], "/")
+	if base == "" && strings.HasPrefix(pattern, "/") {
+		base = "/"
+	}
+	baseV := joinVirtual(cwd, base)
+	baseR, err := s.realFromVirtual(baseV)
+	if err != nil {
+		return nil, err
+	}
+	rest := segs[fixed:]
+
+	var files []fileInfo
+	err = s.walk(baseR, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return nil // Skip entries we can't access
+		}
+		if p == baseR {
+			return nil
+		}
+		name := filepath.Base(p)
+		if strings.HasPrefix(name, ".") || s.shouldIgnore(p, name) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(baseR, p)
+		if err != nil {
+			return nil
+		}
+		relSlash := filepath.ToSlash(rel)
+		if matchPathSegments(rest, strings.Split(relSlash, "/")) {
+			files = append(files, fileInfo{
+				virtualPath:  path.Join(baseV, relSlash),
+				realPath:     p,
+				relativePath: rel,
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
+// copyFile copies the contents and mode of a regular file to dst
+func (s *server) copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = in.Close() }()
+	info, err := in.Stat()
+	if err != nil {
+		return err
+	}
+	// -force replaces files, but never writes through a symlink to
+	// whatever it points at
+	if existing, err := os.Lstat(dst); err == nil && existing.Mode()&os.ModeSymlink != 0 {
+		return &codedError{code: codePerm, msg: dst + ": is a symlink"}
+	}
+	out, err := s.createFile(dst, true, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, in)
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		_ = s.remove(dst, false)
+	}
+	return err
+}
+
+// copyDirectory recursively copies the visible files of srcDir into dstDir,
+// skipping the same hidden and ignored entries as directory downloads
+func (s *server) copyDirectory(srcDir, dstDir string) error {
+	files, err := s.collectFilesFromDirectory(srcDir, srcDir)
+	if err != nil {
+		return err
+	}
+	if err := s.mkdir(dstDir, true); err != nil {
+		return err
+	}
+	for _, f := range files {
+		rel, err := filepath.Rel(srcDir, f.realPath)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dstDir, rel)
+		if f.isDir {
+			if err := s.mkdir(target, true); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := s.mkdir(filepath.Dir(target), true); err != nil {
+			return err
+		}
+		if err := s.copyFile(f.realPath, target); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // collectFilesFromDirectory recursively collects all files from a directory
 func (s *server) collectFilesFromDirectory(virtualDir, realDir string) ([]fileInfo, error) {
 	var files []fileInfo
 	baseDir := filepath.Base(realDir)
 
-	err := filepath.Walk(realDir, func(path string, info os.FileInfo, err error) error {
+	err := s.walk(realDir, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
 			return nil // Skip files we can't access
 		}
 
-		if info.IsDir() {
+		if info.IsDir() && path == realDir {
 			return nil
 		}
 
@@ -2201,49 +7424,178 @@ func (s *server) collectFilesFromDirectory(virtualDir, realDir string) ([]fileIn
 			virtualPath:  path,
 			realPath:     path,
 			relativePath: archivePath,
+			isDir:        info.IsDir(),
 		})
 
-		return nil
-	})
-	if err != nil {
-		return nil, err
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return files, nil
+}
+
+// zipEntry is a file resolved for inclusion in a zip archive
+type zipEntry struct {
+	file fileInfo
+	info os.FileInfo
+}
+
+// zipEntries stats the files to archive, skipping anything that is not a
+// regular file or a directory entry we can access
+func zipEntries(files []fileInfo) []zipEntry {
+	entries := make([]zipEntry, 0, len(files))
+	for _, file := range files {
+		info, err := os.Stat(file.realPath)
+		if err != nil || !(info.Mode().IsRegular() || file.isDir && info.IsDir()) {
+			continue
+		}
+		entries = append(entries, zipEntry{file: file, info: info})
+	}
+	// Sort by archive path so the same selection always yields the same zip
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].file.relativePath < entries[j].file.relativePath
+	})
+	return entries
+}
+
+// checkZipLimits reports, with the configured limit, when an archive of
+// entries would exceed -maxzipfiles or -maxzipbytes
+func (s *server) checkZipLimits(entries []zipEntry) error {
+	count := 0
+	var total int64
+	for _, e := range entries {
+		if !e.file.isDir {
+			count++
+			total += e.info.Size()
+		}
+	}
+	if s.maxZipFiles > 0 && count > s.maxZipFiles {
+		return &codedError{codeTooLarge, fmt.Sprintf("too many files for one archive (%d > limit %d)", count, s.maxZipFiles)}
+	}
+	if s.maxZipBytes > 0 {
+		if total > s.maxZipBytes {
+			return &codedError{codeTooLarge, fmt.Sprintf("archive too large (%s > limit %s)", formatHumanSize(total), formatHumanSize(s.maxZipBytes))}
+		}
+	}
+	return nil
+}
+
+// zipHeader builds the archive header for an entry
+func zipHeader(e zipEntry, method uint16) (*zip.FileHeader, error) {
+	header, err := zip.FileInfoHeader(e.info)
+	if err != nil {
+		return nil, err
+	}
+	// Use the relative path for the archive
+	header.Name = e.file.relativePath
+	header.Method = method
+	if e.file.isDir {
+		// a trailing slash marks a directory entry, which has no data
+		header.Name = strings.TrimSuffix(header.Name, "/") + "/"
+		header.Method = zip.Store
+	}
+	return header, nil
+}
+
+// countingWriter discards everything written to it, counting the bytes
+type countingWriter struct{ n int64 }
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	c.n += int64(len(p))
+	return len(p), nil
+}
+
+// zeroReader yields an endless stream of zero bytes
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	clear(p)
+	return len(p), nil
+}
+
+// storedZipSize computes the exact size of the uncompressed archive
+// sendZipArchive produces for entries. The layout of a stored archive depends
+// only on entry names and sizes, so it is built against a counting writer
+// with zeros standing in for file contents.
+func storedZipSize(entries []zipEntry) (int64, error) {
+	cw := &countingWriter{}
+	zw := zip.NewWriter(cw)
+	for _, e := range entries {
+		header, err := zipHeader(e, zip.Store)
+		if err != nil {
+			continue
+		}
+		writer, err := zw.CreateHeader(header)
+		if err != nil {
+			return 0, err
+		}
+		if e.file.isDir {
+			continue
+		}
+		if _, err := io.CopyN(writer, zeroReader{}, e.info.Size()); err != nil {
+			return 0, err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return 0, err
+	}
+	return cw.n, nil
+}
+
+// sendZipArchive creates and sends a zip archive containing the specified files.
+// With store set, entries are not compressed and the response carries an exact
+// Content-Length so browsers can show real download progress.
+func (s *server) sendZipArchive(ctx context.Context, w http.ResponseWriter, files []fileInfo, filename string, store bool) {
+	entries := zipEntries(files)
+	if err := s.checkZipLimits(entries); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
 	}
 
-	return files, nil
-}
+	method := zip.Deflate
+	if store {
+		method = zip.Store
+		if size, err := storedZipSize(entries); err == nil {
+			w.Header().Set("Content-Length", fmt.Sprintf("%d", size))
+		}
+	}
 
-// sendZipArchive creates and sends a zip archive containing the specified files
-func (s *server) sendZipArchive(w http.ResponseWriter, files []fileInfo, filename string) {
 	w.Header().Set("Content-Type", "application/zip")
-	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
+	w.Header().Set("Content-Disposition", contentDisposition("attachment", filename))
 
 	zipWriter := zip.NewWriter(w)
 	defer func() { _ = zipWriter.Close() }()
 
-	for _, file := range files {
+	for _, e := range entries {
+		if ctx.Err() != nil {
+			return // the client went away; don't zip for nobody
+		}
+		if e.file.isDir {
+			if header, err := zipHeader(e, zip.Store); err == nil {
+				_, _ = zipWriter.CreateHeader(header)
+			}
+			continue
+		}
+
 		// Open the file
-		f, err := os.Open(file.realPath)
+		f, err := os.Open(e.file.realPath)
 		if err != nil {
 			continue // Skip files we can't open
 		}
 
-		info, err := f.Stat()
-		if err != nil {
-			_ = f.Close()
-			continue
+		// Create zip file header; already-compressed formats are stored as-is
+		entryMethod := method
+		if isPrecompressed(e.file.relativePath) {
+			entryMethod = zip.Store
 		}
-
-		// Create zip file header
-		header, err := zip.FileInfoHeader(info)
+		header, err := zipHeader(e, entryMethod)
 		if err != nil {
 			_ = f.Close()
 			continue
 		}
 
-		// Use the relative path for the archive
-		header.Name = file.relativePath
-		header.Method = zip.Deflate
-
 		// Create the file in the zip
 		writer, err := zipWriter.CreateHeader(header)
 		if err != nil {
@@ -2251,8 +7603,8 @@ func (s *server) sendZipArchive(w http.ResponseWriter, files []fileInfo, filenam
 			continue
 		}
 
-		// Copy file content to zip
-		_, err = io.Copy(writer, f)
+		// Copy exactly the size we announced, even if the file grew meanwhile
+		_, err = io.CopyN(writer, ctxReader{ctx, f}, e.info.Size())
 		_ = f.Close()
 
 		if err != nil {
@@ -2262,41 +7614,51 @@ func (s *server) sendZipArchive(w http.ResponseWriter, files []fileInfo, filenam
 }
 
 // buildTree recursively builds a tree representation of the directory structure
-func (s *server) buildTree(result *strings.Builder, dirPath, prefix string, showHidden bool, maxDepth, currentDepth int) (int, int) {
+func (s *server) buildTree(result *strings.Builder, guard *walkGuard, dirPath, prefix string, showHidden, natural bool, maxDepth, currentDepth int) (int, int) {
 	if maxDepth >= 0 && currentDepth >= maxDepth {
 		return 0, 0
 	}
+	if !guard.enter(dirPath) {
+		return 0, 0
+	}
 
-	entries, err := os.ReadDir(dirPath)
+	entries, err := s.readDir(dirPath)
 	if err != nil {
 		return 0, 0
 	}
 
 	// Filter and sort entries
-	var validEntries []os.DirEntry
+	var validEntries []dirEntry
 	for _, entry := range entries {
-		name := entry.Name()
+		name := entry.name
 		if !showHidden && strings.HasPrefix(name, ".") {
 			continue
 		}
+		if s.shouldIgnore(filepath.Join(dirPath, name), name) {
+			continue
+		}
 		validEntries = append(validEntries, entry)
 	}
 
 	// Sort: directories first, then files, alphabetically within each group
 	sort.Slice(validEntries, func(i, j int) bool {
-		iDir := validEntries[i].IsDir()
-		jDir := validEntries[j].IsDir()
-		if iDir != jDir {
-			return iDir && !jDir
+		if c := dirsBefore(validEntries[i].info.IsDir(), validEntries[j].info.IsDir()); c != 0 {
+			return c < 0
+		}
+		if natural {
+			return naturalLess(validEntries[i].name, validEntries[j].name)
 		}
-		return validEntries[i].Name() < validEntries[j].Name()
+		return validEntries[i].name < validEntries[j].name
 	})
 
 	dirCount := 0
 	fileCount := 0
 
 	for i, entry := range validEntries {
-		name := entry.Name()
+		if guard.done() {
+			break
+		}
+		name := entry.name
 		isLast := i == len(validEntries)-1
 
 		// Build the tree symbols
@@ -2309,17 +7671,17 @@ func (s *server) buildTree(result *strings.Builder, dirPath, prefix string, show
 
 		// Get file info for colorization
 		fullPath := filepath.Join(dirPath, name)
-		info, err := entry.Info()
-		if err != nil {
-			continue
-		}
+		info := entry.info
 
 		// Add colorized name
 		coloredName := colorizeName(info, name)
 		result.WriteString(prefix + connector + coloredName + "\n")
 
-		if entry.IsDir() {
+		if info.IsDir() {
 			dirCount++
+			if s.guarded(fullPath) {
+				continue
+			}
 			// Recursively process subdirectories
 			var newPrefix string
 			if isLast {
@@ -2327,7 +7689,7 @@ func (s *server) buildTree(result *strings.Builder, dirPath, prefix string, show
 			} else {
 				newPrefix = prefix + "│   "
 			}
-			subDirCount, subFileCount := s.buildTree(result, fullPath, newPrefix, showHidden, maxDepth, currentDepth+1)
+			subDirCount, subFileCount := s.buildTree(result, guard, fullPath, newPrefix, showHidden, natural, maxDepth, currentDepth+1)
 			dirCount += subDirCount
 			fileCount += subFileCount
 		} else {
@@ -2349,18 +7711,19 @@ func urlEscapeVirtual(v string) string {
 
 func (s *server) handleDownload(w http.ResponseWriter, r *http.Request) {
 	sess := s.getSession(w, r)
+	store := r.URL.Query().Get("store") == "1"
 
 	// Check if it's a single file download
 	if path := r.URL.Query().Get("path"); path != "" {
 		// Single file download
 		vp := cleanVirtual(path)
-		rp, err := s.realFromVirtual(joinVirtual(sess.cwd, vp))
-		if err != nil {
+		rp, err := s.realFromVirtual(joinVirtual(sess.getCwd(), vp))
+		if err != nil || !s.accessAllowed(rp, s.accessIP(r)) {
 			http.Error(w, "permission denied", http.StatusForbidden)
 			return
 		}
 		info, err := os.Stat(rp)
-		if err != nil {
+		if err != nil || s.shouldIgnore(rp, filepath.Base(rp)) {
 			http.NotFound(w, r)
 			return
 		}
@@ -2381,7 +7744,7 @@ func (s *server) handleDownload(w http.ResponseWriter, r *http.Request) {
 			ctype = "application/octet-stream"
 		}
 		w.Header().Set("Content-Type", ctype)
-		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
+		w.Header().Set("Content-Disposition", contentDisposition("attachment", filename))
 		http.ServeContent(w, r, filename, info.ModTime(), f)
 		return
 	}
@@ -2390,7 +7753,7 @@ func (s *server) handleDownload(w http.ResponseWriter, r *http.Request) {
 	if dir := r.URL.Query().Get("dir"); dir != "" {
 		vp := cleanVirtual(dir)
 		rp, err := s.realFromVirtual(vp)
-		if err != nil {
+		if err != nil || !s.accessAllowed(rp, s.accessIP(r)) {
 			http.Error(w, "permission denied", http.StatusForbidden)
 			return
 		}
@@ -2411,7 +7774,7 @@ func (s *server) handleDownload(w http.ResponseWriter, r *http.Request) {
 		}
 
 		dirName := filepath.Base(rp)
-		s.sendZipArchive(w, files, dirName+".zip")
+		s.sendZipArchive(r.Context(), w, files, dirName+".zip", store)
 		return
 	}
 
@@ -2419,35 +7782,616 @@ func (s *server) handleDownload(w http.ResponseWriter, r *http.Request) {
 	if pattern := r.URL.Query().Get("pattern"); pattern != "" {
 		cwd := r.URL.Query().Get("cwd")
 		if cwd == "" {
-			cwd = sess.cwd
+			cwd = sess.getCwd()
+		}
+		if _, denied := s.deniedArg(cwd, []string{".", pattern}, s.accessIP(r)); denied {
+			http.Error(w, "permission denied", http.StatusForbidden)
+			return
+		}
+
+		files, err := s.collectFilesForDownload(cwd, pattern)
+		if err != nil {
+			http.Error(w, "failed to collect files", http.StatusInternalServerError)
+			return
+		}
+		files = s.accessibleFiles(files, s.accessIP(r))
+
+		if len(files) == 0 {
+			http.Error(w, "no matching files found", http.StatusNotFound)
+			return
+		}
+
+		s.sendZipArchive(r.Context(), w, files, "archive.zip", store)
+		return
+	}
+
+	http.Error(w, "missing download parameters", http.StatusBadRequest)
+}
+
+// ===== WebSocket terminal =====
+
+// wsGUID is the fixed key suffix from RFC 6455 section 1.3
+const wsGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// wsMaxMessage bounds client messages; commands are short JSON objects
+const wsMaxMessage = 64 * 1024
+
+// WebSocket frame opcodes
+const (
+	wsOpText  = 0x1
+	wsOpClose = 0x8
+	wsOpPing  = 0x9
+	wsOpPong  = 0xa
+)
+
+// wsConn is a minimal server side WebSocket connection: enough of RFC 6455
+// for the terminal (text messages, fragmentation, ping/pong and close),
+// without extensions or subprotocols
+type wsConn struct {
+	conn net.Conn
+	br   *bufio.Reader
+	mu   sync.Mutex // serializes frame writes
+}
+
+// wsMessage is what the browser sends over /api/ws: a command to run, or
+// an interrupt (Ctrl+C) for the one currently running
+type wsMessage struct {
+	execReq
+	Interrupt bool `json:"interrupt,omitempty"`
+}
+
+// upgradeWebSocket validates the handshake and takes over the connection.
+// On failure an HTTP error has already been written.
+func upgradeWebSocket(w http.ResponseWriter, r *http.Request, trustedOrigin func(string) bool) (*wsConn, error) {
+	if !strings.EqualFold(r.Header.Get("Upgrade"), "websocket") ||
+		!strings.Contains(strings.ToLower(r.Header.Get("Connection")), "upgrade") {
+		http.Error(w, "websocket upgrade required", http.StatusBadRequest)
+		return nil, errors.New("not a websocket handshake")
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		http.Error(w, "unsupported websocket version", http.StatusUpgradeRequired)
+		return nil, errors.New("unsupported websocket version")
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if key == "" {
+		http.Error(w, "missing Sec-WebSocket-Key", http.StatusBadRequest)
+		return nil, errors.New("missing websocket key")
+	}
+	// browsers send cookies on cross-site WebSocket connections, so refuse
+	// other origins or any page could drive a visitor's session
+	if origin := r.Header.Get("Origin"); origin != "" && !trustedOrigin(origin) {
+		if u, err := url.Parse(origin); err != nil || !strings.EqualFold(u.Host, r.Host) {
+			http.Error(w, "cross-origin websocket refused", http.StatusForbidden)
+			return nil, errors.New("cross-origin websocket")
+		}
+	}
+
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "websocket not supported", http.StatusInternalServerError)
+		return nil, errors.New("hijacking not supported")
+	}
+	conn, brw, err := hj.Hijack()
+	if err != nil {
+		return nil, err
+	}
+
+	sum := sha1.Sum([]byte(key + wsGUID))
+	var resp strings.Builder
+	resp.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n")
+	resp.WriteString("Sec-WebSocket-Accept: " + base64.StdEncoding.EncodeToString(sum[:]) + "\r\n")
+	// keep a session cookie issued by getSession for this request
+	for _, c := range w.Header().Values("Set-Cookie") {
+		resp.WriteString("Set-Cookie: " + c + "\r\n")
+	}
+	resp.WriteString("\r\n")
+	if _, err := conn.Write([]byte(resp.String())); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	_ = conn.SetDeadline(time.Time{}) // drop the server's header timeouts
+	return &wsConn{conn: conn, br: brw.Reader}, nil
+}
+
+// writeFrame sends one unfragmented, unmasked frame
+func (c *wsConn) writeFrame(op byte, payload []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	hdr := []byte{0x80 | op}
+	switch n := len(payload); {
+	case n < 126:
+		hdr = append(hdr, byte(n))
+	case n <= 0xffff:
+		hdr = binary.BigEndian.AppendUint16(append(hdr, 126), uint16(n))
+	default:
+		hdr = binary.BigEndian.AppendUint64(append(hdr, 127), uint64(n))
+	}
+	_ = c.conn.SetWriteDeadline(time.Now().Add(30 * time.Second))
+	_, err := c.conn.Write(append(hdr, payload...))
+	return err
+}
+
+// readMessage returns the next data message, reassembling fragments and
+// answering pings on the way. A close frame is echoed and ends the stream.
+func (c *wsConn) readMessage() ([]byte, error) {
+	var msg []byte
+	for {
+		var h [2]byte
+		if _, err := io.ReadFull(c.br, h[:]); err != nil {
+			return nil, err
+		}
+		fin, op := h[0]&0x80 != 0, h[0]&0x0f
+		if h[1]&0x80 == 0 {
+			return nil, errors.New("websocket: unmasked client frame")
+		}
+		n := uint64(h[1] & 0x7f)
+		switch n {
+		case 126:
+			var b [2]byte
+			if _, err := io.ReadFull(c.br, b[:]); err != nil {
+				return nil, err
+			}
+			n = uint64(binary.BigEndian.Uint16(b[:]))
+		case 127:
+			var b [8]byte
+			if _, err := io.ReadFull(c.br, b[:]); err != nil {
+				return nil, err
+			}
+			n = binary.BigEndian.Uint64(b[:])
+		}
+		if n > wsMaxMessage || uint64(len(msg))+n > wsMaxMessage {
+			_ = c.writeFrame(wsOpClose, []byte{0x03, 0xf1}) // 1009: message too big
+			return nil, errors.New("websocket: message too large")
+		}
+		var mask [4]byte
+		if _, err := io.ReadFull(c.br, mask[:]); err != nil {
+			return nil, err
+		}
+		payload := make([]byte, n)
+		if _, err := io.ReadFull(c.br, payload); err != nil {
+			return nil, err
+		}
+		for i := range payload {
+			payload[i] ^= mask[i%4]
+		}
+
+		switch op {
+		case wsOpPing:
+			if err := c.writeFrame(wsOpPong, payload); err != nil {
+				return nil, err
+			}
+			continue
+		case wsOpPong:
+			continue
+		case wsOpClose:
+			_ = c.writeFrame(wsOpClose, payload[:min(2, len(payload))])
+			return nil, io.EOF
+		}
+		msg = append(msg, payload...)
+		if fin {
+			return msg, nil
+		}
+	}
+}
+
+// writeJSON sends v as a text message
+func (c *wsConn) writeJSON(v any) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return c.writeFrame(wsOpText, b)
+}
+
+// wsWriter adapts a wsConn to the io.Writer runCommand expects: each JSON
+// response becomes one text message, and lineOutput can stream through it
+type wsWriter struct {
+	ws *wsConn
+}
+
+func (w wsWriter) Write(p []byte) (int, error) {
+	if err := w.ws.writeFrame(wsOpText, bytes.TrimSpace(p)); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (w wsWriter) streamLine(line string) {
+	_ = w.ws.writeJSON(execResp{Output: line, Stream: true})
+}
+
+// handleWS runs terminal commands over a WebSocket so long outputs reach
+// the browser as they are produced. Commands run one at a time; the
+// client queues input while one is running and may send an interrupt.
+func (s *server) handleWS(w http.ResponseWriter, r *http.Request) {
+	sess := s.getSession(w, r)
+	ws, err := upgradeWebSocket(w, r, func(origin string) bool { return s.corsOrigin(origin) == origin })
+	if err != nil {
+		return
+	}
+	defer func() { _ = ws.conn.Close() }()
+
+	// after hijacking, r.Context() no longer ends with the connection
+	connCtx, closeConn := context.WithCancel(context.Background())
+	defer closeConn()
+
+	var (
+		cancelCmd context.CancelFunc
+		done      chan struct{}
+	)
+	running := func() bool {
+		if done == nil {
+			return false
+		}
+		select {
+		case <-done:
+			return false
+		default:
+			return true
+		}
+	}
+	for {
+		data, err := ws.readMessage()
+		if err != nil {
+			break
+		}
+		var msg wsMessage
+		if err := json.Unmarshal(data, &msg); err != nil {
+			_ = ws.writeJSON(execResp{Output: "error: bad request"})
+			continue
+		}
+		if msg.Interrupt {
+			if running() {
+				cancelCmd()
+			}
+			continue
+		}
+		if running() {
+			_ = ws.writeJSON(execResp{Output: "error: a command is still running (Ctrl+C to stop it)"})
+			continue
+		}
+		ctx, cancel := context.WithCancel(connCtx)
+		cancelCmd, done = cancel, make(chan struct{})
+		go func(req execReq, done chan struct{}) {
+			defer close(done)
+			defer cancel()
+			// net/http recovers handler panics, but this goroutine is ours:
+			// without this a crashing command would take the server down
+			defer func() {
+				if p := recover(); p != nil {
+					logf("panic running %q over websocket: %v\n%s", req.Input, p, debug.Stack())
+					_ = ws.writeJSON(errorResp(codeIO, "error: internal error"))
+				}
+			}()
+			s.runCommand(wsWriter{ws: ws}, r.WithContext(ctx), sess, req)
+		}(msg.execReq, done)
+	}
+	closeConn()
+	if done != nil {
+		<-done
+	}
+}
+
+// handleHealthz answers liveness probes: the process is up and serving
+func (s *server) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	_, _ = io.WriteString(w, "ok\n")
+}
+
+// versionResp is the JSON answer of /api/version
+type versionResp struct {
+	Version string `json:"version"`
+	Go      string `json:"go"`
+	Built   string `json:"built,omitempty"`
+	Commit  string `json:"commit,omitempty"`
+	Root    string `json:"root,omitempty"` // basename of the served directory
+}
+
+// buildInfo gathers what the binary knows about its own build; it never
+// changes, so it is computed once
+var buildInfo = sync.OnceValue(func() versionResp {
+	info := versionResp{Version: version, Go: runtime.Version(), Built: buildDate}
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		for _, setting := range bi.Settings {
+			switch setting.Key {
+			case "vcs.time":
+				if info.Built == "" {
+					info.Built = setting.Value
+				}
+			case "vcs.revision":
+				info.Commit = setting.Value
+			}
+		}
+	}
+	return info
+})
+
+// handleVersion reports the running build, for clients and monitoring
+// that need to tell deployments apart
+func (s *server) handleVersion(w http.ResponseWriter, r *http.Request) {
+	info := buildInfo()
+	if base := s.rootName(); base != string(filepath.Separator) && base != "." {
+		info.Root = base
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-cache")
+	_ = json.NewEncoder(w).Encode(info)
+}
+
+// handleReadyz answers readiness probes: 503 while the served directory,
+// or any -mount, is unreachable, e.g. when a network mount has dropped
+func (s *server) handleReadyz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	dirs := []string{s.rootAbs}
+	for _, m := range s.mounts {
+		dirs = append(dirs, m.abs)
+	}
+	for _, dir := range dirs {
+		if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+			http.Error(w, "root directory unavailable", http.StatusServiceUnavailable)
+			return
+		}
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	_, _ = io.WriteString(w, "ok\n")
+}
+
+// handleArchive streams a single entry out of a zip or tar archive, so a
+// file can be fetched from a large bundle without downloading all of it:
+// /api/archive?path=bundle.tar.gz&entry=docs/manual.pdf
+func (s *server) handleArchive(w http.ResponseWriter, r *http.Request) {
+	sess := s.getSession(w, r)
+	q := r.URL.Query()
+	archive, entry := q.Get("path"), q.Get("entry")
+	if archive == "" || entry == "" {
+		http.Error(w, "missing path or entry parameter", http.StatusBadRequest)
+		return
+	}
+	entry, ok := cleanArchiveEntry(entry)
+	if !ok {
+		http.Error(w, "invalid entry name", http.StatusBadRequest)
+		return
+	}
+
+	rp, err := s.realFromVirtual(joinVirtual(sess.getCwd(), archive))
+	if err != nil || !s.accessAllowed(rp, s.accessIP(r)) {
+		http.Error(w, "permission denied", http.StatusForbidden)
+		return
+	}
+	info, err := os.Stat(rp)
+	if err != nil || s.shouldIgnore(rp, filepath.Base(rp)) {
+		http.NotFound(w, r)
+		return
+	}
+	if info.IsDir() {
+		http.Error(w, "is a directory", http.StatusBadRequest)
+		return
+	}
+	f, err := os.Open(rp)
+	if err != nil {
+		http.Error(w, "cannot open", http.StatusInternalServerError)
+		return
+	}
+	defer func() { _ = f.Close() }()
+
+	filename := path.Base(entry)
+	ctype := mime.TypeByExtension(path.Ext(filename))
+	if ctype == "" {
+		ctype = "application/octet-stream"
+	}
+	disposition := "attachment"
+	if q.Get("inline") == "1" {
+		disposition = "inline"
+	}
+	send := func(size int64, content io.Reader) {
+		w.Header().Set("Content-Type", withCharset(ctype))
+		w.Header().Set("Content-Disposition", contentDisposition(disposition, filename))
+		w.Header().Set("Content-Length", fmt.Sprint(size))
+		_, _ = io.Copy(w, content)
+	}
+
+	br := bufio.NewReader(f)
+	magic, _ := br.Peek(4)
+	if bytes.HasPrefix(magic, []byte("PK\x03\x04")) || bytes.HasPrefix(magic, []byte("PK\x05\x06")) {
+		zr, err := zip.NewReader(f, info.Size())
+		if err != nil {
+			http.Error(w, "not a valid zip archive", http.StatusUnprocessableEntity)
+			return
+		}
+		for _, zf := range zr.File {
+			if name, ok := cleanArchiveEntry(zf.Name); !ok || name != entry || zf.FileInfo().IsDir() {
+				continue
+			}
+			rc, err := zf.Open()
+			if err != nil {
+				http.Error(w, "unsupported or corrupt entry", http.StatusUnprocessableEntity)
+				return
+			}
+			defer func() { _ = rc.Close() }()
+			send(int64(zf.UncompressedSize64), rc)
+			return
+		}
+		http.Error(w, "entry not found in archive", http.StatusNotFound)
+		return
+	}
+
+	var tr io.Reader = br
+	switch {
+	case bytes.HasPrefix(magic, []byte("\x1f\x8b")):
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			http.Error(w, "corrupt gzip data", http.StatusUnprocessableEntity)
+			return
+		}
+		defer func() { _ = gz.Close() }()
+		tr = gz
+	case bytes.HasPrefix(magic, []byte("BZh")):
+		tr = bzip2.NewReader(br)
+	}
+	tarReader := tar.NewReader(tr)
+	for {
+		hdr, err := tarReader.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			http.Error(w, "not a valid tar archive", http.StatusUnprocessableEntity)
+			return
+		}
+		if name, ok := cleanArchiveEntry(hdr.Name); ok && name == entry && hdr.Typeflag == tar.TypeReg {
+			send(hdr.Size, tarReader)
+			return
+		}
+	}
+	http.Error(w, "entry not found in archive", http.StatusNotFound)
+}
+
+// cleanArchiveEntry normalizes an archive member name and rejects names
+// that are absolute or climb out of the archive with ".."
+func cleanArchiveEntry(name string) (string, bool) {
+	name = strings.ReplaceAll(name, "\\", "/")
+	if strings.HasPrefix(name, "/") {
+		return "", false
+	}
+	clean := path.Clean(name)
+	if clean == "." || clean == ".." || strings.HasPrefix(clean, "../") {
+		return "", false
+	}
+	return clean, true
+}
+
+// handleUpload stores multipart file uploads in the session's cwd.
+// Only available with -writable; existing files are kept unless -force.
+func (s *server) handleUpload(w http.ResponseWriter, r *http.Request) {
+	if !s.writable {
+		http.Error(w, "uploads disabled (server is read-only)", http.StatusForbidden)
+		return
+	}
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	cwd := s.getSession(w, r).getCwd()
+
+	realDir, err := s.realFromVirtual(cwd)
+	if err != nil {
+		http.Error(w, "permission denied", http.StatusForbidden)
+		return
+	}
+	if info, err := os.Stat(realDir); err != nil || !info.IsDir() {
+		http.Error(w, "no such directory", http.StatusNotFound)
+		return
+	}
+
+	mr, err := r.MultipartReader()
+	if err != nil {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+
+	var uploaded []string
+	for {
+		part, err := mr.NextPart()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		if part.FileName() == "" {
+			continue // not a file field
+		}
+
+		// Browsers may send full client paths; keep only the base name
+		name := path.Base(strings.ReplaceAll(part.FileName(), "\\", "/"))
+		if name == "." || name == "/" || strings.HasPrefix(name, ".") {
+			http.Error(w, "invalid file name", http.StatusBadRequest)
+			return
+		}
+		vp := joinVirtual(cwd, name)
+		rp, err := s.realFromVirtual(vp)
+		if err != nil || filepath.Dir(rp) != realDir {
+			http.Error(w, "permission denied", http.StatusForbidden)
+			return
+		}
+		if s.shouldIgnore(rp, name) {
+			http.Error(w, "file name is ignored", http.StatusForbidden)
+			return
 		}
 
-		files, err := s.collectFilesForDownload(cwd, pattern)
+		f, err := s.createFile(rp, s.force, 0o644)
 		if err != nil {
-			http.Error(w, "failed to collect files", http.StatusInternalServerError)
+			if errors.Is(err, os.ErrExist) {
+				http.Error(w, "file exists: "+name, http.StatusConflict)
+			} else {
+				http.Error(w, "cannot create file", http.StatusInternalServerError)
+			}
 			return
 		}
-
-		if len(files) == 0 {
-			http.Error(w, "no matching files found", http.StatusNotFound)
+		_, err = io.Copy(f, part)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			_ = s.remove(rp, false)
+			http.Error(w, "upload failed", http.StatusInternalServerError)
 			return
 		}
 
-		s.sendZipArchive(w, files, "archive.zip")
-		return
+		s.logCommand("upload", vp, getClientIP(r))
+		uploaded = append(uploaded, vp)
 	}
 
-	http.Error(w, "missing download parameters", http.StatusBadRequest)
+	if len(uploaded) == 0 {
+		http.Error(w, "no files uploaded", http.StatusBadRequest)
+		return
+	}
+	_ = json.NewEncoder(w).Encode(uploadResp{Files: uploaded})
 }
 
 func (s *server) handleComplete(w http.ResponseWriter, r *http.Request) {
 	sess := s.getSession(w, r)
 	var req completeReq
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "bad request", http.StatusBadRequest)
+	if !s.decodeBody(w, r, &req) {
+		return
+	}
+
+	if word := strings.TrimLeft(req.Line, " \t"); word != "" && !strings.ContainsAny(word, " \t") {
+		items := []completeItem{}
+		names := commandNames(s.writable)
+		for name := range s.aliases {
+			if !slices.Contains(names, name) {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if strings.HasPrefix(name, word) && (s.commandAllowed(name) || s.aliases[name] != "") {
+				items = append(items, completeItem{Name: name, Replacement: name})
+			}
+		}
+		_ = json.NewEncoder(w).Encode(completeResp{Items: items})
 		return
 	}
 
+	// Complete flags when the word under the cursor starts with a dash
+	if fields := strings.Fields(req.Line); len(fields) > 1 && !strings.HasSuffix(req.Line, " ") {
+		if word := fields[len(fields)-1]; strings.HasPrefix(word, "-") {
+			items := []completeItem{}
+			for _, flag := range commandFlags(fields[0]) {
+				if strings.HasPrefix(flag, word) {
+					items = append(items, completeItem{Name: flag, Replacement: flag})
+				}
+			}
+			_ = json.NewEncoder(w).Encode(completeResp{Items: items})
+			return
+		}
+	}
+
 	arg := req.Path
 	if arg == "" {
 		arg = ""
@@ -2466,15 +8410,15 @@ func (s *server) handleComplete(w http.ResponseWriter, r *http.Request) {
 	if strings.HasPrefix(arg, "/") {
 		baseV = cleanVirtual(dirPart)
 	} else {
-		baseV = joinVirtual(sess.cwd, dirPart)
+		baseV = joinVirtual(sess.getCwd(), dirPart)
 	}
 	baseR, err := s.realFromVirtual(baseV)
-	if err != nil {
+	if err != nil || !s.accessAllowed(baseR, s.accessIP(r)) {
 		_ = json.NewEncoder(w).Encode(completeResp{Items: nil})
 		return
 	}
 
-	ents, err := os.ReadDir(baseR)
+	ents, err := s.readDir(baseR)
 	if err != nil {
 		_ = json.NewEncoder(w).Encode(completeResp{Items: nil})
 		return
@@ -2484,26 +8428,32 @@ func (s *server) handleComplete(w http.ResponseWriter, r *http.Request) {
 	maxItems := 200
 	items := make([]completeItem, 0, 16)
 
+	// Case-folded matches are kept aside and only used when nothing
+	// matches the prefix exactly, so READ still completes readme
+	var folded []completeItem
+	foldedBase := strings.ToLower(basePart)
+
 	for _, e := range ents {
-		name := e.Name()
-		if !strings.HasPrefix(name, basePart) {
+		name := e.name
+		exact := strings.HasPrefix(name, basePart)
+		if !exact && (len(folded) >= maxItems || !strings.HasPrefix(strings.ToLower(name), foldedBase)) {
 			continue
 		}
 		if !showHidden && strings.HasPrefix(name, ".") {
 			continue
 		}
 
-		isDir := e.IsDir()
+		isDir := e.info.IsDir()
 		if req.DirsOnly && !isDir {
 			continue
 		}
+		if s.shouldIgnore(filepath.Join(baseR, name), name) {
+			continue
+		}
 
 		if req.TextOnly || req.MaxSize > 0 {
 			if !isDir {
-				info, err := e.Info()
-				if err != nil {
-					continue
-				}
+				info := e.info
 				// Use file category to check if viewable
 				cat := getFileCategory(name)
 				
@@ -2522,11 +8472,24 @@ func (s *server) handleComplete(w http.ResponseWriter, r *http.Request) {
 			}
 		}
 
+		if !exact {
+			folded = append(folded, completeItem{Name: name, Dir: isDir})
+			continue
+		}
 		items = append(items, completeItem{Name: name, Dir: isDir})
 		if len(items) >= maxItems {
 			break
 		}
 	}
+	if len(items) == 0 {
+		items = folded
+	}
+	for i := range items {
+		items[i].Replacement = dirPart + items[i].Name
+		if items[i].Dir {
+			items[i].Replacement += "/"
+		}
+	}
 
 	// Sort: directories first, then files; alphabetical within each
 	sort.Slice(items, func(i, j int) bool {
@@ -2541,6 +8504,16 @@ func (s *server) handleComplete(w http.ResponseWriter, r *http.Request) {
 
 // ===== Main =====
 
+// stringList is a flag that may be given several times
+type stringList []string
+
+func (l *stringList) String() string { return strings.Join(*l, ",") }
+
+func (l *stringList) Set(v string) error {
+	*l = append(*l, v)
+	return nil
+}
+
 func main() {
 	// Environment variable helper functions
 	getEnvOrDefault := func(key, defaultValue string) string {
@@ -2557,6 +8530,15 @@ func main() {
 		}
 		return defaultValue
 	}
+	getEnvOrDefaultBool := func(key string, defaultValue bool) bool {
+		switch strings.ToLower(os.Getenv(key)) {
+		case "1", "true", "yes", "on":
+			return true
+		case "0", "false", "no", "off":
+			return false
+		}
+		return defaultValue
+	}
 	getEnvOrDefaultInt := func(key string, defaultValue int) int {
 		if v := os.Getenv(key); v != "" {
 			var result int
@@ -2566,6 +8548,14 @@ func main() {
 		}
 		return defaultValue
 	}
+	getEnvOrDefaultDuration := func(key string, defaultValue time.Duration) time.Duration {
+		if v := os.Getenv(key); v != "" {
+			if d, err := time.ParseDuration(v); err == nil {
+				return d
+			}
+		}
+		return defaultValue
+	}
 
 	// Define flags with environment variable support (LSGET_* prefix)
 	var (
@@ -2575,9 +8565,55 @@ func main() {
 		catMax          = flag.Int64("catmax", getEnvOrDefaultInt64("LSGET_CATMAX", 4*1024), "max bytes printable via `cat` and used by completion (env: LSGET_CATMAX)")
 		pidFileFlag     = flag.String("pid", getEnvOrDefault("LSGET_PID", ""), "path to PID file (env: LSGET_PID)")
 		logfileFlag     = flag.String("logfile", getEnvOrDefault("LSGET_LOGFILE", ""), "path to log file for statistics (env: LSGET_LOGFILE)")
+		accessLogFlag   = flag.String("accesslog", getEnvOrDefault("LSGET_ACCESSLOG", ""), "path to access log, kept free of operational messages (default: same as -logfile) (env: LSGET_ACCESSLOG)")
 		baseURL         = flag.String("baseurl", getEnvOrDefault("LSGET_BASEURL", ""), "base URL for the site - full URL without trailing slash (e.g., https://files.example.com) (env: LSGET_BASEURL)")
 		sitemapInterval = flag.Int("sitemap", getEnvOrDefaultInt("LSGET_SITEMAP", 0), "generate sitemap.xml every N minutes (0 = disabled) (env: LSGET_SITEMAP)")
+		logLevelFlag    = flag.String("loglevel", getEnvOrDefault("LSGET_LOGLEVEL", "info"), "console log level: error, warn, info or debug (env: LSGET_LOGLEVEL)")
+		writable        = flag.Bool("writable", getEnvOrDefaultBool("LSGET_WRITABLE", false), "allow uploads and file management commands (env: LSGET_WRITABLE)")
+		force           = flag.Bool("force", getEnvOrDefaultBool("LSGET_FORCE", false), "with -writable, allow replacing existing files (env: LSGET_FORCE)")
+		logFormatFlag   = flag.String("logformat", getEnvOrDefault("LSGET_LOGFORMAT", logFormatCombined), "access log format: common, combined or json (env: LSGET_LOGFORMAT)")
+		maxZipFiles     = flag.Int("maxzipfiles", getEnvOrDefaultInt("LSGET_MAXZIPFILES", 0), "max files in one zip download (0 = unlimited) (env: LSGET_MAXZIPFILES)")
+		maxZipBytes     = flag.Int64("maxzipbytes", getEnvOrDefaultInt64("LSGET_MAXZIPBYTES", 0), "max total bytes in one zip download (0 = unlimited) (env: LSGET_MAXZIPBYTES)")
+		walkTimeout     = flag.Duration("walk-timeout", getEnvOrDefaultDuration("LSGET_WALK_TIMEOUT", defaultWalkTimeout), "longest time find, grep -r or tree may search before answering with partial results (0 = no limit) (env: LSGET_WALK_TIMEOUT)")
+		shutdownTimeout = flag.Duration("shutdown-timeout", getEnvOrDefaultDuration("LSGET_SHUTDOWN_TIMEOUT", 30*time.Second), "how long to let in-flight downloads finish when stopping (env: LSGET_SHUTDOWN_TIMEOUT)")
+		caseInsensitive = flag.Bool("case-insensitive", getEnvOrDefaultBool("LSGET_CASE_INSENSITIVE", runtime.GOOS == "darwin" || runtime.GOOS == "windows"), "treat paths and ignore patterns case-insensitively, as the filesystem does (env: LSGET_CASE_INSENSITIVE)")
+		useGitignore    = flag.Bool("use-gitignore", getEnvOrDefaultBool("LSGET_USE_GITIGNORE", false), "also hide files excluded by .gitignore files (env: LSGET_USE_GITIGNORE)")
+		exifGPS         = flag.Bool("exifgps", getEnvOrDefaultBool("LSGET_EXIFGPS", false), "show GPS coordinates in exif output (env: LSGET_EXIFGPS)")
+		maxSum          = flag.Int64("maxsum", getEnvOrDefaultInt64("LSGET_MAXSUM", defaultMaxSum), "max file size in bytes that sum will hash (0 = unlimited) (env: LSGET_MAXSUM)")
+		maxBody         = flag.Int64("maxbody", getEnvOrDefaultInt64("LSGET_MAXBODY", defaultMaxBody), "max bytes in an API request body (0 = unlimited) (env: LSGET_MAXBODY)")
+		grepMax         = flag.Int64("grepmax", getEnvOrDefaultInt64("LSGET_GREPMAX", defaultGrepMax), "bytes of each file searched by grep; larger files are searched partially (0 = whole file) (env: LSGET_GREPMAX)")
+		grepResults     = flag.Int("grepresults", getEnvOrDefaultInt("LSGET_GREPRESULTS", defaultGrepResults), "max matching lines one grep reports (0 = unlimited) (env: LSGET_GREPRESULTS)")
+		maxLine         = flag.Int("maxline", getEnvOrDefaultInt("LSGET_MAXLINE", maxLineBytes), "longest line in bytes that grep will search (env: LSGET_MAXLINE)")
+		aliasesFile     = flag.String("aliases", getEnvOrDefault("LSGET_ALIASES", ""), "file of command aliases, one name = command per line (env: LSGET_ALIASES)")
+		enableFlag      = flag.String("enable", getEnvOrDefault("LSGET_ENABLE", ""), "comma-separated commands to accept; all others are disabled (default: all) (env: LSGET_ENABLE)")
+		disableFlag     = flag.String("disable", getEnvOrDefault("LSGET_DISABLE", ""), "comma-separated commands to refuse (env: LSGET_DISABLE)")
+		cspFlag         = flag.String("csp", getEnvOrDefault("LSGET_CSP", defaultCSP), "Content-Security-Policy for HTML pages, or \"off\" (env: LSGET_CSP)")
+		title           = flag.String("title", getEnvOrDefault("LSGET_TITLE", ""), "browser title of the pages (default: the brand, or \"📂 lsget\") (env: LSGET_TITLE)")
+		brand           = flag.String("brand", getEnvOrDefault("LSGET_BRAND", ""), "name shown in the welcome message and error pages (default: lsget) (env: LSGET_BRAND)")
+		message         = flag.String("message", getEnvOrDefault("LSGET_MESSAGE", ""), "banner shown at the top of the terminal, e.g. a maintenance notice (env: LSGET_MESSAGE)")
+		messageFile     = flag.String("message-file", getEnvOrDefault("LSGET_MESSAGE_FILE", ""), "file holding the banner, re-read on every page load; overrides -message (env: LSGET_MESSAGE_FILE)")
+		bookmarksFlag   = flag.String("bookmarks", getEnvOrDefault("LSGET_BOOKMARKS", ""), "file to keep bookmarks in across restarts; must be outside the served directory (default: memory only) (env: LSGET_BOOKMARKS)")
+		assetsDir       = flag.String("assets", getEnvOrDefault("LSGET_ASSETS", ""), "directory of CSS, JS and images served at /assets/; custom.css and custom.js there are loaded by the terminal (env: LSGET_ASSETS)")
+		trustedProxy    = flag.String("trusted-proxy", getEnvOrDefault("LSGET_TRUSTED_PROXY", ""), "comma-separated addresses or CIDR ranges of reverse proxies whose X-Forwarded-For .lsgetaccess rules trust (env: LSGET_TRUSTED_PROXY)")
+		corsOrigin      = flag.String("cors-origin", getEnvOrDefault("LSGET_CORS_ORIGIN", ""), "comma-separated origins allowed to call /api/* from the browser, or * (default: same origin only) (env: LSGET_CORS_ORIGIN)")
+		cacheSize       = flag.Int("cache-size", getEnvOrDefaultInt("LSGET_CACHE_SIZE", 256), "number of directory listings to cache (0 = disabled) (env: LSGET_CACHE_SIZE)")
 	)
+	// Repeatable flags start from their comma-separated variable
+	getEnvList := func(key string) stringList {
+		var list stringList
+		for _, v := range strings.Split(os.Getenv(key), ",") {
+			if v = strings.TrimSpace(v); v != "" {
+				list = append(list, v)
+			}
+		}
+		return list
+	}
+	ignorePatterns := getEnvList("LSGET_IGNORE")
+	flag.Var(&ignorePatterns, "ignore", "hide files matching this .gitignore-style pattern everywhere; repeatable (env: LSGET_IGNORE, comma-separated)")
+	onlyPatterns := getEnvList("LSGET_ONLY")
+	flag.Var(&onlyPatterns, "only", "show only files matching this .gitignore-style pattern; repeatable (env: LSGET_ONLY, comma-separated)")
+	mountSpecs := getEnvList("LSGET_MOUNT")
+	flag.Var(&mountSpecs, "mount", "serve DIR as /NAME, given as NAME=DIR; repeatable, replaces -dir (env: LSGET_MOUNT, comma-separated)")
 	flag.Parse()
 
 	if *printVersion {
@@ -2602,13 +8638,129 @@ func main() {
 		fmt.Fprintf(os.Stderr, "dir is not a directory: %s\n", rootAbs)
 		exitFunc(1)
 	}
+	var mounts []mount
+	for _, spec := range mountSpecs {
+		m, err := parseMount(spec)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "invalid -mount: %v\n", err)
+			exitFunc(1)
+		}
+		mounts = append(mounts, m)
+	}
+	if len(mounts) > 0 {
+		if rootAbs, err = newMountRoot(mounts); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to set up mounts: %v\n", err)
+			exitFunc(1)
+		}
+		mountRoot = rootAbs
+	}
+
+	// The access log defaults to -logfile for backward compatibility; when it
+	// points elsewhere, -logfile receives operational messages instead
+	accessLog := *accessLogFlag
+	if accessLog == "" {
+		accessLog = *logfileFlag
+	}
+	logFile = accessLog
+	if *logfileFlag != "" && *logfileFlag != accessLog {
+		appLogFile = *logfileFlag
+	}
+
+	level, err := parseLogLevel(*logLevelFlag)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid -loglevel: %v\n", err)
+		exitFunc(1)
+	}
+	consoleLevel = level
 
-	// Set global log file path
-	if *logfileFlag != "" {
-		logFile = *logfileFlag
+	if !validLogFormat(*logFormatFlag) {
+		fmt.Fprintf(os.Stderr, "invalid -logformat %q (use common, combined or json)\n", *logFormatFlag)
+		exitFunc(1)
+	}
+	logFormat = *logFormatFlag
+	if *maxLine > 0 {
+		maxLineBytes = *maxLine
 	}
 
-	s := newServer(rootAbs, *catMax, *logfileFlag, *baseURL)
+	s := newServer(rootAbs, *catMax, accessLog, *baseURL)
+	s.mounts = mounts
+	s.writable = *writable
+	s.force = *force
+	s.maxZipFiles = *maxZipFiles
+	s.maxZipBytes = *maxZipBytes
+	s.exifGPS = *exifGPS
+	s.useGitignore = *useGitignore
+	s.caseInsensitive = *caseInsensitive
+	s.title = *title
+	s.brand = *brand
+	s.message = *message
+	s.messageFile = *messageFile
+	if *assetsDir != "" {
+		assetsAbs, absErr := filepath.Abs(*assetsDir)
+		info, statErr := os.Stat(assetsAbs)
+		if absErr != nil || statErr != nil || !info.IsDir() {
+			fmt.Fprintf(os.Stderr, "assets is not a directory: %s\n", *assetsDir)
+			exitFunc(1)
+		}
+		s.assetsAbs = assetsAbs
+	}
+	if *bookmarksFlag != "" {
+		bookmarksAbs, absErr := filepath.Abs(*bookmarksFlag)
+		if absErr != nil || s.servesPath(bookmarksAbs) {
+			fmt.Fprintf(os.Stderr, "bookmarks file must be outside the served directory: %s\n", *bookmarksFlag)
+			exitFunc(1)
+		}
+		s.bookmarksPath = bookmarksAbs
+		s.loadBookmarks()
+	}
+	if s.ignoreRules, err = parsePatterns(ignorePatterns); err != nil {
+		fmt.Fprintf(os.Stderr, "invalid -ignore: %v\n", err)
+		exitFunc(1)
+	}
+	if s.onlyRules, err = parsePatterns(onlyPatterns); err != nil {
+		fmt.Fprintf(os.Stderr, "invalid -only: %v\n", err)
+		exitFunc(1)
+	}
+	s.maxSum = *maxSum
+	s.grepMax = *grepMax
+	s.grepResults = *grepResults
+	s.walkTimeout = *walkTimeout
+	s.maxBody = *maxBody
+	if *cacheSize > 0 {
+		s.listings = newListingCache(*cacheSize)
+	}
+	for _, proxy := range strings.Split(*trustedProxy, ",") {
+		if proxy = strings.TrimSpace(proxy); proxy == "" {
+			continue
+		}
+		network, err := parseNetwork(proxy)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "invalid -trusted-proxy: %v\n", err)
+			exitFunc(1)
+		}
+		s.trustedProxies = append(s.trustedProxies, network)
+	}
+	for _, origin := range strings.Split(*corsOrigin, ",") {
+		if origin = strings.TrimSpace(origin); origin != "" {
+			s.corsOrigins = append(s.corsOrigins, origin)
+		}
+	}
+	if s.enabled, err = parseCommandList(*enableFlag); err != nil {
+		fmt.Fprintf(os.Stderr, "invalid -enable: %v\n", err)
+		exitFunc(1)
+	}
+	if s.disabled, err = parseCommandList(*disableFlag); err != nil {
+		fmt.Fprintf(os.Stderr, "invalid -disable: %v\n", err)
+		exitFunc(1)
+	}
+	if *aliasesFile != "" {
+		aliases, err := loadAliases(*aliasesFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to load aliases: %v\n", err)
+			exitFunc(1)
+		}
+		s.aliases = aliases
+	}
 
 	// Special case: if sitemap is 0 and baseURL is set, generate once and exit
 	if *sitemapInterval != 0 && *baseURL != "" {
@@ -2643,14 +8795,13 @@ func main() {
 			}
 		}
 
+		removeRuntimeFiles()
 		exitFunc(0)
 	}
 
 	// Create PID file if specified
 	if *pidFileFlag != "" {
-		pid := os.Getpid()
-		pidStr := fmt.Sprintf("%d", pid)
-		if err := os.WriteFile(*pidFileFlag, []byte(pidStr), 0o644); err != nil {
+		if err := writePIDFile(*pidFileFlag); err != nil {
 			fmt.Fprintf(os.Stderr, "failed to create PID file: %v\n", err)
 			exitFunc(1)
 		}
@@ -2665,25 +8816,52 @@ func main() {
 
 	mux := http.NewServeMux()
 	mux.HandleFunc("/api/config", s.handleConfig)
+	mux.HandleFunc("/api/version", s.handleVersion)
 	mux.HandleFunc("/api/exec", s.handleExec)
+	mux.HandleFunc("/api/ws", s.handleWS)
 	mux.HandleFunc("/api/complete", s.handleComplete)
 	mux.HandleFunc("/api/download", s.handleDownload)
+	mux.HandleFunc("/api/upload", s.handleUpload)
+	mux.HandleFunc("/api/archive", s.handleArchive)
 	mux.HandleFunc("/api/static/", s.handleStaticFile)
 	mux.HandleFunc("/sitemap.xml", s.handleSitemap)
+	mux.HandleFunc("/healthz", s.handleHealthz)
+	mux.HandleFunc("/readyz", s.handleReadyz)
 	// Vendored JavaScript dependencies
 	mux.HandleFunc("/assets/js/marked.min.js", s.handleVendoredMarked)
 	mux.HandleFunc("/assets/js/datastar.js", s.handleVendoredDatastar)
+	mux.HandleFunc("/favicon.ico", s.handleFavicon)
+	if s.assetsAbs != "" {
+		mux.HandleFunc(assetsPrefix, s.handleAssets)
+	}
 	mux.HandleFunc("/", s.handleIndex) // Catch-all route must be last
 
-	fmt.Printf("Serving %s on http://%s  (cat max = %d bytes)\n", rootAbs, *addr, *catMax)
+	if len(mounts) > 0 {
+		for _, m := range mounts {
+			logf("Mounting %s at /%s\n", m.abs, m.name)
+		}
+		logf("Serving %d mounts on http://%s  (cat max = %d bytes)\n", len(mounts), *addr, *catMax)
+	} else {
+		logf("Serving %s on http://%s  (cat max = %d bytes)\n", rootAbs, *addr, *catMax)
+	}
 	if s.logfile != "" {
-		fmt.Printf("Logging to: %s\n", s.logfile)
+		logf("Logging to: %s\n", s.logfile)
 	} else {
-		fmt.Println("Logging disabled (use -logfile or LSGET_LOGFILE to enable)")
+		logf("Logging disabled (use -logfile or LSGET_LOGFILE to enable)\n")
+	}
+	if appLogFile != "" {
+		logf("Application log: %s\n", appLogFile)
+	}
+	if s.writable {
+		logf("Writable mode enabled: uploads accepted at /api/upload\n")
+	}
+	csp := *cspFlag
+	if csp == "off" {
+		csp = ""
 	}
 	srv := &http.Server{
 		Addr:              *addr,
-		Handler:           logRequests(mux),
+		Handler:           logRequests(gzipResponses(securityHeaders(s.cors(mux), csp))),
 		ReadHeaderTimeout: 5 * time.Second,
 	}
 
@@ -2693,30 +8871,160 @@ func main() {
 
 	go func() {
 		for sig := range c {
-			fmt.Printf("\nReceived signal %s, shutting down server...\n", sig)
-			// Remove PID file if it exists
-			if pidFile != "" {
-				_ = os.Remove(pidFile)
-			}
-			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-			if err := srv.Shutdown(ctx); err != nil {
+			logf("\nReceived signal %s, shutting down server...\n", sig)
+			removeRuntimeFiles()
+			if err := shutdownServer(srv, *shutdownTimeout); err != nil {
 				fmt.Fprintf(os.Stderr, "server shutdown error: %v\n", err)
 			}
-			cancel()
 			exitFunc(0)
 		}
 	}()
 
+	// SIGHUP reloads mutable state without dropping connections
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			logf("Received SIGHUP, reloading...\n")
+			s.reload()
+		}
+	}()
+
 	if err := listenAndServe(srv); err != nil && !errors.Is(err, http.ErrServerClosed) {
 		fmt.Fprintf(os.Stderr, "server error: %v\n", err)
-		// Remove PID file on error
-		if pidFile != "" {
-			_ = os.Remove(pidFile)
-		}
+		removeRuntimeFiles()
 		exitFunc(1)
 	}
 }
 
+// writePIDFile records the process id at p for init scripts
+func writePIDFile(p string) error {
+	return os.WriteFile(p, []byte(strconv.Itoa(os.Getpid())), 0o644)
+}
+
+// removeRuntimeFiles deletes the PID file and the -mount link directory
+// on the way out
+func removeRuntimeFiles() {
+	if pidFile != "" {
+		_ = os.Remove(pidFile)
+	}
+	if mountRoot != "" {
+		_ = os.RemoveAll(mountRoot)
+	}
+}
+
+// shutdownServer stops accepting connections and waits up to timeout for
+// in-flight requests, such as large downloads, to complete. Whatever is
+// still running after that is cut off.
+func shutdownServer(srv *http.Server, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	err := srv.Shutdown(ctx)
+	if errors.Is(err, context.DeadlineExceeded) {
+		_ = srv.Close()
+		return fmt.Errorf("requests still running after %s were aborted", timeout)
+	}
+	return err
+}
+
+// accessLogEntry holds the fields of one access log line
+type accessLogEntry struct {
+	IP        string        `json:"ip"`
+	User      string        `json:"user"`
+	Time      time.Time     `json:"time"`
+	Method    string        `json:"method"`
+	URI       string        `json:"uri"`
+	Proto     string        `json:"proto"`
+	Status    int           `json:"status"`
+	Size      int           `json:"size"`
+	Referer   string        `json:"referer,omitempty"`
+	UserAgent string        `json:"user_agent,omitempty"`
+	Duration  time.Duration `json:"-"`
+}
+
+// Supported -logformat values
+const (
+	logFormatCommon   = "common"
+	logFormatCombined = "combined"
+	logFormatJSON     = "json"
+)
+
+// validLogFormat reports whether f is a supported -logformat value
+func validLogFormat(f string) bool {
+	return f == logFormatCommon || f == logFormatCombined || f == logFormatJSON
+}
+
+// escapeLogField escapes quotes, backslashes and control characters the way
+// Apache does, so an untrusted value can neither close its quoted field nor
+// start a new log line
+func escapeLogField(v string) string {
+	if !strings.ContainsFunc(v, func(r rune) bool { return r == '"' || r == '\\' || r < 0x20 || r == 0x7f }) {
+		return v
+	}
+	var b strings.Builder
+	for i := 0; i < len(v); i++ {
+		switch c := v[i]; {
+		case c == '"' || c == '\\':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case c < 0x20 || c == 0x7f:
+			fmt.Fprintf(&b, "\\x%02x", c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// formatAccessLog renders an entry as a newline-terminated log line in the
+// given format. A non-zero Duration is appended (debug console output).
+func formatAccessLog(e accessLogEntry, format string) string {
+	if format == logFormatJSON {
+		type jsonEntry struct {
+			accessLogEntry
+			DurationMS *float64 `json:"duration_ms,omitempty"`
+		}
+		je := jsonEntry{accessLogEntry: e}
+		if e.Duration > 0 {
+			ms := float64(e.Duration.Microseconds()) / 1000
+			je.DurationMS = &ms
+		}
+		b, err := json.Marshal(je)
+		if err != nil {
+			return ""
+		}
+		return string(b) + "\n"
+	}
+
+	// Common Log Format:
+	// "%h %l %u %t \"%r\" %>s %b"
+	sizeStr := "-"
+	if e.Size > 0 {
+		sizeStr = fmt.Sprintf("%d", e.Size)
+	}
+	line := fmt.Sprintf("%s - %s %s \"%s %s %s\" %d %s",
+		e.IP, escapeLogField(e.User), e.Time.Format("[02/Jan/2006:15:04:05 -0700]"),
+		escapeLogField(e.Method), escapeLogField(e.URI), escapeLogField(e.Proto), e.Status, sizeStr)
+
+	// Combined Log Format adds:
+	// "\"%{Referer}i\" \"%{User-agent}i\""
+	if format != logFormatCommon {
+		referer := e.Referer
+		if referer == "" {
+			referer = "-"
+		}
+		userAgent := e.UserAgent
+		if userAgent == "" {
+			userAgent = "-"
+		}
+		line += fmt.Sprintf(" \"%s\" \"%s\"", escapeLogField(referer), escapeLogField(userAgent))
+	}
+	if e.Duration > 0 {
+		line += " " + e.Duration.Round(time.Microsecond).String()
+	}
+	return line + "\n"
+}
+
 // responseLogger wraps a ResponseWriter to capture status code and response size
 type responseLogger struct {
 	http.ResponseWriter
@@ -2729,6 +9037,17 @@ func (rl *responseLogger) WriteHeader(code int) {
 	rl.ResponseWriter.WriteHeader(code)
 }
 
+// Hijack lets /api/ws take over the connection; the request is logged
+// as 101 Switching Protocols once the WebSocket closes
+func (rl *responseLogger) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hj, ok := rl.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("hijacking not supported")
+	}
+	rl.statusCode = http.StatusSwitchingProtocols
+	return hj.Hijack()
+}
+
 func (rl *responseLogger) Write(b []byte) (int, error) {
 	if rl.statusCode == 0 {
 		rl.statusCode = http.StatusOK
@@ -2738,50 +9057,279 @@ func (rl *responseLogger) Write(b []byte) (int, error) {
 	return size, err
 }
 
-func logRequests(next http.Handler) http.Handler {
+// corsOrigin returns the Access-Control-Allow-Origin value for a request
+// from origin, or "" when that origin may not call the API
+func (s *server) corsOrigin(origin string) string {
+	for _, allowed := range s.corsOrigins {
+		if allowed == "*" {
+			return "*"
+		}
+		if strings.EqualFold(strings.TrimSuffix(allowed, "/"), origin) {
+			return origin
+		}
+	}
+	return ""
+}
+
+// cors lets the origins listed with -cors-origin call /api/* from the
+// browser, answering preflight requests itself. Listed origins may send
+// the session cookie; with "*" any origin may call, but without cookies.
+func (s *server) cors(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// Wrap the ResponseWriter to capture status code and size
-		rl := &responseLogger{ResponseWriter: w}
+		origin := r.Header.Get("Origin")
+		if origin == "" || !strings.HasPrefix(r.URL.Path, "/api/") {
+			next.ServeHTTP(w, r)
+			return
+		}
+		h := w.Header()
+		h.Add("Vary", "Origin")
+		allowed := s.corsOrigin(origin)
+		if allowed == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		h.Set("Access-Control-Allow-Origin", allowed)
+		if allowed != "*" {
+			h.Set("Access-Control-Allow-Credentials", "true")
+		}
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			h.Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
+			if headers := r.Header.Get("Access-Control-Request-Headers"); headers != "" {
+				h.Set("Access-Control-Allow-Headers", headers)
+			}
+			h.Set("Access-Control-Max-Age", "600")
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		h.Set("Access-Control-Expose-Headers", "Content-Disposition")
+		next.ServeHTTP(w, r)
+	})
+}
 
-		next.ServeHTTP(rl, r)
+// gzipMinSize is the smallest response worth compressing, when its size
+// is known up front
+const gzipMinSize = 1024
 
-		// Get remote IP address
-		ip := getClientIP(r)
+var gzipWriters = sync.Pool{New: func() any { return gzip.NewWriter(io.Discard) }}
+
+// acceptsGzip reports whether the client listed gzip in Accept-Encoding
+// without refusing it with q=0
+func acceptsGzip(r *http.Request) bool {
+	for _, coding := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		name, params, _ := strings.Cut(coding, ";")
+		if !strings.EqualFold(strings.TrimSpace(name), "gzip") {
+			continue
+		}
+		for _, param := range strings.Split(params, ";") {
+			key, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+			if strings.EqualFold(key, "q") {
+				if q, err := strconv.ParseFloat(value, 64); err == nil && q == 0 {
+					return false
+				}
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// compressibleType reports whether a response of this content type is
+// worth gzipping; archives, images and media are compressed already
+func compressibleType(contentType string) bool {
+	mediaType, _, _ := strings.Cut(contentType, ";")
+	mediaType = strings.ToLower(strings.TrimSpace(mediaType))
+	switch {
+	case strings.HasPrefix(mediaType, "text/"):
+		return true
+	case strings.HasSuffix(mediaType, "+json"), strings.HasSuffix(mediaType, "+xml"):
+		return true
+	}
+	switch mediaType {
+	case "application/json", "application/javascript", "application/xml", "image/svg+xml":
+		return true
+	}
+	return false
+}
 
-		// Get user identifier (using "-" as we don't have user auth)
-		user := "-"
+// gzipResponses compresses text, HTML and JSON responses for clients that
+// accept gzip. Range requests and WebSocket upgrades pass through untouched.
+func gzipResponses(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodHead || r.Header.Get("Range") != "" ||
+			r.Header.Get("Upgrade") != "" || !acceptsGzip(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		gw := &gzipWriter{ResponseWriter: w}
+		defer gw.close()
+		next.ServeHTTP(gw, r)
+	})
+}
 
-		// Get timestamp in CLF format
-		timestamp := time.Now().Format("[02/Jan/2006:15:04:05 -0700]")
+// gzipWriter decides whether to compress when the body starts, once the
+// handler has settled the content type and status
+type gzipWriter struct {
+	http.ResponseWriter
+	status  int
+	started bool
+	gz      *gzip.Writer
+}
 
-		// Get request line
-		requestLine := fmt.Sprintf("%s %s %s", r.Method, r.URL.RequestURI(), r.Proto)
+func (gw *gzipWriter) WriteHeader(code int) {
+	if gw.status == 0 {
+		gw.status = code
+	}
+}
 
-		// Get status code and response size
-		statusCode := rl.statusCode
-		responseSize := rl.size
+func (gw *gzipWriter) start(body []byte) {
+	gw.started = true
+	status := gw.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	h := gw.Header()
+	contentType := h.Get("Content-Type")
+	if contentType == "" && len(body) > 0 {
+		contentType = http.DetectContentType(body)
+		h.Set("Content-Type", contentType)
+	}
+	if compressibleType(contentType) {
+		h.Add("Vary", "Accept-Encoding")
+	}
+	size, err := strconv.ParseInt(h.Get("Content-Length"), 10, 64)
+	small := err == nil && size < gzipMinSize
+	if status == http.StatusOK && len(body) > 0 && !small &&
+		h.Get("Content-Encoding") == "" && compressibleType(contentType) {
+		h.Set("Content-Encoding", "gzip")
+		h.Del("Content-Length")
+		h.Del("Accept-Ranges")
+		gw.gz = gzipWriters.Get().(*gzip.Writer)
+		gw.gz.Reset(gw.ResponseWriter)
+	}
+	gw.ResponseWriter.WriteHeader(status)
+}
 
-		// Get referer and user agent
-		referer := r.Referer()
-		if referer == "" {
-			referer = "-"
-		}
-		userAgent := r.UserAgent()
-		if userAgent == "" {
-			userAgent = "-"
-		}
+func (gw *gzipWriter) Write(b []byte) (int, error) {
+	if !gw.started {
+		gw.start(b)
+	}
+	if gw.gz != nil {
+		return gw.gz.Write(b)
+	}
+	return gw.ResponseWriter.Write(b)
+}
 
-		// Combined Log Format:
-		// "%h %l %u %t \"%r\" %>s %b \"%{Referer}i\" \"%{User-agent}i"
-		sizeStr := "-"
-		if responseSize > 0 {
-			sizeStr = fmt.Sprintf("%d", responseSize)
+func (gw *gzipWriter) close() {
+	if !gw.started {
+		if gw.status != 0 {
+			gw.ResponseWriter.WriteHeader(gw.status)
 		}
+		return
+	}
+	if gw.gz != nil {
+		_ = gw.gz.Close()
+		gw.gz.Reset(io.Discard)
+		gzipWriters.Put(gw.gz)
+		gw.gz = nil
+	}
+}
+
+// defaultCSP fits the embedded UI: its inline scripts and styles, the
+// vendored datastar, which compiles expressions with Function(), and
+// README images hosted elsewhere (badges and the like)
+const defaultCSP = "default-src 'self'; script-src 'self' 'unsafe-inline' 'unsafe-eval'; " +
+	"style-src 'self' 'unsafe-inline'; img-src 'self' data: blob: https:; connect-src 'self'; " +
+	"object-src 'none'; base-uri 'self'; frame-ancestors 'self'"
+
+// securityHeaders sets X-Content-Type-Options on every response and, on
+// HTML ones, Content-Security-Policy (unless csp is empty),
+// X-Frame-Options and Referrer-Policy
+func securityHeaders(next http.Handler, csp string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(&headerWriter{ResponseWriter: w, csp: csp}, r)
+	})
+}
+
+// headerWriter adds the security headers just before the response starts,
+// the first moment its content type is known
+type headerWriter struct {
+	http.ResponseWriter
+	csp     string
+	written bool
+}
+
+func (hw *headerWriter) addHeaders(body []byte) {
+	if hw.written {
+		return
+	}
+	hw.written = true
+	h := hw.Header()
+	h.Set("X-Content-Type-Options", "nosniff")
+	contentType := h.Get("Content-Type")
+	if contentType == "" && body != nil && h.Get("Content-Encoding") == "" {
+		// what net/http would sniff anyway; with nosniff it must be explicit
+		contentType = http.DetectContentType(body)
+		h.Set("Content-Type", contentType)
+	}
+	if !strings.HasPrefix(contentType, "text/html") {
+		return
+	}
+	if hw.csp != "" {
+		h.Set("Content-Security-Policy", hw.csp)
+	}
+	h.Set("X-Frame-Options", "SAMEORIGIN")
+	h.Set("Referrer-Policy", "strict-origin-when-cross-origin")
+}
 
-		logLine := fmt.Sprintf("%s %s %s %s \"%s\" %d %s \"%s\" \"%s\"\n",
-			ip, "-", user, timestamp, requestLine, statusCode, sizeStr, referer, userAgent)
+func (hw *headerWriter) WriteHeader(code int) {
+	hw.addHeaders(nil)
+	hw.ResponseWriter.WriteHeader(code)
+}
+
+func (hw *headerWriter) Write(b []byte) (int, error) {
+	hw.addHeaders(b)
+	return hw.ResponseWriter.Write(b)
+}
+
+func (hw *headerWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hj, ok := hw.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("hijacking not supported")
+	}
+	return hj.Hijack()
+}
+
+func logRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Wrap the ResponseWriter to capture status code and size
+		rl := &responseLogger{ResponseWriter: w}
+		start := time.Now()
+
+		next.ServeHTTP(rl, r)
 
-		fmt.Print(logLine)
+		entry := accessLogEntry{
+			IP:        getClientIP(r),
+			User:      "-", // we don't have user auth
+			Time:      time.Now(),
+			Method:    r.Method,
+			URI:       r.URL.RequestURI(),
+			Proto:     r.Proto,
+			Status:    rl.statusCode,
+			Size:      rl.size,
+			Referer:   r.Referer(),
+			UserAgent: r.UserAgent(),
+		}
+		logLine := formatAccessLog(entry, logFormat)
+
+		// Console output is filtered by level; the file log always gets everything
+		if statusLogLevel(entry.Status) <= consoleLevel {
+			if consoleLevel == logLevelDebug {
+				entry.Duration = time.Since(start)
+				fmt.Print(formatAccessLog(entry, logFormat))
+			} else {
+				fmt.Print(logLine)
+			}
+		}
 
 		// Write to log file if specified
 		if logFile != "" {
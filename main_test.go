@@ -3,6 +3,7 @@ package main
 import (
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -52,8 +53,8 @@ func TestCollectFilesForDownload(t *testing.T) {
 	if err != nil {
 		t.Errorf("Failed to collect directory files: %v", err)
 	}
-	if len(files) != 4 {
-		t.Errorf("Expected 4 files in directory, got %d", len(files))
+	if n := len(regularFiles(files)); n != 4 {
+		t.Errorf("Expected 4 files in directory, got %d", n)
 	}
 }
 
@@ -91,8 +92,12 @@ func TestCollectFilesFromDirectory(t *testing.T) {
 	if err != nil {
 		t.Errorf("Failed to collect files from directory: %v", err)
 	}
-	if len(files) != 3 {
-		t.Errorf("Expected 3 files, got %d", len(files))
+	if n := len(regularFiles(files)); n != 3 {
+		t.Errorf("Expected 3 files, got %d", n)
+	}
+	// plus one entry for the subdirectory
+	if len(files) != 4 {
+		t.Errorf("Expected 4 entries, got %d", len(files))
 	}
 
 	// Check that relative paths are correct
@@ -102,3 +107,104 @@ func TestCollectFilesFromDirectory(t *testing.T) {
 		}
 	}
 }
+
+func TestExpandBraces(t *testing.T) {
+	tests := map[string][]string{
+		"file.{txt,md}":    {"file.txt", "file.md"},
+		"{a,b{1,2}}.go":    {"a.go", "b1.go", "b2.go"},
+		"x{1,2}y{a,b}":     {"x1ya", "x1yb", "x2ya", "x2yb"},
+		"{lit}.{txt,md}":   {"{lit}.txt", "{lit}.md"},
+		"plain.txt":        {"plain.txt"},
+		"open{a,b":         {"open{a,b"},
+		"src/*.{go,mod}":   {"src/*.go", "src/*.mod"},
+		"{,backup.}tar.gz": {"tar.gz", "backup.tar.gz"},
+	}
+	for in, want := range tests {
+		got, err := expandBraces(in)
+		if err != nil || strings.Join(got, "|") != strings.Join(want, "|") {
+			t.Errorf("expandBraces(%q) = %q, %v, want %q", in, got, err, want)
+		}
+	}
+
+	// 2^30 alternatives must fail fast instead of being produced
+	if _, err := expandBraces(strings.Repeat("{a,b}", 30)); err != errTooManyBraces {
+		t.Errorf("exponential pattern: %v", err)
+	}
+	if got, err := expandBraces(strings.Repeat("{a,b}", 10)); err != nil || len(got) != maxBraceWords {
+		t.Errorf("pattern at the limit: %d words, %v", len(got), err)
+	}
+	if !hasBraces("x{1,2}") || hasBraces("{lit}") {
+		t.Error("hasBraces")
+	}
+}
+
+func TestCollectFilesForDownload_Braces(t *testing.T) {
+	root := t.TempDir()
+	for _, name := range []string{"file.txt", "file.md", "file.go", "notes.md"} {
+		if err := os.WriteFile(filepath.Join(root, name), []byte(name), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s := newServer(root, 1024, "", "")
+
+	files, err := s.collectFilesForDownload("/", "file.{txt,md,missing}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, f := range files {
+		names = append(names, f.relativePath)
+	}
+	if strings.Join(names, " ") != "file.txt file.md" {
+		t.Fatalf("brace download: %v", names)
+	}
+
+	// overlapping alternatives don't add a file twice
+	files, _ = s.collectFilesForDownload("/", "{*.md,notes.md}")
+	if len(files) != 2 {
+		t.Fatalf("overlapping braces: %d files", len(files))
+	}
+}
+
+func TestCollectFilesForDownload_DoubleStar(t *testing.T) {
+	root := t.TempDir()
+	for _, rel := range []string{
+		"src/main.go", "src/pkg/a.go", "src/pkg/deep/b.go", "src/pkg/readme.md",
+		"src/.git/hooks/x.go", "src/vendor/v.go", "other/c.go",
+	} {
+		p := filepath.Join(root, filepath.FromSlash(rel))
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(rel), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(root, "src", ".lsgetignore"), []byte("vendor\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	s := newServer(root, 1024, "", "")
+
+	collect := func(cwd, pattern string) string {
+		t.Helper()
+		files, err := s.collectFilesForDownload(cwd, pattern)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, f := range files {
+			got = append(got, f.virtualPath)
+		}
+		return strings.Join(got, " ")
+	}
+
+	if got := collect("/", "src/**/*.go"); got != "/src/main.go /src/pkg/a.go /src/pkg/deep/b.go" {
+		t.Fatalf("src/**/*.go: %s", got)
+	}
+	if got := collect("/src", "**/deep/*"); got != "/src/pkg/deep/b.go" {
+		t.Fatalf("**/deep/*: %s", got)
+	}
+	if got := collect("/other", "/**/*.md"); got != "/src/pkg/readme.md" {
+		t.Fatalf("/**/*.md: %s", got)
+	}
+}
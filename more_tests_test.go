@@ -3,14 +3,21 @@ package main
 import (
 	"archive/zip"
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"flag"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
 	"io"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
 	"testing"
 	"time"
@@ -133,6 +140,55 @@ func TestParseIgnoreAndShouldIgnore(t *testing.T) {
 	}
 }
 
+func TestGitignore(t *testing.T) {
+	s := newTestServer(t)
+	write := func(name, content string) {
+		t.Helper()
+		p := filepath.Join(s.rootAbs, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(".gitignore", "*.log\n!keep.log\nbuild/\n/top.txt\ndocs/**/draft.md\n")
+	write("sub/.gitignore", "!*.log\nlocal.txt\n")
+	write(".lsgetignore", "*.key\n")
+	for _, name := range []string{"a.log", "keep.log", "build/out.bin", "top.txt", "docs/x/y/draft.md",
+		"sub/a.log", "sub/local.txt", "sub/top.txt", "sub/build", "id.key", "notes.txt"} {
+		write(name, "x")
+	}
+
+	ignored := func(name string) bool {
+		p := filepath.Join(s.rootAbs, filepath.FromSlash(name))
+		return s.shouldIgnore(p, filepath.Base(p))
+	}
+	if ignored("a.log") || !ignored("id.key") {
+		t.Fatal(".gitignore must be opt-in, .lsgetignore always applies")
+	}
+
+	s.useGitignore = true
+	for name, want := range map[string]bool{
+		"a.log":             true,
+		"keep.log":          false, // negated
+		"build":             true,
+		"build/out.bin":     true, // inside an excluded directory
+		"top.txt":           true,
+		"sub/top.txt":       false, // anchored to the root .gitignore
+		"docs/x/y/draft.md": true,
+		"sub/a.log":         false, // re-included by the deeper file
+		"sub/local.txt":     true,
+		"sub/build":         false, // build/ matches directories only
+		"id.key":            true,
+		"notes.txt":         false,
+	} {
+		if got := ignored(name); got != want {
+			t.Errorf("%s: ignored = %v, want %v", name, got, want)
+		}
+	}
+}
+
 // ---- looksText ----
 
 func TestLooksText_Heuristics(t *testing.T) {
@@ -147,6 +203,50 @@ func TestLooksText_Heuristics(t *testing.T) {
 	if looksText([]byte(strings.Repeat("\x00\x01", 100))) {
 		t.Fatal("too binary")
 	}
+	// UTF-16 with a BOM, and big endian without one
+	if !looksText([]byte("\xff\xfek\x00e\x00y\x00=\x001\x00\r\x00\n\x00")) {
+		t.Fatal("utf16le with BOM")
+	}
+	if !looksText([]byte("\x00k\x00e\x00y\x00=\x001")) {
+		t.Fatal("utf16be without BOM")
+	}
+	if looksText([]byte("\x01\x00\x02\x00\x03\x00\x04\x00")) {
+		t.Fatal("NUL-padded control bytes are binary")
+	}
+}
+
+func TestHandleExec_CatUTF16(t *testing.T) {
+	s := newTestServer(t)
+	content := []byte{0xff, 0xfe}
+	for _, r := range "[core]\r\nname=café\r\n" {
+		content = append(content, byte(r), byte(r>>8))
+	}
+	if err := os.WriteFile(filepath.Join(s.rootAbs, "export.ini"), content, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if out := execJSON(t, s, "cat export.ini").Output; out != "[core]\nname=café\n" {
+		t.Fatalf("cat utf16: %q", out)
+	}
+}
+
+func TestHandleExec_CatHead(t *testing.T) {
+	s := newTestServer(t)
+	s.catMax = 10
+	if err := os.WriteFile(filepath.Join(s.rootAbs, "big.txt"), []byte("abcdefghié rest"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if out := execJSON(t, s, "cat big.txt").Output; !strings.Contains(out, "file too large") {
+		t.Fatalf("cat without --head should refuse: %q", out)
+	}
+	if out := execJSON(t, s, "cat --head big.txt").Output; out != "abcdefghi\n... [truncated, 7 more bytes]" {
+		t.Fatalf("cat --head: %q", out)
+	}
+	if out := execJSON(t, s, "cat --head *.txt").Output; !strings.Contains(out, "[truncated, 7 more bytes]") {
+		t.Fatalf("cat --head with a glob: %q", out)
+	}
+	if out := execJSON(t, s, "cat -x big.txt").Output; out != "cat: invalid option '-x'" {
+		t.Fatalf("unknown option: %q", out)
+	}
 }
 
 // ---- static file not found ----
@@ -227,6 +327,43 @@ func TestCollectFilesForDownload_DotAndSubPattern(t *testing.T) {
 
 // ---- sendZipArchive ----
 
+func TestCancelledContextStopsWork(t *testing.T) {
+	s := newTestServer(t)
+	if err := os.MkdirAll(filepath.Join(s.rootAbs, "d"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	f := filepath.Join(s.rootAbs, "d", "needle.txt")
+	if err := os.WriteFile(f, []byte("needle\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel() // the client has gone away
+
+	w := httptest.NewRecorder()
+	s.sendZipArchive(ctx, w, []fileInfo{{realPath: f, relativePath: "needle.txt"}}, "test.zip", false)
+	zr, err := zip.NewReader(bytes.NewReader(w.Body.Bytes()), int64(w.Body.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(zr.File) != 0 {
+		t.Fatalf("zip written for a cancelled request: %d entries", len(zr.File))
+	}
+
+	for _, in := range []string{"find / -name needle.txt", "grep -r needle /", "grep needle d/needle.txt", "tree /"} {
+		body, _ := json.Marshal(execReq{Input: in})
+		r := httptest.NewRequest("POST", "/api/exec", bytes.NewReader(body)).WithContext(ctx)
+		w := httptest.NewRecorder()
+		s.handleExec(w, r)
+		var resp execResp
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+		if strings.Contains(resp.Output, "needle.txt") || strings.Contains(resp.Output, "timed out") {
+			t.Fatalf("%s kept working after the client left: %q", in, resp.Output)
+		}
+	}
+}
+
 func TestSendZipArchive_Content(t *testing.T) {
 	s := newTestServer(t)
 	f1 := filepath.Join(s.rootAbs, "a.txt")
@@ -235,7 +372,7 @@ func TestSendZipArchive_Content(t *testing.T) {
 	_ = os.WriteFile(f2, []byte("BB"), 0o644)
 	files := []fileInfo{{realPath: f1, relativePath: "a.txt"}, {realPath: f2, relativePath: "b.txt"}}
 	w := httptest.NewRecorder()
-	s.sendZipArchive(w, files, "test.zip")
+	s.sendZipArchive(context.Background(), w, files, "test.zip", false)
 	if ct := w.Result().Header.Get("Content-Type"); ct != "application/zip" {
 		t.Fatalf("ctype: %q", ct)
 	}
@@ -272,7 +409,7 @@ func TestBuildTree_HiddenAndDepth(t *testing.T) {
 	}
 
 	var b strings.Builder
-	dirs, files := s.buildTree(&b, s.rootAbs, "", true, 1, 0)
+	dirs, files := s.buildTree(&b, &walkGuard{}, s.rootAbs, "", true, false, 1, 0)
 	out := b.String()
 	if !strings.Contains(out, ".hidden") {
 		t.Fatalf("should include hidden: %q", out)
@@ -282,6 +419,62 @@ func TestBuildTree_HiddenAndDepth(t *testing.T) {
 	}
 }
 
+func TestWalkGuard_SymlinkLoop(t *testing.T) {
+	s := newTestServer(t)
+	if err := os.MkdirAll(filepath.Join(s.rootAbs, "sub", "deeper"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(s.rootAbs, "sub", "needle.txt"), []byte("needle\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(".", filepath.Join(s.rootAbs, "sub", "loop")); err != nil {
+		t.Skip("symlinks not supported")
+	}
+	if err := os.Symlink("..", filepath.Join(s.rootAbs, "sub", "deeper", "up")); err != nil {
+		t.Fatal(err)
+	}
+
+	g := &walkGuard{}
+	steps := []struct {
+		path string
+		want bool
+	}{
+		{"", true},
+		{"sub", true},
+		{"sub/loop", false},
+		{"sub/deeper", true},
+		{"sub/deeper/up", false},
+	}
+	for _, st := range steps {
+		if got := g.enter(filepath.Join(s.rootAbs, st.path)); got != st.want {
+			t.Fatalf("enter(%q) = %v, want %v", st.path, got, st.want)
+		}
+	}
+	// every walker finishes and reports the file once
+	if out := stripANSI(execJSON(t, s, "find / -name needle.txt").Output); out != "/sub/needle.txt" {
+		t.Fatalf("find: %q", out)
+	}
+	if out := stripANSI(execJSON(t, s, "grep -r needle /").Output); out != "/sub/needle.txt:needle" {
+		t.Fatalf("grep -r: %q", out)
+	}
+	if out := stripANSI(execJSON(t, s, "tree /").Output); strings.Count(out, "needle.txt") != 1 {
+		t.Fatalf("tree: %q", out)
+	}
+	files, err := s.collectFilesFromDirectory("/sub", filepath.Join(s.rootAbs, "sub"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := 0
+	for _, f := range files {
+		if strings.HasSuffix(f.relativePath, "needle.txt") {
+			found++
+		}
+	}
+	if found != 1 {
+		t.Fatalf("collectFilesFromDirectory: %v", files)
+	}
+}
+
 // ---- handleComplete filters ----
 
 func TestHandleComplete_Filters(t *testing.T) {
@@ -400,3 +593,403 @@ func TestMain_ListenPaths(t *testing.T) {
 	}()
 	main()
 }
+
+func TestMain_SeparateAccessLog(t *testing.T) {
+	oldListen := listenAndServe
+	oldLog, oldApp := logFile, appLogFile
+	defer func() { listenAndServe, logFile, appLogFile = oldListen, oldLog, oldApp }()
+	listenAndServe = func(*http.Server) error { return http.ErrServerClosed }
+
+	dir := makeTempDir(t)
+	logs := makeTempDir(t)
+	appLog := filepath.Join(logs, "app.log")
+	accessLog := filepath.Join(logs, "access.log")
+	flag.CommandLine = flag.NewFlagSet("lsget", flag.ContinueOnError)
+	os.Args = []string{"lsget", "-dir", dir, "-logfile", appLog, "-accesslog", accessLog}
+	main()
+
+	if logFile != accessLog || appLogFile != appLog {
+		t.Fatalf("log paths: access=%q app=%q", logFile, appLogFile)
+	}
+	data, err := os.ReadFile(appLog)
+	if err != nil || !strings.Contains(string(data), "Serving "+dir) {
+		t.Fatalf("app log should hold startup message: %v %q", err, data)
+	}
+	if _, err := os.Stat(accessLog); err == nil {
+		t.Fatal("access log should not receive operational messages")
+	}
+}
+
+func TestSendZipArchive_StoreContentLength(t *testing.T) {
+	s := newTestServer(t)
+	f1 := filepath.Join(s.rootAbs, "a.txt")
+	f2 := filepath.Join(s.rootAbs, "b.bin")
+	_ = os.WriteFile(f1, []byte("hello"), 0o644)
+	_ = os.WriteFile(f2, bytes.Repeat([]byte{7}, 100000), 0o644)
+	files := []fileInfo{
+		{realPath: f1, relativePath: "d/a.txt"},
+		{realPath: f2, relativePath: "d/b.bin"},
+		{realPath: filepath.Join(s.rootAbs, "gone"), relativePath: "d/gone"},
+	}
+	w := httptest.NewRecorder()
+	s.sendZipArchive(context.Background(), w, files, "test.zip", true)
+
+	cl := w.Result().Header.Get("Content-Length")
+	if cl == "" || cl != fmt.Sprintf("%d", w.Body.Len()) {
+		t.Fatalf("Content-Length %q, body %d bytes", cl, w.Body.Len())
+	}
+	zr, err := zip.NewReader(bytes.NewReader(w.Body.Bytes()), int64(w.Body.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(zr.File) != 2 || zr.File[0].Method != zip.Store {
+		t.Fatalf("stored entries: %d", len(zr.File))
+	}
+	rc, _ := zr.File[1].Open()
+	data, err := io.ReadAll(rc)
+	_ = rc.Close()
+	if err != nil || len(data) != 100000 {
+		t.Fatalf("stored content: %v %d", err, len(data))
+	}
+
+	// Deflate mode can't know its size ahead of time
+	w2 := httptest.NewRecorder()
+	s.sendZipArchive(context.Background(), w2, files, "test.zip", false)
+	if w2.Result().Header.Get("Content-Length") != "" {
+		t.Fatal("deflate archive should not set Content-Length")
+	}
+}
+
+func TestSendZipArchive_StoresCompressedFormats(t *testing.T) {
+	s := newTestServer(t)
+	jpg := filepath.Join(s.rootAbs, "photo.jpg")
+	txt := filepath.Join(s.rootAbs, "notes.txt")
+	_ = os.WriteFile(jpg, []byte{0xff, 0xd8, 0xff, 0xe0}, 0o644)
+	_ = os.WriteFile(txt, []byte("plain text"), 0o644)
+	files := []fileInfo{{realPath: jpg, relativePath: "photo.jpg"}, {realPath: txt, relativePath: "notes.txt"}}
+
+	w := httptest.NewRecorder()
+	s.sendZipArchive(context.Background(), w, files, "test.zip", false)
+	zr, err := zip.NewReader(bytes.NewReader(w.Body.Bytes()), int64(w.Body.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	methods := map[string]uint16{}
+	for _, f := range zr.File {
+		methods[f.Name] = f.Method
+	}
+	if methods["photo.jpg"] != zip.Store {
+		t.Fatalf("jpg should be stored, got method %d", methods["photo.jpg"])
+	}
+	if methods["notes.txt"] != zip.Deflate {
+		t.Fatalf("txt should be deflated, got method %d", methods["notes.txt"])
+	}
+}
+
+func TestZipLimits(t *testing.T) {
+	s := newTestServer(t)
+	if err := os.Mkdir(filepath.Join(s.rootAbs, "d"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		_ = os.WriteFile(filepath.Join(s.rootAbs, "d", fmt.Sprintf("f%d.txt", i)), bytes.Repeat([]byte("x"), 1000), 0o644)
+	}
+
+	s.maxZipFiles = 2
+	resp := execJSON(t, s, "get d")
+	if !strings.Contains(resp.Output, "limit 2") || resp.Download != "" {
+		t.Fatalf("file limit in get: %+v", resp)
+	}
+	w := httptest.NewRecorder()
+	s.handleDownload(w, httptest.NewRequest("GET", "/api/download?dir=/d", nil))
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("file limit in download: %d", w.Code)
+	}
+
+	s.maxZipFiles = 0
+	s.maxZipBytes = 2500
+	if resp := execJSON(t, s, "get d/*.txt"); !strings.Contains(resp.Output, "archive too large") {
+		t.Fatalf("byte limit in get: %+v", resp)
+	}
+
+	s.maxZipBytes = 3000
+	if resp := execJSON(t, s, "get d"); resp.Download == "" {
+		t.Fatalf("within limits: %+v", resp)
+	}
+}
+
+func TestSendZipArchive_DeterministicOrder(t *testing.T) {
+	s := newTestServer(t)
+	var files []fileInfo
+	for _, name := range []string{"c.txt", "a.txt", "b/z.txt", "b/a.txt"} {
+		p := filepath.Join(s.rootAbs, filepath.FromSlash(name))
+		_ = os.MkdirAll(filepath.Dir(p), 0o755)
+		_ = os.WriteFile(p, []byte(name), 0o644)
+		files = append(files, fileInfo{realPath: p, relativePath: name})
+	}
+
+	zipOf := func(files []fileInfo) []byte {
+		w := httptest.NewRecorder()
+		s.sendZipArchive(context.Background(), w, files, "test.zip", false)
+		return w.Body.Bytes()
+	}
+	first := zipOf(files)
+	slices.Reverse(files)
+	if second := zipOf(files); !bytes.Equal(first, second) {
+		t.Fatal("archives of the same files differ with input order")
+	}
+
+	zr, err := zip.NewReader(bytes.NewReader(first), int64(len(first)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, f := range zr.File {
+		names = append(names, f.Name)
+	}
+	if strings.Join(names, " ") != "a.txt b/a.txt b/z.txt c.txt" {
+		t.Fatalf("entry order: %v", names)
+	}
+}
+
+func TestSendZipArchive_EmptyDirectories(t *testing.T) {
+	s := newTestServer(t)
+	root := filepath.Join(s.rootAbs, "skel")
+	for _, d := range []string{"skel/empty", "skel/src/nested-empty", "skel/.hidden"} {
+		_ = os.MkdirAll(filepath.Join(s.rootAbs, d), 0o755)
+	}
+	_ = os.WriteFile(filepath.Join(root, "src", "main.go"), []byte("package main"), 0o644)
+
+	files, err := s.collectFilesFromDirectory("/skel", root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, store := range []bool{false, true} {
+		w := httptest.NewRecorder()
+		s.sendZipArchive(context.Background(), w, files, "skel.zip", store)
+		if store && w.Result().Header.Get("Content-Length") != fmt.Sprintf("%d", w.Body.Len()) {
+			t.Fatalf("stored Content-Length %q, body %d", w.Result().Header.Get("Content-Length"), w.Body.Len())
+		}
+		zr, err := zip.NewReader(bytes.NewReader(w.Body.Bytes()), int64(w.Body.Len()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var names []string
+		for _, f := range zr.File {
+			names = append(names, f.Name)
+		}
+		if got := strings.Join(names, " "); got != "skel/empty/ skel/src/ skel/src/main.go skel/src/nested-empty/" {
+			t.Fatalf("entries (store=%v): %s", store, got)
+		}
+	}
+}
+
+func TestShutdownServer_WaitsForInFlight(t *testing.T) {
+	started := make(chan struct{})
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		time.Sleep(300 * time.Millisecond) // a slow download
+		_, _ = io.WriteString(w, "complete")
+	})}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() { _ = srv.Serve(ln) }()
+
+	type result struct {
+		body string
+		err  error
+	}
+	done := make(chan result, 1)
+	go func() {
+		resp, err := http.Get("http://" + ln.Addr().String() + "/big.iso")
+		if err != nil {
+			done <- result{err: err}
+			return
+		}
+		defer func() { _ = resp.Body.Close() }()
+		b, err := io.ReadAll(resp.Body)
+		done <- result{string(b), err}
+	}()
+
+	<-started
+	if err := shutdownServer(srv, 5*time.Second); err != nil {
+		t.Fatalf("shutdown: %v", err)
+	}
+	if r := <-done; r.err != nil || r.body != "complete" {
+		t.Fatalf("in-flight request: %q %v", r.body, r.err)
+	}
+}
+
+func TestShutdownServer_Timeout(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	defer close(release)
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+	})}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() { _ = srv.Serve(ln) }()
+	go func() {
+		if resp, err := http.Get("http://" + ln.Addr().String() + "/"); err == nil {
+			_ = resp.Body.Close()
+		}
+	}()
+
+	<-started
+	if err := shutdownServer(srv, 50*time.Millisecond); err == nil || !strings.Contains(err.Error(), "aborted") {
+		t.Fatalf("expected timeout error, got %v", err)
+	}
+}
+
+// ---- listing cache ----
+
+func TestListingCache(t *testing.T) {
+	s := newTestServer(t)
+	s.listings = newListingCache(1)
+	sub := filepath.Join(s.rootAbs, "sub")
+	if err := os.Mkdir(sub, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(sub, "a.txt"), []byte("x"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	first, err := s.readDir(sub)
+	if err != nil || len(first) != 1 {
+		t.Fatalf("readDir: %v %v", first, err)
+	}
+	if again, _ := s.readDir(sub); &again[0] != &first[0] {
+		t.Fatal("second read should come from the cache")
+	}
+
+	// a new entry bumps the directory modtime and invalidates the listing
+	past := time.Now().Add(-time.Hour)
+	_ = os.Chtimes(sub, past, past)
+	if err := os.WriteFile(filepath.Join(sub, "b.txt"), []byte("x"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := s.readDir(sub); len(got) != 2 {
+		t.Fatalf("stale listing: %v", got)
+	}
+
+	// the least recently used listing is evicted
+	if _, err := s.readDir(s.rootAbs); err != nil {
+		t.Fatal(err)
+	}
+	if s.listings.lru.Len() != 1 {
+		t.Fatalf("cache should hold 1 listing, has %d", s.listings.lru.Len())
+	}
+	if _, ok := s.listings.entries[sub]; ok {
+		t.Fatal("sub should have been evicted")
+	}
+}
+
+func BenchmarkLsLargeDirectory(b *testing.B) {
+	root := b.TempDir()
+	for i := range 5000 {
+		if err := os.WriteFile(filepath.Join(root, fmt.Sprintf("file%05d.txt", i)), nil, 0o644); err != nil {
+			b.Fatal(err)
+		}
+	}
+	for _, size := range []int{0, 16} {
+		b.Run(fmt.Sprintf("cache=%d", size), func(b *testing.B) {
+			s := newServer(root, 4096, "", "")
+			if size > 0 {
+				s.listings = newListingCache(size)
+			}
+			sess := &session{cwd: "/"}
+			r := httptest.NewRequest("POST", "/api/exec", nil)
+			for b.Loop() {
+				s.runCommand(io.Discard, r, sess, execReq{Input: "ls -l"})
+			}
+		})
+	}
+}
+
+// ---- read-only guarantee ----
+
+// TestWritesGoThroughGuard checks that files only change through the write
+// helpers, which refuse to run on a read-only server. Logging, the PID file,
+// the bookmarks file and the opt-in sitemap write to operator-chosen paths,
+// and the -mount links to a temporary directory of their own; these are
+// exempt.
+func TestWritesGoThroughGuard(t *testing.T) {
+	writeFuncs := map[string]bool{
+		"Chmod": true, "Chown": true, "Chtimes": true, "Create": true, "CreateTemp": true,
+		"Lchown": true, "Link": true, "Mkdir": true, "MkdirAll": true, "MkdirTemp": true,
+		"OpenFile": true, "Remove": true, "RemoveAll": true, "Rename": true,
+		"Symlink": true, "Truncate": true, "WriteFile": true,
+	}
+	allowed := map[string]bool{
+		"mkdir": true, "remove": true, "rename": true, "createFile": true, "writeFileAtomic": true,
+		"logf": true, "logCommand": true, "logRequests": true, "generateSitemap": true, "newMountRoot": true,
+		"writePIDFile": true, "removeRuntimeFiles": true, "writeBookmarksFile": true,
+	}
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, "main.go", nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, decl := range f.Decls {
+		fn, ok := decl.(*ast.FuncDecl)
+		if !ok || allowed[fn.Name.Name] {
+			continue
+		}
+		ast.Inspect(fn, func(n ast.Node) bool {
+			if sel, ok := n.(*ast.SelectorExpr); ok {
+				if pkg, ok := sel.X.(*ast.Ident); ok && pkg.Name == "os" && writeFuncs[sel.Sel.Name] {
+					t.Errorf("%s: %s calls os.%s outside the write helpers", fset.Position(sel.Pos()), fn.Name.Name, sel.Sel.Name)
+				}
+			}
+			return true
+		})
+	}
+}
+
+func TestReadOnlyServerNeverWrites(t *testing.T) {
+	s := newTestServer(t)
+	if err := os.Mkdir(filepath.Join(s.rootAbs, "d"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(s.rootAbs, "d", "f.txt"), []byte("x"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	snapshot := func() []string {
+		var paths []string
+		_ = filepath.WalkDir(s.rootAbs, func(p string, d os.DirEntry, err error) error {
+			paths = append(paths, p)
+			return nil
+		})
+		return paths
+	}
+	before := snapshot()
+
+	for _, cmd := range []string{"mkdir new", "mkdir -p a/b", "rm d/f.txt", "rm -r d", "mv d/f.txt g.txt", "cp -r d e", "bookmark home /d"} {
+		execJSON(t, s, cmd)
+	}
+	w := httptest.NewRecorder()
+	s.handleUpload(w, uploadRequest(t, "up.txt", "data"))
+	if w.Code == http.StatusOK {
+		t.Fatalf("upload accepted on read-only server")
+	}
+
+	if after := snapshot(); !slices.Equal(before, after) {
+		t.Fatalf("tree changed on read-only server:\nbefore %v\nafter  %v", before, after)
+	}
+	for _, err := range []error{
+		s.mkdir(filepath.Join(s.rootAbs, "x"), true),
+		s.remove(filepath.Join(s.rootAbs, "d"), true),
+		s.rename(filepath.Join(s.rootAbs, "d"), filepath.Join(s.rootAbs, "e")),
+		s.writeFileAtomic(filepath.Join(s.rootAbs, "x"), nil, 0o644),
+	} {
+		if !errors.Is(err, errReadOnly) {
+			t.Fatalf("write helper ran on read-only server: %v", err)
+		}
+	}
+}
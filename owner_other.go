@@ -0,0 +1,10 @@
+//go:build !unix
+
+package main
+
+import "os"
+
+// fileOwner is not available on this platform; ls -g leaves the columns out.
+func fileOwner(info os.FileInfo) (owner, group string, ok bool) {
+	return "", "", false
+}
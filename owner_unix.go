@@ -0,0 +1,41 @@
+//go:build unix
+
+package main
+
+import (
+	"os"
+	"os/user"
+	"strconv"
+	"sync"
+	"syscall"
+)
+
+// ownerNames caches uid/gid lookups, which may hit NSS on every call
+var ownerNames sync.Map
+
+// fileOwner returns the owner and group names of a file, falling back to
+// the numeric IDs when they don't resolve to a name.
+func fileOwner(info os.FileInfo) (owner, group string, ok bool) {
+	st, isStat := info.Sys().(*syscall.Stat_t)
+	if !isStat {
+		return "", "", false
+	}
+	return lookupOwner("u", strconv.FormatUint(uint64(st.Uid), 10)),
+		lookupOwner("g", strconv.FormatUint(uint64(st.Gid), 10)), true
+}
+
+func lookupOwner(kind, id string) string {
+	if name, ok := ownerNames.Load(kind + id); ok {
+		return name.(string)
+	}
+	name := id
+	if kind == "u" {
+		if u, err := user.LookupId(id); err == nil {
+			name = u.Username
+		}
+	} else if g, err := user.LookupGroupId(id); err == nil {
+		name = g.Name
+	}
+	ownerNames.Store(kind+id, name)
+	return name
+}
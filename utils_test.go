@@ -2,13 +2,23 @@ package main
 
 import (
 	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
 	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"hash"
+	"io"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
 	"os"
 	"path"
 	"path/filepath"
+	"runtime"
+	"slices"
 	"strings"
 	"testing"
 	"time"
@@ -29,7 +39,7 @@ func newTestServer(t *testing.T) *server {
 }
 
 func TestRenderHelp(t *testing.T) {
-	s := renderHelp()
+	s := renderHelp(false, "", nil)
 	if !strings.Contains(s, version) {
 		t.Fatalf("help should contain version, got %q", s)
 	}
@@ -51,6 +61,12 @@ func TestCleanJoinRealFromVirtual(t *testing.T) {
 	if joinVirtual("/a", "/x") != "/x" {
 		t.Fatal("join absolute wins")
 	}
+	// ~ is the virtual root; ~user is a plain name
+	for arg, want := range map[string]string{"~": "/", "~/": "/", "~/f": "/f", "~/../f": "/f", "~bob": "/a/~bob", "x~": "/a/x~"} {
+		if got := joinVirtual("/a", arg); got != want {
+			t.Fatalf("joinVirtual(/a, %q) = %q, want %q", arg, got, want)
+		}
+	}
 	// realFromVirtual root
 	r, err := s.realFromVirtual("/")
 	if err != nil || r != s.rootAbs {
@@ -63,16 +79,38 @@ func TestCleanJoinRealFromVirtual(t *testing.T) {
 }
 
 func TestParseArgs(t *testing.T) {
-	args := parseArgs(`cmd "a b" 'c d' e f`)
+	args, err := parseArgs(`cmd "a b" 'c d' e f`)
 	exp := []string{"cmd", "a b", "c d", "e", "f"}
-	if len(args) != len(exp) {
-		t.Fatalf("args len: %v", args)
+	if err != nil || len(args) != len(exp) {
+		t.Fatalf("args len: %v %v", args, err)
 	}
 	for i := range exp {
 		if args[i] != exp[i] {
 			t.Fatalf("arg %d = %q", i, args[i])
 		}
 	}
+
+	for _, line := range []string{`cat "foo`, `cat 'foo bar`, `cat "a\"`} {
+		if _, err := parseArgs(line); err != errUnterminatedQuote {
+			t.Errorf("parseArgs(%q) error = %v", line, err)
+		}
+	}
+}
+
+func TestParseArgsExpand(t *testing.T) {
+	vars := map[string]string{"CWD": "/docs", "HOME": "/"}
+	cases := map[string][]string{
+		`cd $CWD/sub`:          {"cd", "/docs/sub"},
+		`cat "${CWD}/a b.txt"`: {"cat", "/docs/a b.txt"},
+		`grep '$CWD' $HOME`:    {"grep", "$CWD", "/"},
+		`cat "\$CWD" $PATH $`:  {"cat", "$CWD", "$PATH", "$"},
+	}
+	for line, want := range cases {
+		got, err := parseArgsExpand(line, vars)
+		if err != nil || strings.Join(got, "|") != strings.Join(want, "|") {
+			t.Errorf("parseArgsExpand(%q) = %q, %v; want %q", line, got, err, want)
+		}
+	}
 }
 
 func TestLooksText(t *testing.T) {
@@ -199,6 +237,11 @@ func TestProcessHTMLTemplate(t *testing.T) {
 	if !strings.Contains(so, "/foo/bar") {
 		t.Fatal("INITIAL_PATH not injected")
 	}
+
+	// the help lands in data-signals='...', so an apostrophe would cut it off
+	if strings.Contains(string(s.processHTMLTemplate([]byte("{{HELP_MESSAGE}}"), "/")), "'") {
+		t.Fatal("apostrophe left unescaped in help")
+	}
 }
 
 func TestHandleIndexServesAndFile(t *testing.T) {
@@ -222,6 +265,28 @@ func TestHandleIndexServesAndFile(t *testing.T) {
 	}
 }
 
+func TestServeFileCharset(t *testing.T) {
+	s := newTestServer(t)
+	files := map[string]string{
+		"notes.txt": "héllo",
+		"LICENSE":   "Licensed under the AGPL — see below",
+		"blob":      "\x00\x01\x02\xff",
+	}
+	want := map[string]string{
+		"notes.txt": "text/plain; charset=utf-8",
+		"LICENSE":   "text/plain; charset=utf-8",
+		"blob":      "application/octet-stream",
+	}
+	for name, data := range files {
+		_ = os.WriteFile(filepath.Join(s.rootAbs, name), []byte(data), 0o644)
+		w := httptest.NewRecorder()
+		s.handleIndex(w, httptest.NewRequest("GET", "/"+name, nil))
+		if ct := w.Result().Header.Get("Content-Type"); ct != want[name] {
+			t.Errorf("%s: Content-Type %q, want %q", name, ct, want[name])
+		}
+	}
+}
+
 func TestHandleStaticFile(t *testing.T) {
 	s := newTestServer(t)
 	fp := filepath.Join(s.rootAbs, "static.js")
@@ -263,3 +328,776 @@ func TestFormatLongTimeStability(t *testing.T) {
 	_ = formatLong(info, "x", false)
 	_ = time.Now() // just touch time to ensure import used in test logic
 }
+
+func TestVisibleLen(t *testing.T) {
+	tests := []struct {
+		in   string
+		want int
+	}{
+		{"", 0},
+		{"plain", 5},
+		{colorRed + "red" + colorReset, 3},
+		{colorBlue + colorBold + "dir/" + colorReset, 4},
+		{colorGreen + "café" + colorReset, 4},
+		{"a" + colorYellow + colorBold + "b" + colorReset + "c", 3},
+	}
+	for _, tt := range tests {
+		if got := visibleLen(tt.in); got != tt.want {
+			t.Errorf("visibleLen(%q) = %d, want %d", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestFormatColumns(t *testing.T) {
+	names := []string{"a", "bb", "ccc", "dddd", "e"}
+
+	// Wide terminal: everything on one row
+	if got := formatColumns(names, 80); got != "a  bb  ccc  dddd  e" {
+		t.Fatalf("one row: %q", got)
+	}
+	// Narrow terminal: filled top-to-bottom
+	got := formatColumns(names, 10)
+	want := "a    dddd\nbb   e\nccc"
+	if got != want {
+		t.Fatalf("columns:\n%q\nwant\n%q", got, want)
+	}
+	// Too narrow for two columns falls back to one per line
+	if got := formatColumns(names, 3); got != strings.Join(names, "\n") {
+		t.Fatalf("single column: %q", got)
+	}
+	// Padding ignores ANSI codes
+	colored := []string{colorRed + "x" + colorReset, "yy", "z"}
+	if got := formatColumns(colored, 6); got != colored[0]+"   z\nyy" {
+		t.Fatalf("colored: %q", got)
+	}
+}
+
+func TestParseAndStatusLogLevel(t *testing.T) {
+	for in, want := range map[string]logLevel{"error": logLevelError, "WARN": logLevelWarn, "info": logLevelInfo, "debug": logLevelDebug} {
+		got, err := parseLogLevel(in)
+		if err != nil || got != want {
+			t.Errorf("parseLogLevel(%q) = %v, %v", in, got, err)
+		}
+	}
+	if _, err := parseLogLevel("loud"); err == nil {
+		t.Error("expected error for unknown level")
+	}
+	if statusLogLevel(503) != logLevelError || statusLogLevel(404) != logLevelWarn || statusLogLevel(200) != logLevelInfo {
+		t.Error("status mapping")
+	}
+}
+
+func TestLogRequests_FileIgnoresLevel(t *testing.T) {
+	oldFile, oldLevel := logFile, consoleLevel
+	defer func() { logFile, consoleLevel = oldFile, oldLevel }()
+	logFile = filepath.Join(makeTempDir(t), "access.log")
+	consoleLevel = logLevelError
+
+	h := httpHandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(200) })
+	logRequests(h).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/quiet", nil))
+
+	data, err := os.ReadFile(logFile)
+	if err != nil || !strings.Contains(string(data), "GET /quiet") {
+		t.Fatalf("file log should record every request: %v %q", err, data)
+	}
+}
+
+func TestFormatAccessLog(t *testing.T) {
+	e := accessLogEntry{
+		IP: "1.2.3.4", User: "-", Time: time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC),
+		Method: "GET", URI: "/a?b=c", Proto: "HTTP/1.1", Status: 200, Size: 42,
+		Referer: "http://ref", UserAgent: "ua",
+	}
+	common := formatAccessLog(e, logFormatCommon)
+	if common != `1.2.3.4 - - [02/Jan/2025:03:04:05 +0000] "GET /a?b=c HTTP/1.1" 200 42`+"\n" {
+		t.Fatalf("common: %q", common)
+	}
+	combined := formatAccessLog(e, logFormatCombined)
+	if combined != strings.TrimSuffix(common, "\n")+` "http://ref" "ua"`+"\n" {
+		t.Fatalf("combined: %q", combined)
+	}
+
+	var decoded map[string]any
+	if err := json.Unmarshal([]byte(formatAccessLog(e, logFormatJSON)), &decoded); err != nil {
+		t.Fatalf("json: %v", err)
+	}
+	if decoded["uri"] != "/a?b=c" || decoded["status"] != float64(200) || decoded["user_agent"] != "ua" {
+		t.Fatalf("json fields: %#v", decoded)
+	}
+	if _, ok := decoded["duration_ms"]; ok {
+		t.Fatal("duration should be omitted when zero")
+	}
+}
+
+func TestLogCommandEscaping(t *testing.T) {
+	s := newTestServer(t)
+	s.logfile = filepath.Join(makeTempDir(t), "access.log")
+	s.logCommand("get\"x", "/evil\nname\".txt", "1.2.3.4")
+
+	data, err := os.ReadFile(s.logfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	line := string(data)
+	if strings.Count(line, "\n") != 1 || strings.Count(line, `"`) != 6 {
+		t.Fatalf("log line must stay one CLF record: %q", line)
+	}
+	if !strings.Contains(line, "/api/exec?cmd=get%22x&file=%2Fevil%0Aname%22.txt") {
+		t.Fatalf("expected escaped command and file: %q", line)
+	}
+
+	e := accessLogEntry{IP: "1.2.3.4", User: "-", Method: "GET", URI: "/", Proto: "HTTP/1.1", Status: 200, UserAgent: "a\"b\\c\r\n"}
+	if got := formatAccessLog(e, logFormatCombined); !strings.HasSuffix(got, `"a\"b\\c\x0d\x0a"`+"\n") {
+		t.Fatalf("user agent not escaped: %q", got)
+	}
+}
+
+func TestContentDisposition(t *testing.T) {
+	tests := map[string]string{
+		"report.pdf":       `attachment; filename="report.pdf"; filename*=UTF-8''report.pdf`,
+		"résumé final.pdf": `attachment; filename="r_sum_ final.pdf"; filename*=UTF-8''r%C3%A9sum%C3%A9%20final.pdf`,
+		`say "hi" (1).zip`: `attachment; filename="say _hi_ (1).zip"; filename*=UTF-8''say%20%22hi%22%20%281%29.zip`,
+	}
+	for name, want := range tests {
+		if got := contentDisposition("attachment", name); got != want {
+			t.Errorf("contentDisposition(%q) =\n %s\nwant\n %s", name, got, want)
+		}
+	}
+}
+
+func TestWithCharset(t *testing.T) {
+	tests := map[string]string{
+		"text/plain":               "text/plain; charset=utf-8",
+		"text/html; charset=utf-8": "text/html; charset=utf-8",
+		"application/json":         "application/json; charset=utf-8",
+		"application/ld+json":      "application/ld+json; charset=utf-8",
+		"image/svg+xml":            "image/svg+xml; charset=utf-8",
+		"image/png":                "image/png",
+		"application/octet-stream": "application/octet-stream",
+	}
+	for in, want := range tests {
+		if got := withCharset(in); got != want {
+			t.Errorf("withCharset(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestUnifiedDiff(t *testing.T) {
+	diff := func(a, b string) string {
+		t.Helper()
+		out, err := unifiedDiff("a", "b", a, b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return out
+	}
+	if got := diff("same\n", "same\n"); got != "" {
+		t.Fatalf("identical: %q", got)
+	}
+
+	oldText := "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\n12\n13\n14\n15\n"
+	newText := "1\n2\nthree\n4\n5\n6\n7\n8\n9\n10\n11\n12\n13\n14\n15\n16\n"
+	want := strings.Join([]string{
+		"--- a",
+		"+++ b",
+		"@@ -1,6 +1,6 @@",
+		" 1",
+		" 2",
+		"-3",
+		"+three",
+		" 4",
+		" 5",
+		" 6",
+		"@@ -13,3 +13,4 @@",
+		" 13",
+		" 14",
+		" 15",
+		"+16",
+	}, "\n")
+	if got := stripANSI(diff(oldText, newText)); got != want {
+		t.Fatalf("diff:\n%s\nwant:\n%s", got, want)
+	}
+
+	if got := stripANSI(diff("", "x\n")); got != "--- a\n+++ b\n@@ -0,0 +1,1 @@\n+x" {
+		t.Fatalf("from empty: %q", got)
+	}
+
+	// long identical runs cost nothing; only the differing middle is tabled
+	same := strings.Repeat("\n", 100000)
+	if got := diff(same+"x\n"+same, same+"y\n"+same); !strings.Contains(stripANSI(got), "-x\n+y") {
+		t.Fatalf("common prefix and suffix: %q", got)
+	}
+	many := func(c string) string { return strings.Repeat(c+"\n", 4096) }
+	if _, err := unifiedDiff("a", "b", many("a"), many("b")); !errors.Is(err, errDiffTooLarge) {
+		t.Fatalf("large diff: %v", err)
+	}
+}
+
+func TestHealthAndReadiness(t *testing.T) {
+	root := t.TempDir()
+	s := newServer(root, 4*1024, "", "")
+
+	w := httptest.NewRecorder()
+	s.handleHealthz(w, httptest.NewRequest("GET", "/healthz", nil))
+	if w.Code != http.StatusOK || w.Body.String() != "ok\n" {
+		t.Fatalf("healthz: %d %q", w.Code, w.Body.String())
+	}
+	w = httptest.NewRecorder()
+	s.handleReadyz(w, httptest.NewRequest("GET", "/readyz", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("readyz: %d", w.Code)
+	}
+
+	if err := os.Remove(root); err != nil {
+		t.Fatal(err)
+	}
+	w = httptest.NewRecorder()
+	s.handleReadyz(w, httptest.NewRequest("GET", "/readyz", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("readyz without root: %d", w.Code)
+	}
+}
+
+func TestHandleVersion(t *testing.T) {
+	root := filepath.Join(t.TempDir(), "releases")
+	s := newServer(root, 4*1024, "", "")
+
+	w := httptest.NewRecorder()
+	s.handleVersion(w, httptest.NewRequest("GET", "/api/version", nil))
+	var got versionResp
+	if err := json.NewDecoder(w.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Version != version || got.Go != runtime.Version() || got.Root != "releases" {
+		t.Fatalf("version: %+v", got)
+	}
+	if strings.Contains(w.Body.String(), root) {
+		t.Fatal("version must not reveal the absolute root")
+	}
+}
+
+func TestHandleFavicon(t *testing.T) {
+	root := t.TempDir()
+	s := newServer(root, 4*1024, "", "")
+
+	w := httptest.NewRecorder()
+	s.handleFavicon(w, httptest.NewRequest("GET", "/favicon.ico", nil))
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "image/x-icon" || !bytes.Equal(w.Body.Bytes(), embeddedFavicon) {
+		t.Fatalf("embedded favicon: %d %q", w.Code, w.Header().Get("Content-Type"))
+	}
+
+	custom := []byte("\x00\x00\x01\x00custom")
+	if err := os.WriteFile(filepath.Join(root, "favicon.ico"), custom, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	w = httptest.NewRecorder()
+	s.handleFavicon(w, httptest.NewRequest("GET", "/favicon.ico", nil))
+	if !bytes.Equal(w.Body.Bytes(), custom) {
+		t.Fatalf("root favicon not preferred: %q", w.Body.String())
+	}
+}
+
+func TestServeErrorPages(t *testing.T) {
+	root := t.TempDir()
+	s := newServer(root, 4*1024, "", "")
+
+	// API and command line clients keep plain text errors
+	w := httptest.NewRecorder()
+	s.handleIndex(w, httptest.NewRequest("GET", "/missing.txt?nojs=1", nil))
+	if w.Code != http.StatusNotFound || !strings.HasPrefix(w.Header().Get("Content-Type"), "text/plain") {
+		t.Fatalf("plain 404: %d %q", w.Code, w.Header().Get("Content-Type"))
+	}
+
+	// browsers get the themed page, with the path escaped
+	r := httptest.NewRequest("GET", "/docs/<x>.txt?nojs=1", nil)
+	r.Header.Set("Accept", "text/html,application/xhtml+xml")
+	w = httptest.NewRecorder()
+	s.handleIndex(w, r)
+	body := w.Body.String()
+	if w.Code != http.StatusNotFound || !strings.Contains(body, "No such file or directory") ||
+		strings.Contains(body, "<x>") || !strings.Contains(body, `href="/docs?nojs=1"`) {
+		t.Fatalf("html 404: %d %s", w.Code, body)
+	}
+
+	// a 404.html in the root replaces the built-in page
+	if err := os.WriteFile(filepath.Join(root, "404.html"), []byte("<h1>gone</h1>"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	w = httptest.NewRecorder()
+	s.handleIndex(w, r)
+	if w.Code != http.StatusNotFound || w.Body.String() != "<h1>gone</h1>" {
+		t.Fatalf("custom 404: %d %q", w.Code, w.Body.String())
+	}
+}
+
+func TestHashParallelMatchesSequential(t *testing.T) {
+	fp := filepath.Join(t.TempDir(), "data.bin")
+	if err := os.WriteFile(fp, bytes.Repeat([]byte("lsget"), 100000), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(fp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = f.Close() }()
+
+	seq := []hash.Hash{md5.New(), sha256.New()}
+	if err := hashSequential(f, seq); err != nil {
+		t.Fatal(err)
+	}
+	par := []hash.Hash{md5.New(), sha256.New()}
+	if err := hashParallel(context.Background(), f, 500000, par); err != nil {
+		t.Fatal(err)
+	}
+	for i := range seq {
+		if !bytes.Equal(seq[i].Sum(nil), par[i].Sum(nil)) {
+			t.Fatalf("digest %d differs", i)
+		}
+	}
+}
+
+func BenchmarkChecksums(b *testing.B) {
+	const size = 32 << 20
+	fp := filepath.Join(b.TempDir(), "data.bin")
+	if err := os.WriteFile(fp, bytes.Repeat([]byte{0xa5}, size), 0o644); err != nil {
+		b.Fatal(err)
+	}
+	f, err := os.Open(fp)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer func() { _ = f.Close() }()
+
+	b.Run("sequential", func(b *testing.B) {
+		b.SetBytes(size)
+		for b.Loop() {
+			_, _ = f.Seek(0, 0)
+			_ = hashSequential(f, []hash.Hash{md5.New(), sha256.New()})
+		}
+	})
+	b.Run("parallel", func(b *testing.B) {
+		b.SetBytes(size)
+		for b.Loop() {
+			_ = hashParallel(context.Background(), f, size, []hash.Hash{md5.New(), sha256.New()})
+		}
+	})
+}
+
+func TestNormalizeNewlines(t *testing.T) {
+	cases := map[string]string{
+		"a\nb":       "a\nb",
+		"a\r\nb\r\n": "a\nb\n",
+		"a\rb\r":     "a\nb\n",
+		"a\r\n\rb":   "a\n\nb",
+	}
+	for in, want := range cases {
+		if got := normalizeNewlines(in); got != want {
+			t.Errorf("normalizeNewlines(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+// jailFixture builds a root with a nested directory, a symlink staying
+// inside it, one pointing outside and a dangling one pointing outside
+func jailFixture(t testing.TB) (root, outside string) {
+	base := t.TempDir()
+	root = filepath.Join(base, "root")
+	outside = filepath.Join(base, "outside")
+	for _, d := range []string{filepath.Join(root, "docs", "sub"), outside} {
+		if err := os.MkdirAll(d, 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	links := map[string]string{
+		"inside":   filepath.Join(root, "docs"),
+		"relative": "docs/sub",
+		"escape":   outside,
+		"dangling": filepath.Join(outside, "new.txt"),
+		"up":       "..",
+	}
+	for name, target := range links {
+		if err := os.Symlink(target, filepath.Join(root, name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return root, outside
+}
+
+func TestResolveInJail(t *testing.T) {
+	root, _ := jailFixture(t)
+	ok := map[string]string{
+		"/":                  root,
+		"/docs/../docs/sub":  filepath.Join(root, "docs", "sub"),
+		"/../../etc/passwd":  filepath.Join(root, "etc", "passwd"),
+		"../docs":            filepath.Join(root, "docs"),
+		"/inside/sub":        filepath.Join(root, "inside", "sub"),
+		"/relative/new/deep": filepath.Join(root, "relative", "new", "deep"),
+		"/..hidden":          filepath.Join(root, "..hidden"),
+		`/docs\..\..\x`:      filepath.Join(root, `docs\..\..\x`),
+	}
+	for v, want := range ok {
+		if got, err := resolveInJail(root, v, false); err != nil || got != want {
+			t.Errorf("resolveInJail(%q) = %q, %v; want %q", v, got, err, want)
+		}
+	}
+	for _, v := range []string{"/escape", "/escape/file", "/dangling", "/up", "/up/outside", "/inside/../escape"} {
+		if got, err := resolveInJail(root, v, false); err == nil {
+			t.Errorf("resolveInJail(%q) = %q, want an error", v, got)
+		}
+	}
+}
+
+func TestCaseInsensitivePaths(t *testing.T) {
+	root, _ := jailFixture(t)
+	// a link spelling the root in another case, as macOS and Windows
+	// paths often do; it only counts as inside when case is ignored
+	shouted := filepath.Join(filepath.Dir(root), "ROOT", "new.txt")
+	if err := os.Symlink(shouted, filepath.Join(root, "shouted")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := resolveInJail(root, "/shouted", false); err == nil {
+		t.Fatal("case-sensitive check should refuse a differently cased root")
+	}
+	if _, err := resolveInJail(root, "/shouted", true); err != nil {
+		t.Fatalf("case-insensitive check: %v", err)
+	}
+	for _, v := range []string{"/escape", "/up/outside", "/dangling"} {
+		if _, err := resolveInJail(root, v, true); err == nil {
+			t.Errorf("resolveInJail(%q) with folding should still fail", v)
+		}
+	}
+
+	s := newServer(root, 4*1024, "", "")
+	if err := os.WriteFile(filepath.Join(root, ".lsgetignore"), []byte("*.key\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	key := filepath.Join(root, "ID.KEY")
+	bookmarks := filepath.Join(root, strings.ToUpper(legacyBookmarksFile))
+	if s.shouldIgnore(key, "ID.KEY") || s.shouldIgnore(bookmarks, filepath.Base(bookmarks)) {
+		t.Fatal("patterns are case-sensitive by default")
+	}
+	s.caseInsensitive = true
+	if !s.shouldIgnore(key, "ID.KEY") || !s.shouldIgnore(bookmarks, filepath.Base(bookmarks)) {
+		t.Fatal("case-insensitive server should hide differently cased matches")
+	}
+
+	t.Run("filesystem", func(t *testing.T) {
+		if _, err := os.Stat(filepath.Join(root, "DOCS")); err != nil {
+			t.Skip("filesystem is case-sensitive")
+		}
+		rp, err := s.realFromVirtual("/DOCS/SUB")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info, err := os.Stat(rp); err != nil || !info.IsDir() {
+			t.Fatalf("stat %s: %v", rp, err)
+		}
+	})
+}
+
+func FuzzResolveInJail(f *testing.F) {
+	root, _ := jailFixture(f)
+	realRoot, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		f.Fatal(err)
+	}
+	for _, seed := range []string{"/", "..", "/docs/../../x", "escape/a", "up/root/docs", `..\..\x`, "inside//sub/./..", "dangling"} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, v string) {
+		got, err := resolveInJail(root, v, false)
+		if err != nil {
+			return
+		}
+		if !pathWithin(root, got) {
+			t.Fatalf("resolveInJail(%q) = %q, outside the root", v, got)
+		}
+		if resolved, err := evalExisting(got, 0); err == nil && !pathWithin(realRoot, resolved) {
+			t.Fatalf("resolveInJail(%q) = %q, which resolves to %q outside the root", v, got, resolved)
+		}
+	})
+}
+
+func FuzzParseArgs(f *testing.F) {
+	for _, seed := range []string{`cmd "a b" 'c d' e f`, `cat "foo`, `a\ b`, `"x\"y"`, `''`, "\t\n", `$CWD "${X}"`} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, line string) {
+		args, err := parseArgs(line)
+		if err != nil {
+			return
+		}
+		// double-quoting every argument must give the same arguments back
+		quoted := make([]string, len(args))
+		for i, a := range args {
+			if a == "" {
+				t.Fatalf("parseArgs(%q) returned an empty argument", line)
+			}
+			quoted[i] = `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(a) + `"`
+		}
+		again, err := parseArgs(strings.Join(quoted, " "))
+		if err != nil || strings.Join(again, "\x00") != strings.Join(args, "\x00") {
+			t.Fatalf("round trip of %q: %q became %q (%v)", line, args, again, err)
+		}
+	})
+}
+
+func FuzzVirtualPaths(f *testing.F) {
+	s := newServer(f.TempDir(), 4096, "", "")
+	for _, seed := range [][2]string{{"/", ".."}, {"/a/b", "../../.."}, {"", "~/x"}, {"/a", "/b//c/."}, {"a/../..", `..\..`}} {
+		f.Add(seed[0], seed[1])
+	}
+	f.Fuzz(func(t *testing.T, base, arg string) {
+		for _, v := range []string{cleanVirtual(arg), joinVirtual(base, arg)} {
+			if !strings.HasPrefix(v, "/") || path.Clean(v) != v || cleanVirtual(v) != v {
+				t.Fatalf("not a clean absolute virtual path: %q (base %q, arg %q)", v, base, arg)
+			}
+			if slices.Contains(strings.Split(v, "/"), "..") {
+				t.Fatalf("virtual path keeps '..': %q", v)
+			}
+			if rp, err := s.realFromVirtual(v); err == nil && !pathWithin(s.rootAbs, rp) {
+				t.Fatalf("realFromVirtual(%q) = %q, outside %q", v, rp, s.rootAbs)
+			}
+		}
+	})
+}
+
+func TestSecurityHeaders(t *testing.T) {
+	h := securityHeaders(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/page":
+			_, _ = w.Write([]byte("<!DOCTYPE html><html><body>hi</body></html>"))
+		case "/json":
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = w.Write([]byte("{}"))
+		}
+	}), "default-src 'self'")
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/page", nil))
+	for name, want := range map[string]string{
+		"Content-Type":            "text/html; charset=utf-8",
+		"Content-Security-Policy": "default-src 'self'",
+		"X-Content-Type-Options":  "nosniff",
+		"X-Frame-Options":         "SAMEORIGIN",
+		"Referrer-Policy":         "strict-origin-when-cross-origin",
+	} {
+		if got := w.Header().Get(name); got != want {
+			t.Errorf("%s = %q, want %q", name, got, want)
+		}
+	}
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/json", nil))
+	if w.Header().Get("X-Content-Type-Options") != "nosniff" || w.Header().Get("Content-Security-Policy") != "" {
+		t.Fatalf("non-HTML responses only get nosniff: %v", w.Header())
+	}
+
+	off := securityHeaders(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.WriteHeader(http.StatusNotFound)
+	}), "")
+	w = httptest.NewRecorder()
+	off.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	if w.Header().Get("Content-Security-Policy") != "" || w.Header().Get("X-Frame-Options") != "SAMEORIGIN" {
+		t.Fatalf("-csp off should only drop the CSP: %v", w.Header())
+	}
+}
+
+func TestCORS(t *testing.T) {
+	s := newServer(t.TempDir(), 4*1024, "", "")
+	s.corsOrigins = []string{"https://app.example.com/"}
+	called := false
+	h := s.cors(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { called = true }))
+
+	req := httptest.NewRequest("OPTIONS", "/api/exec", nil)
+	req.Header.Set("Origin", "https://app.example.com")
+	req.Header.Set("Access-Control-Request-Method", "POST")
+	req.Header.Set("Access-Control-Request-Headers", "content-type")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if called || w.Code != http.StatusNoContent {
+		t.Fatalf("preflight: code %d, reached handler %v", w.Code, called)
+	}
+	for name, want := range map[string]string{
+		"Access-Control-Allow-Origin":      "https://app.example.com",
+		"Access-Control-Allow-Credentials": "true",
+		"Access-Control-Allow-Methods":     "GET, POST, OPTIONS",
+		"Access-Control-Allow-Headers":     "content-type",
+	} {
+		if got := w.Header().Get(name); got != want {
+			t.Errorf("preflight %s = %q, want %q", name, got, want)
+		}
+	}
+
+	req = httptest.NewRequest("POST", "/api/exec", nil)
+	req.Header.Set("Origin", "https://evil.example.com")
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Header().Get("Access-Control-Allow-Origin") != "" || w.Header().Get("Vary") != "Origin" {
+		t.Fatalf("unlisted origin got CORS headers: %v", w.Header())
+	}
+
+	req = httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Origin", "https://app.example.com")
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Header().Get("Access-Control-Allow-Origin") != "" {
+		t.Fatal("CORS headers outside /api/")
+	}
+
+	s.corsOrigins = []string{"*"}
+	req = httptest.NewRequest("GET", "/api/complete", nil)
+	req.Header.Set("Origin", "https://other.example.com")
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Header().Get("Access-Control-Allow-Origin") != "*" || w.Header().Get("Access-Control-Allow-Credentials") != "" {
+		t.Fatalf("wildcard must not allow credentials: %v", w.Header())
+	}
+}
+
+func TestGzipResponses(t *testing.T) {
+	listing := strings.Repeat(`{"output":"file.txt\n"}`, 200)
+	h := gzipResponses(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/json":
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = w.Write([]byte(listing))
+		case "/small":
+			w.Header().Set("Content-Type", "text/plain")
+			w.Header().Set("Content-Length", "2")
+			_, _ = w.Write([]byte("hi"))
+		case "/zip":
+			w.Header().Set("Content-Type", "application/zip")
+			_, _ = w.Write([]byte(listing))
+		case "/missing":
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(listing))
+		}
+	}))
+	get := func(path, acceptEncoding string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", path, nil)
+		if acceptEncoding != "" {
+			req.Header.Set("Accept-Encoding", acceptEncoding)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w
+	}
+
+	w := get("/json", "deflate, gzip;q=0.8")
+	if w.Header().Get("Content-Encoding") != "gzip" || w.Header().Get("Vary") != "Accept-Encoding" {
+		t.Fatalf("JSON not compressed: %v", w.Header())
+	}
+	zr, err := gzip.NewReader(w.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body, _ := io.ReadAll(zr); string(body) != listing {
+		t.Fatalf("decompressed body differs: %d bytes", len(body))
+	}
+
+	for _, tc := range []struct{ path, accept string }{
+		{"/json", ""},
+		{"/json", "gzip;q=0"},
+		{"/small", "gzip"},
+		{"/zip", "gzip"},
+		{"/missing", "gzip"},
+	} {
+		if w := get(tc.path, tc.accept); w.Header().Get("Content-Encoding") != "" {
+			t.Errorf("%s with %q should not be compressed", tc.path, tc.accept)
+		}
+	}
+	if w := get("/missing", "gzip"); w.Code != http.StatusNotFound || w.Body.String() != listing {
+		t.Fatalf("status or body lost: %d", w.Code)
+	}
+}
+
+func TestBranding(t *testing.T) {
+	s := newTestServer(t)
+	page := []byte("<title>{{TITLE}}</title>{{HELP_MESSAGE}}")
+	out := string(s.processHTMLTemplate(page, "/"))
+	if !strings.Contains(out, "<title>"+defaultTitle+"</title>") || !strings.Contains(out, "lsget") {
+		t.Fatalf("defaults not kept: %q", out)
+	}
+
+	s.brand = "ACME <Files>"
+	out = string(s.processHTMLTemplate(page, "/"))
+	if !strings.Contains(out, "<title>ACME &lt;Files&gt;</title>") {
+		t.Fatalf("brand should stand in for the title: %q", out)
+	}
+	if !strings.Contains(out, "Welcome to <span class=\\\"ps1\\\">ACME &lt;Files&gt;</span>") {
+		t.Fatalf("brand missing from welcome: %q", out)
+	}
+
+	s.title = "Downloads"
+	if out = string(s.processHTMLTemplate(page, "/")); !strings.Contains(out, "<title>Downloads</title>") {
+		t.Fatalf("title not applied: %q", out)
+	}
+
+	w := httptest.NewRecorder()
+	s.serveNoJSDirectory(w, httptest.NewRequest("GET", "/?nojs=1", nil), "/")
+	if body := w.Body.String(); !strings.Contains(body, "<title>Index of / - Downloads</title>") {
+		t.Fatalf("nojs title: %q", body)
+	}
+
+	w = httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/missing", nil)
+	r.Header.Set("Accept", "text/html")
+	s.serveError(w, r, http.StatusNotFound)
+	if body := w.Body.String(); !strings.Contains(body, "ACME &lt;Files&gt;: /missing") {
+		t.Fatalf("error page brand: %q", body)
+	}
+}
+
+func TestAnnouncement(t *testing.T) {
+	s := newTestServer(t)
+	page := []byte(`"buffer": "{{ANNOUNCEMENT}}{{HELP_MESSAGE}}"`)
+	if out := string(s.processHTMLTemplate(page, "/")); strings.Contains(out, "announce") {
+		t.Fatalf("no banner expected when unset: %q", out)
+	}
+
+	s.message = "Maintenance <b>tonight</b>\n\x1b[31mC:\\backup \"now\"\n"
+	out := string(s.processHTMLTemplate(page, "/"))
+	for _, want := range []string{
+		`<div class=\"line announce\">Maintenance &lt;b&gt;tonight&lt;/b&gt;</div>`,
+		`<div class=\"line announce\">[31mC:\\backup &#34;now&#34;</div><br/>`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Fatalf("banner missing %q: %q", want, out)
+		}
+	}
+	if strings.Contains(out, "<b>") || strings.Contains(out, "\x1b") {
+		t.Fatalf("banner not sanitized: %q", out)
+	}
+
+	file := filepath.Join(t.TempDir(), "motd")
+	s.messageFile = file
+	if got := s.announcement(); got != s.message {
+		t.Fatalf("missing file should fall back to -message, got %q", got)
+	}
+	if err := os.WriteFile(file, []byte("Usage policy applies"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if got := s.announcement(); got != "Usage policy applies" {
+		t.Fatalf("file not read: %q", got)
+	}
+}
+
+func TestNaturalLess(t *testing.T) {
+	names := []string{"v10", "v2", "file10.txt", "file2.txt", "file02.txt", "v1.10", "v1.9", "v", "a"}
+	slices.SortFunc(names, func(a, b string) int {
+		if naturalLess(a, b) {
+			return -1
+		}
+		if naturalLess(b, a) {
+			return 1
+		}
+		return 0
+	})
+	want := "a file02.txt file2.txt file10.txt v v1.9 v1.10 v2 v10"
+	if got := strings.Join(names, " "); got != want {
+		t.Fatalf("natural order:\n got %s\nwant %s", got, want)
+	}
+}